@@ -3,10 +3,19 @@ package app
 import (
 	"github.com/ThoronicLLC/collector/pkg/core"
 
+	eventhub_input "github.com/ThoronicLLC/collector/internal/input/eventhub"
 	file_input "github.com/ThoronicLLC/collector/internal/input/file"
+	gcs_input "github.com/ThoronicLLC/collector/internal/input/gcs"
+	http_input "github.com/ThoronicLLC/collector/internal/input/http"
+	http_poll_input "github.com/ThoronicLLC/collector/internal/input/http_poll"
+	journald_input "github.com/ThoronicLLC/collector/internal/input/journald"
 	kafka_input "github.com/ThoronicLLC/collector/internal/input/kafka"
 	msgraph_input "github.com/ThoronicLLC/collector/internal/input/msgraph"
+	o365_input "github.com/ThoronicLLC/collector/internal/input/o365"
 	pubsub_input "github.com/ThoronicLLC/collector/internal/input/pubsub"
+	s3_input "github.com/ThoronicLLC/collector/internal/input/s3"
+	servicebus_input "github.com/ThoronicLLC/collector/internal/input/servicebus"
+	sqs_input "github.com/ThoronicLLC/collector/internal/input/sqs"
 	syslog_input "github.com/ThoronicLLC/collector/internal/input/syslog"
 
 	cel_processor "github.com/ThoronicLLC/collector/internal/processor/cel"
@@ -26,11 +35,20 @@ import (
 
 func AddInternalInputs() map[string]core.InputHandler {
 	return map[string]core.InputHandler{
-		file_input.InputName:    file_input.Handler(),
-		kafka_input.InputName:   kafka_input.Handler(),
-		pubsub_input.InputName:  pubsub_input.Handler(),
-		syslog_input.InputName:  syslog_input.Handler(),
-		msgraph_input.InputName: msgraph_input.Handler(),
+		file_input.InputName:       file_input.Handler(),
+		kafka_input.InputName:      kafka_input.Handler(),
+		pubsub_input.InputName:     pubsub_input.Handler(),
+		syslog_input.InputName:     syslog_input.Handler(),
+		msgraph_input.InputName:    msgraph_input.Handler(),
+		journald_input.InputName:   journald_input.Handler(),
+		sqs_input.InputName:        sqs_input.Handler(),
+		s3_input.InputName:         s3_input.Handler(),
+		gcs_input.InputName:        gcs_input.Handler(),
+		http_input.InputName:       http_input.Handler(),
+		http_poll_input.InputName:  http_poll_input.Handler(),
+		o365_input.InputName:       o365_input.Handler(),
+		eventhub_input.InputName:   eventhub_input.Handler(),
+		servicebus_input.InputName: servicebus_input.Handler(),
 	}
 }
 
@@ -1,13 +1,22 @@
 package manager
 
 import (
+	"fmt"
 	"github.com/ThoronicLLC/collector/pkg/core"
 	log "github.com/sirupsen/logrus"
+	"io"
 	"os"
 	"sync"
 	"time"
 )
 
+const (
+	// outputRetryWait is the wait before the first retry of a failed output write, doubling for each retry after up
+	// to outputMaxRetryWait
+	outputRetryWait    = 5 * time.Second
+	outputMaxRetryWait = 5 * time.Minute
+)
+
 type Manager struct {
 	status       *Status
 	id           string
@@ -21,6 +30,8 @@ type Manager struct {
 	processPipe  chan core.PipelineResults
 	outputPipe   chan core.PipelineResults
 	statePipe    chan core.State
+	stop         chan struct{}
+	stopOnce     sync.Once
 }
 
 type Config struct {
@@ -55,6 +66,7 @@ func New(config Config) *Manager {
 		processPipe: make(chan core.PipelineResults, 20),
 		outputPipe:  make(chan core.PipelineResults, 20),
 		statePipe:   make(chan core.State, 20),
+		stop:        make(chan struct{}),
 	}
 
 	// Add a local error handler that also updated internal status
@@ -94,6 +106,7 @@ func (manager *Manager) Run() {
 	go func() {
 		defer wg.Done()
 		manager.outputHandler()
+		manager.closeOutputs()
 		close(manager.statePipe)
 	}()
 
@@ -117,6 +130,9 @@ func (manager *Manager) Status() *Status {
 }
 
 func (manager *Manager) Stop() {
+	manager.stopOnce.Do(func() {
+		close(manager.stop)
+	})
 	manager.input.Stop()
 }
 
@@ -128,10 +144,10 @@ func (manager *Manager) processHandler(errorHandler core.ErrorHandler) {
 			break
 		}
 
-		// If the input returned 0 results, update state and continue
+		// Results without events skip the processors, but still pass through the outputs so their state is saved
+		// and they are acknowledged in order
 		if res.ResultCount == 0 {
-			manager.successfulStatus(0)
-			manager.statePipe <- res.State
+			manager.outputPipe <- res
 			continue
 		}
 
@@ -141,6 +157,7 @@ func (manager *Manager) processHandler(errorHandler core.ErrorHandler) {
 		tmpWriter, err := core.NewTmpWriter()
 		if err != nil {
 			errorHandler(false, err)
+			res.Acknowledge(false)
 			continue
 		}
 
@@ -165,6 +182,7 @@ func (manager *Manager) processHandler(errorHandler core.ErrorHandler) {
 
 		if err != nil {
 			errorHandler(false, err)
+			res.Acknowledge(false)
 			continue
 		}
 
@@ -172,11 +190,14 @@ func (manager *Manager) processHandler(errorHandler core.ErrorHandler) {
 			FilePath:    currentFile,
 			ResultCount: currentCount,
 			State:       res.State,
+			Ack:         res.Ack,
 		}
 	}
 }
 
 func (manager *Manager) outputHandler() {
+	// Once results are not delivered no later state is saved, so the input reads them again after a restart
+	undelivered := false
 	for {
 		res, ok := <-manager.outputPipe
 		if !ok {
@@ -187,31 +208,84 @@ func (manager *Manager) outputHandler() {
 		// Setup current file tracker
 		currentFile := res.FilePath
 
-		// Send data to outputs, if they fail, continue
-		var err error
-		for _, v := range manager.outputs {
-			// Start writing results to output
-			_, err = v.Write(currentFile)
-			if err != nil {
-				manager.errorHandler(false, err)
-				continue
-			}
+		// Send data to outputs, retrying the outputs that fail
+		delivered := true
+		if res.ResultCount > 0 {
+			delivered = manager.writeOutputs(currentFile)
 		}
 
 		// Delete old results
-		err = removeIfExists(currentFile)
+		err := removeIfExists(currentFile)
 		if err != nil {
 			manager.errorHandler(false, err)
 		}
 
+		// Release the input's in-flight budget and let it settle the results with the source
+		res.Acknowledge(delivered)
+
 		// Update status
-		manager.successfulStatus(res.ResultCount)
+		if delivered {
+			manager.successfulStatus(res.ResultCount)
 
-		// Log debug
-		log.Debugf("output successfully processed %d results for: %s", res.ResultCount, manager.id)
+			// Log debug
+			log.Debugf("output successfully processed %d results for: %s", res.ResultCount, manager.id)
+		}
 
 		// Save state
-		manager.statePipe <- res.State
+		undelivered = undelivered || !delivered
+		if !undelivered {
+			manager.statePipe <- res.State
+		}
+	}
+}
+
+// closeOutputs closes the outputs that hold clients open between writes, once the last results have been written
+func (manager *Manager) closeOutputs() {
+	for _, output := range manager.outputs {
+		closer, ok := output.(io.Closer)
+		if !ok {
+			continue
+		}
+
+		err := closer.Close()
+		if err != nil {
+			manager.errorHandler(false, fmt.Errorf("issue closing output: %s", err))
+		}
+	}
+}
+
+// writeOutputs writes the results to every output. Outputs that fail are retried with an increasing wait until they
+// succeed or the manager is stopped, which holds up the pipeline so inputs apply backpressure during an outage.
+// Outputs that partially wrote the results only write the rest when retried. It returns whether every output wrote
+// the results.
+func (manager *Manager) writeOutputs(filePath string) bool {
+	pending := manager.outputs
+	wait := outputRetryWait
+	for {
+		failed := make([]core.Output, 0)
+		for _, v := range pending {
+			_, err := v.Write(filePath)
+			if err != nil {
+				manager.errorHandler(false, err)
+				failed = append(failed, v)
+			}
+		}
+
+		if len(failed) == 0 {
+			return true
+		}
+
+		log.Warnf("retrying %d failed outputs in %s for: %s", len(failed), wait, manager.id)
+		select {
+		case <-manager.stop:
+			return false
+		case <-time.After(wait):
+		}
+		pending = failed
+		wait *= 2
+		if wait > outputMaxRetryWait {
+			wait = outputMaxRetryWait
+		}
 	}
 }
 
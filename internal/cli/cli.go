@@ -97,7 +97,7 @@ func defaultErrorHandler() core.ErrorHandler {
 // SetupCloseHandler creates a 'listener' on a new goroutine which will notify the
 // program if it receives an interrupt from the OS.
 func setupCloseHandler(appCollector *collector.Collector) {
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		// Wait for first CTRL+C
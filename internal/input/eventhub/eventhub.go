@@ -0,0 +1,336 @@
+package eventhub
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azeventhubs"
+	"github.com/ThoronicLLC/collector/pkg/core"
+	"sync"
+	"time"
+)
+
+var InputName = "eventhub"
+
+const (
+	startPositionEarliest = "earliest"
+	startPositionLatest   = "latest"
+
+	// retryDelay is the wait before receiving from a partition again after an error
+	retryDelay = 5 * time.Second
+)
+
+// Config for the Event Hubs input. Every partition is read with its own client and the sequence number of the
+// last event delivered to the outputs is saved in the input state, so a restart continues from the next event.
+// Partitions are read again from that event when a batch fails to be delivered.
+type Config struct {
+	ConnectionString string   `json:"connection_string" validate:"required"`
+	EventHub         string   `json:"event_hub"` // Not needed when the connection string has an EntityPath
+	ConsumerGroup    string   `json:"consumer_group" validate:"required"`
+	Partitions       []string `json:"partitions"` // Partitions to read, defaults to all
+
+	// StartPosition is where partitions without a saved sequence number start: earliest or latest
+	StartPosition string `json:"start_position" validate:"in:earliest,latest"`
+
+	// UnwrapRecords writes each element of the records array used by Azure Diagnostic Settings as its own event
+	UnwrapRecords bool `json:"unwrap_records"`
+
+	// Number of events to receive at once, and the seconds to wait for them before writing what was received
+	ReceiveBatchSize int `json:"receive_batch_size" validate:"required|min:1"`
+	MaxWaitTime      int `json:"max_wait_time" validate:"required|min:1"`
+
+	FlushFrequency int   `json:"flush_frequency" validate:"required|min:0"`
+	MaxBatchBytes  int64 `json:"max_batch_bytes" validate:"min:0"`
+	MaxBatchEvents int   `json:"max_batch_events" validate:"min:0"`
+
+	// MaxInFlightBatches is how many batches may be waiting on the outputs before the partitions stop receiving,
+	// leaving the events in the event hub. MaxDiskBytes stops receiving once the waiting batches use that many bytes
+	// of temp files. Zero disables either limit.
+	MaxInFlightBatches int   `json:"max_in_flight_batches" validate:"min:0"`
+	MaxDiskBytes       int64 `json:"max_disk_bytes" validate:"min:0"`
+}
+
+type eventHubInput struct {
+	config     Config
+	ctx        context.Context
+	cancelFunc context.CancelFunc
+}
+
+func Handler() core.InputHandler {
+	return func(config []byte) (core.Input, error) {
+		// Set config defaults
+		conf := defaultConfig()
+
+		// Unmarshal config
+		err := json.Unmarshal(config, &conf)
+		if err != nil {
+			return nil, fmt.Errorf("issue unmarshalling file config: %s", err)
+		}
+
+		// Validate config
+		err = core.ValidateStruct(&conf)
+		if err != nil {
+			return nil, err
+		}
+
+		// Setup context
+		ctx, cancelFn := context.WithCancel(context.Background())
+
+		return &eventHubInput{
+			config:     conf,
+			ctx:        ctx,
+			cancelFunc: cancelFn,
+		}, nil
+	}
+}
+
+func (e *eventHubInput) Run(errorHandler core.ErrorHandler, state core.State, processPipe chan<- core.PipelineResults) {
+	// Track the sequence number of each partition
+	checkpoints := newCheckpointTracker(loadState(state))
+
+	// Setup local variables
+	batcher, err := core.NewBatcher(e.ctx, core.BatchConfig{
+		FlushFrequency:     e.config.FlushFrequency,
+		MaxBatchBytes:      e.config.MaxBatchBytes,
+		MaxBatchEvents:     e.config.MaxBatchEvents,
+		MaxInFlightBatches: e.config.MaxInFlightBatches,
+		MaxDiskBytes:       e.config.MaxDiskBytes,
+		DropPolicy:         core.DropPolicyBlock,
+		State:              checkpoints.state,
+	}, processPipe)
+	if err != nil {
+		errorHandler(true, err)
+		return
+	}
+
+	// Setup new client
+	client, err := azeventhubs.NewConsumerClientFromConnectionString(e.config.ConnectionString, e.config.EventHub, e.config.ConsumerGroup, nil)
+	if err != nil {
+		errorHandler(true, fmt.Errorf("issue setting up event hub client: %s", err))
+		return
+	}
+	defer client.Close(context.Background())
+
+	partitionClients, err := e.newPartitionClients(client, checkpoints)
+	if err != nil {
+		errorHandler(true, err)
+		return
+	}
+
+	// Setup wait group. The flush context is only cancelled once the partitions have stopped writing, so the final
+	// flush includes every event received.
+	var wg sync.WaitGroup
+	var partitionWg sync.WaitGroup
+	flushCtx, flushCancelFn := context.WithCancel(context.Background())
+
+	for partitionID, partitionClient := range partitionClients {
+		partitionWg.Add(1)
+		go func(partitionID string, partitionClient *azeventhubs.PartitionClient) {
+			defer partitionWg.Done()
+			e.consume(client, partitionID, partitionClient, batcher, checkpoints, errorHandler)
+		}(partitionID, partitionClient)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer flushCancelFn()
+		partitionWg.Wait()
+	}()
+
+	// Start timed process sync go routine
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		batcher.Run(flushCtx, errorHandler)
+	}()
+
+	wg.Wait()
+}
+
+func (e *eventHubInput) Stop() {
+	e.cancelFunc()
+}
+
+// newPartitionClients creates a client for each partition, starting after the saved sequence number if there is one
+func (e *eventHubInput) newPartitionClients(client *azeventhubs.ConsumerClient, checkpoints *checkpointTracker) (map[string]*azeventhubs.PartitionClient, error) {
+	partitions := e.config.Partitions
+	if len(partitions) == 0 {
+		properties, err := client.GetEventHubProperties(e.ctx, nil)
+		if err != nil {
+			return nil, fmt.Errorf("issue getting event hub properties: %s", err)
+		}
+		partitions = properties.PartitionIDs
+	}
+
+	partitionClients := make(map[string]*azeventhubs.PartitionClient)
+	for _, partitionID := range partitions {
+		partitionClient, err := e.newPartitionClient(client, partitionID, checkpoints)
+		if err != nil {
+			for _, v := range partitionClients {
+				_ = v.Close(context.Background())
+			}
+			return nil, err
+		}
+		partitionClients[partitionID] = partitionClient
+	}
+
+	return partitionClients, nil
+}
+
+// newPartitionClient creates a client for the partition, starting after the saved sequence number if there is one
+func (e *eventHubInput) newPartitionClient(client *azeventhubs.ConsumerClient, partitionID string, checkpoints *checkpointTracker) (*azeventhubs.PartitionClient, error) {
+	partitionClient, err := client.NewPartitionClient(partitionID, &azeventhubs.PartitionClientOptions{
+		StartPosition: e.startPosition(partitionID, checkpoints),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("issue creating partition %s client: %s", partitionID, err)
+	}
+
+	return partitionClient, nil
+}
+
+// startPosition returns the position after the saved sequence number, or the configured start position
+func (e *eventHubInput) startPosition(partitionID string, checkpoints *checkpointTracker) azeventhubs.StartPosition {
+	sequenceNumber, ok := checkpoints.get(partitionID)
+	if ok {
+		return azeventhubs.StartPosition{SequenceNumber: &sequenceNumber, Inclusive: false}
+	}
+
+	enabled := true
+	if e.config.StartPosition == startPositionLatest {
+		return azeventhubs.StartPosition{Latest: &enabled}
+	}
+
+	return azeventhubs.StartPosition{Earliest: &enabled}
+}
+
+// consume writes the events from the partition to the batcher until the input is stopped or an event can not be
+// written. The partition is read again from the last delivered event whenever a batch fails to be delivered.
+func (e *eventHubInput) consume(client *azeventhubs.ConsumerClient, partitionID string, partitionClient *azeventhubs.PartitionClient, batcher *core.Batcher, checkpoints *checkpointTracker, errorHandler core.ErrorHandler) {
+	defer func() {
+		err := partitionClient.Close(context.Background())
+		if err != nil {
+			errorHandler(false, fmt.Errorf("error closing partition %s client: %s", partitionID, err))
+		}
+	}()
+
+	maxWaitTime := time.Duration(e.config.MaxWaitTime) * time.Second
+	generation := checkpoints.generation(partitionID)
+	for {
+		// Start reading from the last delivered event again after a failed delivery
+		if checkpoints.generation(partitionID) != generation {
+			errorHandler(false, fmt.Errorf("events from partition %s were not delivered, reading again from the last delivered event", partitionID))
+			_ = partitionClient.Close(context.Background())
+
+			var err error
+			generation = checkpoints.generation(partitionID)
+			partitionClient, err = e.newPartitionClient(client, partitionID, checkpoints)
+			if err != nil {
+				errorHandler(true, err)
+				return
+			}
+		}
+
+		receiveCtx, cancelFn := context.WithTimeout(e.ctx, maxWaitTime)
+		events, err := partitionClient.ReceiveEvents(receiveCtx, e.config.ReceiveBatchSize, nil)
+		cancelFn()
+		if err != nil {
+			if e.ctx.Err() != nil {
+				return
+			}
+
+			// No events arrived before the wait time
+			if errors.Is(err, context.DeadlineExceeded) {
+				continue
+			}
+
+			errorHandler(false, fmt.Errorf("error receiving events from partition %s: %s", partitionID, err))
+			select {
+			case <-e.ctx.Done():
+				return
+			case <-time.After(retryDelay):
+			}
+			continue
+		}
+
+		for _, event := range events {
+			records, err := eventRecords(event.Body, e.config.UnwrapRecords)
+			if err != nil {
+				errorHandler(false, fmt.Errorf("unable to unwrap records of event %d in partition %s: %s", event.SequenceNumber, partitionID, err))
+			}
+
+			// The checkpoint advances once the batch holding the last record is delivered, as batches are
+			// acknowledged in order
+			for i, record := range records {
+				ack := checkpoints.partialAck(partitionID, generation)
+				if i == len(records)-1 {
+					ack = checkpoints.ack(partitionID, generation, event.SequenceNumber)
+				}
+
+				// Stop reading the partition so the checkpoint never moves past the event
+				_, writeErr := batcher.WriteWithAck(record, ack)
+				if writeErr != nil {
+					errorHandler(true, fmt.Errorf("error writing to tmp file, stopped reading partition %s: %s", partitionID, writeErr))
+					return
+				}
+			}
+		}
+	}
+}
+
+// eventRecords returns the elements of the records array in the event body when unwrapping, or the body itself.
+// Bodies that are not JSON objects with a records array are returned unchanged.
+func eventRecords(body []byte, unwrap bool) ([][]byte, error) {
+	body = bytes.TrimSpace(body)
+	if len(body) == 0 {
+		return nil, nil
+	}
+
+	if !unwrap {
+		return [][]byte{body}, nil
+	}
+
+	var document map[string]json.RawMessage
+	err := json.Unmarshal(body, &document)
+	if err != nil {
+		return [][]byte{body}, nil
+	}
+
+	rawRecords, ok := document["records"]
+	if !ok {
+		return [][]byte{body}, nil
+	}
+
+	var records []json.RawMessage
+	err = json.Unmarshal(rawRecords, &records)
+	if err != nil {
+		return [][]byte{body}, fmt.Errorf("issue decoding records field: %s", err)
+	}
+
+	// Compact each record so it is written as a single line
+	results := make([][]byte, 0, len(records))
+	for _, record := range records {
+		var compacted bytes.Buffer
+		err = json.Compact(&compacted, record)
+		if err != nil {
+			return [][]byte{body}, fmt.Errorf("issue compacting record: %s", err)
+		}
+		results = append(results, compacted.Bytes())
+	}
+
+	return results, nil
+}
+
+func defaultConfig() Config {
+	return Config{
+		ConsumerGroup:    azeventhubs.DefaultConsumerGroup,
+		StartPosition:    startPositionEarliest,
+		UnwrapRecords:    true,
+		ReceiveBatchSize: 100,
+		MaxWaitTime:      5,
+		FlushFrequency:   300,
+	}
+}
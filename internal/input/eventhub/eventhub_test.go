@@ -0,0 +1,132 @@
+package eventhub
+
+import (
+	"encoding/json"
+	"github.com/ThoronicLLC/collector/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+var connectionString = "Endpoint=sb://example.servicebus.windows.net/;SharedAccessKeyName=listen;SharedAccessKey=1234567890"
+
+var config1 = `{"connection_string": "` + connectionString + `", "event_hub": "insights-logs"}`
+var config2 = `{"connection_string": "` + connectionString + `;EntityPath=insights-logs", "consumer_group": "collector", "flush_frequency": 100}`
+var config3 = `{"connection_string": "` + connectionString + `", "event_hub": "insights-logs", "partitions": ["0", "1"], "start_position": "latest"}`
+var config4 = `{"connection_string": "` + connectionString + `", "event_hub": "insights-logs", "unwrap_records": false, "receive_batch_size": 500, "max_wait_time": 10, "max_batch_events": 1000}`
+var badConfig1 = `{"connection_string": "", "event_hub": "insights-logs"}`
+var badConfig2 = `{"connection_string": "` + connectionString + `", "event_hub": "insights-logs", "consumer_group": ""}`
+var badConfig3 = `{"connection_string": "` + connectionString + `", "event_hub": "insights-logs", "start_position": "timestamp"}`
+var badConfig4 = `{"connection_string": "` + connectionString + `", "event_hub": "insights-logs", "receive_batch_size": 0}`
+var badConfig5 = `{"connection_string": "` + connectionString + `", "event_hub": "insights-logs", "max_wait_time": 0}`
+var badConfig6 = `{"connection_string": "` + connectionString + `", "event_hub": "insights-logs", "flush_frequency": -1}`
+
+func TestValidate(t *testing.T) {
+	arr := []string{config1, config2, config3, config4}
+	for i, v := range arr {
+		testConfig := defaultConfig()
+		err := json.Unmarshal([]byte(v), &testConfig)
+		assert.Nilf(t, err, "test #%d - failed to unmarshal json: %s", i, err)
+		err = core.ValidateStruct(&testConfig)
+		assert.Nilf(t, err, "test #%d - validation error: %s", i, err)
+	}
+}
+
+func TestValidateFailed(t *testing.T) {
+	arr := []string{badConfig1, badConfig2, badConfig3, badConfig4, badConfig5, badConfig6}
+	for i, v := range arr {
+		testConfig := defaultConfig()
+		err := json.Unmarshal([]byte(v), &testConfig)
+		assert.Nilf(t, err, "test #%d - failed to unmarshal json: %s", i, err)
+		err = core.ValidateStruct(&testConfig)
+		assert.NotNilf(t, err, "test #%d - validation should have returned an error: %s", i, err)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	arr := []string{config1, config2, config3, config4}
+	for i, v := range arr {
+		handleFunc := Handler()
+		_, err := handleFunc([]byte(v))
+		assert.Nilf(t, err, "test #%d - validation error: %s", i, err)
+	}
+}
+
+func TestHandlerFailed(t *testing.T) {
+	arr := []string{badConfig1, badConfig2, badConfig3, badConfig4, badConfig5, badConfig6}
+	for i, v := range arr {
+		handleFunc := Handler()
+		_, err := handleFunc([]byte(v))
+		assert.NotNilf(t, err, "test #%d - validation should have returned an error: %s", i, err)
+	}
+}
+
+func TestEventRecords(t *testing.T) {
+	body := []byte(`{"records": [{"category": "AuditEvent", "properties": {"id": 1}}, {"category": "AuditEvent", "properties": {"id": 2}}]}`)
+	records, err := eventRecords(body, true)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(records))
+	assert.Equal(t, `{"category":"AuditEvent","properties":{"id":1}}`, string(records[0]))
+	assert.Equal(t, `{"category":"AuditEvent","properties":{"id":2}}`, string(records[1]))
+
+	// Bodies are written unchanged when not unwrapping
+	records, err = eventRecords(body, false)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{body}, records)
+
+	// Events without a records array are written unchanged
+	for _, v := range []string{`{"category": "AuditEvent"}`, `plain text event`} {
+		records, err = eventRecords([]byte(v), true)
+		assert.Nil(t, err)
+		assert.Equal(t, [][]byte{[]byte(v)}, records)
+	}
+
+	// Invalid records arrays return the body with an error
+	records, err = eventRecords([]byte(`{"records": "AuditEvent"}`), true)
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, len(records))
+
+	// Empty bodies are skipped
+	records, err = eventRecords([]byte(" "), true)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(records))
+}
+
+func TestCheckpointTracker(t *testing.T) {
+	checkpoints := newCheckpointTracker(loadState([]byte(`{"sequence_numbers": {"0": 42}}`)))
+	sequenceNumber, ok := checkpoints.get("0")
+	assert.True(t, ok)
+	assert.Equal(t, int64(42), sequenceNumber)
+
+	_, ok = checkpoints.get("1")
+	assert.False(t, ok)
+
+	// Checkpoints advance once events are delivered
+	checkpoints.ack("1", 0, 7)(true)
+	assert.Equal(t, `{"sequence_numbers":{"0":42,"1":7}}`, string(checkpoints.state()))
+
+	// A failed delivery starts a new generation and later acks from the old one are ignored
+	checkpoints.partialAck("1", 0)(true)
+	assert.Equal(t, 0, checkpoints.generation("1"))
+	checkpoints.partialAck("1", 0)(false)
+	assert.Equal(t, 1, checkpoints.generation("1"))
+	checkpoints.ack("1", 0, 9)(true)
+	checkpoints.ack("1", 0, 10)(false)
+	assert.Equal(t, 1, checkpoints.generation("1"))
+	assert.Equal(t, `{"sequence_numbers":{"0":42,"1":7}}`, string(checkpoints.state()))
+
+	checkpoints.ack("1", 1, 8)(true)
+	assert.Equal(t, `{"sequence_numbers":{"0":42,"1":8}}`, string(checkpoints.state()))
+
+	// Saved sequence numbers start after the last event delivered
+	input := &eventHubInput{config: defaultConfig()}
+	position := input.startPosition("0", checkpoints)
+	assert.Equal(t, int64(42), *position.SequenceNumber)
+	assert.False(t, position.Inclusive)
+
+	position = input.startPosition("2", checkpoints)
+	assert.True(t, *position.Earliest)
+
+	// Missing or invalid state starts without sequence numbers
+	assert.Equal(t, 0, len(loadState(nil).SequenceNumbers))
+	assert.Equal(t, 0, len(loadState([]byte("")).SequenceNumbers))
+}
@@ -0,0 +1,89 @@
+package eventhub
+
+import (
+	"encoding/json"
+	"github.com/ThoronicLLC/collector/pkg/core"
+	"sync"
+)
+
+// eventHubState is the sequence number of the last event delivered for each partition
+type eventHubState struct {
+	SequenceNumbers map[string]int64 `json:"sequence_numbers"`
+}
+
+func loadState(state core.State) eventHubState {
+	loadedState := eventHubState{}
+	if state != nil {
+		_ = json.Unmarshal(state, &loadedState)
+	}
+
+	if loadedState.SequenceNumbers == nil {
+		loadedState.SequenceNumbers = make(map[string]int64)
+	}
+
+	return loadedState
+}
+
+// checkpointTracker holds the sequence number of the last event delivered for each partition. A failed delivery
+// starts a new generation of the partition, which is read again from the last delivered event. Acks from events
+// read before then are ignored so the checkpoint never moves past the events that failed.
+type checkpointTracker struct {
+	mu              sync.Mutex
+	sequenceNumbers map[string]int64
+	generations     map[string]int
+}
+
+func newCheckpointTracker(state eventHubState) *checkpointTracker {
+	return &checkpointTracker{sequenceNumbers: state.SequenceNumbers, generations: make(map[string]int)}
+}
+
+func (t *checkpointTracker) get(partitionID string) (int64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sequenceNumber, ok := t.sequenceNumbers[partitionID]
+	return sequenceNumber, ok
+}
+
+// generation returns the current generation of the partition
+func (t *checkpointTracker) generation(partitionID string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.generations[partitionID]
+}
+
+// ack returns the batch ack for the last record of an event read in the generation of the partition
+func (t *checkpointTracker) ack(partitionID string, generation int, sequenceNumber int64) func(delivered bool) {
+	return func(delivered bool) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.generations[partitionID] != generation {
+			return
+		}
+
+		if !delivered {
+			t.generations[partitionID]++
+			return
+		}
+		t.sequenceNumbers[partitionID] = sequenceNumber
+	}
+}
+
+// partialAck returns the batch ack for the other records of an event, which only start a new generation when they
+// fail to be delivered
+func (t *checkpointTracker) partialAck(partitionID string, generation int) func(delivered bool) {
+	return func(delivered bool) {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.generations[partitionID] == generation && !delivered {
+			t.generations[partitionID]++
+		}
+	}
+}
+
+// state returns the sequence numbers as the input state
+func (t *checkpointTracker) state() core.State {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state, _ := json.Marshal(eventHubState{SequenceNumbers: t.sequenceNumbers})
+	return state
+}
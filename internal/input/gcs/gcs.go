@@ -0,0 +1,362 @@
+package gcs
+
+import (
+	"cloud.google.com/go/storage"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ThoronicLLC/collector/pkg/core"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+	"sort"
+	"strings"
+	"time"
+)
+
+var InputName = "gcs"
+
+const (
+	afterReadNone   = "none"
+	afterReadDelete = "delete"
+	afterReadMove   = "move"
+)
+
+type Config struct {
+	Bucket          string          `json:"bucket" validate:"required"`
+	Prefix          string          `json:"prefix"`
+	Credentials     json.RawMessage `json:"credentials,omitempty"`
+	CredentialsPath string          `json:"credentials_path"`
+	Schedule        int             `json:"schedule" validate:"required|min:0"`
+
+	// RecordsField splits JSON objects into one event per element of the array stored in the field
+	RecordsField string `json:"records_field"`
+
+	// ReadExisting reads the objects already in the bucket on the first run instead of only new objects
+	ReadExisting bool `json:"read_existing"`
+
+	// MaxEventsPerResult splits large objects into multiple results
+	MaxEventsPerResult int `json:"max_events_per_result" validate:"min:0"`
+
+	// AfterRead deletes or moves objects once every output has written them. Objects are left in place when delivery
+	// fails and are read again. Moved objects keep their name under MovePrefix in MoveBucket, which defaults to the
+	// source bucket.
+	AfterRead  string `json:"after_read" validate:"in:none,delete,move"`
+	MoveBucket string `json:"move_bucket"`
+	MovePrefix string `json:"move_prefix"`
+}
+
+type gcsInput struct {
+	config     Config
+	ctx        context.Context
+	cancelFunc context.CancelFunc
+}
+
+// object is a GCS object generation waiting to be read
+type object struct {
+	Name       string
+	Generation int64
+}
+
+func Handler() core.InputHandler {
+	return func(config []byte) (core.Input, error) {
+		// Set config defaults
+		conf := defaultConfig()
+
+		// Unmarshal config
+		err := json.Unmarshal(config, &conf)
+		if err != nil {
+			return nil, fmt.Errorf("issue unmarshalling file config: %s", err)
+		}
+
+		// Validate config
+		err = core.ValidateStruct(&conf)
+		if err != nil {
+			return nil, err
+		}
+
+		// Validate credentials
+		err = validateCredentialsOrPath(conf.Credentials, conf.CredentialsPath)
+		if err != nil {
+			return nil, err
+		}
+
+		// Validate move destination
+		err = validateMoveDestination(conf)
+		if err != nil {
+			return nil, err
+		}
+
+		// Setup context
+		ctx, cancelFn := context.WithCancel(context.Background())
+
+		return &gcsInput{
+			config:     conf,
+			ctx:        ctx,
+			cancelFunc: cancelFn,
+		}, nil
+	}
+}
+
+// Run will execute the input with the supplied context and state and return results
+func (input *gcsInput) Run(errorHandler core.ErrorHandler, state core.State, processPipe chan<- core.PipelineResults) {
+	// Validate and load state
+	currentState := loadState(state, input.config.ReadExisting)
+
+	// Setup new client
+	opts := make([]option.ClientOption, 0)
+	if input.config.Credentials != nil && len(input.config.Credentials) > 0 && string(input.config.Credentials) != "null" {
+		opts = append(opts, option.WithCredentialsJSON(input.config.Credentials))
+	} else if input.config.CredentialsPath != "" {
+		opts = append(opts, option.WithCredentialsFile(input.config.CredentialsPath))
+	}
+
+	// Setup storage client
+	client, err := storage.NewClient(input.ctx, opts...)
+	if err != nil {
+		errorHandler(true, fmt.Errorf("issue initializing storage client: %s", err))
+		return
+	}
+	defer client.Close()
+
+	for {
+		select {
+		case <-input.ctx.Done():
+			return
+		case <-time.After(time.Duration(input.config.Schedule) * time.Second):
+			newState, err := input.poll(client, currentState, processPipe, errorHandler)
+			if err != nil {
+				errorHandler(false, err)
+			}
+
+			// Update current state to the last object read
+			currentState = newState
+		}
+	}
+}
+
+func (input *gcsInput) Stop() {
+	input.cancelFunc()
+}
+
+// poll reads every new object in the bucket and returns the state after the last object read
+func (input *gcsInput) poll(client *storage.Client, state gcsState, processPipe chan<- core.PipelineResults, errorHandler core.ErrorHandler) (gcsState, error) {
+	objects, err := input.listObjects(client, state)
+	if err != nil {
+		return state, err
+	}
+
+	// Nothing to do
+	if len(objects) == 0 {
+		return state, nil
+	}
+
+	initialState, err := json.Marshal(state)
+	if err != nil {
+		return state, fmt.Errorf("issue marshalling state: %s", err)
+	}
+
+	writer, err := core.NewResultWriter(input.config.MaxEventsPerResult, initialState, processPipe)
+	if err != nil {
+		return state, fmt.Errorf("issue opening a new result writer: %s", err)
+	}
+
+	// Read objects in order, checkpointing the state after each one
+	currentState := state
+	completed := make([]object, 0, len(objects))
+	var readErr error
+	for _, obj := range objects {
+		if input.ctx.Err() != nil {
+			break
+		}
+
+		readErr = input.readObject(client, obj, writer)
+		if readErr != nil {
+			break
+		}
+
+		currentState = currentState.advance(obj.Name, obj.Generation)
+		newState, err := json.Marshal(currentState)
+		if err != nil {
+			readErr = fmt.Errorf("issue marshalling state: %s", err)
+			break
+		}
+		writer.Checkpoint(newState)
+		completed = append(completed, obj)
+	}
+
+	// Send the results read so far. After a failure the state points at the last complete object, so the failed
+	// object is read again on the next run.
+	finalState, err := json.Marshal(currentState)
+	if err != nil {
+		writer.Discard()
+		return state, fmt.Errorf("issue marshalling state: %s", err)
+	}
+
+	if input.config.AfterRead == afterReadNone {
+		err = writer.Flush(finalState)
+		if err != nil {
+			writer.Discard()
+			return state, err
+		}
+		return currentState, readErr
+	}
+
+	// Wait for the outputs before deleting or moving the objects
+	delivered, err := writer.FlushWait(input.ctx, finalState)
+	if err != nil {
+		writer.Discard()
+		return state, err
+	}
+
+	// Objects are left in place unless every result reached the outputs, and are read again from the previous state
+	if !delivered {
+		if input.ctx.Err() == nil {
+			errorHandler(false, fmt.Errorf("results were not delivered, leaving %d objects in gs://%s", len(completed), input.config.Bucket))
+		}
+		return state, readErr
+	}
+
+	for _, obj := range completed {
+		// Objects left behind on shutdown are not read again as the state has moved past them
+		if input.ctx.Err() != nil {
+			break
+		}
+
+		err = input.afterRead(client, obj)
+		if err != nil {
+			errorHandler(false, err)
+		}
+	}
+
+	return currentState, readErr
+}
+
+// listObjects returns the objects under the prefix that have not been read yet, oldest first
+func (input *gcsInput) listObjects(client *storage.Client, state gcsState) ([]object, error) {
+	objects := make([]object, 0)
+	it := client.Bucket(input.config.Bucket).Objects(input.ctx, &storage.Query{Prefix: input.config.Prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("issue listing gs://%s/%s: %s", input.config.Bucket, input.config.Prefix, err)
+		}
+
+		// Skip folder placeholders
+		if strings.HasSuffix(attrs.Name, "/") {
+			continue
+		}
+
+		if state.processed(attrs.Name, attrs.Generation) {
+			continue
+		}
+
+		objects = append(objects, object{Name: attrs.Name, Generation: attrs.Generation})
+	}
+
+	sortObjects(objects)
+	return objects, nil
+}
+
+// readObject writes the object generation to the results, one event per line or per record when records_field is
+// set. Objects stored with gzip content encoding are decompressed by the client, other gzip objects are detected.
+func (input *gcsInput) readObject(client *storage.Client, obj object, writer *core.ResultWriter) error {
+	objectReader, err := client.Bucket(input.config.Bucket).Object(obj.Name).Generation(obj.Generation).NewReader(input.ctx)
+	if err != nil {
+		return fmt.Errorf("issue getting object gs://%s/%s: %s", input.config.Bucket, obj.Name, err)
+	}
+
+	reader, err := core.NewDecompressingReader(objectReader)
+	if err != nil {
+		_ = objectReader.Close()
+		return fmt.Errorf("issue reading object gs://%s/%s: %s", input.config.Bucket, obj.Name, err)
+	}
+	defer reader.Close()
+
+	writeLine := func(line []byte) error {
+		_, err := writer.Write(line)
+		return err
+	}
+
+	if input.config.RecordsField != "" {
+		err = core.ReadJSONRecords(reader, input.config.RecordsField, writeLine)
+	} else {
+		err = core.ReadLines(reader, writeLine)
+	}
+	if err != nil {
+		return fmt.Errorf("issue reading gs://%s/%s: %s", input.config.Bucket, obj.Name, err)
+	}
+
+	return nil
+}
+
+// afterRead deletes or moves the object generation. Newer generations written since the object was read are left
+// alone.
+func (input *gcsInput) afterRead(client *storage.Client, obj object) error {
+	source := client.Bucket(input.config.Bucket).Object(obj.Name)
+
+	if input.config.AfterRead == afterReadMove {
+		destination := client.Bucket(moveBucket(input.config)).Object(input.config.MovePrefix + obj.Name)
+		_, err := destination.CopierFrom(source.Generation(obj.Generation)).Run(input.ctx)
+		if err != nil {
+			return fmt.Errorf("issue moving gs://%s/%s: %s", input.config.Bucket, obj.Name, err)
+		}
+	}
+
+	err := source.If(storage.Conditions{GenerationMatch: obj.Generation}).Delete(input.ctx)
+	if err != nil && err != storage.ErrObjectNotExist {
+		return fmt.Errorf("issue deleting gs://%s/%s: %s", input.config.Bucket, obj.Name, err)
+	}
+
+	return nil
+}
+
+// sortObjects sorts objects by generation and then name
+func sortObjects(objects []object) {
+	sort.Slice(objects, func(i, j int) bool {
+		if objects[i].Generation != objects[j].Generation {
+			return objects[i].Generation < objects[j].Generation
+		}
+		return objects[i].Name < objects[j].Name
+	})
+}
+
+// moveBucket returns the bucket objects are moved to
+func moveBucket(conf Config) string {
+	if conf.MoveBucket != "" {
+		return conf.MoveBucket
+	}
+	return conf.Bucket
+}
+
+// validateMoveDestination checks moved objects are not read again
+func validateMoveDestination(conf Config) error {
+	if conf.AfterRead != afterReadMove || moveBucket(conf) != conf.Bucket {
+		return nil
+	}
+
+	if strings.HasPrefix(conf.MovePrefix, conf.Prefix) {
+		return fmt.Errorf("move_prefix must be outside of the prefix being read when moving within the same bucket")
+	}
+
+	return nil
+}
+
+func validateCredentialsOrPath(credentials json.RawMessage, path string) error {
+	if credentials != nil && len(credentials) > 0 && string(credentials) != "null" {
+		return nil
+	} else if path != "" {
+		return nil
+	}
+
+	return fmt.Errorf("missing credentials")
+}
+
+func defaultConfig() Config {
+	return Config{
+		Schedule:           60,
+		MaxEventsPerResult: 100000,
+		AfterRead:          afterReadNone,
+	}
+}
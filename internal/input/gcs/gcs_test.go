@@ -0,0 +1,83 @@
+package gcs
+
+import (
+	"encoding/json"
+	"github.com/ThoronicLLC/collector/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+var config1 = `{"bucket": "example-bucket", "credentials_path": "/tmp/file.txt", "schedule": 30}`
+var config2 = `{"bucket": "example-bucket", "prefix": "logs/", "credentials": {}, "records_field": "records", "read_existing": true, "max_events_per_result": 1000}`
+var config3 = `{"bucket": "example-bucket", "prefix": "logs/", "credentials": {}, "after_read": "move", "move_prefix": "processed/"}`
+var config4 = `{"bucket": "example-bucket", "credentials": {}, "after_read": "move", "move_bucket": "archive-bucket"}`
+var badConfig1 = `{"bucket": "", "credentials": {}}`
+var badConfig2 = `{"bucket": "example-bucket", "credentials": {}, "schedule": 0}`
+var badConfig3 = `{"bucket": "example-bucket", "credentials": {}, "after_read": "archive"}`
+var badConfig4 = `{"bucket": "example-bucket", "credentials": {}, "max_events_per_result": -1}`
+var badConfig5 = `{"bucket": "example-bucket"}`
+var badConfig6 = `{"bucket": "example-bucket", "prefix": "logs/", "credentials": {}, "after_read": "move", "move_prefix": "logs/processed/"}`
+
+func TestValidate(t *testing.T) {
+	arr := []string{config1, config2, config3, config4}
+	for i, v := range arr {
+		testConfig := defaultConfig()
+		err := json.Unmarshal([]byte(v), &testConfig)
+		assert.Nilf(t, err, "test #%d - failed to unmarshal json: %s", i, err)
+		err = core.ValidateStruct(&testConfig)
+		assert.Nilf(t, err, "test #%d - validation error: %s", i, err)
+	}
+}
+
+func TestValidateFailed(t *testing.T) {
+	arr := []string{badConfig1, badConfig2, badConfig3, badConfig4}
+	for i, v := range arr {
+		testConfig := defaultConfig()
+		err := json.Unmarshal([]byte(v), &testConfig)
+		assert.Nilf(t, err, "test #%d - failed to unmarshal json: %s", i, err)
+		err = core.ValidateStruct(&testConfig)
+		assert.NotNilf(t, err, "test #%d - validation should have returned an error: %s", i, err)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	arr := []string{config1, config2, config3, config4}
+	for i, v := range arr {
+		testConfig := defaultConfig()
+		err := json.Unmarshal([]byte(v), &testConfig)
+		assert.Nilf(t, err, "test #%d - failed to unmarshal json: %s", i, err)
+		handleFunc := Handler()
+		_, err = handleFunc([]byte(v))
+		assert.Nilf(t, err, "test #%d - validation error: %s", i, err)
+	}
+}
+
+func TestHandlerFailed(t *testing.T) {
+	arr := []string{badConfig1, badConfig2, badConfig3, badConfig4, badConfig5, badConfig6}
+	for i, v := range arr {
+		testConfig := defaultConfig()
+		err := json.Unmarshal([]byte(v), &testConfig)
+		assert.Nilf(t, err, "test #%d - failed to unmarshal json: %s", i, err)
+		handleFunc := Handler()
+		_, err = handleFunc([]byte(v))
+		assert.NotNilf(t, err, "test #%d - validation should have returned an error: %s", i, err)
+	}
+}
+
+func TestState(t *testing.T) {
+	state := gcsState{Generation: 100, Names: []string{"a.log"}}
+	assert.True(t, state.processed("old.log", 99))
+	assert.True(t, state.processed("a.log", 100))
+	assert.False(t, state.processed("b.log", 100))
+	assert.False(t, state.processed("a.log", 101))
+
+	state = state.advance("b.log", 100)
+	assert.Equal(t, gcsState{Generation: 100, Names: []string{"a.log", "b.log"}}, state)
+
+	state = state.advance("a.log", 101)
+	assert.Equal(t, gcsState{Generation: 101, Names: []string{"a.log"}}, state)
+
+	objects := []object{{Name: "b", Generation: 2}, {Name: "c", Generation: 1}, {Name: "a", Generation: 2}}
+	sortObjects(objects)
+	assert.Equal(t, []object{{Name: "c", Generation: 1}, {Name: "a", Generation: 2}, {Name: "b", Generation: 2}}, objects)
+}
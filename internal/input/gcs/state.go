@@ -0,0 +1,64 @@
+package gcs
+
+import (
+	"encoding/json"
+	"github.com/ThoronicLLC/collector/pkg/core"
+	"time"
+)
+
+// gcsState tracks the newest object generation processed along with the names processed at exactly that
+// generation. Generations are assigned from the time an object is written, so rewritten objects are read again.
+type gcsState struct {
+	Generation int64    `json:"generation"`
+	Names      []string `json:"names"`
+}
+
+func defaultState(readExisting bool) gcsState {
+	if readExisting {
+		return gcsState{}
+	}
+
+	return gcsState{Generation: time.Now().UnixMicro()}
+}
+
+func loadState(state core.State, readExisting bool) gcsState {
+	if state == nil {
+		return defaultState(readExisting)
+	}
+
+	var loadedState gcsState
+	err := json.Unmarshal(state, &loadedState)
+	if err != nil {
+		return defaultState(readExisting)
+	}
+
+	return loadedState
+}
+
+// processed checks if the object generation has already been read
+func (s gcsState) processed(name string, generation int64) bool {
+	if generation < s.Generation {
+		return true
+	}
+
+	if generation == s.Generation {
+		for _, v := range s.Names {
+			if v == name {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// advance returns the state after the object has been read. Objects must be read in generation order.
+func (s gcsState) advance(name string, generation int64) gcsState {
+	if generation > s.Generation {
+		return gcsState{Generation: generation, Names: []string{name}}
+	}
+
+	names := make([]string, 0, len(s.Names)+1)
+	names = append(names, s.Names...)
+	return gcsState{Generation: s.Generation, Names: append(names, name)}
+}
@@ -0,0 +1,218 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ThoronicLLC/collector/pkg/core"
+	"io"
+	"mime"
+	"net/http"
+)
+
+// requestError is an error caused by the client which is returned with the supplied status code
+type requestError struct {
+	status int
+	err    error
+}
+
+func (e *requestError) Error() string {
+	return e.err.Error()
+}
+
+// readBody reads the request body, decompressing gzip bodies. The body is limited to maxSize bytes both before
+// and after decompression.
+func readBody(w http.ResponseWriter, r *http.Request, maxSize int64) ([]byte, error) {
+	if maxSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+	}
+
+	// Gzip bodies are detected whether or not a content encoding is sent
+	reader, err := core.NewDecompressingReader(r.Body)
+	if err != nil {
+		return nil, &requestError{status: http.StatusBadRequest, err: err}
+	}
+	defer reader.Close()
+
+	var limitedReader io.Reader = reader
+	if maxSize > 0 {
+		limitedReader = io.LimitReader(reader, maxSize+1)
+	}
+
+	body, err := io.ReadAll(limitedReader)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, &requestError{status: http.StatusRequestEntityTooLarge, err: fmt.Errorf("request body too large")}
+		}
+		return nil, &requestError{status: http.StatusBadRequest, err: fmt.Errorf("issue reading request body: %s", err)}
+	}
+
+	if maxSize > 0 && int64(len(body)) > maxSize {
+		return nil, &requestError{status: http.StatusRequestEntityTooLarge, err: fmt.Errorf("request body too large")}
+	}
+
+	return body, nil
+}
+
+// parseBody splits the body into events. JSON arrays produce an event per element, JSON objects (including
+// newline delimited JSON) an event per object and anything else an event per line.
+func parseBody(body []byte, contentType string) ([][]byte, error) {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 {
+		return nil, nil
+	}
+
+	if trimmed[0] == '[' || trimmed[0] == '{' {
+		events, err := parseJSON(trimmed)
+		if err == nil {
+			return events, nil
+		}
+
+		// Only fall back to lines if the client did not claim to send JSON
+		if isJSON(contentType) {
+			return nil, &requestError{status: http.StatusBadRequest, err: err}
+		}
+	}
+
+	events := make([][]byte, 0)
+	err := core.ReadLines(bytes.NewReader(trimmed), func(line []byte) error {
+		events = append(events, append([]byte(nil), line...))
+		return nil
+	})
+	if err != nil {
+		return nil, &requestError{status: http.StatusBadRequest, err: fmt.Errorf("issue reading lines: %s", err)}
+	}
+
+	return events, nil
+}
+
+// parseJSON decodes a stream of JSON values, compacting each event so it is written as a single line
+func parseJSON(body []byte) ([][]byte, error) {
+	events := make([][]byte, 0)
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	for {
+		var value json.RawMessage
+		err := decoder.Decode(&value)
+		if err == io.EOF {
+			return events, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid json: %s", err)
+		}
+
+		// Split arrays into their elements
+		values := []json.RawMessage{value}
+		if bytes.HasPrefix(bytes.TrimSpace(value), []byte("[")) {
+			values = nil
+			err = json.Unmarshal(value, &values)
+			if err != nil {
+				return nil, fmt.Errorf("invalid json: %s", err)
+			}
+		}
+
+		for _, v := range values {
+			var compacted bytes.Buffer
+			err = json.Compact(&compacted, v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid json: %s", err)
+			}
+			events = append(events, compacted.Bytes())
+		}
+	}
+}
+
+// withMetadata adds the metadata to the event under the @metadata key. JSON objects keep their fields, while any
+// other value is stored in a message field. Events that already have an @metadata object have the metadata merged
+// into it.
+func withMetadata(event json.RawMessage, metadata map[string]interface{}) ([]byte, error) {
+	var compacted bytes.Buffer
+	err := json.Compact(&compacted, event)
+	if err != nil {
+		return nil, fmt.Errorf("invalid json: %s", err)
+	}
+
+	fields := compacted.Bytes()
+	if len(fields) == 0 || fields[0] != '{' {
+		fields = append(append([]byte(`{"message":`), fields...), '}')
+	}
+
+	if len(metadata) == 0 {
+		return fields, nil
+	}
+
+	var object map[string]json.RawMessage
+	err = json.Unmarshal(fields, &object)
+	if err != nil {
+		return nil, fmt.Errorf("invalid json: %s", err)
+	}
+
+	if existing, ok := object["@metadata"]; ok {
+		return mergeMetadata(object, existing, metadata)
+	}
+
+	metadataBytes, err := json.Marshal(metadata)
+	if err != nil {
+		return nil, fmt.Errorf("issue marshalling metadata: %s", err)
+	}
+
+	// Append the metadata as the last field of the object
+	result := append([]byte(nil), fields[:len(fields)-1]...)
+	if len(fields) > 2 {
+		result = append(result, ',')
+	}
+	result = append(result, []byte(`"@metadata":`)...)
+	result = append(result, metadataBytes...)
+	return append(result, '}'), nil
+}
+
+// mergeMetadata sets the metadata on the existing @metadata object of the event, replacing fields with the same
+// name. An existing value that is not an object is replaced.
+func mergeMetadata(object map[string]json.RawMessage, existing json.RawMessage, metadata map[string]interface{}) ([]byte, error) {
+	merged := make(map[string]interface{})
+	if bytes.HasPrefix(existing, []byte("{")) {
+		err := json.Unmarshal(existing, &merged)
+		if err != nil {
+			return nil, fmt.Errorf("invalid json: %s", err)
+		}
+	}
+
+	for k, v := range metadata {
+		merged[k] = v
+	}
+
+	metadataBytes, err := json.Marshal(merged)
+	if err != nil {
+		return nil, fmt.Errorf("issue marshalling metadata: %s", err)
+	}
+	object["@metadata"] = metadataBytes
+
+	return json.Marshal(object)
+}
+
+// isJSON checks if the content type is a JSON media type
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return mediaType == "application/json" || mediaType == "application/x-ndjson"
+}
+
+// writeError writes the error response, using the status code of request errors
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		status = reqErr.status
+	}
+
+	writeJSON(w, status, map[string]interface{}{"error": err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,213 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ThoronicLLC/collector/pkg/core"
+	"github.com/google/uuid"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// elasticsearchVersion is the version reported to clients that check the cluster version before sending
+const elasticsearchVersion = "7.10.2"
+
+// bulkItem is the result of a single bulk action
+type bulkItem struct {
+	Action string
+	Index  string
+	ID     string
+	Status int
+	Result string
+}
+
+// bulkAction is the metadata line of a bulk action
+type bulkAction struct {
+	Index string `json:"_index"`
+	ID    string `json:"_id"`
+}
+
+// elasticsearchRoutes serves the Elasticsearch bulk API along with the cluster info clients request on startup
+func (h *httpInput) elasticsearchRoutes(mux *http.ServeMux, batcher *core.Batcher, errorHandler core.ErrorHandler) {
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		// Clients refuse to connect to servers that do not identify as Elasticsearch
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+
+		if !h.authorized(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="collector"`)
+			writeElasticsearchError(w, http.StatusUnauthorized, "security_exception", "unable to authenticate")
+			return
+		}
+
+		// Cluster info
+		if r.URL.Path == "/" && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+			writeJSON(w, http.StatusOK, map[string]interface{}{
+				"name":         "collector",
+				"cluster_name": "collector",
+				"version":      map[string]interface{}{"number": elasticsearchVersion, "build_flavor": "default"},
+				"tagline":      "You Know, for Search",
+			})
+			return
+		}
+
+		// Bulk requests to /_bulk or /{index}/_bulk
+		index, ok := bulkIndex(r.URL.Path)
+		if !ok {
+			writeElasticsearchError(w, http.StatusNotFound, "resource_not_found_exception", fmt.Sprintf("no handler found for uri [%s]", r.URL.Path))
+			return
+		}
+		if r.Method != http.MethodPost && r.Method != http.MethodPut {
+			writeElasticsearchError(w, http.StatusMethodNotAllowed, "method_not_allowed", fmt.Sprintf("incorrect HTTP method for uri [%s]", r.URL.Path))
+			return
+		}
+
+		start := time.Now()
+		body, err := readBody(w, r, h.config.MaxBodySize)
+		if err != nil {
+			status := http.StatusBadRequest
+			var reqErr *requestError
+			if errors.As(err, &reqErr) {
+				status = reqErr.status
+			}
+			writeElasticsearchError(w, status, "parse_exception", err.Error())
+			return
+		}
+
+		events, items, err := parseBulk(body, index)
+		if err != nil {
+			writeElasticsearchError(w, http.StatusBadRequest, "illegal_argument_exception", err.Error())
+			return
+		}
+
+		err = writeEvents(batcher, events)
+		if err != nil {
+			errorHandler(false, err)
+			writeElasticsearchError(w, http.StatusTooManyRequests, "es_rejected_execution_exception", "issue writing events")
+			return
+		}
+
+		writeBulkResponse(w, items, time.Since(start))
+	})
+}
+
+// bulkIndex returns the default index from the bulk request path
+func bulkIndex(path string) (string, bool) {
+	trimmed := strings.Trim(path, "/")
+	if trimmed == "_bulk" {
+		return "", true
+	}
+
+	parts := strings.Split(trimmed, "/")
+	if len(parts) == 2 && parts[1] == "_bulk" && parts[0] != "" {
+		return parts[0], true
+	}
+
+	return "", false
+}
+
+// parseBulk unwraps the actions in a bulk request body. The documents from index, create and update actions are
+// returned as events with the action, index and id stored under the @metadata key.
+func parseBulk(body []byte, defaultIndex string) ([][]byte, []bulkItem, error) {
+	lines := make([][]byte, 0)
+	err := core.ReadLines(bytes.NewReader(body), func(line []byte) error {
+		lines = append(lines, append([]byte(nil), line...))
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("issue reading bulk request: %s", err)
+	}
+
+	events := make([][]byte, 0)
+	items := make([]bulkItem, 0)
+	for i := 0; i < len(lines); i++ {
+		var actionLine map[string]bulkAction
+		err = json.Unmarshal(lines[i], &actionLine)
+		if err != nil || len(actionLine) != 1 {
+			return nil, nil, fmt.Errorf("malformed action/metadata line [%d], expected a single action", i+1)
+		}
+
+		for action, meta := range actionLine {
+			item := bulkItem{Action: action, Index: meta.Index, ID: meta.ID, Status: http.StatusCreated, Result: "created"}
+			if item.Index == "" {
+				item.Index = defaultIndex
+			}
+			if item.ID == "" {
+				item.ID = uuid.New().String()
+			}
+
+			switch action {
+			case "index", "create", "update":
+				if i+1 >= len(lines) {
+					return nil, nil, fmt.Errorf("the bulk request must be terminated by a newline, missing source for action line [%d]", i+1)
+				}
+				i++
+
+				document := json.RawMessage(lines[i])
+				if action == "update" {
+					item.Status, item.Result = http.StatusOK, "updated"
+					document = updateDocument(document)
+				}
+
+				event, err := withMetadata(document, map[string]interface{}{"action": action, "index": item.Index, "id": item.ID})
+				if err != nil {
+					return nil, nil, fmt.Errorf("malformed source line [%d]: %s", i+1, err)
+				}
+				events = append(events, event)
+			case "delete":
+				item.Status, item.Result = http.StatusOK, "deleted"
+			default:
+				return nil, nil, fmt.Errorf("malformed action/metadata line [%d], unknown action [%s]", i+1, action)
+			}
+
+			items = append(items, item)
+		}
+	}
+
+	return events, items, nil
+}
+
+// updateDocument returns the partial document from an update action, or the whole source for scripted updates
+func updateDocument(source json.RawMessage) json.RawMessage {
+	var update struct {
+		Doc json.RawMessage `json:"doc"`
+	}
+	err := json.Unmarshal(source, &update)
+	if err != nil || len(update.Doc) == 0 {
+		return source
+	}
+
+	return update.Doc
+}
+
+// writeBulkResponse writes the bulk response clients use to check which actions succeeded
+func writeBulkResponse(w http.ResponseWriter, items []bulkItem, took time.Duration) {
+	responseItems := make([]map[string]interface{}, 0, len(items))
+	for _, item := range items {
+		responseItems = append(responseItems, map[string]interface{}{
+			item.Action: map[string]interface{}{
+				"_index":   item.Index,
+				"_id":      item.ID,
+				"_version": 1,
+				"result":   item.Result,
+				"status":   item.Status,
+			},
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"took":   took.Milliseconds(),
+		"errors": false,
+		"items":  responseItems,
+	})
+}
+
+// writeElasticsearchError writes the error in the Elasticsearch error format
+func writeElasticsearchError(w http.ResponseWriter, status int, errorType, reason string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error":  map[string]interface{}{"type": errorType, "reason": reason},
+		"status": status,
+	})
+}
@@ -0,0 +1,311 @@
+package http
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ThoronicLLC/collector/internal/integrations/tlsconfig"
+	"github.com/ThoronicLLC/collector/pkg/core"
+	log "github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var InputName = "http"
+
+// shutdownTimeout is how long in-flight requests have to finish once the input is stopped
+const shutdownTimeout = 30 * time.Second
+
+const (
+	modeRaw               = "raw"
+	modeSplunkHEC         = "splunk_hec"
+	modeElasticsearchBulk = "elasticsearch_bulk"
+)
+
+// Config for the http input. In raw mode events are accepted on Path. The splunk_hec and elasticsearch_bulk modes
+// serve the Splunk HTTP Event Collector and Elasticsearch bulk API paths instead.
+type Config struct {
+	Address        string    `json:"address" validate:"required"`
+	Mode           string    `json:"mode" validate:"in:raw,splunk_hec,elasticsearch_bulk"`
+	Path           string    `json:"path" validate:"required"`
+	BearerToken    string    `json:"bearer_token"`
+	Username       string    `json:"username"`
+	Password       string    `json:"password"`
+	TLS            TLSConfig `json:"tls"`
+	MaxBodySize    int64     `json:"max_body_size" validate:"min:0"` // Max request size in bytes after decompression
+	FlushFrequency int       `json:"flush_frequency" validate:"required|min:0"`
+	MaxBatchBytes  int64     `json:"max_batch_bytes" validate:"min:0"`
+	MaxBatchEvents int       `json:"max_batch_events" validate:"min:0"`
+
+	// MaxInFlightBatches is how many batches may be waiting on the outputs before requests wait for one to finish,
+	// so clients see slower responses rather than errors. MaxDiskBytes makes requests wait once the waiting batches
+	// use that many bytes of temp files. Zero disables either limit.
+	MaxInFlightBatches int   `json:"max_in_flight_batches" validate:"min:0"`
+	MaxDiskBytes       int64 `json:"max_disk_bytes" validate:"min:0"`
+}
+
+// TLSConfig is the configuration for serving HTTPS. Client certificates are required when a client CA is supplied.
+type TLSConfig struct {
+	CertFile     string `json:"cert_file"`
+	KeyFile      string `json:"key_file"`
+	ClientCAFile string `json:"client_ca_file"`
+}
+
+type httpInput struct {
+	config     Config
+	ctx        context.Context
+	cancelFunc context.CancelFunc
+}
+
+func Handler() core.InputHandler {
+	return func(config []byte) (core.Input, error) {
+		// Set config defaults
+		conf := defaultConfig()
+
+		// Unmarshal config
+		err := json.Unmarshal(config, &conf)
+		if err != nil {
+			return nil, fmt.Errorf("issue unmarshalling file config: %s", err)
+		}
+
+		// Validate config
+		err = core.ValidateStruct(&conf)
+		if err != nil {
+			return nil, err
+		}
+
+		// Validate auth and TLS settings
+		err = validateConfig(conf)
+		if err != nil {
+			return nil, err
+		}
+
+		// Setup context
+		ctx, cancelFn := context.WithCancel(context.Background())
+
+		return &httpInput{
+			config:     conf,
+			ctx:        ctx,
+			cancelFunc: cancelFn,
+		}, nil
+	}
+}
+
+func (h *httpInput) Run(errorHandler core.ErrorHandler, state core.State, processPipe chan<- core.PipelineResults) {
+	// Setup local variables
+	batcher, err := core.NewBatcher(h.ctx, core.BatchConfig{
+		FlushFrequency:     h.config.FlushFrequency,
+		MaxBatchBytes:      h.config.MaxBatchBytes,
+		MaxBatchEvents:     h.config.MaxBatchEvents,
+		MaxInFlightBatches: h.config.MaxInFlightBatches,
+		MaxDiskBytes:       h.config.MaxDiskBytes,
+		DropPolicy:         core.DropPolicyBlock,
+	}, processPipe)
+	if err != nil {
+		errorHandler(true, err)
+		return
+	}
+
+	// Setup server
+	server := &http.Server{
+		Handler:           h.routes(batcher, errorHandler),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	// Setup TLS
+	if h.config.TLS.CertFile != "" {
+		server.TLSConfig, err = tlsconfig.NewServerConfig(h.config.TLS.CertFile, h.config.TLS.KeyFile, h.config.TLS.ClientCAFile)
+		if err != nil {
+			errorHandler(true, err)
+			return
+		}
+	}
+
+	listener, err := net.Listen("tcp", h.config.Address)
+	if err != nil {
+		errorHandler(true, fmt.Errorf("unable to start http listener on %s: %s", h.config.Address, err))
+		return
+	}
+	if server.TLSConfig != nil {
+		listener = tls.NewListener(listener, server.TLSConfig)
+	}
+
+	// Setup wait group. The flush context is only cancelled once the server has finished every request, so the
+	// final flush includes every event received.
+	var wg sync.WaitGroup
+	flushCtx, flushCancelFn := context.WithCancel(context.Background())
+
+	// Start timed process sync go routine
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		batcher.Run(flushCtx, errorHandler)
+	}()
+
+	// Shutdown the server once the input is stopped
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer flushCancelFn()
+		<-h.ctx.Done()
+
+		shutdownCtx, cancelFn := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancelFn()
+		err := server.Shutdown(shutdownCtx)
+		if err != nil {
+			errorHandler(false, fmt.Errorf("issue shutting down http server: %s", err))
+		}
+	}()
+
+	log.Debugf("http input listening on %s%s", h.config.Address, h.config.Path)
+	err = server.Serve(listener)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		errorHandler(true, fmt.Errorf("http server stopped: %s", err))
+		h.cancelFunc()
+	}
+
+	wg.Wait()
+}
+
+func (h *httpInput) Stop() {
+	h.cancelFunc()
+}
+
+// routes builds the request handler for the server
+func (h *httpInput) routes(batcher *core.Batcher, errorHandler core.ErrorHandler) http.Handler {
+	mux := http.NewServeMux()
+	switch h.config.Mode {
+	case modeSplunkHEC:
+		h.splunkRoutes(mux, batcher, errorHandler)
+		return mux
+	case modeElasticsearchBulk:
+		h.elasticsearchRoutes(mux, batcher, errorHandler)
+		return mux
+	}
+
+	mux.HandleFunc(h.config.Path, h.authenticate(func(w http.ResponseWriter, r *http.Request) {
+		events, err := h.readEvents(w, r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		err = writeEvents(batcher, events)
+		if err != nil {
+			errorHandler(false, err)
+			writeJSON(w, http.StatusInternalServerError, map[string]interface{}{"error": "issue writing events"})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": "ok", "events": len(events)})
+	}))
+	return mux
+}
+
+// authenticate only allows POST requests with valid credentials through to the handler
+func (h *httpInput) authenticate(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]interface{}{"error": "method not allowed"})
+			return
+		}
+
+		if !h.authorized(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="collector"`)
+			writeJSON(w, http.StatusUnauthorized, map[string]interface{}{"error": "unauthorized"})
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// authorized checks the request credentials. When both a bearer token and basic auth are configured either is
+// accepted. Splunk clients send the token with the Splunk scheme instead of Bearer.
+func (h *httpInput) authorized(r *http.Request) bool {
+	if h.config.BearerToken == "" && h.config.Username == "" {
+		return true
+	}
+
+	if h.config.BearerToken != "" {
+		authorization := r.Header.Get("Authorization")
+		for _, scheme := range []string{"Bearer ", "Splunk "} {
+			if strings.HasPrefix(authorization, scheme) && secureCompare(strings.TrimPrefix(authorization, scheme), h.config.BearerToken) {
+				return true
+			}
+		}
+	}
+
+	if h.config.Username != "" {
+		username, password, ok := r.BasicAuth()
+		if ok && secureCompare(username, h.config.Username) && secureCompare(password, h.config.Password) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readEvents decompresses and parses the request body
+func (h *httpInput) readEvents(w http.ResponseWriter, r *http.Request) ([][]byte, error) {
+	body, err := readBody(w, r, h.config.MaxBodySize)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseBody(body, r.Header.Get("Content-Type"))
+}
+
+// writeEvents writes the events to the current batch
+func writeEvents(batcher *core.Batcher, events [][]byte) error {
+	for _, event := range events {
+		_, err := batcher.Write(event)
+		if err != nil {
+			return fmt.Errorf("issue writing event: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// validateConfig checks the auth and TLS settings are complete
+func validateConfig(conf Config) error {
+	if conf.Username != "" && conf.Password == "" {
+		return fmt.Errorf("basic auth requires a username and password")
+	}
+
+	if conf.Password != "" && conf.Username == "" {
+		return fmt.Errorf("basic auth requires a username and password")
+	}
+
+	if (conf.TLS.CertFile == "") != (conf.TLS.KeyFile == "") {
+		return fmt.Errorf("tls requires a cert_file and key_file")
+	}
+
+	if conf.TLS.ClientCAFile != "" && conf.TLS.CertFile == "" {
+		return fmt.Errorf("client_ca_file requires a cert_file and key_file")
+	}
+
+	return nil
+}
+
+func secureCompare(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+func defaultConfig() Config {
+	return Config{
+		Address:        ":8080",
+		Mode:           modeRaw,
+		Path:           "/",
+		MaxBodySize:    10 * 1024 * 1024,
+		FlushFrequency: 300,
+	}
+}
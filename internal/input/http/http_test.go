@@ -0,0 +1,193 @@
+package http
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"github.com/ThoronicLLC/collector/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+var config1 = `{"address": ":8080", "flush_frequency": 60}`
+var config2 = `{"address": "127.0.0.1:9000", "path": "/webhook", "bearer_token": "secret", "max_body_size": 1024}`
+var config3 = `{"address": ":8443", "username": "user", "password": "pass", "tls": {"cert_file": "/tmp/cert.pem", "key_file": "/tmp/key.pem", "client_ca_file": "/tmp/ca.pem"}}`
+var config4 = `{"address": ":8088", "mode": "splunk_hec", "bearer_token": "secret"}`
+var config5 = `{"address": ":9200", "mode": "elasticsearch_bulk", "username": "elastic", "password": "changeme"}`
+var badConfig1 = `{"address": ""}`
+var badConfig2 = `{"address": ":8080", "flush_frequency": 0}`
+var badConfig3 = `{"address": ":8080", "max_body_size": -1}`
+var badConfig4 = `{"address": ":8080", "username": "user"}`
+var badConfig5 = `{"address": ":8080", "tls": {"cert_file": "/tmp/cert.pem"}}`
+var badConfig6 = `{"address": ":8080", "tls": {"client_ca_file": "/tmp/ca.pem"}}`
+var badConfig7 = `{"address": ":8080", "mode": "splunk"}`
+
+func TestValidate(t *testing.T) {
+	arr := []string{config1, config2, config3, config4, config5}
+	for i, v := range arr {
+		testConfig := defaultConfig()
+		err := json.Unmarshal([]byte(v), &testConfig)
+		assert.Nilf(t, err, "test #%d - failed to unmarshal json: %s", i, err)
+		err = core.ValidateStruct(&testConfig)
+		assert.Nilf(t, err, "test #%d - validation error: %s", i, err)
+	}
+}
+
+func TestValidateFailed(t *testing.T) {
+	arr := []string{badConfig1, badConfig2, badConfig3, badConfig7}
+	for i, v := range arr {
+		testConfig := defaultConfig()
+		err := json.Unmarshal([]byte(v), &testConfig)
+		assert.Nilf(t, err, "test #%d - failed to unmarshal json: %s", i, err)
+		err = core.ValidateStruct(&testConfig)
+		assert.NotNilf(t, err, "test #%d - validation should have returned an error: %s", i, err)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	arr := []string{config1, config2, config3, config4, config5}
+	for i, v := range arr {
+		handleFunc := Handler()
+		_, err := handleFunc([]byte(v))
+		assert.Nilf(t, err, "test #%d - validation error: %s", i, err)
+	}
+}
+
+func TestHandlerFailed(t *testing.T) {
+	arr := []string{badConfig1, badConfig2, badConfig3, badConfig4, badConfig5, badConfig6, badConfig7}
+	for i, v := range arr {
+		handleFunc := Handler()
+		_, err := handleFunc([]byte(v))
+		assert.NotNilf(t, err, "test #%d - validation should have returned an error: %s", i, err)
+	}
+}
+
+func TestParseBody(t *testing.T) {
+	tests := []struct {
+		body        string
+		contentType string
+		expected    []string
+	}{
+		{`[{"a": 1}, {"b": 2}]`, "application/json", []string{`{"a":1}`, `{"b":2}`}},
+		{"{\"a\": 1}\n{\"b\": 2}\n", "application/x-ndjson", []string{`{"a":1}`, `{"b":2}`}},
+		{"{\n  \"a\": 1\n}", "application/json", []string{`{"a":1}`}},
+		{"line 1\n\nline 2\n", "text/plain", []string{"line 1", "line 2"}},
+		{"{not json}\nline 2", "text/plain", []string{"{not json}", "line 2"}},
+	}
+	for i, test := range tests {
+		events, err := parseBody([]byte(test.body), test.contentType)
+		assert.Nilf(t, err, "test #%d - parse error: %s", i, err)
+		actual := make([]string, 0)
+		for _, v := range events {
+			actual = append(actual, string(v))
+		}
+		assert.Equalf(t, test.expected, actual, "test #%d", i)
+	}
+
+	// Invalid JSON is rejected when the client sends a JSON content type
+	_, err := parseBody([]byte("{not json}"), "application/json; charset=utf-8")
+	assert.NotNil(t, err)
+}
+
+func TestRoutes(t *testing.T) {
+	processPipe := make(chan core.PipelineResults, 10)
+	batcher, err := core.NewBatcher(context.Background(), core.BatchConfig{FlushFrequency: 300}, processPipe)
+	assert.Nil(t, err)
+
+	conf := defaultConfig()
+	conf.BearerToken = "secret"
+	input := &httpInput{config: conf}
+	server := httptest.NewServer(input.routes(batcher, func(bool, error) {}))
+	defer server.Close()
+
+	// Missing token
+	response, err := http.Post(server.URL, "application/json", strings.NewReader(`{"a": 1}`))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, response.StatusCode)
+
+	// Gzip compressed NDJSON
+	var compressed bytes.Buffer
+	gzipWriter := gzip.NewWriter(&compressed)
+	_, _ = gzipWriter.Write([]byte("{\"a\": 1}\n{\"b\": 2}\n"))
+	_ = gzipWriter.Close()
+
+	request, _ := http.NewRequest(http.MethodPost, server.URL, &compressed)
+	request.Header.Set("Authorization", "Bearer secret")
+	request.Header.Set("Content-Encoding", "gzip")
+	response, err = http.DefaultClient.Do(request)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+
+	assert.Nil(t, batcher.Flush())
+	res := <-processPipe
+	assert.Equal(t, 2, res.ResultCount)
+	content, _ := os.ReadFile(res.FilePath)
+	assert.Equal(t, "{\"a\":1}\n{\"b\":2}\n", string(content))
+	_ = os.Remove(res.FilePath)
+}
+
+func TestWithMetadata(t *testing.T) {
+	event, err := withMetadata(json.RawMessage(`{"a": 1}`), map[string]interface{}{"host": "web-1"})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"a":1,"@metadata":{"host":"web-1"}}`, string(event))
+
+	// Existing metadata is merged instead of adding a second key
+	event, err = withMetadata(json.RawMessage(`{"a": 1, "@metadata": {"host": "old", "tenant": "t1"}}`), map[string]interface{}{"host": "web-1"})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"@metadata":{"host":"web-1","tenant":"t1"},"a":1}`, string(event))
+
+	event, err = withMetadata(json.RawMessage(`{"@metadata": "old"}`), map[string]interface{}{"host": "web-1"})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"@metadata":{"host":"web-1"}}`, string(event))
+}
+
+func TestParseHECEvents(t *testing.T) {
+	body := `{"time": 1426279439.123, "host": "web-1", "sourcetype": "access", "event": {"action": "login"}}{"event": "plain text", "fields": {"env": "prod"}}`
+	events, err := parseHECEvents([]byte(body))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(events))
+	assert.Equal(t, `{"action":"login","@metadata":{"host":"web-1","sourcetype":"access","time":1426279439.123}}`, string(events[0]))
+	assert.Equal(t, `{"message":"plain text","@metadata":{"fields":{"env":"prod"}}}`, string(events[1]))
+
+	// Envelopes must contain an event
+	_, err = parseHECEvents([]byte(`{"event": "one"}{"host": "web-1"}`))
+	var hecErr *hecError
+	assert.ErrorAs(t, err, &hecErr)
+	assert.Equal(t, hecCodeEventRequired, hecErr.code)
+	assert.Equal(t, 1, hecErr.eventIndex)
+}
+
+func TestParseBulk(t *testing.T) {
+	body := `{"index": {"_index": "logs", "_id": "1"}}
+{"message": "one"}
+{"create": {}}
+{"message": "two"}
+{"update": {"_id": "2"}}
+{"doc": {"message": "three"}}
+{"delete": {"_id": "3"}}
+`
+	events, items, err := parseBulk([]byte(body), "default")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(events))
+	assert.Equal(t, `{"message":"one","@metadata":{"action":"index","id":"1","index":"logs"}}`, string(events[0]))
+	assert.Equal(t, `{"message":"three","@metadata":{"action":"update","id":"2","index":"default"}}`, string(events[2]))
+	assert.Equal(t, 4, len(items))
+	assert.Equal(t, "default", items[1].Index)
+	assert.NotEmpty(t, items[1].ID)
+	assert.Equal(t, "delete", items[3].Action)
+
+	// Actions must be followed by their source
+	_, _, err = parseBulk([]byte(`{"index": {}}`), "")
+	assert.NotNil(t, err)
+
+	index, ok := bulkIndex("/logs/_bulk")
+	assert.True(t, ok)
+	assert.Equal(t, "logs", index)
+	_, ok = bulkIndex("/logs/_search")
+	assert.False(t, ok)
+}
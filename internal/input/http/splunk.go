@@ -0,0 +1,217 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ThoronicLLC/collector/pkg/core"
+	"io"
+	"net/http"
+)
+
+// Splunk HTTP Event Collector response codes
+//
+// https://docs.splunk.com/Documentation/Splunk/latest/Data/TroubleshootHTTPEventCollector#Possible_error_codes
+const (
+	hecCodeSuccess       = 0
+	hecCodeTokenRequired = 2
+	hecCodeInvalidToken  = 4
+	hecCodeNoData        = 5
+	hecCodeInvalidFormat = 6
+	hecCodeServerBusy    = 9
+	hecCodeEventRequired = 12
+	hecCodeEventBlank    = 13
+	hecCodeHealthy       = 17
+	hecCodeNotFound      = 404
+)
+
+// hecEvent is the envelope sent to the HEC event endpoint
+type hecEvent struct {
+	Time       json.RawMessage        `json:"time"`
+	Host       string                 `json:"host"`
+	Source     string                 `json:"source"`
+	SourceType string                 `json:"sourcetype"`
+	Index      string                 `json:"index"`
+	Event      json.RawMessage        `json:"event"`
+	Fields     map[string]interface{} `json:"fields"`
+}
+
+// hecError is a HEC error response
+type hecError struct {
+	status     int
+	code       int
+	text       string
+	eventIndex int
+}
+
+func (e *hecError) Error() string {
+	return e.text
+}
+
+// splunkRoutes serves the Splunk HTTP Event Collector event, raw and health endpoints
+func (h *httpInput) splunkRoutes(mux *http.ServeMux, batcher *core.Batcher, errorHandler core.ErrorHandler) {
+	eventHandler := h.splunkHandler(batcher, errorHandler, func(r *http.Request, body []byte) ([][]byte, error) {
+		return parseHECEvents(body)
+	})
+	rawHandler := h.splunkHandler(batcher, errorHandler, func(r *http.Request, body []byte) ([][]byte, error) {
+		return parseHECRaw(r, body)
+	})
+
+	for _, path := range []string{"/services/collector", "/services/collector/event", "/services/collector/event/1.0"} {
+		mux.HandleFunc(path, eventHandler)
+	}
+	for _, path := range []string{"/services/collector/raw", "/services/collector/raw/1.0"} {
+		mux.HandleFunc(path, rawHandler)
+	}
+	mux.HandleFunc("/services/collector/health", func(w http.ResponseWriter, r *http.Request) {
+		writeHECResponse(w, http.StatusOK, hecCodeHealthy, "HEC is healthy")
+	})
+}
+
+// splunkHandler authenticates HEC requests and writes the parsed events
+func (h *httpInput) splunkHandler(batcher *core.Batcher, errorHandler core.ErrorHandler, parse func(r *http.Request, body []byte) ([][]byte, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Splunk only serves the collector endpoints for POST requests
+		if r.Method != http.MethodPost {
+			writeHECResponse(w, http.StatusNotFound, hecCodeNotFound, "The requested URL was not found on this server.")
+			return
+		}
+
+		if !h.authorized(r) {
+			if r.Header.Get("Authorization") == "" {
+				writeHECResponse(w, http.StatusUnauthorized, hecCodeTokenRequired, "Token is required")
+			} else {
+				writeHECResponse(w, http.StatusForbidden, hecCodeInvalidToken, "Invalid token")
+			}
+			return
+		}
+
+		body, err := readBody(w, r, h.config.MaxBodySize)
+		if err != nil {
+			writeHECError(w, err)
+			return
+		}
+
+		if len(bytes.TrimSpace(body)) == 0 {
+			writeHECResponse(w, http.StatusBadRequest, hecCodeNoData, "No data")
+			return
+		}
+
+		events, err := parse(r, body)
+		if err != nil {
+			writeHECError(w, err)
+			return
+		}
+
+		err = writeEvents(batcher, events)
+		if err != nil {
+			errorHandler(false, err)
+			writeHECResponse(w, http.StatusServiceUnavailable, hecCodeServerBusy, "Server is busy")
+			return
+		}
+
+		writeHECResponse(w, http.StatusOK, hecCodeSuccess, "Success")
+	}
+}
+
+// parseHECEvents unwraps a stream of HEC event envelopes. The metadata sent with each event is stored under the
+// @metadata key.
+func parseHECEvents(body []byte) ([][]byte, error) {
+	events := make([][]byte, 0)
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	for i := 0; ; i++ {
+		var envelope hecEvent
+		err := decoder.Decode(&envelope)
+		if err == io.EOF {
+			return events, nil
+		} else if err != nil {
+			return nil, &hecError{status: http.StatusBadRequest, code: hecCodeInvalidFormat, text: "Invalid data format", eventIndex: i}
+		}
+
+		trimmedEvent := bytes.TrimSpace(envelope.Event)
+		if len(trimmedEvent) == 0 || bytes.Equal(trimmedEvent, []byte("null")) {
+			return nil, &hecError{status: http.StatusBadRequest, code: hecCodeEventRequired, text: "Event field is required", eventIndex: i}
+		}
+		if bytes.Equal(trimmedEvent, []byte(`""`)) {
+			return nil, &hecError{status: http.StatusBadRequest, code: hecCodeEventBlank, text: "Event field cannot be blank", eventIndex: i}
+		}
+
+		metadata := hecMetadata(envelope.Host, envelope.Source, envelope.SourceType, envelope.Index)
+		if len(envelope.Time) > 0 && !bytes.Equal(envelope.Time, []byte("null")) {
+			metadata["time"] = envelope.Time
+		}
+		if len(envelope.Fields) > 0 {
+			metadata["fields"] = envelope.Fields
+		}
+
+		event, err := withMetadata(envelope.Event, metadata)
+		if err != nil {
+			return nil, &hecError{status: http.StatusBadRequest, code: hecCodeInvalidFormat, text: "Invalid data format", eventIndex: i}
+		}
+		events = append(events, event)
+	}
+}
+
+// parseHECRaw returns an event per line of the raw body, with the metadata from the query string
+func parseHECRaw(r *http.Request, body []byte) ([][]byte, error) {
+	query := r.URL.Query()
+	metadata := hecMetadata(query.Get("host"), query.Get("source"), query.Get("sourcetype"), query.Get("index"))
+
+	events := make([][]byte, 0)
+	err := core.ReadLines(bytes.NewReader(body), func(line []byte) error {
+		message, err := json.Marshal(string(line))
+		if err != nil {
+			return err
+		}
+
+		event, err := withMetadata(message, metadata)
+		if err != nil {
+			return err
+		}
+
+		events = append(events, event)
+		return nil
+	})
+	if err != nil {
+		return nil, &hecError{status: http.StatusBadRequest, code: hecCodeInvalidFormat, text: "Invalid data format"}
+	}
+
+	return events, nil
+}
+
+// hecMetadata builds the event metadata, leaving out empty values
+func hecMetadata(host, source, sourceType, index string) map[string]interface{} {
+	metadata := make(map[string]interface{})
+	for k, v := range map[string]string{"host": host, "source": source, "sourcetype": sourceType, "index": index} {
+		if v != "" {
+			metadata[k] = v
+		}
+	}
+	return metadata
+}
+
+// writeHECError writes the error in the HEC response format
+func writeHECError(w http.ResponseWriter, err error) {
+	var hecErr *hecError
+	if errors.As(err, &hecErr) {
+		body := map[string]interface{}{"text": hecErr.text, "code": hecErr.code}
+		if hecErr.code == hecCodeInvalidFormat || hecErr.code == hecCodeEventRequired || hecErr.code == hecCodeEventBlank {
+			body["invalid-event-number"] = hecErr.eventIndex
+		}
+		writeJSON(w, hecErr.status, body)
+		return
+	}
+
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		writeHECResponse(w, reqErr.status, hecCodeInvalidFormat, fmt.Sprintf("Invalid data format: %s", reqErr))
+		return
+	}
+
+	writeHECResponse(w, http.StatusInternalServerError, hecCodeServerBusy, "Server is busy")
+}
+
+func writeHECResponse(w http.ResponseWriter, status int, code int, text string) {
+	writeJSON(w, status, map[string]interface{}{"text": text, "code": code})
+}
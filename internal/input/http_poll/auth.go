@@ -0,0 +1,111 @@
+package http_poll
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-resty/resty/v2"
+	"golang.org/x/oauth2/clientcredentials"
+	"net/http"
+)
+
+// AuthConfig is the configuration for authenticating API requests. Only one method may be enabled.
+type AuthConfig struct {
+	Bearer AuthBearerConfig `json:"bearer"`
+	Basic  AuthBasicConfig  `json:"basic"`
+	OAuth2 AuthOAuth2Config `json:"oauth2"`
+	APIKey AuthAPIKeyConfig `json:"api_key"`
+}
+
+// AuthBearerConfig is the configuration for a static bearer token
+type AuthBearerConfig struct {
+	Enabled bool   `json:"enabled"`
+	Token   string `json:"token"`
+}
+
+// AuthBasicConfig is the configuration for basic auth
+type AuthBasicConfig struct {
+	Enabled  bool   `json:"enabled"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// AuthOAuth2Config is the configuration for the OAuth2 client credentials flow. Tokens are refreshed automatically.
+type AuthOAuth2Config struct {
+	Enabled        bool              `json:"enabled"`
+	TokenURL       string            `json:"token_url"`
+	ClientID       string            `json:"client_id"`
+	ClientSecret   string            `json:"client_secret"`
+	Scopes         []string          `json:"scopes"`
+	EndpointParams map[string]string `json:"endpoint_params"`
+}
+
+// AuthAPIKeyConfig is the configuration for an API key sent in a request header
+type AuthAPIKeyConfig struct {
+	Enabled bool   `json:"enabled"`
+	Header  string `json:"header"`
+	Value   string `json:"value"`
+	Prefix  string `json:"prefix"` // Optional scheme sent before the key, such as "SSWS" for Okta
+}
+
+// validateAuthConfig checks that one auth method at most is enabled and it has the required settings
+func validateAuthConfig(conf AuthConfig) error {
+	enabled := 0
+	for _, v := range []bool{conf.Bearer.Enabled, conf.Basic.Enabled, conf.OAuth2.Enabled, conf.APIKey.Enabled} {
+		if v {
+			enabled++
+		}
+	}
+	if enabled > 1 {
+		return fmt.Errorf("only one of bearer, basic, oauth2 or api_key auth may be enabled")
+	}
+
+	switch {
+	case conf.Bearer.Enabled && conf.Bearer.Token == "":
+		return fmt.Errorf("bearer auth requires a token")
+	case conf.Basic.Enabled && conf.Basic.Username == "":
+		return fmt.Errorf("basic auth requires a username")
+	case conf.OAuth2.Enabled && (conf.OAuth2.TokenURL == "" || conf.OAuth2.ClientID == "" || conf.OAuth2.ClientSecret == ""):
+		return fmt.Errorf("oauth2 auth requires a token_url, client_id and client_secret")
+	case conf.APIKey.Enabled && (conf.APIKey.Header == "" || conf.APIKey.Value == ""):
+		return fmt.Errorf("api_key auth requires a header and value")
+	}
+
+	return nil
+}
+
+// newRestyClient creates a client that authenticates every request with the configured method
+func newRestyClient(ctx context.Context, conf AuthConfig) *resty.Client {
+	var client *resty.Client
+	if conf.OAuth2.Enabled {
+		params := make(map[string][]string)
+		for k, v := range conf.OAuth2.EndpointParams {
+			params[k] = []string{v}
+		}
+
+		oauthConfig := clientcredentials.Config{
+			ClientID:       conf.OAuth2.ClientID,
+			ClientSecret:   conf.OAuth2.ClientSecret,
+			TokenURL:       conf.OAuth2.TokenURL,
+			Scopes:         conf.OAuth2.Scopes,
+			EndpointParams: params,
+		}
+		client = resty.NewWithClient(oauthConfig.Client(ctx))
+	} else {
+		client = resty.NewWithClient(&http.Client{})
+	}
+
+	switch {
+	case conf.Bearer.Enabled:
+		client.SetAuthToken(conf.Bearer.Token)
+	case conf.Basic.Enabled:
+		client.SetBasicAuth(conf.Basic.Username, conf.Basic.Password)
+	case conf.APIKey.Enabled:
+		value := conf.APIKey.Value
+		if conf.APIKey.Prefix != "" {
+			value = fmt.Sprintf("%s %s", conf.APIKey.Prefix, value)
+		}
+		client.SetHeader(conf.APIKey.Header, value)
+	}
+
+	return client
+}
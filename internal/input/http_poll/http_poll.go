@@ -0,0 +1,407 @@
+package http_poll
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ThoronicLLC/collector/pkg/core"
+	"github.com/go-resty/resty/v2"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/pretty"
+	"io"
+	"net/http"
+	"net/url"
+	"text/template"
+	"time"
+)
+
+var InputName = "http_poll"
+
+// Config for the http_poll input. The URL, params, headers and body are Go templates with the watermark available
+// as {{.Start}}, {{.End}} and {{.Cursor}}.
+type Config struct {
+	URL        string            `json:"url" validate:"required"`
+	Method     string            `json:"method" validate:"in:GET,POST"`
+	Params     map[string]string `json:"params"`
+	Headers    map[string]string `json:"headers"`
+	Body       string            `json:"body"`
+	AuthConfig AuthConfig        `json:"auth_config"`
+	Schedule   int               `json:"schedule" validate:"required|min:0"`
+	MaxPages   int               `json:"max_pages" validate:"required|min:1"`
+	Timeout    int               `json:"timeout" validate:"min:0"` // Request timeout in seconds
+
+	// RecordsPath is the gjson path to the records in the response. The whole response is used when empty.
+	RecordsPath string           `json:"records_path"`
+	Pagination  PaginationConfig `json:"pagination"`
+	Watermark   WatermarkConfig  `json:"watermark"`
+}
+
+type httpPollInput struct {
+	config     Config
+	ctx        context.Context
+	cancelFunc context.CancelFunc
+	client     *resty.Client
+	templates  *requestTemplates
+}
+
+// templateData is the watermark available to the request templates
+type templateData struct {
+	Start  string
+	End    string
+	Cursor string
+}
+
+// requestTemplates are the parsed templates for the first request of each poll
+type requestTemplates struct {
+	url     *template.Template
+	params  map[string]*template.Template
+	headers map[string]*template.Template
+	body    *template.Template
+}
+
+// renderedRequest is the first request of a poll with the templates applied
+type renderedRequest struct {
+	page    pageRequest
+	headers map[string]string
+	body    string
+}
+
+func Handler() core.InputHandler {
+	return func(config []byte) (core.Input, error) {
+		// Set config defaults
+		conf := defaultConfig()
+
+		// Unmarshal config
+		err := json.Unmarshal(config, &conf)
+		if err != nil {
+			return nil, fmt.Errorf("issue unmarshalling file config: %s", err)
+		}
+
+		// Validate config
+		err = core.ValidateStruct(&conf)
+		if err != nil {
+			return nil, err
+		}
+
+		err = validateConfig(conf)
+		if err != nil {
+			return nil, err
+		}
+
+		// Parse request templates
+		templates, err := parseTemplates(conf)
+		if err != nil {
+			return nil, err
+		}
+
+		// Setup context
+		ctx, cancelFn := context.WithCancel(context.Background())
+
+		// Setup client
+		client := newRestyClient(ctx, conf.AuthConfig)
+		client.SetTimeout(time.Duration(conf.Timeout) * time.Second)
+
+		return &httpPollInput{
+			config:     conf,
+			ctx:        ctx,
+			cancelFunc: cancelFn,
+			client:     client,
+			templates:  templates,
+		}, nil
+	}
+}
+
+func defaultConfig() Config {
+	return Config{
+		Method:     http.MethodGet,
+		Schedule:   60,
+		MaxPages:   1000,
+		Timeout:    60,
+		Pagination: PaginationConfig{Type: paginationNone, StartPage: 1},
+		Watermark:  WatermarkConfig{Type: watermarkNone, Format: "rfc3339"},
+	}
+}
+
+// Run will execute the input with the supplied context and state and return results
+func (input *httpPollInput) Run(errorHandler core.ErrorHandler, state core.State, processPipe chan<- core.PipelineResults) {
+	// Validate and load state
+	currentState := loadState(state, input.config.Watermark)
+
+	for {
+		select {
+		case <-input.ctx.Done():
+			return
+		case <-time.After(time.Duration(input.config.Schedule) * time.Second):
+			currentStateBytes, err := json.Marshal(currentState)
+			if err != nil {
+				errorHandler(false, fmt.Errorf("issue marshalling current state: %s", err))
+				continue
+			}
+
+			writer, err := core.NewResultWriter(0, currentStateBytes, processPipe)
+			if err != nil {
+				errorHandler(false, fmt.Errorf("issue opening a new result writer: %s", err))
+				continue
+			}
+
+			// Discard the results on error so the same window is requested again next run
+			newState, err := input.poll(currentState, writer)
+			if err != nil {
+				writer.Discard()
+				errorHandler(false, err)
+				continue
+			}
+
+			// Marshal new state
+			newStateBytes, err := json.Marshal(newState)
+			if err != nil {
+				writer.Discard()
+				errorHandler(false, fmt.Errorf("issue marshalling new state: %s", err))
+				continue
+			}
+
+			err = writer.Flush(newStateBytes)
+			if err != nil {
+				writer.Discard()
+				errorHandler(false, err)
+				continue
+			}
+
+			// Update current state to the new state since successful run
+			currentState = newState
+		}
+	}
+}
+
+func (input *httpPollInput) Stop() {
+	input.cancelFunc()
+}
+
+// poll requests every page from the current watermark and writes the records, returning the new watermark. The
+// current watermark is returned when max_pages stops the poll before the last page.
+func (input *httpPollInput) poll(state httpPollState, writer io.Writer) (httpPollState, error) {
+	end := time.Now().UTC()
+	request, err := input.templates.render(templateData{
+		Start:  formatTime(state.Time, input.config.Watermark.Format),
+		End:    formatTime(end, input.config.Watermark.Format),
+		Cursor: state.Cursor,
+	})
+	if err != nil {
+		return state, err
+	}
+
+	tracker := newWatermarkTracker(input.config.Watermark, state, end)
+	page := firstPage(input.config.Pagination, request.page)
+	for i := 0; ; i++ {
+		// The pages left are not read, so the next poll starts from the same watermark
+		if i >= input.config.MaxPages {
+			log.Warnf("http_poll stopped after reading max_pages (%d) from %s, the next poll starts from the same watermark", input.config.MaxPages, input.config.URL)
+			return state, nil
+		}
+
+		req := input.client.R().
+			SetContext(input.ctx).
+			SetHeaders(request.headers).
+			SetQueryParamsFromValues(page.params)
+		if request.body != "" {
+			req.SetBody(request.body)
+		}
+
+		response, err := req.Execute(input.config.Method, page.url)
+		if err != nil {
+			return state, fmt.Errorf("issue requesting %s: %s", page.url, err)
+		}
+
+		if response.IsError() {
+			return state, fmt.Errorf("unexpected status code %d from %s: %s", response.StatusCode(), page.url, truncate(response.Body(), 512))
+		}
+
+		records, err := extractRecords(response.Body(), input.config.RecordsPath)
+		if err != nil {
+			return state, fmt.Errorf("issue reading response from %s: %s", page.url, err)
+		}
+
+		for _, record := range records {
+			// Records read by the last poll are returned again from the start of the watermark
+			if tracker.seen(record) {
+				continue
+			}
+
+			_, err = writer.Write(recordBytes(record))
+			if err != nil {
+				return state, fmt.Errorf("issue writing record to temp file: %s", err)
+			}
+			tracker.observe(record)
+		}
+
+		next, ok, err := nextPage(input.config.Pagination, page, response, len(records))
+		if err != nil {
+			return state, err
+		}
+		if !ok {
+			break
+		}
+		page = next
+	}
+
+	return tracker.result(), nil
+}
+
+// extractRecords returns the records at the path in the response. Arrays return a record per element and any
+// other value a single record.
+func extractRecords(body []byte, path string) ([]gjson.Result, error) {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil, nil
+	}
+
+	if !gjson.ValidBytes(body) {
+		return nil, fmt.Errorf("invalid json")
+	}
+
+	value := gjson.ParseBytes(body)
+	if path != "" {
+		value = value.Get(path)
+	}
+
+	if !value.Exists() || value.Type == gjson.Null {
+		return nil, nil
+	}
+
+	if value.IsArray() {
+		return value.Array(), nil
+	}
+
+	return []gjson.Result{value}, nil
+}
+
+// recordBytes compacts JSON records onto a single line and unquotes strings
+func recordBytes(record gjson.Result) []byte {
+	if record.Type == gjson.String {
+		return []byte(record.String())
+	}
+	return pretty.Ugly([]byte(record.Raw))
+}
+
+func truncate(body []byte, size int) string {
+	if len(body) > size {
+		return string(body[:size]) + "..."
+	}
+	return string(body)
+}
+
+// validateConfig checks the auth, pagination and watermark settings
+func validateConfig(conf Config) error {
+	err := validateAuthConfig(conf.AuthConfig)
+	if err != nil {
+		return err
+	}
+
+	err = validatePaginationConfig(conf.Pagination)
+	if err != nil {
+		return err
+	}
+
+	return validateWatermarkConfig(conf.Watermark)
+}
+
+// parseTemplates parses the request templates so mistakes are reported when the config is loaded
+func parseTemplates(conf Config) (*requestTemplates, error) {
+	parse := func(name, text string) (*template.Template, error) {
+		tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("issue parsing %s template: %s", name, err)
+		}
+		return tmpl, nil
+	}
+
+	var err error
+	templates := &requestTemplates{
+		params:  make(map[string]*template.Template),
+		headers: make(map[string]*template.Template),
+	}
+
+	templates.url, err = parse("url", conf.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	templates.body, err = parse("body", conf.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range conf.Params {
+		templates.params[k], err = parse(fmt.Sprintf("param %s", k), v)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for k, v := range conf.Headers {
+		templates.headers[k], err = parse(fmt.Sprintf("header %s", k), v)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Check the templates render and the URL is valid
+	request, err := templates.render(templateData{})
+	if err != nil {
+		return nil, err
+	}
+
+	parsedURL, err := url.Parse(request.page.url)
+	if err != nil || parsedURL.Scheme == "" || parsedURL.Host == "" {
+		return nil, fmt.Errorf("url must be an absolute http or https url")
+	}
+
+	return templates, nil
+}
+
+// render applies the watermark to the templates
+func (t *requestTemplates) render(data templateData) (renderedRequest, error) {
+	execute := func(tmpl *template.Template) (string, error) {
+		var buf bytes.Buffer
+		err := tmpl.Execute(&buf, data)
+		if err != nil {
+			return "", fmt.Errorf("issue rendering %s template: %s", tmpl.Name(), err)
+		}
+		return buf.String(), nil
+	}
+
+	requestURL, err := execute(t.url)
+	if err != nil {
+		return renderedRequest{}, err
+	}
+
+	body, err := execute(t.body)
+	if err != nil {
+		return renderedRequest{}, err
+	}
+
+	params := url.Values{}
+	for k, v := range t.params {
+		value, err := execute(v)
+		if err != nil {
+			return renderedRequest{}, err
+		}
+		params.Set(k, value)
+	}
+
+	headers := make(map[string]string)
+	for k, v := range t.headers {
+		value, err := execute(v)
+		if err != nil {
+			return renderedRequest{}, err
+		}
+		headers[k] = value
+	}
+
+	return renderedRequest{
+		page:    pageRequest{url: requestURL, params: params},
+		headers: headers,
+		body:    body,
+	}, nil
+}
@@ -0,0 +1,218 @@
+package http_poll
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/ThoronicLLC/collector/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var config1 = `{"url": "https://api.example.com/logs"}`
+var config2 = `{"url": "https://api.example.com/logs", "params": {"since": "{{.Start}}", "until": "{{.End}}"}, "auth_config": {"bearer": {"enabled": true, "token": "secret"}}, "records_path": "data", "pagination": {"type": "next_link", "next_link_path": "links.next"}, "watermark": {"type": "time", "field": "published", "initial_lookback": 3600}}`
+var config3 = `{"url": "https://example.okta.com/api/v1/logs", "auth_config": {"api_key": {"enabled": true, "header": "Authorization", "prefix": "SSWS", "value": "key"}}, "pagination": {"type": "link_header"}, "watermark": {"type": "time"}}`
+var config4 = `{"url": "https://api.example.com/events", "method": "POST", "body": "{\"cursor\": \"{{.Cursor}}\"}", "auth_config": {"oauth2": {"enabled": true, "token_url": "https://login.example.com/token", "client_id": "id", "client_secret": "secret"}}, "pagination": {"type": "cursor", "cursor_path": "next_cursor", "cursor_param": "cursor"}, "watermark": {"type": "cursor", "field": "id"}}`
+var config5 = `{"url": "https://api.example.com/audit", "auth_config": {"basic": {"enabled": true, "username": "user", "password": "pass"}}, "pagination": {"type": "page_number", "page_param": "page"}, "watermark": {"type": "time", "format": "unix_ms"}}`
+var badConfig1 = `{"url": ""}`
+var badConfig2 = `{"url": "https://api.example.com/logs", "method": "DELETE"}`
+var badConfig3 = `{"url": "https://api.example.com/logs", "max_pages": 0}`
+var badConfig4 = `{"url": "https://api.example.com/logs", "auth_config": {"bearer": {"enabled": true, "token": "secret"}, "basic": {"enabled": true, "username": "user"}}}`
+var badConfig5 = `{"url": "https://api.example.com/logs", "pagination": {"type": "cursor", "cursor_path": "next"}}`
+var badConfig6 = `{"url": "https://api.example.com/logs", "watermark": {"type": "cursor"}}`
+var badConfig7 = `{"url": "https://api.example.com/logs?since={{.Start"}`
+var badConfig8 = `{"url": "/logs"}`
+
+func TestValidate(t *testing.T) {
+	arr := []string{config1, config2, config3, config4, config5}
+	for i, v := range arr {
+		testConfig := defaultConfig()
+		err := json.Unmarshal([]byte(v), &testConfig)
+		assert.Nilf(t, err, "test #%d - failed to unmarshal json: %s", i, err)
+		err = core.ValidateStruct(&testConfig)
+		assert.Nilf(t, err, "test #%d - validation error: %s", i, err)
+	}
+}
+
+func TestValidateFailed(t *testing.T) {
+	arr := []string{badConfig1, badConfig2, badConfig3}
+	for i, v := range arr {
+		testConfig := defaultConfig()
+		err := json.Unmarshal([]byte(v), &testConfig)
+		assert.Nilf(t, err, "test #%d - failed to unmarshal json: %s", i, err)
+		err = core.ValidateStruct(&testConfig)
+		assert.NotNilf(t, err, "test #%d - validation should have returned an error: %s", i, err)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	arr := []string{config1, config2, config3, config4, config5}
+	for i, v := range arr {
+		handleFunc := Handler()
+		_, err := handleFunc([]byte(v))
+		assert.Nilf(t, err, "test #%d - validation error: %s", i, err)
+	}
+}
+
+func TestHandlerFailed(t *testing.T) {
+	arr := []string{badConfig1, badConfig2, badConfig3, badConfig4, badConfig5, badConfig6, badConfig7, badConfig8}
+	for i, v := range arr {
+		handleFunc := Handler()
+		_, err := handleFunc([]byte(v))
+		assert.NotNilf(t, err, "test #%d - validation should have returned an error: %s", i, err)
+	}
+}
+
+func TestPoll(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		query := r.URL.Query()
+		switch r.URL.Path {
+		case "/next_link":
+			if query.Get("page") == "2" {
+				_, _ = fmt.Fprint(w, `{"data": [{"id": 3, "published": "2022-01-01T00:00:03Z"}]}`)
+				return
+			}
+			_, _ = fmt.Fprint(w, `{"data": [{"id": 1, "published": "2022-01-01T00:00:02Z"}, {"id": 2, "published": "2022-01-01T00:00:01Z"}], "links": {"next": "/next_link?page=2"}}`)
+		case "/link_header":
+			if query.Get("after") == "" {
+				w.Header().Set("Link", `<https://ignored.example.com/self>; rel="self", </link_header?after=2>; rel="next"`)
+				_, _ = fmt.Fprint(w, `[{"id": 1}, {"id": 2}]`)
+				return
+			}
+			_, _ = fmt.Fprint(w, `[{"id": 3}]`)
+		case "/cursor":
+			switch query.Get("cursor") {
+			case "":
+				_, _ = fmt.Fprint(w, `{"events": [{"id": "a"}, {"id": "b"}], "next_cursor": "b"}`)
+			case "b":
+				_, _ = fmt.Fprint(w, `{"events": [{"id": "c"}], "next_cursor": "b"}`)
+			}
+		case "/page_number":
+			if query.Get("page") == "3" {
+				_, _ = fmt.Fprint(w, `{"items": []}`)
+				return
+			}
+			_, _ = fmt.Fprintf(w, `{"items": ["page %s"]}`, query.Get("page"))
+		}
+	}))
+	defer server.Close()
+
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		config   string
+		expected []string
+		state    httpPollState
+	}{
+		{
+			config:   `{"url": "%s/next_link", "records_path": "data", "pagination": {"type": "next_link", "next_link_path": "links.next"}, "watermark": {"type": "time", "field": "published", "id_field": "id"}}`,
+			expected: []string{`{"id":1,"published":"2022-01-01T00:00:02Z"}`, `{"id":2,"published":"2022-01-01T00:00:01Z"}`, `{"id":3,"published":"2022-01-01T00:00:03Z"}`},
+			state:    httpPollState{Time: start.Add(3 * time.Second), IDs: []string{"3"}},
+		},
+		{
+			config:   `{"url": "%s/next_link", "records_path": "data", "max_pages": 1, "pagination": {"type": "next_link", "next_link_path": "links.next"}, "watermark": {"type": "time", "field": "published", "id_field": "id"}}`,
+			expected: []string{`{"id":1,"published":"2022-01-01T00:00:02Z"}`, `{"id":2,"published":"2022-01-01T00:00:01Z"}`},
+			state:    httpPollState{Time: start},
+		},
+		{
+			config:   `{"url": "%s/link_header", "pagination": {"type": "link_header"}}`,
+			expected: []string{`{"id":1}`, `{"id":2}`, `{"id":3}`},
+			state:    httpPollState{Time: start},
+		},
+		{
+			config:   `{"url": "%s/cursor", "records_path": "events", "pagination": {"type": "cursor", "cursor_path": "next_cursor", "cursor_param": "cursor"}, "watermark": {"type": "cursor", "field": "id"}}`,
+			expected: []string{`{"id":"a"}`, `{"id":"b"}`, `{"id":"c"}`},
+			state:    httpPollState{Time: start, Cursor: "c"},
+		},
+		{
+			config:   `{"url": "%s/page_number", "records_path": "items", "pagination": {"type": "page_number", "page_param": "page"}}`,
+			expected: []string{"page 1", "page 2"},
+			state:    httpPollState{Time: start},
+		},
+	}
+
+	for i, test := range tests {
+		conf := strings.Replace(test.config, "{", `{"auth_config": {"bearer": {"enabled": true, "token": "secret"}}, `, 1)
+		input, err := Handler()([]byte(fmt.Sprintf(conf, server.URL)))
+		assert.Nilf(t, err, "test #%d - handler error: %s", i, err)
+
+		var buf bytes.Buffer
+		newState, err := input.(*httpPollInput).poll(httpPollState{Time: start}, &lineWriter{&buf})
+		assert.Nilf(t, err, "test #%d - poll error: %s", i, err)
+		assert.Equalf(t, strings.Join(test.expected, "\n")+"\n", buf.String(), "test #%d", i)
+		assert.Equalf(t, test.state, newState, "test #%d", i)
+	}
+}
+
+func TestWatermarkTracker(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	conf := WatermarkConfig{Type: watermarkTime, Field: "published", IDField: "id"}
+	tracker := newWatermarkTracker(conf, httpPollState{Time: start, IDs: []string{"1"}}, start.Add(time.Hour))
+
+	// Records read at the watermark by the last poll are skipped
+	records := gjson.Parse(`[{"id": 1, "published": "2022-01-01T00:00:00Z"}, {"id": 2, "published": "2022-01-01T00:00:00Z"}, {"id": 3, "published": "2021-12-31T23:59:59Z"}]`).Array()
+	assert.True(t, tracker.seen(records[0]))
+	assert.False(t, tracker.seen(records[1]))
+	assert.True(t, tracker.seen(records[2]))
+
+	tracker.observe(records[1])
+	tracker.observe(records[2])
+	assert.Equal(t, httpPollState{Time: start, IDs: []string{"1", "2"}}, tracker.result())
+
+	// Records earlier in the second of a watermark with fractional seconds are skipped
+	watermark := start.Add(500 * time.Millisecond)
+	tracker = newWatermarkTracker(conf, httpPollState{Time: watermark, IDs: []string{"5"}}, start.Add(time.Hour))
+	assert.True(t, tracker.seen(gjson.Parse(`{"id": 6, "published": "2022-01-01T00:00:00.25Z"}`)))
+	assert.False(t, tracker.seen(gjson.Parse(`{"id": 7, "published": "2022-01-01T00:00:00.75Z"}`)))
+
+	// A newer record moves the watermark and starts the IDs again
+	tracker.observe(gjson.Parse(`{"id": 4, "published": "2022-01-01T00:00:05Z"}`))
+	assert.Equal(t, httpPollState{Time: start.Add(5 * time.Second), IDs: []string{"4"}}, tracker.result())
+
+	// Records are identified by their content without an ID field
+	conf.IDField = ""
+	tracker = newWatermarkTracker(conf, httpPollState{Time: start}, start.Add(time.Hour))
+	tracker.observe(records[0])
+	tracker = newWatermarkTracker(conf, tracker.result(), start.Add(time.Hour))
+	assert.True(t, tracker.seen(records[0]))
+	assert.False(t, tracker.seen(records[1]))
+}
+
+func TestPollFailed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	input, err := Handler()([]byte(fmt.Sprintf(`{"url": "%s", "watermark": {"type": "time"}}`, server.URL)))
+	assert.Nil(t, err)
+
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	newState, err := input.(*httpPollInput).poll(httpPollState{Time: start}, &lineWriter{&bytes.Buffer{}})
+	assert.NotNil(t, err)
+	assert.Equal(t, start, newState.Time)
+}
+
+func TestParseLinkHeader(t *testing.T) {
+	assert.Equal(t, "https://api.example.com/logs?after=1", parseLinkHeader([]string{`<https://api.example.com/logs?after=1>; rel="next"`}))
+	assert.Equal(t, "/next", parseLinkHeader([]string{`</prev>; rel="prev"`, `</next>; rel="next last"`}))
+	assert.Equal(t, "", parseLinkHeader([]string{`</self>; rel="self"`}))
+}
+
+type lineWriter struct {
+	buf *bytes.Buffer
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	return w.buf.WriteString("\n")
+}
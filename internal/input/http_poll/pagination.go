@@ -0,0 +1,170 @@
+package http_poll
+
+import (
+	"fmt"
+	"github.com/go-resty/resty/v2"
+	"github.com/tidwall/gjson"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	paginationNone       = "none"
+	paginationNextLink   = "next_link"
+	paginationCursor     = "cursor"
+	paginationPageNumber = "page_number"
+	paginationLinkHeader = "link_header"
+)
+
+// PaginationConfig is the configuration for following the pages of a response
+//
+//   - next_link follows the URL found at NextLinkPath in the response body
+//   - cursor sends the value found at CursorPath in the CursorParam query parameter
+//   - page_number increments the PageParam query parameter from StartPage until an empty page is returned
+//   - link_header follows the rel="next" URL in the Link response header
+type PaginationConfig struct {
+	Type         string `json:"type"`
+	NextLinkPath string `json:"next_link_path"`
+	CursorPath   string `json:"cursor_path"`
+	CursorParam  string `json:"cursor_param"`
+	PageParam    string `json:"page_param"`
+	StartPage    int    `json:"start_page"`
+}
+
+// pageRequest is the URL and query parameters of a single page
+type pageRequest struct {
+	url    string
+	params url.Values
+	page   int
+}
+
+// validatePaginationConfig checks the pagination type has the settings it requires
+func validatePaginationConfig(conf PaginationConfig) error {
+	switch conf.Type {
+	case paginationNone, paginationLinkHeader:
+	case paginationNextLink:
+		if conf.NextLinkPath == "" {
+			return fmt.Errorf("next_link pagination requires a next_link_path")
+		}
+	case paginationCursor:
+		if conf.CursorPath == "" || conf.CursorParam == "" {
+			return fmt.Errorf("cursor pagination requires a cursor_path and cursor_param")
+		}
+	case paginationPageNumber:
+		if conf.PageParam == "" {
+			return fmt.Errorf("page_number pagination requires a page_param")
+		}
+	default:
+		return fmt.Errorf("pagination type must be one of none, next_link, cursor, page_number or link_header")
+	}
+
+	return nil
+}
+
+// firstPage sets the page number on the first request
+func firstPage(conf PaginationConfig, request pageRequest) pageRequest {
+	if conf.Type == paginationPageNumber {
+		request.page = conf.StartPage
+		request.params = cloneValues(request.params)
+		request.params.Set(conf.PageParam, strconv.Itoa(conf.StartPage))
+	}
+
+	return request
+}
+
+// nextPage returns the request for the page after the response, or false once the last page has been read
+func nextPage(conf PaginationConfig, current pageRequest, response *resty.Response, recordCount int) (pageRequest, bool, error) {
+	switch conf.Type {
+	case paginationNextLink:
+		link := gjson.GetBytes(response.Body(), conf.NextLinkPath).String()
+		if link == "" {
+			return pageRequest{}, false, nil
+		}
+		return followLink(current, link)
+	case paginationLinkHeader:
+		link := parseLinkHeader(response.Header().Values("Link"))
+		if link == "" {
+			return pageRequest{}, false, nil
+		}
+		return followLink(current, link)
+	case paginationCursor:
+		cursor := gjson.GetBytes(response.Body(), conf.CursorPath).String()
+
+		// Stop when the API returns the same cursor again, as it would never finish
+		if cursor == "" || cursor == current.params.Get(conf.CursorParam) {
+			return pageRequest{}, false, nil
+		}
+
+		next := pageRequest{url: current.url, params: cloneValues(current.params)}
+		next.params.Set(conf.CursorParam, cursor)
+		return next, true, nil
+	case paginationPageNumber:
+		if recordCount == 0 {
+			return pageRequest{}, false, nil
+		}
+
+		next := pageRequest{url: current.url, params: cloneValues(current.params), page: current.page + 1}
+		next.params.Set(conf.PageParam, strconv.Itoa(next.page))
+		return next, true, nil
+	}
+
+	return pageRequest{}, false, nil
+}
+
+// followLink resolves the link against the current URL. Links carry their own query parameters, so the configured
+// parameters are not sent again.
+func followLink(current pageRequest, link string) (pageRequest, bool, error) {
+	base, err := url.Parse(current.url)
+	if err != nil {
+		return pageRequest{}, false, fmt.Errorf("issue parsing url: %s", err)
+	}
+
+	reference, err := url.Parse(link)
+	if err != nil {
+		return pageRequest{}, false, fmt.Errorf("issue parsing next link: %s", err)
+	}
+
+	next := base.ResolveReference(reference).String()
+	if next == current.url && len(current.params) == 0 {
+		return pageRequest{}, false, nil
+	}
+
+	return pageRequest{url: next, params: url.Values{}}, true, nil
+}
+
+// parseLinkHeader returns the rel="next" URL from RFC 8288 Link headers
+func parseLinkHeader(headers []string) string {
+	for _, header := range headers {
+		for _, link := range strings.Split(header, ",") {
+			parts := strings.Split(link, ";")
+			target := strings.TrimSpace(parts[0])
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+
+			for _, param := range parts[1:] {
+				key, value, found := strings.Cut(strings.TrimSpace(param), "=")
+				if !found || !strings.EqualFold(strings.TrimSpace(key), "rel") {
+					continue
+				}
+
+				for _, rel := range strings.Fields(strings.Trim(strings.TrimSpace(value), `"`)) {
+					if strings.EqualFold(rel, "next") {
+						return strings.TrimSuffix(strings.TrimPrefix(target, "<"), ">")
+					}
+				}
+			}
+		}
+	}
+
+	return ""
+}
+
+func cloneValues(values url.Values) url.Values {
+	cloned := url.Values{}
+	for k, v := range values {
+		cloned[k] = append([]string(nil), v...)
+	}
+	return cloned
+}
@@ -0,0 +1,209 @@
+package http_poll
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ThoronicLLC/collector/pkg/core"
+	"github.com/tidwall/gjson"
+	"hash/fnv"
+	"strconv"
+	"time"
+)
+
+const (
+	watermarkNone   = "none"
+	watermarkTime   = "time"
+	watermarkCursor = "cursor"
+)
+
+// WatermarkConfig is the configuration for tracking where the next poll starts from.
+//
+// Time watermarks are available to the request templates as {{.Start}} and {{.End}}. When Field is set the next
+// poll starts from the newest record timestamp, otherwise from the end of the last poll. Records at the newest
+// timestamp are returned again by the next poll, so they are identified by IDField, or their content when it is not
+// set, and skipped once read. Earlier records are skipped too, as the request start can be rounded down to the
+// second. Cursor watermarks are available as {{.Cursor}} and are taken from Field in the last record returned.
+type WatermarkConfig struct {
+	Type            string `json:"type"`
+	Field           string `json:"field"`            // gjson path in each record
+	IDField         string `json:"id_field"`         // gjson path of the record ID
+	Format          string `json:"format"`           // rfc3339, unix, unix_ms or a Go time layout
+	InitialLookback int    `json:"initial_lookback"` // Seconds to look back on the first run
+	InitialCursor   string `json:"initial_cursor"`
+}
+
+type httpPollState struct {
+	Time   time.Time `json:"time"`
+	Cursor string    `json:"cursor"`
+
+	// IDs of the records read with the watermark time
+	IDs []string `json:"ids,omitempty"`
+}
+
+func defaultState(conf WatermarkConfig) httpPollState {
+	return httpPollState{
+		Time:   time.Now().UTC().Add(-time.Duration(conf.InitialLookback) * time.Second),
+		Cursor: conf.InitialCursor,
+	}
+}
+
+func loadState(state core.State, conf WatermarkConfig) httpPollState {
+	if state == nil {
+		return defaultState(conf)
+	}
+
+	var loadedState httpPollState
+	err := json.Unmarshal(state, &loadedState)
+	if err != nil {
+		return defaultState(conf)
+	}
+
+	return loadedState
+}
+
+// validateWatermarkConfig checks the watermark settings
+func validateWatermarkConfig(conf WatermarkConfig) error {
+	switch conf.Type {
+	case watermarkNone, watermarkTime:
+	case watermarkCursor:
+		if conf.Field == "" {
+			return fmt.Errorf("cursor watermark requires a field")
+		}
+	default:
+		return fmt.Errorf("watermark type must be one of none, time or cursor")
+	}
+
+	if conf.InitialLookback < 0 {
+		return fmt.Errorf("watermark initial_lookback must be positive")
+	}
+
+	return nil
+}
+
+// watermarkTracker follows the watermark through the records of a poll
+type watermarkTracker struct {
+	conf  WatermarkConfig
+	start httpPollState
+	state httpPollState
+	end   time.Time
+}
+
+func newWatermarkTracker(conf WatermarkConfig, state httpPollState, end time.Time) *watermarkTracker {
+	return &watermarkTracker{conf: conf, start: state, state: state, end: end}
+}
+
+// seen checks if the record was read by the last poll, either before the time watermark it started from or at it.
+// Formats such as rfc3339 request from the start of the second, so earlier records in that second are returned again.
+func (w *watermarkTracker) seen(record gjson.Result) bool {
+	recordTime, ok := w.recordTime(record)
+	if !ok {
+		return false
+	}
+
+	if recordTime.Before(w.start.Time) {
+		return true
+	}
+
+	if !recordTime.Equal(w.start.Time) {
+		return false
+	}
+
+	id := w.recordID(record)
+	for _, v := range w.start.IDs {
+		if v == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// observe updates the watermark from a record
+func (w *watermarkTracker) observe(record gjson.Result) {
+	switch w.conf.Type {
+	case watermarkTime:
+		recordTime, ok := w.recordTime(record)
+		if !ok || recordTime.Before(w.state.Time) {
+			return
+		}
+
+		if recordTime.After(w.state.Time) {
+			w.state.Time = recordTime
+			w.state.IDs = nil
+		}
+		w.state.IDs = append(w.state.IDs, w.recordID(record))
+	case watermarkCursor:
+		if w.conf.Field == "" {
+			return
+		}
+
+		value := record.Get(w.conf.Field)
+		if value.Exists() {
+			w.state.Cursor = value.String()
+		}
+	}
+}
+
+// recordTime returns the timestamp of a record for time watermarks read from a field
+func (w *watermarkTracker) recordTime(record gjson.Result) (time.Time, bool) {
+	if w.conf.Type != watermarkTime || w.conf.Field == "" {
+		return time.Time{}, false
+	}
+
+	value := record.Get(w.conf.Field)
+	if !value.Exists() {
+		return time.Time{}, false
+	}
+
+	return parseTime(value, w.conf.Format)
+}
+
+// recordID returns the ID of the record, or a hash of the record when there is no ID field
+func (w *watermarkTracker) recordID(record gjson.Result) string {
+	if w.conf.IDField != "" {
+		return record.Get(w.conf.IDField).String()
+	}
+
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(record.Raw))
+	return strconv.FormatUint(hash.Sum64(), 16)
+}
+
+// result returns the state to save after the poll
+func (w *watermarkTracker) result() httpPollState {
+	if w.conf.Type == watermarkTime && w.conf.Field == "" {
+		w.state.Time = w.end
+	}
+	return w.state
+}
+
+// formatTime formats the time for the request templates
+func formatTime(t time.Time, format string) string {
+	switch format {
+	case "", "rfc3339":
+		return t.UTC().Format(time.RFC3339)
+	case "unix":
+		return strconv.FormatInt(t.Unix(), 10)
+	case "unix_ms":
+		return strconv.FormatInt(t.UnixMilli(), 10)
+	default:
+		return t.UTC().Format(format)
+	}
+}
+
+// parseTime parses a record timestamp
+func parseTime(value gjson.Result, format string) (time.Time, bool) {
+	switch format {
+	case "", "rfc3339":
+		t, err := time.Parse(time.RFC3339Nano, value.String())
+		return t, err == nil
+	case "unix":
+		seconds := value.Float()
+		return time.Unix(0, int64(seconds*float64(time.Second))).UTC(), seconds > 0
+	case "unix_ms":
+		return time.UnixMilli(value.Int()).UTC(), value.Int() > 0
+	default:
+		t, err := time.Parse(format, value.String())
+		return t, err == nil
+	}
+}
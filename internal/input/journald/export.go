@@ -0,0 +1,70 @@
+package journald
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// cursorField is the journal field holding the entry cursor
+const cursorField = "__CURSOR"
+
+// JournalEntry is a single journal entry from the journal export format
+type JournalEntry map[string]string
+
+// exportReader reads entries from the systemd journal export format
+//
+// https://systemd.io/JOURNAL_EXPORT_FORMATS/
+type exportReader struct {
+	reader *bufio.Reader
+}
+
+func newExportReader(r io.Reader) *exportReader {
+	return &exportReader{reader: bufio.NewReader(r)}
+}
+
+// Next returns the next journal entry. It returns io.EOF when there are no more entries.
+func (e *exportReader) Next() (JournalEntry, error) {
+	entry := make(JournalEntry)
+	for {
+		line, err := e.reader.ReadString('\n')
+		if err != nil {
+			// Return the last entry if the stream ended without a trailing blank line
+			if err == io.EOF && len(entry) > 0 && line == "" {
+				return entry, nil
+			}
+			return nil, err
+		}
+		line = strings.TrimSuffix(line, "\n")
+
+		// A blank line marks the end of an entry
+		if line == "" {
+			if len(entry) == 0 {
+				continue
+			}
+			return entry, nil
+		}
+
+		// Text fields are serialized as KEY=value
+		if idx := strings.IndexByte(line, '='); idx >= 0 {
+			entry[line[:idx]] = line[idx+1:]
+			continue
+		}
+
+		// Binary fields are serialized as the field name, a little endian 64-bit size, the data and a newline
+		var size uint64
+		err = binary.Read(e.reader, binary.LittleEndian, &size)
+		if err != nil {
+			return nil, fmt.Errorf("issue reading binary field size for %s: %s", line, err)
+		}
+
+		data := make([]byte, size+1)
+		_, err = io.ReadFull(e.reader, data)
+		if err != nil {
+			return nil, fmt.Errorf("issue reading binary field %s: %s", line, err)
+		}
+		entry[line] = string(data[:size])
+	}
+}
@@ -0,0 +1,207 @@
+package journald
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ThoronicLLC/collector/pkg/core"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+)
+
+var InputName = "journald"
+
+type Config struct {
+	Directory      string   `json:"directory"`
+	Units          []string `json:"units"`
+	ReadFromHead   bool     `json:"read_from_head"`
+	JournalctlPath string   `json:"journalctl_path" validate:"required"`
+	Schedule       int      `json:"schedule" validate:"required|min:0"`
+}
+
+type journaldInput struct {
+	config     Config
+	ctx        context.Context
+	cancelFunc context.CancelFunc
+}
+
+func Handler() core.InputHandler {
+	return func(config []byte) (core.Input, error) {
+		// Set config defaults
+		conf := Config{
+			JournalctlPath: "journalctl",
+			Schedule:       60,
+		}
+
+		// Unmarshal config
+		err := json.Unmarshal(config, &conf)
+		if err != nil {
+			return nil, fmt.Errorf("issue unmarshalling file config: %s", err)
+		}
+
+		// Validate config
+		err = core.ValidateStruct(&conf)
+		if err != nil {
+			return nil, err
+		}
+
+		// Setup context
+		ctx, cancelFn := context.WithCancel(context.Background())
+
+		return &journaldInput{
+			config:     conf,
+			ctx:        ctx,
+			cancelFunc: cancelFn,
+		}, nil
+	}
+}
+
+// Run will execute the input with the supplied context and state and return results
+func (input *journaldInput) Run(errorHandler core.ErrorHandler, state core.State, processPipe chan<- core.PipelineResults) {
+	// Validate and load state
+	currentState := loadState(state)
+
+	for {
+		select {
+		case <-input.ctx.Done():
+			return
+		case <-time.After(time.Duration(input.config.Schedule) * time.Second):
+			// Create temp file
+			tmpFile, err := core.NewTmpWriter()
+			if err != nil {
+				errorHandler(false, fmt.Errorf("issue opening a new temp file writer: %s", err))
+				continue
+			}
+
+			// Copy current state to a new object for modification
+			newState := currentState
+
+			// Read all new journal entries since the last cursor
+			cursor, err := input.readJournal(currentState, tmpFile)
+			if err != nil {
+				errorHandler(false, err)
+
+				// Discard the partial results so they are read again on the next run
+				partialPath := tmpFile.Name()
+				_ = tmpFile.Close()
+				if partialPath != "" {
+					_ = os.Remove(partialPath)
+				}
+				continue
+			}
+			if cursor != "" {
+				newState.Cursor = cursor
+			}
+
+			// Get results file name and size
+			path := tmpFile.Name()
+			linesWritten := tmpFile.WriteCount
+			err = tmpFile.Close()
+			if err != nil {
+				errorHandler(false, fmt.Errorf("issue closing file: %s", err))
+				continue
+			}
+
+			// Marshal new state
+			newStateBytes, err := json.Marshal(newState)
+			if err != nil {
+				errorHandler(false, fmt.Errorf("issue marshalling new state: %s", err))
+				continue
+			}
+
+			// Setup pipeline results for next stage
+			result := core.PipelineResults{
+				FilePath:    path,
+				ResultCount: linesWritten,
+				State:       newStateBytes,
+				RetryCount:  0,
+			}
+
+			// Pipe results to next stage
+			processPipe <- result
+
+			// Update current state to the new state since successful run
+			currentState = newState
+		}
+	}
+}
+
+func (input *journaldInput) Stop() {
+	input.cancelFunc()
+}
+
+// readJournal runs journalctl in export mode, writes each entry as a JSON event and returns the last cursor read
+func (input *journaldInput) readJournal(state journaldState, writer io.Writer) (string, error) {
+	cmd := exec.CommandContext(input.ctx, input.config.JournalctlPath, buildArgs(input.config, state)...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", fmt.Errorf("issue setting up journalctl output: %s", err)
+	}
+
+	log.Debugf("running journalctl: %s", cmd.String())
+	err = cmd.Start()
+	if err != nil {
+		return "", fmt.Errorf("issue starting journalctl: %s", err)
+	}
+
+	// Read entries until the export stream ends
+	lastCursor := ""
+	reader := newExportReader(stdout)
+	for {
+		entry, err := reader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			_ = cmd.Wait()
+			return "", fmt.Errorf("issue reading journal entry: %s", err)
+		}
+
+		event, err := json.Marshal(entry)
+		if err != nil {
+			_ = cmd.Wait()
+			return "", fmt.Errorf("issue marshalling journal entry: %s", err)
+		}
+
+		_, err = writer.Write(event)
+		if err != nil {
+			_ = cmd.Wait()
+			return "", fmt.Errorf("issue writing journal entry: %s", err)
+		}
+
+		if cursor, ok := entry[cursorField]; ok {
+			lastCursor = cursor
+		}
+	}
+
+	err = cmd.Wait()
+	if err != nil {
+		return "", fmt.Errorf("journalctl exited with error: %s", err)
+	}
+
+	return lastCursor, nil
+}
+
+// buildArgs builds the journalctl arguments for the supplied config and state
+func buildArgs(conf Config, state journaldState) []string {
+	args := []string{"--no-pager", "--output=export"}
+
+	if conf.Directory != "" {
+		args = append(args, fmt.Sprintf("--directory=%s", conf.Directory))
+	}
+
+	for _, unit := range conf.Units {
+		args = append(args, fmt.Sprintf("--unit=%s", unit))
+	}
+
+	// Resume from the stored cursor, or start from the first run time unless reading from the head
+	if state.Cursor != "" {
+		args = append(args, fmt.Sprintf("--after-cursor=%s", state.Cursor))
+	} else if !conf.ReadFromHead {
+		args = append(args, fmt.Sprintf("--since=@%d", state.Since))
+	}
+
+	return args
+}
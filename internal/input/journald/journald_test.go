@@ -0,0 +1,98 @@
+package journald
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/ThoronicLLC/collector/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+var config1 = `{"schedule": 10}`
+var config2 = `{"directory": "/var/log/journal", "units": ["sshd.service", "sudo.service"], "read_from_head": true, "schedule": 60}`
+var config3 = `{"journalctl_path": "/usr/bin/journalctl", "schedule": 30}`
+var badConfig1 = `{"schedule": -1}`
+var badConfig2 = `{"schedule": 0}`
+var badConfig3 = `{"journalctl_path": "", "schedule": 10}`
+
+func TestValidate(t *testing.T) {
+	arr := []string{config1, config2, config3}
+	for i, v := range arr {
+		testConfig := Config{JournalctlPath: "journalctl", Schedule: 60}
+		err := json.Unmarshal([]byte(v), &testConfig)
+		assert.Nilf(t, err, "test #%d - failed to unmarshal json: %s", i, err)
+		err = core.ValidateStruct(&testConfig)
+		assert.Nilf(t, err, "test #%d - validation error: %s", i, err)
+	}
+}
+
+func TestValidateFailed(t *testing.T) {
+	arr := []string{badConfig1, badConfig2, badConfig3}
+	for i, v := range arr {
+		testConfig := Config{JournalctlPath: "journalctl", Schedule: 60}
+		err := json.Unmarshal([]byte(v), &testConfig)
+		assert.Nilf(t, err, "test #%d - failed to unmarshal json: %s", i, err)
+		err = core.ValidateStruct(&testConfig)
+		assert.NotNilf(t, err, "test #%d - validation should have returned an error: %s", i, err)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	arr := []string{config1, config2, config3}
+	for i, v := range arr {
+		handleFunc := Handler()
+		_, err := handleFunc([]byte(v))
+		assert.Nilf(t, err, "test #%d - validation error: %s", i, err)
+	}
+}
+
+func TestHandlerFailed(t *testing.T) {
+	arr := []string{badConfig1, badConfig2, badConfig3}
+	for i, v := range arr {
+		handleFunc := Handler()
+		_, err := handleFunc([]byte(v))
+		assert.NotNilf(t, err, "test #%d - validation should have returned an error: %s", i, err)
+	}
+}
+
+func TestExportReader(t *testing.T) {
+	var buf bytes.Buffer
+	buf.WriteString("__CURSOR=s=1;i=1\nMESSAGE=first entry\n_SYSTEMD_UNIT=sshd.service\n\n")
+	buf.WriteString("__CURSOR=s=1;i=2\nMESSAGE\n")
+	_ = binary.Write(&buf, binary.LittleEndian, uint64(11))
+	buf.WriteString("line1\nline2\n")
+	buf.WriteString("PRIORITY=6\n\n")
+
+	reader := newExportReader(&buf)
+
+	entry, err := reader.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, "s=1;i=1", entry[cursorField])
+	assert.Equal(t, "first entry", entry["MESSAGE"])
+	assert.Equal(t, "sshd.service", entry["_SYSTEMD_UNIT"])
+
+	entry, err = reader.Next()
+	assert.Nil(t, err)
+	assert.Equal(t, "s=1;i=2", entry[cursorField])
+	assert.Equal(t, "line1\nline2", entry["MESSAGE"])
+	assert.Equal(t, "6", entry["PRIORITY"])
+
+	_, err = reader.Next()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestBuildArgs(t *testing.T) {
+	conf := Config{Directory: "/var/log/journal", Units: []string{"sshd.service"}}
+
+	args := buildArgs(conf, journaldState{Since: 1700000000})
+	assert.Equal(t, []string{"--no-pager", "--output=export", "--directory=/var/log/journal", "--unit=sshd.service", "--since=@1700000000"}, args)
+
+	args = buildArgs(conf, journaldState{Cursor: "s=1;i=2", Since: 1700000000})
+	assert.Equal(t, []string{"--no-pager", "--output=export", "--directory=/var/log/journal", "--unit=sshd.service", "--after-cursor=s=1;i=2"}, args)
+
+	conf.ReadFromHead = true
+	args = buildArgs(conf, journaldState{Since: 1700000000})
+	assert.Equal(t, []string{"--no-pager", "--output=export", "--directory=/var/log/journal", "--unit=sshd.service"}, args)
+}
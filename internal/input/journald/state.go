@@ -0,0 +1,30 @@
+package journald
+
+import (
+	"encoding/json"
+	"github.com/ThoronicLLC/collector/pkg/core"
+	"time"
+)
+
+type journaldState struct {
+	Cursor string `json:"cursor"`
+	Since  int64  `json:"since"`
+}
+
+func defaultState() journaldState {
+	return journaldState{Since: time.Now().Unix()}
+}
+
+func loadState(state core.State) journaldState {
+	if state == nil {
+		return defaultState()
+	}
+
+	var loadedState journaldState
+	err := json.Unmarshal(state, &loadedState)
+	if err != nil {
+		return defaultState()
+	}
+
+	return loadedState
+}
@@ -0,0 +1,220 @@
+package kafka
+
+import (
+  "encoding/json"
+  "errors"
+  "fmt"
+  "github.com/ThoronicLLC/collector/internal/integrations/schemaregistry"
+  "github.com/hamba/avro/v2"
+  "math/big"
+  "os"
+  "strings"
+  "sync"
+)
+
+// avroDecoder decodes Confluent framed avro messages into JSON
+type avroDecoder struct {
+  registry    *schemaregistry.Client
+  localSchema avro.Schema
+
+  mu      sync.Mutex
+  schemas map[int]avro.Schema
+}
+
+func newAvroDecoder(registry *schemaregistry.Client, schemaFile string) (*avroDecoder, error) {
+  decoder := &avroDecoder{
+    registry: registry,
+    schemas:  make(map[int]avro.Schema),
+  }
+
+  if schemaFile != "" {
+    schemaBytes, err := os.ReadFile(schemaFile)
+    if err != nil {
+      return nil, fmt.Errorf("issue reading avro schema file: %s", err)
+    }
+
+    decoder.localSchema, err = avro.ParseBytesWithCache(schemaBytes, "", &avro.SchemaCache{})
+    if err != nil {
+      return nil, fmt.Errorf("issue parsing avro schema file: %s", err)
+    }
+  }
+
+  return decoder, nil
+}
+
+func (d *avroDecoder) decode(value []byte) ([]byte, error) {
+  id, payload, err := splitFraming(value)
+  if err != nil {
+    return nil, err
+  }
+
+  schema, err := d.schema(id)
+  if err != nil {
+    return nil, err
+  }
+
+  var event interface{}
+  err = avro.Unmarshal(schema, payload, &event)
+  if err != nil {
+    return nil, fmt.Errorf("issue decoding avro message with schema %d: %s", id, err)
+  }
+
+  return json.Marshal(normalizeAvro(schema, event))
+}
+
+// schema returns the parsed schema for the ID, using the local schema when the registry does not have it
+func (d *avroDecoder) schema(id int) (avro.Schema, error) {
+  d.mu.Lock()
+  schema, ok := d.schemas[id]
+  d.mu.Unlock()
+  if ok {
+    return schema, nil
+  }
+
+  if d.registry == nil {
+    return d.localSchema, nil
+  }
+
+  schema, err := d.registrySchema(id)
+  if err != nil {
+    if d.localSchema != nil && errors.Is(err, schemaregistry.ErrNotFound) {
+      return d.localSchema, nil
+    }
+    return nil, err
+  }
+
+  d.mu.Lock()
+  d.schemas[id] = schema
+  d.mu.Unlock()
+
+  return schema, nil
+}
+
+// registrySchema fetches and parses the schema with the named types it references
+func (d *avroDecoder) registrySchema(id int) (avro.Schema, error) {
+  registered, err := d.registry.SchemaByID(id)
+  if err != nil {
+    return nil, err
+  }
+  if registered.SchemaType != schemaregistry.TypeAvro {
+    return nil, fmt.Errorf("schema %d is a %s schema, not avro", id, strings.ToLower(registered.SchemaType))
+  }
+
+  // Each schema gets its own cache since named types can change between versions
+  cache := &avro.SchemaCache{}
+  err = d.parseReferences(registered.References, cache, make(map[string]bool))
+  if err != nil {
+    return nil, err
+  }
+
+  schema, err := avro.ParseWithCache(registered.Schema, "", cache)
+  if err != nil {
+    return nil, fmt.Errorf("issue parsing avro schema %d: %s", id, err)
+  }
+
+  return schema, nil
+}
+
+// parseReferences parses the referenced schemas into the cache, dependencies first
+func (d *avroDecoder) parseReferences(references []schemaregistry.Reference, cache *avro.SchemaCache, parsed map[string]bool) error {
+  for _, reference := range references {
+    if parsed[reference.Name] {
+      continue
+    }
+    parsed[reference.Name] = true
+
+    referenced, err := d.registry.SchemaByReference(reference)
+    if err != nil {
+      return err
+    }
+
+    err = d.parseReferences(referenced.References, cache, parsed)
+    if err != nil {
+      return err
+    }
+
+    _, err = avro.ParseWithCache(referenced.Schema, "", cache)
+    if err != nil {
+      return fmt.Errorf("issue parsing referenced avro schema %s: %s", reference.Name, err)
+    }
+  }
+
+  return nil
+}
+
+// normalizeAvro walks the decoded value with its schema so it marshals to plain JSON. Union values are unwrapped
+// from the object keyed by their type name and decimals are written as numbers with the scale of the schema.
+func normalizeAvro(schema avro.Schema, value interface{}) interface{} {
+  switch s := schema.(type) {
+  case *avro.RefSchema:
+    return normalizeAvro(s.Schema(), value)
+  case *avro.RecordSchema:
+    fields, ok := value.(map[string]interface{})
+    if !ok {
+      return value
+    }
+    for _, field := range s.Fields() {
+      if v, ok := fields[field.Name()]; ok {
+        fields[field.Name()] = normalizeAvro(field.Type(), v)
+      }
+    }
+  case *avro.ArraySchema:
+    items, ok := value.([]interface{})
+    if !ok {
+      return value
+    }
+    for i, item := range items {
+      items[i] = normalizeAvro(s.Items(), item)
+    }
+  case *avro.MapSchema:
+    values, ok := value.(map[string]interface{})
+    if !ok {
+      return value
+    }
+    for k, v := range values {
+      values[k] = normalizeAvro(s.Values(), v)
+    }
+  case *avro.UnionSchema:
+    wrapped, ok := value.(map[string]interface{})
+    if !ok || len(wrapped) != 1 {
+      return value
+    }
+    for name, v := range wrapped {
+      for _, member := range s.Types() {
+        if avroTypeName(member) == name {
+          return normalizeAvro(member, v)
+        }
+      }
+    }
+  }
+
+  // Decimals are decoded as fractions
+  if rat, ok := value.(*big.Rat); ok {
+    scale := 0
+    if logical, ok := schema.(avro.LogicalTypeSchema); ok {
+      if decimal, ok := logical.Logical().(*avro.DecimalLogicalSchema); ok {
+        scale = decimal.Scale()
+      }
+    }
+    return json.Number(rat.FloatString(scale))
+  }
+
+  return value
+}
+
+// avroTypeName is the name a union value is keyed by when decoded
+func avroTypeName(schema avro.Schema) string {
+  if ref, ok := schema.(*avro.RefSchema); ok {
+    schema = ref.Schema()
+  }
+
+  if named, ok := schema.(avro.NamedSchema); ok {
+    return named.FullName()
+  }
+
+  name := string(schema.Type())
+  if logical, ok := schema.(avro.LogicalTypeSchema); ok && logical.Logical() != nil {
+    name += "." + string(logical.Logical().Type())
+  }
+  return name
+}
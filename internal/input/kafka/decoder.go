@@ -0,0 +1,115 @@
+package kafka
+
+import (
+  "encoding/binary"
+  "encoding/json"
+  "fmt"
+  "github.com/ThoronicLLC/collector/internal/integrations/schemaregistry"
+  kafkago "github.com/segmentio/kafka-go"
+  "time"
+)
+
+const (
+  formatRaw      = "raw"
+  formatAvro     = "avro"
+  formatProtobuf = "protobuf"
+)
+
+// SchemaRegistryConfig is the configuration for the Confluent compatible schema registry used to decode messages
+type SchemaRegistryConfig struct {
+  URL      string `json:"url"`
+  Username string `json:"username"`
+  Password string `json:"password"`
+  Timeout  int    `json:"timeout"` // Request timeout in seconds
+}
+
+// valueDecoder converts a message value into a JSON event
+type valueDecoder interface {
+  decode(value []byte) ([]byte, error)
+}
+
+// newDecoder creates the decoder for the configured format, or nil when messages are read as is. The registry is
+// used when configured, falling back to the local schema file for schemas it does not have. Other registry errors
+// are not fallen back on, so messages are never decoded with the wrong schema.
+func newDecoder(conf Config) (valueDecoder, error) {
+  if conf.Format == "" || conf.Format == formatRaw {
+    return nil, nil
+  }
+
+  var registry *schemaregistry.Client
+  if conf.SchemaRegistry.URL != "" {
+    var err error
+    registry, err = schemaregistry.NewClient(schemaregistry.Config{
+      URL:      conf.SchemaRegistry.URL,
+      Username: conf.SchemaRegistry.Username,
+      Password: conf.SchemaRegistry.Password,
+      Timeout:  time.Duration(conf.SchemaRegistry.Timeout) * time.Second,
+    })
+    if err != nil {
+      return nil, err
+    }
+  }
+
+  switch conf.Format {
+  case formatAvro:
+    return newAvroDecoder(registry, conf.SchemaFile)
+  case formatProtobuf:
+    return newProtobufDecoder(registry, conf.SchemaFile, conf.MessageType)
+  }
+
+  return nil, fmt.Errorf("unknown format: %s", conf.Format)
+}
+
+// validateDecoderConfig makes sure there is a schema to decode avro and protobuf messages with
+func validateDecoderConfig(conf Config) error {
+  if conf.Format != formatAvro && conf.Format != formatProtobuf {
+    return nil
+  }
+
+  if conf.SchemaRegistry.URL == "" && conf.SchemaFile == "" {
+    return fmt.Errorf("the %s format requires a schema_registry url or a schema_file", conf.Format)
+  }
+
+  if conf.Format == formatProtobuf && conf.SchemaFile != "" && conf.MessageType == "" {
+    return fmt.Errorf("the protobuf schema_file requires a message_type")
+  }
+
+  return nil
+}
+
+// splitFraming splits the Confluent wire format into the schema ID and payload. Framed messages start with a zero
+// magic byte followed by the schema ID as a 4 byte big endian integer.
+func splitFraming(value []byte) (int, []byte, error) {
+  if len(value) < 5 || value[0] != 0 {
+    return 0, nil, fmt.Errorf("message is not in the schema registry wire format")
+  }
+
+  return int(binary.BigEndian.Uint32(value[1:5])), value[5:], nil
+}
+
+// undecodedEvent holds a message value that could not be decoded, so it is written instead of being dropped
+type undecodedEvent struct {
+  Value    []byte            `json:"value"`
+  Metadata undecodedMetadata `json:"@metadata"`
+}
+
+type undecodedMetadata struct {
+  DecodeError string `json:"decode_error"`
+  Topic       string `json:"topic"`
+  Partition   int    `json:"partition"`
+  Offset      int64  `json:"offset"`
+}
+
+// newUndecodedEvent returns the event written for a message that could not be decoded, with the value base64
+// encoded and the decode error under the @metadata key
+func newUndecodedEvent(m kafkago.Message, decodeErr error) ([]byte, error) {
+  return json.Marshal(undecodedEvent{
+    Value: m.Value,
+    Metadata: undecodedMetadata{
+      DecodeError: decodeErr.Error(),
+      Topic:       m.Topic,
+      Partition:   m.Partition,
+      Offset:      m.Offset,
+    },
+  })
+}
@@ -0,0 +1,175 @@
+package kafka
+
+import (
+  "encoding/base64"
+  "encoding/binary"
+  "fmt"
+  "github.com/hamba/avro/v2"
+  kafkago "github.com/segmentio/kafka-go"
+  "github.com/stretchr/testify/assert"
+  "google.golang.org/protobuf/proto"
+  "google.golang.org/protobuf/reflect/protodesc"
+  "google.golang.org/protobuf/reflect/protoreflect"
+  "google.golang.org/protobuf/types/descriptorpb"
+  "google.golang.org/protobuf/types/dynamicpb"
+  "math/big"
+  "net/http"
+  "net/http/httptest"
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+var testAvroSchema = `{"type": "record", "name": "Login", "fields": [{"name": "user", "type": "string"}, {"name": "count", "type": "long"}, {"name": "host", "type": ["null", "string"]}, {"name": "amount", "type": {"type": "bytes", "logicalType": "decimal", "precision": 9, "scale": 2}}]}`
+
+// framed adds the schema registry wire format header to the payload
+func framed(id int, payload ...[]byte) []byte {
+  value := []byte{0, 0, 0, 0, 0}
+  binary.BigEndian.PutUint32(value[1:], uint32(id))
+  for _, v := range payload {
+    value = append(value, v...)
+  }
+  return value
+}
+
+// testFileDescriptor is a protobuf file with a nested message and a second top level message
+func testFileDescriptor() *descriptorpb.FileDescriptorProto {
+  return &descriptorpb.FileDescriptorProto{
+    Name:    proto.String("events.proto"),
+    Package: proto.String("events"),
+    Syntax:  proto.String("proto3"),
+    MessageType: []*descriptorpb.DescriptorProto{
+      {
+        Name: proto.String("Alert"),
+        Field: []*descriptorpb.FieldDescriptorProto{
+          {Name: proto.String("alert_name"), Number: proto.Int32(1), Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(), JsonName: proto.String("alertName")},
+        },
+      },
+      {
+        Name: proto.String("Login"),
+        Field: []*descriptorpb.FieldDescriptorProto{
+          {Name: proto.String("user_name"), Number: proto.Int32(1), Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(), JsonName: proto.String("userName")},
+        },
+      },
+    },
+  }
+}
+
+// testProtobufPayload encodes a message of the named type with a single string field
+func testProtobufPayload(t *testing.T, messageName, value string) []byte {
+  file, err := protodesc.NewFile(testFileDescriptor(), nil)
+  assert.Nil(t, err)
+  descriptor := file.Messages().ByName(protoreflect.Name(messageName))
+  message := dynamicpb.NewMessage(descriptor)
+  message.Set(descriptor.Fields().ByNumber(1), protoreflect.ValueOfString(value))
+  payload, err := proto.Marshal(message)
+  assert.Nil(t, err)
+  return payload
+}
+
+func TestAvroDecoder(t *testing.T) {
+  requests := 0
+  server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    requests++
+    if r.URL.Path == "/schemas/ids/7" {
+      _, _ = w.Write([]byte(fmt.Sprintf(`{"schema": %q}`, testAvroSchema)))
+      return
+    }
+    if r.URL.Path == "/schemas/ids/9" {
+      w.WriteHeader(http.StatusUnauthorized)
+      return
+    }
+    w.WriteHeader(http.StatusNotFound)
+  }))
+  defer server.Close()
+
+  decoder, err := newDecoder(Config{Format: formatAvro, SchemaRegistry: SchemaRegistryConfig{URL: server.URL}})
+  assert.Nil(t, err)
+
+  schema := avro.MustParse(testAvroSchema)
+  payload, err := avro.Marshal(schema, map[string]interface{}{"user": "bob", "count": int64(3), "host": "web-1", "amount": big.NewRat(1234, 100)})
+  assert.Nil(t, err)
+
+  event, err := decoder.decode(framed(7, payload))
+  assert.Nil(t, err)
+  assert.JSONEq(t, `{"user": "bob", "count": 3, "host": "web-1", "amount": 12.34}`, string(event))
+
+  // Schemas are only fetched once
+  _, err = decoder.decode(framed(7, payload))
+  assert.Nil(t, err)
+  assert.Equal(t, 1, requests)
+
+  // Unknown schemas and unframed messages fail
+  _, err = decoder.decode(framed(8, payload))
+  assert.NotNil(t, err)
+  _, err = decoder.decode(payload)
+  assert.NotNil(t, err)
+
+  // The local schema is used when the registry does not have the schema
+  schemaFile := filepath.Join(t.TempDir(), "login.avsc")
+  assert.Nil(t, os.WriteFile(schemaFile, []byte(testAvroSchema), 0600))
+  decoder, err = newDecoder(Config{Format: formatAvro, SchemaRegistry: SchemaRegistryConfig{URL: server.URL}, SchemaFile: schemaFile})
+  assert.Nil(t, err)
+  event, err = decoder.decode(framed(8, payload))
+  assert.Nil(t, err)
+  assert.JSONEq(t, `{"user": "bob", "count": 3, "host": "web-1", "amount": 12.34}`, string(event))
+
+  // Other registry errors do not fall back to the local schema
+  _, err = decoder.decode(framed(9, payload))
+  assert.NotNil(t, err)
+}
+
+func TestUndecodedEvent(t *testing.T) {
+  event, err := newUndecodedEvent(kafkago.Message{Topic: "logins", Partition: 2, Offset: 40, Value: []byte("bad")}, fmt.Errorf("unknown magic byte"))
+  assert.Nil(t, err)
+  assert.JSONEq(t, `{"value": "YmFk", "@metadata": {"decode_error": "unknown magic byte", "topic": "logins", "partition": 2, "offset": 40}}`, string(event))
+}
+
+func TestProtobufDecoder(t *testing.T) {
+  descriptorBytes, err := proto.Marshal(testFileDescriptor())
+  assert.Nil(t, err)
+
+  server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    if r.URL.Path == "/schemas/ids/3" {
+      schema := "syntax = \"proto3\";"
+      if r.URL.Query().Get("format") == "serialized" {
+        schema = base64.StdEncoding.EncodeToString(descriptorBytes)
+      }
+      _, _ = w.Write([]byte(fmt.Sprintf(`{"schemaType": "PROTOBUF", "schema": %q}`, schema)))
+      return
+    }
+    w.WriteHeader(http.StatusNotFound)
+  }))
+  defer server.Close()
+
+  decoder, err := newDecoder(Config{Format: formatProtobuf, SchemaRegistry: SchemaRegistryConfig{URL: server.URL}})
+  assert.Nil(t, err)
+
+  // A zero index count is the first message
+  event, err := decoder.decode(framed(3, []byte{0}, testProtobufPayload(t, "Alert", "malware")))
+  assert.Nil(t, err)
+  assert.JSONEq(t, `{"alert_name": "malware"}`, string(event))
+
+  // Indexes [1] are written as a zigzag count of 1 followed by the zigzag index 1
+  event, err = decoder.decode(framed(3, []byte{2, 2}, testProtobufPayload(t, "Login", "bob")))
+  assert.Nil(t, err)
+  assert.JSONEq(t, `{"user_name": "bob"}`, string(event))
+
+  _, err = decoder.decode(framed(3, []byte{2, 8}, testProtobufPayload(t, "Login", "bob")))
+  assert.NotNil(t, err)
+
+  // Local descriptor sets are read with the message type
+  setBytes, err := proto.Marshal(&descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{testFileDescriptor()}})
+  assert.Nil(t, err)
+  schemaFile := filepath.Join(t.TempDir(), "events.desc")
+  assert.Nil(t, os.WriteFile(schemaFile, setBytes, 0600))
+
+  decoder, err = newDecoder(Config{Format: formatProtobuf, SchemaFile: schemaFile, MessageType: "events.Login"})
+  assert.Nil(t, err)
+  event, err = decoder.decode(framed(4, []byte{0}, testProtobufPayload(t, "Login", "alice")))
+  assert.Nil(t, err)
+  assert.JSONEq(t, `{"user_name": "alice"}`, string(event))
+
+  _, err = newDecoder(Config{Format: formatProtobuf, SchemaFile: schemaFile, MessageType: "events.Missing"})
+  assert.NotNil(t, err)
+}
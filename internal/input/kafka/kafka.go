@@ -7,27 +7,68 @@ import (
   "github.com/ThoronicLLC/collector/internal/integrations/kafka"
   "github.com/ThoronicLLC/collector/pkg/core"
   kafkago "github.com/segmentio/kafka-go"
+  "regexp"
+  "sort"
   "sync"
   "time"
 )
 
 var InputName = "kafka"
 
+const (
+  startOffsetEarliest  = "earliest"
+  startOffsetLatest    = "latest"
+  startOffsetTimestamp = "timestamp"
+)
+
+// Config for the kafka input. Topics are read with the consumer group when a group ID is set. Without a group the
+// partitions are read directly and their offsets are saved in the input state.
 type Config struct {
   Brokers        []string         `json:"brokers" validate:"required"`
-  Topic          string           `json:"topic" validate:"required"`
-  GroupID        string           `json:"group_id" validate:"required"`
+  Topic          string           `json:"topic"`
+  Topics         []string         `json:"topics"`
+  TopicRegex     string           `json:"topic_regex"` // Matched against the topics when the input starts
+  GroupID        string           `json:"group_id"`
+  Partitions     []int            `json:"partitions"` // Partitions to read without a group, defaults to all
   MinBytes       int              `json:"min_bytes"`
   MaxBytes       int              `json:"max_bytes"`
   AuthConfig     kafka.AuthConfig `json:"auth_config"`
   IncludeHeaders bool             `json:"include_headers"`
   FlushFrequency int              `json:"flush_frequency" validate:"required|min:0"`
+  MaxBatchBytes  int64            `json:"max_batch_bytes" validate:"min:0"`
+  MaxBatchEvents int              `json:"max_batch_events" validate:"min:0"`
+
+  // StartOffset is where partitions without a committed or saved offset start: earliest, latest or timestamp
+  StartOffset    string `json:"start_offset" validate:"in:earliest,latest,timestamp"`
+  StartTimestamp string `json:"start_timestamp"` // RFC3339 time used by the timestamp start offset
+
+  // Format of the message values: raw, avro or protobuf. Avro and protobuf messages are expected in the Confluent
+  // wire format and are decoded to JSON with the schema from the registry, or the local schema file when the
+  // registry is not set or does not have the schema. Protobuf schema files are a FileDescriptorSet read with the
+  // message type. Messages that can not be decoded are written with the base64 value under value and the error
+  // under @metadata.
+  Format         string               `json:"format" validate:"in:raw,avro,protobuf"`
+  SchemaRegistry SchemaRegistryConfig `json:"schema_registry"`
+  SchemaFile     string               `json:"schema_file"`
+  MessageType    string               `json:"message_type"`
+
+  // MaxInFlightBatches is how many batches may be waiting on the outputs before fetching stops, leaving the lag on
+  // the brokers. MaxDiskBytes stops fetching once the waiting batches use that many bytes of temp files. Zero
+  // disables either limit.
+  MaxInFlightBatches int   `json:"max_in_flight_batches" validate:"min:0"`
+  MaxDiskBytes       int64 `json:"max_disk_bytes" validate:"min:0"`
 }
 
 type kafkaInput struct {
   config     Config
   ctx        context.Context
   cancelFunc context.CancelFunc
+  decoder    valueDecoder
+}
+
+// kafkaState is the next offset to read for each partition read without a group
+type kafkaState struct {
+  Offsets map[string]map[int]int64 `json:"offsets"`
 }
 
 func Handler() core.InputHandler {
@@ -38,6 +79,8 @@ func Handler() core.InputHandler {
       MaxBytes:       10e6, // 10MB
       FlushFrequency: 300,
       IncludeHeaders: false,
+      StartOffset:    startOffsetEarliest,
+      Format:         formatRaw,
     }
 
     // Unmarshal config
@@ -52,6 +95,28 @@ func Handler() core.InputHandler {
       return nil, err
     }
 
+    // Validate topic and offset settings
+    err = validateConfig(conf)
+    if err != nil {
+      return nil, err
+    }
+
+    err = conf.AuthConfig.Validate()
+    if err != nil {
+      return nil, err
+    }
+
+    // Setup the schema decoder
+    err = validateDecoderConfig(conf)
+    if err != nil {
+      return nil, err
+    }
+
+    decoder, err := newDecoder(conf)
+    if err != nil {
+      return nil, err
+    }
+
     // Setup context
     ctx, cancelFn := context.WithCancel(context.Background())
 
@@ -59,101 +124,75 @@ func Handler() core.InputHandler {
       config:     conf,
       ctx:        ctx,
       cancelFunc: cancelFn,
+      decoder:    decoder,
     }, nil
   }
 }
 
 func (k *kafkaInput) Run(errorHandler core.ErrorHandler, state core.State, processPipe chan<- core.PipelineResults) {
+  // Track the offsets of partitions read without a group
+  offsets := newOffsetTracker(loadState(state))
+  batchConfig := core.BatchConfig{
+    FlushFrequency:     k.config.FlushFrequency,
+    MaxBatchBytes:      k.config.MaxBatchBytes,
+    MaxBatchEvents:     k.config.MaxBatchEvents,
+    MaxInFlightBatches: k.config.MaxInFlightBatches,
+    MaxDiskBytes:       k.config.MaxDiskBytes,
+    DropPolicy:         core.DropPolicyBlock,
+  }
+  if k.config.GroupID == "" {
+    batchConfig.State = offsets.state
+  }
+
   // Setup local variables
-  tmpWriter, err := core.NewTmpWriter()
+  batcher, err := core.NewBatcher(k.ctx, batchConfig, processPipe)
   if err != nil {
     errorHandler(true, err)
     return
   }
 
-  reader, err := kafka.NewReader(kafka.ReaderConfig{
-    Ctx:        k.ctx,
-    AuthConfig: k.config.AuthConfig,
-    Brokers:    k.config.Brokers,
-    Topic:      k.config.Topic,
-    GroupID:    k.config.GroupID,
-    MinBytes:   k.config.MinBytes,
-    MaxBytes:   k.config.MaxBytes,
-  })
-
+  readers, err := k.newReaders(offsets)
   if err != nil {
     errorHandler(true, err)
     return
   }
 
-  // Setup wait group
+  // Setup wait group. The flush context is only cancelled once the readers have stopped writing, so the final
+  // flush includes every message read.
   var wg sync.WaitGroup
-  flushCtx, flushCancelFn := context.WithCancel(k.ctx)
+  var readerWg sync.WaitGroup
+  flushCtx, flushCancelFn := context.WithCancel(context.Background())
+
+  for _, reader := range readers {
+    readerWg.Add(1)
+    go func(reader *kafka.Reader) {
+      defer readerWg.Done()
+      k.consume(reader, batcher, offsets, errorHandler)
+    }(reader)
+  }
 
   wg.Add(1)
   go func() {
     defer wg.Done()
     defer flushCancelFn()
-    for {
-      m, err := reader.ReadMessage()
-      if err != nil {
-        if err == context.Canceled {
-          return
-        } else {
-          errorHandler(false, fmt.Errorf("error reading message: %w", err))
-          continue
-        }
-      }
-
-      // Get message value
-      messageValue := m.Value
-
-      // If headers should be included, and the message is json, add them to the message
-      if k.config.IncludeHeaders {
-        newMessageValue, err := addHeadersToJsonMessages(m)
-        if err != nil {
-          errorHandler(false, fmt.Errorf("unable to add kafka headers to message: %w", err))
-        } else {
-          messageValue = newMessageValue
-        }
-      }
-
-      _, writeErr := tmpWriter.Write(messageValue)
-      if writeErr != nil {
-        errorHandler(false, fmt.Errorf("error writing to tmp file: %w", writeErr))
-      }
-    }
+    readerWg.Wait()
   }()
 
   // Start timed process sync go routine
   wg.Add(1)
   go func() {
     defer wg.Done()
-    for {
-      select {
-      case <-flushCtx.Done():
-        return
-      case <-time.After(time.Duration(k.config.FlushFrequency) * time.Second):
-        err = flush(tmpWriter, processPipe)
-        if err != nil {
-          errorHandler(false, fmt.Errorf("issue flushing file: %s", err))
-        }
-      }
-    }
+    batcher.Run(flushCtx, errorHandler)
   }()
 
   wg.Wait()
 
-  // Close the reader
-  err = reader.Close()
-  if err != nil {
-    errorHandler(false, fmt.Errorf("error closing reader: %w", err))
-  }
-
-  // Flush any remaining data to pipeline before return
-  err = flush(tmpWriter, processPipe)
-  if err != nil {
-    errorHandler(false, fmt.Errorf("issue flushing file: %s", err))
+  // Close the readers
+  for _, reader := range readers {
+    err = reader.Close()
+    if err != nil {
+      errorHandler(false, fmt.Errorf("error closing reader: %w", err))
+    }
   }
 }
 
@@ -161,22 +200,60 @@ func (k *kafkaInput) Stop() {
   k.cancelFunc()
 }
 
-func flush(tmpFile *core.TmpWriter, processPipe chan<- core.PipelineResults) error {
-  // Rotate the temp writer
-  count, fileName, err := tmpFile.Rotate()
-  if err != nil {
-    return err
-  }
+// consume writes the messages from the reader to the batcher until the input is stopped or a message can not be
+// written
+func (k *kafkaInput) consume(reader *kafka.Reader, batcher *core.Batcher, offsets *offsetTracker, errorHandler core.ErrorHandler) {
+  for {
+    m, err := reader.ReadMessage()
+    if err != nil {
+      if err == context.Canceled {
+        return
+      } else {
+        errorHandler(false, fmt.Errorf("error reading message: %w", err))
+        continue
+      }
+    }
 
-  // Only send on if there are results
-  processPipe <- core.PipelineResults{
-    FilePath:    fileName,
-    ResultCount: count,
-    State:       nil,
-    RetryCount:  0,
-  }
+    // Decode schema registry framed messages to JSON. Messages that can not be decoded are written with their raw
+    // value so they are not lost.
+    if k.decoder != nil {
+      decoded, err := k.decoder.decode(m.Value)
+      if err != nil {
+        errorHandler(false, fmt.Errorf("unable to decode message at %s/%d offset %d, writing the raw value: %w", m.Topic, m.Partition, m.Offset, err))
+        decoded, err = newUndecodedEvent(m, err)
+        if err != nil {
+          errorHandler(true, fmt.Errorf("error encoding undecoded message, stopped reading %s/%d at offset %d: %w", m.Topic, m.Partition, m.Offset, err))
+          return
+        }
+      }
+      m.Value = decoded
+    }
 
-  return nil
+    // Get message value
+    messageValue := m.Value
+
+    // If headers should be included, and the message is json, add them to the message
+    if k.config.IncludeHeaders {
+      newMessageValue, err := addHeadersToJsonMessages(m)
+      if err != nil {
+        errorHandler(false, fmt.Errorf("unable to add kafka headers to message: %w", err))
+      } else {
+        messageValue = newMessageValue
+      }
+    }
+
+    // Stop reading so the offset never moves past a message missing from the batches
+    _, writeErr := batcher.Write(messageValue)
+    if writeErr != nil {
+      errorHandler(true, fmt.Errorf("error writing to tmp file, stopped reading %s/%d at offset %d: %w", m.Topic, m.Partition, m.Offset, writeErr))
+      return
+    }
+
+    // Only advance the offset once the message is in a batch
+    if k.config.GroupID == "" {
+      offsets.set(m.Topic, m.Partition, m.Offset+1)
+    }
+  }
 }
 
 func addHeadersToJsonMessages(message kafkago.Message) ([]byte, error) {
@@ -212,3 +289,307 @@ func addHeadersToJsonMessages(message kafkago.Message) ([]byte, error) {
 
   return newMessage, nil
 }
+
+// newReaders creates a single reader for the consumer group, or a reader for each partition without a group
+func (k *kafkaInput) newReaders(offsets *offsetTracker) ([]*kafka.Reader, error) {
+  client, err := kafka.NewClient(kafka.ClientConfig{
+    AuthConfig: k.config.AuthConfig,
+    Brokers:    k.config.Brokers,
+  })
+  if err != nil {
+    return nil, err
+  }
+
+  partitions, err := k.topicPartitions(client)
+  if err != nil {
+    return nil, err
+  }
+
+  if k.config.GroupID != "" {
+    reader, err := k.newGroupReader(client, partitions)
+    if err != nil {
+      return nil, err
+    }
+    return []*kafka.Reader{reader}, nil
+  }
+
+  readers := make([]*kafka.Reader, 0)
+  for _, topic := range sortedTopics(partitions) {
+    for _, partition := range partitions[topic] {
+      reader, err := k.newPartitionReader(client, topic, partition, offsets)
+      if err != nil {
+        for _, v := range readers {
+          _ = v.Close()
+        }
+        return nil, err
+      }
+      readers = append(readers, reader)
+    }
+  }
+
+  return readers, nil
+}
+
+// topicPartitions returns the partitions to read for each configured or matching topic
+func (k *kafkaInput) topicPartitions(client *kafka.Client) (map[string][]int, error) {
+  var topics []string
+  switch {
+  case k.config.Topic != "":
+    topics = []string{k.config.Topic}
+  case len(k.config.Topics) > 0:
+    topics = k.config.Topics
+  }
+
+  partitions, err := client.Partitions(k.ctx, topics)
+  if err != nil {
+    return nil, fmt.Errorf("issue listing topics: %w", err)
+  }
+
+  // Keep the topics matching the regex
+  if k.config.TopicRegex != "" {
+    topicRegex := regexp.MustCompile(k.config.TopicRegex)
+    for topic := range partitions {
+      if !topicRegex.MatchString(topic) {
+        delete(partitions, topic)
+      }
+    }
+
+    if len(partitions) == 0 {
+      return nil, fmt.Errorf("no topics match %s", k.config.TopicRegex)
+    }
+  }
+
+  // Keep the configured partitions
+  if len(k.config.Partitions) > 0 {
+    for topic, available := range partitions {
+      partitions[topic] = make([]int, 0)
+      for _, partition := range k.config.Partitions {
+        if !containsPartition(available, partition) {
+          return nil, fmt.Errorf("topic %s has no partition %d", topic, partition)
+        }
+        partitions[topic] = append(partitions[topic], partition)
+      }
+    }
+  }
+
+  return partitions, nil
+}
+
+// newGroupReader creates the consumer group reader. With a timestamp start offset, partitions the group has no
+// committed offset for are committed at the timestamp first.
+func (k *kafkaInput) newGroupReader(client *kafka.Client, partitions map[string][]int) (*kafka.Reader, error) {
+  startOffset := kafkago.FirstOffset
+  switch k.config.StartOffset {
+  case startOffsetLatest:
+    startOffset = kafkago.LastOffset
+  case startOffsetTimestamp:
+    err := k.commitStartOffsets(client, partitions)
+    if err != nil {
+      return nil, err
+    }
+  }
+
+  return kafka.NewReader(kafka.ReaderConfig{
+    Ctx:         k.ctx,
+    AuthConfig:  k.config.AuthConfig,
+    Brokers:     k.config.Brokers,
+    Topics:      sortedTopics(partitions),
+    GroupID:     k.config.GroupID,
+    StartOffset: startOffset,
+    MinBytes:    k.config.MinBytes,
+    MaxBytes:    k.config.MaxBytes,
+  })
+}
+
+// commitStartOffsets commits the start timestamp offsets for the partitions without a committed offset
+func (k *kafkaInput) commitStartOffsets(client *kafka.Client, partitions map[string][]int) error {
+  startTime, _ := time.Parse(time.RFC3339, k.config.StartTimestamp)
+
+  committed, err := client.CommittedOffsets(k.ctx, k.config.GroupID, partitions)
+  if err != nil {
+    return fmt.Errorf("issue getting committed offsets: %w", err)
+  }
+
+  offsets := make(map[string]map[int]int64)
+  for topic, topicPartitions := range partitions {
+    missing := make([]int, 0)
+    for _, partition := range topicPartitions {
+      offset, ok := committed[topic][partition]
+      if !ok || offset < 0 {
+        missing = append(missing, partition)
+      }
+    }
+
+    if len(missing) == 0 {
+      continue
+    }
+
+    offsets[topic], err = client.OffsetsAt(k.ctx, topic, missing, startTime)
+    if err != nil {
+      return fmt.Errorf("issue getting offsets at %s: %w", k.config.StartTimestamp, err)
+    }
+  }
+
+  if len(offsets) == 0 {
+    return nil
+  }
+
+  err = client.CommitOffsets(k.ctx, k.config.GroupID, offsets)
+  if err != nil {
+    return fmt.Errorf("issue committing start offsets: %w", err)
+  }
+
+  return nil
+}
+
+// newPartitionReader creates a reader for a single partition, starting from the saved offset if there is one
+func (k *kafkaInput) newPartitionReader(client *kafka.Client, topic string, partition int, offsets *offsetTracker) (*kafka.Reader, error) {
+  reader, err := kafka.NewReader(kafka.ReaderConfig{
+    Ctx:        k.ctx,
+    AuthConfig: k.config.AuthConfig,
+    Brokers:    k.config.Brokers,
+    Topic:      topic,
+    Partition:  partition,
+    MinBytes:   k.config.MinBytes,
+    MaxBytes:   k.config.MaxBytes,
+  })
+  if err != nil {
+    return nil, err
+  }
+
+  offset, ok := offsets.get(topic, partition)
+  if !ok {
+    offset, err = k.startOffset(client, topic, partition)
+    if err != nil {
+      _ = reader.Close()
+      return nil, err
+    }
+  }
+
+  err = reader.SetOffset(offset)
+  if err != nil {
+    _ = reader.Close()
+    return nil, err
+  }
+
+  return reader, nil
+}
+
+// startOffset returns the configured start offset of a partition without a saved offset
+func (k *kafkaInput) startOffset(client *kafka.Client, topic string, partition int) (int64, error) {
+  switch k.config.StartOffset {
+  case startOffsetLatest:
+    return kafkago.LastOffset, nil
+  case startOffsetTimestamp:
+    startTime, _ := time.Parse(time.RFC3339, k.config.StartTimestamp)
+    offsets, err := client.OffsetsAt(k.ctx, topic, []int{partition}, startTime)
+    if err != nil {
+      return 0, fmt.Errorf("issue getting offsets at %s: %w", k.config.StartTimestamp, err)
+    }
+    return offsets[partition], nil
+  }
+
+  return kafkago.FirstOffset, nil
+}
+
+// offsetTracker holds the next offset to read for each partition
+type offsetTracker struct {
+  mu      sync.Mutex
+  offsets map[string]map[int]int64
+}
+
+func newOffsetTracker(state kafkaState) *offsetTracker {
+  return &offsetTracker{offsets: state.Offsets}
+}
+
+func (t *offsetTracker) get(topic string, partition int) (int64, bool) {
+  t.mu.Lock()
+  defer t.mu.Unlock()
+  offset, ok := t.offsets[topic][partition]
+  return offset, ok
+}
+
+func (t *offsetTracker) set(topic string, partition int, offset int64) {
+  t.mu.Lock()
+  defer t.mu.Unlock()
+  if t.offsets[topic] == nil {
+    t.offsets[topic] = make(map[int]int64)
+  }
+  t.offsets[topic][partition] = offset
+}
+
+// state returns the offsets as the input state
+func (t *offsetTracker) state() core.State {
+  t.mu.Lock()
+  defer t.mu.Unlock()
+  state, _ := json.Marshal(kafkaState{Offsets: t.offsets})
+  return state
+}
+
+func loadState(state core.State) kafkaState {
+  loadedState := kafkaState{}
+  if state != nil {
+    _ = json.Unmarshal(state, &loadedState)
+  }
+
+  if loadedState.Offsets == nil {
+    loadedState.Offsets = make(map[string]map[int]int64)
+  }
+
+  return loadedState
+}
+
+// validateConfig checks the topic, partition and start offset settings
+func validateConfig(conf Config) error {
+  topicSettings := 0
+  for _, v := range []bool{conf.Topic != "", len(conf.Topics) > 0, conf.TopicRegex != ""} {
+    if v {
+      topicSettings++
+    }
+  }
+  if topicSettings != 1 {
+    return fmt.Errorf("one of topic, topics or topic_regex is required")
+  }
+
+  if conf.TopicRegex != "" {
+    _, err := regexp.Compile(conf.TopicRegex)
+    if err != nil {
+      return fmt.Errorf("issue compiling topic_regex: %s", err)
+    }
+  }
+
+  if len(conf.Partitions) > 0 && conf.GroupID != "" {
+    return fmt.Errorf("partitions cannot be set with a group_id, the group assigns partitions")
+  }
+
+  if len(conf.Partitions) > 0 && conf.Topic == "" {
+    return fmt.Errorf("partitions can only be set with a single topic")
+  }
+
+  if conf.StartOffset == startOffsetTimestamp {
+    _, err := time.Parse(time.RFC3339, conf.StartTimestamp)
+    if err != nil {
+      return fmt.Errorf("timestamp start_offset requires an RFC3339 start_timestamp")
+    }
+  }
+
+  return nil
+}
+
+func containsPartition(partitions []int, partition int) bool {
+  for _, v := range partitions {
+    if v == partition {
+      return true
+    }
+  }
+  return false
+}
+
+func sortedTopics(partitions map[string][]int) []string {
+  topics := make([]string, 0, len(partitions))
+  for topic := range partitions {
+    topics = append(topics, topic)
+  }
+  sort.Strings(topics)
+  return topics
+}
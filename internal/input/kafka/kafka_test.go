@@ -11,14 +11,31 @@ var config1 = `{"brokers": ["uri-1", "uri-2"], "topic": "topic-1", "group_id": "
 var config2 = `{"brokers": ["uri-1"], "topic": "topic-1", "group_id": "security", "auth_config": {"scram_sha_256": {"enabled": true, "username": "user", "password": "pass"}}, "flush_frequency": 300}`
 var config3 = `{"brokers": ["uri-1"], "topic": "topic-1", "group_id": "security", "auth_config": {"scram_sha_512": {"enabled": true, "username": "user", "password": "pass"}}, "flush_frequency": 300}`
 var config4 = `{"brokers": ["uri-1"], "topic": "topic-1", "group_id": "security", "auth_config": {"gssapi_password": {"enabled": true, "username": "user", "password": "pass"}}, "flush_frequency": 300}`
+var config5 = `{"brokers": ["uri-1"], "topic": "topic-1", "group_id": "security", "flush_frequency": 300, "max_batch_bytes": 104857600, "max_batch_events": 50000}`
+var config6 = `{"brokers": ["uri-1"], "topic": "topic-1", "group_id": "", "flush_frequency": 300}`
+var config7 = `{"brokers": ["uri-1"], "topics": ["topic-1", "topic-2"], "group_id": "security", "start_offset": "latest", "flush_frequency": 300}`
+var config8 = `{"brokers": ["uri-1"], "topic_regex": "^audit-.*", "group_id": "security", "start_offset": "timestamp", "start_timestamp": "2022-01-01T00:00:00Z", "flush_frequency": 300}`
+var config9 = `{"brokers": ["uri-1"], "topic": "topic-1", "partitions": [0, 2], "start_offset": "timestamp", "start_timestamp": "2022-01-01T00:00:00Z", "flush_frequency": 300}`
+var config10 = `{"brokers": ["uri-1"], "topic": "topic-1", "group_id": "security", "format": "avro", "schema_registry": {"url": "https://registry.example.com", "username": "key", "password": "secret"}, "flush_frequency": 300}`
+var config11 = `{"brokers": ["uri-1"], "topic": "topic-1", "group_id": "security", "format": "protobuf", "schema_registry": {"url": "http://localhost:8081", "timeout": 10}, "flush_frequency": 300}`
 var badConfig1 = `{"brokers": [], "topic": "topic-1", "group_id": "security", "flush_frequency": 300}`
 var badConfig2 = `{"brokers": [], "topic": "", "group_id": "", "flush_frequency": 0}`
 var badConfig3 = `{"brokers": ["uri-1"], "topic": "", "group_id": "security", "flush_frequency": 300}`
-var badConfig4 = `{"brokers": ["uri-1"], "topic": "topic-1", "group_id": "", "flush_frequency": 300}`
+var badConfig4 = `{"brokers": ["uri-1"], "topic": "topic-1", "group_id": "security", "partitions": [0], "flush_frequency": 300}`
 var badConfig5 = `{"brokers": ["uri-1"], "topic": "topic-1", "group_id": "security", "flush_frequency": 0}`
+var badConfig6 = `{"brokers": ["uri-1"], "topic": "topic-1", "group_id": "security", "flush_frequency": 300, "max_batch_events": -1}`
+var badConfig7 = `{"brokers": ["uri-1"], "topic": "topic-1", "topics": ["topic-2"], "group_id": "security", "flush_frequency": 300}`
+var badConfig8 = `{"brokers": ["uri-1"], "topic_regex": "audit-(", "group_id": "security", "flush_frequency": 300}`
+var badConfig9 = `{"brokers": ["uri-1"], "topic": "topic-1", "group_id": "security", "start_offset": "timestamp", "flush_frequency": 300}`
+var badConfig10 = `{"brokers": ["uri-1"], "topic": "topic-1", "group_id": "security", "start_offset": "newest", "flush_frequency": 300}`
+var badConfig11 = `{"brokers": ["uri-1"], "topics": ["topic-1", "topic-2"], "partitions": [0], "flush_frequency": 300}`
+var badConfig12 = `{"brokers": ["uri-1"], "topic": "topic-1", "group_id": "security", "format": "xml", "flush_frequency": 300}`
+var badConfig13 = `{"brokers": ["uri-1"], "topic": "topic-1", "group_id": "security", "format": "avro", "flush_frequency": 300}`
+var badConfig14 = `{"brokers": ["uri-1"], "topic": "topic-1", "group_id": "security", "format": "protobuf", "schema_file": "/tmp/events.desc", "flush_frequency": 300}`
+var badConfig15 = `{"brokers": ["uri-1"], "topic": "topic-1", "group_id": "security", "format": "avro", "schema_file": "/does/not/exist.avsc", "flush_frequency": 300}`
 
 func TestValidate(t *testing.T) {
-  arr := []string{config1, config2, config3, config4}
+  arr := []string{config1, config2, config3, config4, config5, config6, config7, config8, config9, config10, config11}
   for i, v := range arr {
     var testConfig Config
     err := json.Unmarshal([]byte(v), &testConfig)
@@ -29,7 +46,7 @@ func TestValidate(t *testing.T) {
 }
 
 func TestValidateFailed(t *testing.T) {
-  arr := []string{badConfig1, badConfig2, badConfig3, badConfig4, badConfig5}
+  arr := []string{badConfig1, badConfig2, badConfig5, badConfig6, badConfig10, badConfig12}
   for i, v := range arr {
     var testConfig Config
     err := json.Unmarshal([]byte(v), &testConfig)
@@ -40,7 +57,7 @@ func TestValidateFailed(t *testing.T) {
 }
 
 func TestHandler(t *testing.T) {
-  arr := []string{config1, config2, config3, config4}
+  arr := []string{config1, config2, config3, config4, config5, config6, config7, config8, config9, config10, config11}
   for i, v := range arr {
     var testConfig Config
     err := json.Unmarshal([]byte(v), &testConfig)
@@ -52,7 +69,7 @@ func TestHandler(t *testing.T) {
 }
 
 func TestHandlerFailed(t *testing.T) {
-  arr := []string{badConfig1, badConfig2, badConfig3, badConfig4, badConfig5}
+  arr := []string{badConfig1, badConfig2, badConfig3, badConfig4, badConfig5, badConfig6, badConfig7, badConfig8, badConfig9, badConfig10, badConfig11, badConfig12, badConfig13, badConfig14, badConfig15}
   for i, v := range arr {
     var testConfig Config
     err := json.Unmarshal([]byte(v), &testConfig)
@@ -62,3 +79,21 @@ func TestHandlerFailed(t *testing.T) {
     assert.NotNilf(t, err, "test #%d - validation should have returned an error: %s", i, err)
   }
 }
+
+func TestOffsetTracker(t *testing.T) {
+  offsets := newOffsetTracker(loadState([]byte(`{"offsets": {"topic-1": {"0": 42}}}`)))
+  offset, ok := offsets.get("topic-1", 0)
+  assert.True(t, ok)
+  assert.Equal(t, int64(42), offset)
+
+  _, ok = offsets.get("topic-1", 1)
+  assert.False(t, ok)
+
+  offsets.set("topic-1", 1, 7)
+  offsets.set("topic-2", 0, 3)
+  assert.Equal(t, `{"offsets":{"topic-1":{"0":42,"1":7},"topic-2":{"0":3}}}`, string(offsets.state()))
+
+  // Missing or invalid state starts without offsets
+  assert.Equal(t, 0, len(loadState(nil).Offsets))
+  assert.Equal(t, 0, len(loadState([]byte("")).Offsets))
+}
@@ -0,0 +1,253 @@
+package kafka
+
+import (
+  "encoding/base64"
+  "errors"
+  "fmt"
+  "github.com/ThoronicLLC/collector/internal/integrations/schemaregistry"
+  "google.golang.org/protobuf/encoding/protojson"
+  "google.golang.org/protobuf/encoding/protowire"
+  "google.golang.org/protobuf/proto"
+  "google.golang.org/protobuf/reflect/protodesc"
+  "google.golang.org/protobuf/reflect/protoreflect"
+  "google.golang.org/protobuf/reflect/protoregistry"
+  "google.golang.org/protobuf/types/descriptorpb"
+  "google.golang.org/protobuf/types/dynamicpb"
+  "os"
+  "strings"
+  "sync"
+
+  // Well known types imported by registered schemas are resolved from the global registry
+  _ "google.golang.org/protobuf/types/known/anypb"
+  _ "google.golang.org/protobuf/types/known/durationpb"
+  _ "google.golang.org/protobuf/types/known/emptypb"
+  _ "google.golang.org/protobuf/types/known/fieldmaskpb"
+  _ "google.golang.org/protobuf/types/known/structpb"
+  _ "google.golang.org/protobuf/types/known/timestamppb"
+  _ "google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// protobufDecoder decodes Confluent framed protobuf messages into JSON with the field names from the schema
+type protobufDecoder struct {
+  registry     *schemaregistry.Client
+  localMessage protoreflect.MessageDescriptor
+
+  mu    sync.Mutex
+  files map[int]protoreflect.FileDescriptor
+}
+
+// newProtobufDecoder creates the decoder. The local schema file is a FileDescriptorSet, as written by protoc with
+// --descriptor_set_out and --include_imports.
+func newProtobufDecoder(registry *schemaregistry.Client, schemaFile, messageType string) (*protobufDecoder, error) {
+  decoder := &protobufDecoder{
+    registry: registry,
+    files:    make(map[int]protoreflect.FileDescriptor),
+  }
+
+  if schemaFile != "" {
+    setBytes, err := os.ReadFile(schemaFile)
+    if err != nil {
+      return nil, fmt.Errorf("issue reading protobuf schema file: %s", err)
+    }
+
+    var set descriptorpb.FileDescriptorSet
+    err = proto.Unmarshal(setBytes, &set)
+    if err != nil {
+      return nil, fmt.Errorf("issue parsing protobuf schema file: %s", err)
+    }
+
+    files, err := protodesc.NewFiles(&set)
+    if err != nil {
+      return nil, fmt.Errorf("issue loading protobuf schema file: %s", err)
+    }
+
+    descriptor, err := files.FindDescriptorByName(protoreflect.FullName(messageType))
+    if err != nil {
+      return nil, fmt.Errorf("issue finding message type %s: %s", messageType, err)
+    }
+
+    var ok bool
+    decoder.localMessage, ok = descriptor.(protoreflect.MessageDescriptor)
+    if !ok {
+      return nil, fmt.Errorf("%s is not a message type", messageType)
+    }
+  }
+
+  return decoder, nil
+}
+
+func (d *protobufDecoder) decode(value []byte) ([]byte, error) {
+  id, payload, err := splitFraming(value)
+  if err != nil {
+    return nil, err
+  }
+
+  indexes, payload, err := splitMessageIndexes(payload)
+  if err != nil {
+    return nil, err
+  }
+
+  descriptor, err := d.message(id, indexes)
+  if err != nil {
+    return nil, err
+  }
+
+  message := dynamicpb.NewMessage(descriptor)
+  err = proto.Unmarshal(payload, message)
+  if err != nil {
+    return nil, fmt.Errorf("issue decoding protobuf message with schema %d: %s", id, err)
+  }
+
+  return protojson.MarshalOptions{UseProtoNames: true}.Marshal(message)
+}
+
+// message returns the message type for the schema ID and message indexes, using the local message type when the
+// registry does not have the schema
+func (d *protobufDecoder) message(id int, indexes []int) (protoreflect.MessageDescriptor, error) {
+  if d.registry == nil {
+    return d.localMessage, nil
+  }
+
+  file, err := d.file(id)
+  if err != nil {
+    if d.localMessage != nil && errors.Is(err, schemaregistry.ErrNotFound) {
+      return d.localMessage, nil
+    }
+    return nil, err
+  }
+
+  // The indexes are the path to the message through the nested messages of the file
+  messages := file.Messages()
+  var descriptor protoreflect.MessageDescriptor
+  for _, index := range indexes {
+    if index < 0 || index >= messages.Len() {
+      return nil, fmt.Errorf("message index %v not found in schema %d", indexes, id)
+    }
+    descriptor = messages.Get(index)
+    messages = descriptor.Messages()
+  }
+
+  return descriptor, nil
+}
+
+// file returns the file descriptor for the schema ID along with the files it imports
+func (d *protobufDecoder) file(id int) (protoreflect.FileDescriptor, error) {
+  d.mu.Lock()
+  file, ok := d.files[id]
+  d.mu.Unlock()
+  if ok {
+    return file, nil
+  }
+
+  registered, err := d.registry.SchemaByID(id)
+  if err != nil {
+    return nil, err
+  }
+  if registered.SchemaType != schemaregistry.TypeProtobuf {
+    return nil, fmt.Errorf("schema %d is a %s schema, not protobuf", id, strings.ToLower(registered.SchemaType))
+  }
+
+  files := &protoregistry.Files{}
+  file, err = d.buildFile(registered, fmt.Sprintf("schema-%d.proto", id), files)
+  if err != nil {
+    return nil, fmt.Errorf("issue loading protobuf schema %d: %w", id, err)
+  }
+
+  d.mu.Lock()
+  d.files[id] = file
+  d.mu.Unlock()
+
+  return file, nil
+}
+
+// buildFile builds the file descriptor of a serialized schema after registering the files it references
+func (d *protobufDecoder) buildFile(schema *schemaregistry.Schema, name string, files *protoregistry.Files) (protoreflect.FileDescriptor, error) {
+  for _, reference := range schema.References {
+    _, err := files.FindFileByPath(reference.Name)
+    if err == nil {
+      continue
+    }
+
+    referenced, err := d.registry.SchemaByReference(reference)
+    if err != nil {
+      return nil, err
+    }
+
+    file, err := d.buildFile(referenced, reference.Name, files)
+    if err != nil {
+      return nil, err
+    }
+
+    err = files.RegisterFile(file)
+    if err != nil {
+      return nil, fmt.Errorf("issue registering %s: %s", reference.Name, err)
+    }
+  }
+
+  descriptorBytes, err := base64.StdEncoding.DecodeString(schema.Schema)
+  if err != nil {
+    return nil, fmt.Errorf("issue decoding serialized schema: %s", err)
+  }
+
+  var fileProto descriptorpb.FileDescriptorProto
+  err = proto.Unmarshal(descriptorBytes, &fileProto)
+  if err != nil {
+    return nil, fmt.Errorf("issue parsing serialized schema: %s", err)
+  }
+
+  // Imports are resolved by the reference names
+  fileProto.Name = proto.String(name)
+
+  return protodesc.NewFile(&fileProto, fileResolver{files: files})
+}
+
+// fileResolver resolves imports from the referenced schemas, then the well known types
+type fileResolver struct {
+  files *protoregistry.Files
+}
+
+func (r fileResolver) FindFileByPath(path string) (protoreflect.FileDescriptor, error) {
+  file, err := r.files.FindFileByPath(path)
+  if err == nil {
+    return file, nil
+  }
+  return protoregistry.GlobalFiles.FindFileByPath(path)
+}
+
+func (r fileResolver) FindDescriptorByName(name protoreflect.FullName) (protoreflect.Descriptor, error) {
+  descriptor, err := r.files.FindDescriptorByName(name)
+  if err == nil {
+    return descriptor, nil
+  }
+  return protoregistry.GlobalFiles.FindDescriptorByName(name)
+}
+
+// splitMessageIndexes reads the zigzag encoded message indexes that follow the schema ID. A count of zero is
+// shorthand for the first message in the file.
+func splitMessageIndexes(payload []byte) ([]int, []byte, error) {
+  count, n := protowire.ConsumeVarint(payload)
+  if n < 0 {
+    return nil, nil, fmt.Errorf("issue reading protobuf message indexes")
+  }
+  payload = payload[n:]
+
+  length := protowire.DecodeZigZag(count)
+  if length == 0 {
+    return []int{0}, payload, nil
+  }
+  if length < 0 || length > int64(len(payload)) {
+    return nil, nil, fmt.Errorf("invalid protobuf message index count: %d", length)
+  }
+
+  indexes := make([]int, 0, length)
+  for i := int64(0); i < length; i++ {
+    index, n := protowire.ConsumeVarint(payload)
+    if n < 0 {
+      return nil, nil, fmt.Errorf("issue reading protobuf message indexes")
+    }
+    payload = payload[n:]
+    indexes = append(indexes, int(protowire.DecodeZigZag(index)))
+  }
+
+  return indexes, payload, nil
+}
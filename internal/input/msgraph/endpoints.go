@@ -0,0 +1,97 @@
+package msgraph
+
+import (
+	"fmt"
+	"github.com/ThoronicLLC/collector/internal/integrations/msgraph"
+	"net/url"
+)
+
+const (
+	endpointSecurityAlerts    = "security_alerts"
+	endpointSecurityAlertsV2  = "security_alerts_v2"
+	endpointSecurityIncidents = "security_incidents"
+	endpointSignIns           = "sign_ins"
+	endpointDirectoryAudits   = "directory_audits"
+	endpointDefenderAlerts    = "defender_alerts"
+)
+
+// endpoint is a list API along with the timestamp fields used to filter it. Endpoints without a modifiedField
+// are always filtered by their timestampField.
+type endpoint struct {
+	scope          msgraph.Scope
+	timestampField string
+	modifiedField  string
+	pageSize       int
+	list           func(client *msgraph.Client, params url.Values) (*msgraph.GraphListResponse, error)
+}
+
+var endpoints = map[string]endpoint{
+	endpointSecurityAlerts: {
+		scope:          msgraph.GraphScope,
+		timestampField: "createdDateTime",
+		modifiedField:  "lastModifiedDateTime",
+		pageSize:       1000,
+		list:           (*msgraph.Client).SecurityAlerts,
+	},
+	endpointSecurityAlertsV2: {
+		scope:          msgraph.GraphScope,
+		timestampField: "createdDateTime",
+		modifiedField:  "lastUpdateDateTime",
+		pageSize:       2000,
+		list:           (*msgraph.Client).SecurityAlertsV2,
+	},
+	endpointSecurityIncidents: {
+		scope:          msgraph.GraphScope,
+		timestampField: "createdDateTime",
+		modifiedField:  "lastUpdateDateTime",
+		pageSize:       50,
+		list:           (*msgraph.Client).SecurityIncidents,
+	},
+	endpointSignIns: {
+		scope:          msgraph.GraphScope,
+		timestampField: "createdDateTime",
+		pageSize:       999,
+		list:           (*msgraph.Client).SignIns,
+	},
+	endpointDirectoryAudits: {
+		scope:          msgraph.GraphScope,
+		timestampField: "activityDateTime",
+		pageSize:       999,
+		list:           (*msgraph.Client).DirectoryAudits,
+	},
+	endpointDefenderAlerts: {
+		scope:          msgraph.AtpScope,
+		timestampField: "alertCreationTime",
+		modifiedField:  "lastUpdateTime",
+		pageSize:       10000,
+		list:           (*msgraph.Client).DefenderAlerts,
+	},
+}
+
+// filterField returns the field the endpoint is filtered by
+func (ep endpoint) filterField(lastModified bool) string {
+	if lastModified && ep.modifiedField != "" {
+		return ep.modifiedField
+	}
+	return ep.timestampField
+}
+
+// validateEndpoints checks every endpoint is supported and only listed once
+func validateEndpoints(names []string) error {
+	if len(names) == 0 {
+		return fmt.Errorf("at least one endpoint is required")
+	}
+
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if _, ok := endpoints[name]; !ok {
+			return fmt.Errorf("unsupported endpoint: %s", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("duplicate endpoint: %s", name)
+		}
+		seen[name] = true
+	}
+
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/ThoronicLLC/collector/pkg/core"
 	"github.com/tidwall/pretty"
 	"net/url"
+	"os"
 	"time"
 )
 
@@ -15,32 +16,51 @@ var InputName = "msgraph"
 
 type Config struct {
 	TenantID     string `json:"tenant_id" validate:"required"`
-	ClientID     string `json:"client_id" validate:"required"`
-	ClientSecret string `json:"client_secret" validate:"required"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
 	Schedule     int    `json:"schedule" validate:"required|min:0"`
 
+	// Alternatives to the client secret. The certificate may be a PEM file with the certificate and private key
+	// or a PFX file. Managed identities use the system-assigned identity when no client ID is set.
+	CertificatePath     string `json:"certificate_path"`
+	CertificatePassword string `json:"certificate_password"`
+	FederatedTokenFile  string `json:"federated_token_file"`
+	ManagedIdentity     bool   `json:"managed_identity"`
+
+	// Endpoints to collect from: security_alerts, security_alerts_v2, security_incidents, sign_ins,
+	// directory_audits and defender_alerts. Defaults to security_alerts.
+	Endpoints []string `json:"endpoints"`
+
+	// Lookback is how many seconds before the last run each query starts, to pick up records indexed late. Records
+	// read in the overlap are de-duplicated by ID, remembering at most MaxSeenIDs per endpoint.
+	Lookback   int `json:"lookback" validate:"min:0"`
+	MaxSeenIDs int `json:"max_seen_ids" validate:"min:0"`
+
+	// QueryLastModified filters alerts and incidents by their last modified time instead of the created time, so
+	// updates to a record are read again
+	QueryLastModified bool `json:"query_last_modified"`
+
 	// This is not required. We use the default/global endpoint which will work for most users
 	// https://docs.microsoft.com/en-us/graph/deployments#microsoft-graph-and-graph-explorer-service-root-endpoints
-	GraphEndpoint string `json:"graph_endpoint,omitempty"`
-	LoginEndpoint string `json:"login_endpoint,omitempty"`
+	GraphEndpoint    string `json:"graph_endpoint,omitempty"`
+	LoginEndpoint    string `json:"login_endpoint,omitempty"`
+	DefenderEndpoint string `json:"defender_endpoint,omitempty"`
 }
 
 type msgraphInput struct {
 	config     Config
 	ctx        context.Context
 	cancelFunc context.CancelFunc
-	client     *msgraph.Client
-}
-
-type msgraphState struct {
-	LastTimestamp int64 `json:"last_timestamp"`
+	clients    map[msgraph.Scope]*msgraph.Client
 }
 
 func Handler() core.InputHandler {
 	return func(config []byte) (core.Input, error) {
 		// Set config defaults
 		conf := Config{
-			Schedule: 60,
+			Schedule:   60,
+			Endpoints:  []string{endpointSecurityAlerts},
+			MaxSeenIDs: 10000,
 		}
 
 		// Unmarshal config
@@ -55,20 +75,20 @@ func Handler() core.InputHandler {
 			return nil, err
 		}
 
-		// Setup client
-		client := msgraph.NewClient(conf.TenantID, conf.ClientID, conf.ClientSecret, msgraph.GraphScope.String())
+		err = validateEndpoints(conf.Endpoints)
+		if err != nil {
+			return nil, err
+		}
 
-		// Setup other MS login endpoint
-		if conf.LoginEndpoint != "" {
-			err = client.SetLoginEndpoint(conf.LoginEndpoint)
-			if err != nil {
-				return nil, err
+		// Setup a client for each scope the endpoints need
+		clients := make(map[msgraph.Scope]*msgraph.Client)
+		for _, name := range conf.Endpoints {
+			scope := endpoints[name].scope
+			if _, ok := clients[scope]; ok {
+				continue
 			}
-		}
 
-		// Setup other MS graph endpoint
-		if conf.GraphEndpoint != "" {
-			err = client.SetGraphEndpoint(conf.GraphEndpoint)
+			clients[scope], err = newClient(conf, scope)
 			if err != nil {
 				return nil, err
 			}
@@ -81,22 +101,63 @@ func Handler() core.InputHandler {
 			config:     conf,
 			ctx:        ctx,
 			cancelFunc: cancelFn,
-			client:     client,
+			clients:    clients,
 		}, nil
 	}
 }
 
+func newClient(conf Config, scope msgraph.Scope) (*msgraph.Client, error) {
+	client, err := msgraph.NewClientWithCredentials(conf.TenantID, conf.ClientID, msgraph.Credentials{
+		ClientSecret:        conf.ClientSecret,
+		CertificatePath:     conf.CertificatePath,
+		CertificatePassword: conf.CertificatePassword,
+		FederatedTokenFile:  conf.FederatedTokenFile,
+		ManagedIdentity:     conf.ManagedIdentity,
+	}, scope.String())
+	if err != nil {
+		return nil, err
+	}
+
+	// Setup other MS login endpoint
+	if conf.LoginEndpoint != "" {
+		err = client.SetLoginEndpoint(conf.LoginEndpoint)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Setup other MS graph endpoint
+	if conf.GraphEndpoint != "" {
+		err = client.SetGraphEndpoint(conf.GraphEndpoint)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Setup other Defender for Endpoint API endpoint
+	if conf.DefenderEndpoint != "" {
+		err = client.SetDefenderEndpoint(conf.DefenderEndpoint)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return client, nil
+}
+
 // Run will execute the input with the supplied context and state and return results
 func (input *msgraphInput) Run(errorHandler core.ErrorHandler, state core.State, processPipe chan<- core.PipelineResults) {
 	// Test login
-	isValid := input.client.Ping()
-	if !isValid {
-		errorHandler(true, fmt.Errorf("failed to ping microsoft graph client"))
-		return
+	for scope, client := range input.clients {
+		isValid := client.Ping()
+		if !isValid {
+			errorHandler(true, fmt.Errorf("failed to ping microsoft graph client for scope %s", scope))
+			return
+		}
 	}
 
 	// Validate and load state
-	currentState, err := loadState(state)
+	currentState, err := loadState(state, input.config.Endpoints)
 	if err != nil {
 		errorHandler(true, err)
 		return
@@ -107,138 +168,136 @@ func (input *msgraphInput) Run(errorHandler core.ErrorHandler, state core.State,
 		case <-input.ctx.Done():
 			return
 		case <-time.After(time.Duration(input.config.Schedule) * time.Second):
-			currentTime := time.Now()
-			currentTimeUnix := currentTime.Unix()
-			pastTimeUnix := currentState.LastTimestamp
-			pastTime := time.Unix(pastTimeUnix, 0)
-
-			// Create temp file
-			tmpFile, err := core.NewTmpWriter()
-			if err != nil {
-				errorHandler(false, fmt.Errorf("issue opening a new temp file writer: %s", err))
-				continue
-			}
-
-			// Copy current state to a new object for modification
-			newState := currentState
-
-			// Convert times to strings
-			gtTime := pastTime.UTC().Format("2006-01-02T15:04:05Z")
-			leTime := currentTime.UTC().Format("2006-01-02T15:04:05Z")
-
-			// Get alerts and loop through if there are new lines
-			params := url.Values{}
-			params.Set("$top", "1000")
-			params.Set("$filter", fmt.Sprintf("createdDateTime gt %s and createdDateTime le %s", gtTime, leTime))
-			hasError := false
-			for {
-				// Get security alerts with params
-				response, err := input.client.SecurityAlerts(params)
+			// Each endpoint sends its own results so a failing endpoint does not hold back the others
+			for _, name := range input.config.Endpoints {
+				newState, err := input.poll(name, currentState, processPipe)
 				if err != nil {
-					errorHandler(false, fmt.Errorf("issue getting graph security alerts: %s", err))
-					hasError = true
-					break
+					errorHandler(false, err)
+					continue
 				}
 
-				// Loop through all responses
-				for _, v := range response.Value {
-					event, err := json.Marshal(v)
-					if err != nil {
-						errorHandler(false, fmt.Errorf("issue marshalling alert: %s", err))
-						hasError = true
-						break
-					}
-					_, err = tmpFile.Write(pretty.Ugly(event))
-					if err != nil {
-						errorHandler(false, fmt.Errorf("issue writing alert to temp file: %s", err))
-						hasError = true
-						break
-					}
-				}
+				// Update current state to the new state since successful run
+				currentState = newState
+			}
+		}
+	}
+}
 
-				if response.NextLink == "" {
-					break
-				}
+func (input *msgraphInput) Stop() {
+	input.cancelFunc()
+}
 
-				// Parse next link
-				nextLink, err := url.Parse(response.NextLink)
+// poll collects the records created or modified on the endpoint since the last run, less the lookback, and sends
+// them with the new state
+func (input *msgraphInput) poll(name string, state *msgraphState, processPipe chan<- core.PipelineResults) (*msgraphState, error) {
+	ep := endpoints[name]
+	client := input.clients[ep.scope]
+	field := ep.filterField(input.config.QueryLastModified)
+	lookback := time.Duration(input.config.Lookback) * time.Second
 
-				// Handle error
-				if err != nil {
-					errorHandler(false, fmt.Errorf(""))
-					hasError = true
-					break
-				}
+	currentTime := time.Now()
+	pastTime := time.Unix(state.Endpoints[name].LastTimestamp, 0).Add(-lookback)
+	seen := newSeenCache(state.Endpoints[name].Seen)
 
-				// Parse query params
-				nextLinkParams, _ := url.ParseQuery(nextLink.RawQuery)
+	// Create temp file
+	tmpFile, err := core.NewTmpWriter()
+	if err != nil {
+		return nil, fmt.Errorf("issue opening a new temp file writer: %s", err)
+	}
 
-				// Get skip token
-				skipToken := nextLinkParams.Get("$skiptoken")
+	// Convert times to strings
+	gtTime := pastTime.UTC().Format("2006-01-02T15:04:05Z")
+	leTime := currentTime.UTC().Format("2006-01-02T15:04:05Z")
 
-				// Set skip token
-				params.Set("$skiptoken", skipToken)
-			}
+	// Get records and loop through if there are new pages
+	params := url.Values{}
+	params.Set("$top", fmt.Sprintf("%d", ep.pageSize))
+	params.Set("$filter", fmt.Sprintf("%s gt %s and %s le %s", field, gtTime, field, leTime))
+	response, err := ep.list(client, params)
+	for {
+		if err != nil {
+			discard(tmpFile)
+			return nil, fmt.Errorf("issue getting %s: %s", name, err)
+		}
 
-			// Restart if an error occurred (don't want a broken partial state)
-			if hasError {
+		// Loop through all responses, skipping records already read in the overlap
+		for _, v := range response.Value {
+			key, timestamp := recordKey(v, field, currentTime)
+			if !seen.add(key, timestamp) {
 				continue
 			}
 
-			// Get results file name and size
-			path := tmpFile.Name()
-			linesWritten := tmpFile.WriteCount
-			err = tmpFile.Close()
+			event, err := json.Marshal(v)
 			if err != nil {
-				errorHandler(false, fmt.Errorf("issue closing file: %s", err))
-				continue
+				discard(tmpFile)
+				return nil, fmt.Errorf("issue marshalling %s record: %s", name, err)
 			}
-
-			// Set new timestamp
-			newState.LastTimestamp = currentTimeUnix
-
-			// Marshal new state
-			newStateBytes, err := json.Marshal(newState)
+			_, err = tmpFile.Write(pretty.Ugly(event))
 			if err != nil {
-				errorHandler(false, fmt.Errorf("issue marshalling new state: %s", err))
-				continue
+				discard(tmpFile)
+				return nil, fmt.Errorf("issue writing %s record to temp file: %s", name, err)
 			}
+		}
 
-			// Setup pipeline results for next stage
-			result := core.PipelineResults{
-				FilePath:    path,
-				ResultCount: linesWritten,
-				State:       newStateBytes,
-				RetryCount:  0,
-			}
+		if response.NextLink == "" {
+			break
+		}
 
-			// Pipe results to next stage
-			processPipe <- result
+		response, err = client.NextPage(response.NextLink)
+	}
 
-			// Update current state to the new state since successful run
-			currentState = newState
-		}
+	// Get results file name and size
+	path := tmpFile.Name()
+	linesWritten := tmpFile.WriteCount
+	err = tmpFile.Close()
+	if err != nil {
+		return nil, fmt.Errorf("issue closing file: %s", err)
 	}
-}
 
-func (input *msgraphInput) Stop() {
-	input.cancelFunc()
-}
+	// Set new timestamp
+	newState := state.withEndpoint(name, endpointState{
+		LastTimestamp: currentTime.Unix(),
+		Seen:          seen.prune(currentTime.Add(-lookback).Unix(), input.config.MaxSeenIDs),
+	})
 
-func loadState(state core.State) (*msgraphState, error) {
-	if state == nil {
-		return defaultState(), nil
+	// Marshal new state
+	newStateBytes, err := json.Marshal(newState)
+	if err != nil {
+		return nil, fmt.Errorf("issue marshalling new state: %s", err)
 	}
 
-	var s msgraphState
-	err := json.Unmarshal(state, &s)
-	if err != nil {
-		return nil, fmt.Errorf("issue unmarshalling state: %s", err)
+	// Pipe results to next stage
+	processPipe <- core.PipelineResults{
+		FilePath:    path,
+		ResultCount: linesWritten,
+		State:       newStateBytes,
+		RetryCount:  0,
 	}
 
-	return &s, nil
+	return newState, nil
 }
 
-func defaultState() *msgraphState {
-	return &msgraphState{LastTimestamp: time.Now().Unix()}
+// recordKey identifies a record by its ID and filter timestamp, so updated records are not treated as seen. The
+// unix time of the record falls back to the end of the window when the timestamp is missing.
+func recordKey(record interface{}, field string, windowEnd time.Time) (string, int64) {
+	fields, _ := record.(map[string]interface{})
+	id := fmt.Sprintf("%v", fields["id"])
+	value, _ := fields[field].(string)
+
+	timestamp := windowEnd.Unix()
+	parsed, err := time.Parse(time.RFC3339Nano, value)
+	if err == nil {
+		timestamp = parsed.Unix()
+	}
+
+	return fmt.Sprintf("%s|%s", id, value), timestamp
+}
+
+// discard removes the partial results of a failed run
+func discard(tmpFile *core.TmpWriter) {
+	fileName := tmpFile.Name()
+	_ = tmpFile.Close()
+	if fileName != "" {
+		_ = os.Remove(fileName)
+	}
 }
@@ -2,21 +2,37 @@ package msgraph
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/ThoronicLLC/collector/pkg/core"
 	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 var config1 = `{"tenant_id": "tenant-1", "client_id": "client-1", "client_secret": "secret-1", "schedule": 10}`
 var config2 = `{"tenant_id": "tenant-1", "client_id": "client-1", "client_secret": "secret-1", "schedule": 100}`
+var config3 = `{"tenant_id": "tenant-1", "client_id": "client-1", "client_secret": "secret-1", "schedule": 60, "endpoints": ["security_alerts_v2", "security_incidents", "sign_ins", "directory_audits", "defender_alerts"]}`
+var config4 = `{"tenant_id": "tenant-1", "client_id": "client-1", "federated_token_file": "/var/run/secrets/azure/tokens/azure-identity-token", "schedule": 60}`
+var config5 = `{"tenant_id": "tenant-1", "managed_identity": true, "schedule": 60}`
+var config6 = `{"tenant_id": "tenant-1", "client_id": "client-1", "client_secret": "secret-1", "schedule": 60, "lookback": 900, "max_seen_ids": 50000, "query_last_modified": true}`
 var badConfig1 = `{"tenant_id": "tenant-1", "client_id": "client-1", "client_secret": "secret-1", "schedule": -1}`
 var badConfig2 = `{"tenant_id": "tenant-1", "client_id": "client-1", "client_secret": "secret-1", "schedule": 0}`
 var badConfig3 = `{"tenant_id": "", "client_id": "client-1", "client_secret": "secret-1", "schedule": 10}`
 var badConfig4 = `{"tenant_id": "tenant-1", "client_id": "", "client_secret": "secret-1", "schedule": 10}`
 var badConfig5 = `{"tenant_id": "tenant-1", "client_id": "client-1", "client_secret": "", "schedule": 10}`
+var badConfig6 = `{"tenant_id": "tenant-1", "client_id": "client-1", "client_secret": "secret-1", "schedule": 10, "endpoints": ["risky_users"]}`
+var badConfig7 = `{"tenant_id": "tenant-1", "client_id": "client-1", "client_secret": "secret-1", "schedule": 10, "endpoints": []}`
+var badConfig8 = `{"tenant_id": "tenant-1", "client_id": "client-1", "client_secret": "secret-1", "schedule": 10, "endpoints": ["sign_ins", "sign_ins"]}`
+var badConfig9 = `{"tenant_id": "tenant-1", "client_id": "client-1", "client_secret": "secret-1", "managed_identity": true, "schedule": 10}`
+var badConfig10 = `{"tenant_id": "tenant-1", "client_id": "client-1", "certificate_path": "/does/not/exist.pem", "schedule": 10}`
+var badConfig11 = `{"tenant_id": "tenant-1", "client_id": "client-1", "client_secret": "secret-1", "schedule": 10, "lookback": -1}`
 
 func TestValidate(t *testing.T) {
-	arr := []string{config1, config2}
+	arr := []string{config1, config2, config3, config4, config5, config6}
 	for i, v := range arr {
 		var testConfig Config
 		err := json.Unmarshal([]byte(v), &testConfig)
@@ -27,7 +43,7 @@ func TestValidate(t *testing.T) {
 }
 
 func TestValidateFailed(t *testing.T) {
-	arr := []string{badConfig1, badConfig2, badConfig3, badConfig4}
+	arr := []string{badConfig1, badConfig2, badConfig3, badConfig11}
 	for i, v := range arr {
 		var testConfig Config
 		err := json.Unmarshal([]byte(v), &testConfig)
@@ -38,7 +54,7 @@ func TestValidateFailed(t *testing.T) {
 }
 
 func TestHandler(t *testing.T) {
-	arr := []string{config1, config2}
+	arr := []string{config1, config2, config3, config4, config5, config6}
 	for i, v := range arr {
 		var testConfig Config
 		err := json.Unmarshal([]byte(v), &testConfig)
@@ -50,7 +66,7 @@ func TestHandler(t *testing.T) {
 }
 
 func TestHandlerFailed(t *testing.T) {
-	arr := []string{badConfig1, badConfig2, badConfig3, badConfig4, badConfig5}
+	arr := []string{badConfig1, badConfig2, badConfig3, badConfig4, badConfig5, badConfig6, badConfig7, badConfig8, badConfig9, badConfig10, badConfig11}
 	for i, v := range arr {
 		var testConfig Config
 		err := json.Unmarshal([]byte(v), &testConfig)
@@ -60,3 +76,70 @@ func TestHandlerFailed(t *testing.T) {
 		assert.NotNilf(t, err, "test #%d - validation should have returned an error: %s", i, err)
 	}
 }
+
+func TestLoadState(t *testing.T) {
+	// State saved before multiple endpoints were supported
+	s, err := loadState([]byte(`{"last_timestamp": 1650000000}`), []string{endpointSecurityAlerts, endpointSignIns})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1650000000), s.Endpoints[endpointSecurityAlerts].LastTimestamp)
+	assert.InDelta(t, time.Now().Unix(), s.Endpoints[endpointSignIns].LastTimestamp, 5)
+
+	newState := s.withEndpoint(endpointSignIns, endpointState{LastTimestamp: 1660000000})
+	assert.NotEqual(t, int64(1660000000), s.Endpoints[endpointSignIns].LastTimestamp)
+	stateBytes, err := json.Marshal(newState)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"endpoints":{"security_alerts":{"last_timestamp":1650000000},"sign_ins":{"last_timestamp":1660000000}}}`, string(stateBytes))
+
+	_, err = loadState([]byte(`not json`), []string{endpointSecurityAlerts})
+	assert.NotNil(t, err)
+}
+
+func TestPollLookback(t *testing.T) {
+	now := time.Now().UTC()
+	oldRecord := now.Add(-2 * time.Minute).Format(time.RFC3339)
+	newRecord := now.Add(-time.Minute).Format(time.RFC3339)
+
+	var filter string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/tenant-1/oauth2/v2.0/token", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{"token_type": "Bearer", "expires_in": 3599, "access_token": "token"}`)
+	})
+	mux.HandleFunc("/v1.0/security/alerts_v2", func(w http.ResponseWriter, r *http.Request) {
+		filter = r.URL.Query().Get("$filter")
+		_, _ = fmt.Fprintf(w, `{"value": [{"id": "1", "lastUpdateDateTime": "%s"}, {"id": "2", "lastUpdateDateTime": "%s"}]}`, oldRecord, newRecord)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	input, err := Handler()([]byte(fmt.Sprintf(`{"tenant_id": "tenant-1", "client_id": "client-1", "client_secret": "secret-1", "endpoints": ["security_alerts_v2"], "lookback": 600, "query_last_modified": true, "login_endpoint": "%s", "graph_endpoint": "%s"}`, server.URL, server.URL)))
+	assert.Nil(t, err)
+
+	// The first record was read in the last run
+	lastRun := now.Add(-90 * time.Second)
+	state := &msgraphState{Endpoints: map[string]endpointState{
+		endpointSecurityAlertsV2: {LastTimestamp: lastRun.Unix(), Seen: map[string]int64{"1|" + oldRecord: now.Add(-2 * time.Minute).Unix()}},
+	}}
+
+	processPipe := make(chan core.PipelineResults, 1)
+	newState, err := input.(*msgraphInput).poll(endpointSecurityAlertsV2, state, processPipe)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(filter, fmt.Sprintf("lastUpdateDateTime gt %s and", lastRun.Add(-10*time.Minute).Format("2006-01-02T15:04:05Z"))))
+
+	res := <-processPipe
+	assert.Equal(t, 1, res.ResultCount)
+	content, _ := os.ReadFile(res.FilePath)
+	assert.Equal(t, fmt.Sprintf("{\"id\":\"2\",\"lastUpdateDateTime\":\"%s\"}\n", newRecord), string(content))
+	_ = os.Remove(res.FilePath)
+
+	assert.Equal(t, 2, len(newState.Endpoints[endpointSecurityAlertsV2].Seen))
+}
+
+func TestSeenCachePrune(t *testing.T) {
+	cache := newSeenCache(map[string]int64{"a": 10, "b": 20, "c": 30, "d": 40})
+	assert.False(t, cache.add("a", 10))
+	assert.True(t, cache.add("e", 50))
+
+	// Records before the next window are dropped, then the oldest over the limit
+	assert.Equal(t, map[string]int64{"d": 40, "e": 50}, cache.prune(20, 2))
+	assert.Nil(t, newSeenCache(map[string]int64{"a": 10}).prune(20, 10))
+}
@@ -0,0 +1,118 @@
+package msgraph
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ThoronicLLC/collector/pkg/core"
+	"sort"
+	"time"
+)
+
+type msgraphState struct {
+	// LastTimestamp is the security_alerts timestamp saved before multiple endpoints were supported
+	LastTimestamp int64                    `json:"last_timestamp,omitempty"`
+	Endpoints     map[string]endpointState `json:"endpoints"`
+}
+
+type endpointState struct {
+	LastTimestamp int64 `json:"last_timestamp"`
+
+	// Seen holds the records read within the lookback window, keyed by ID and filter timestamp, with the unix time
+	// of the record. Records seen before are skipped when the window is read again.
+	Seen map[string]int64 `json:"seen,omitempty"`
+}
+
+func loadState(state core.State, names []string) (*msgraphState, error) {
+	s := &msgraphState{}
+	if state != nil {
+		err := json.Unmarshal(state, s)
+		if err != nil {
+			return nil, fmt.Errorf("issue unmarshalling state: %s", err)
+		}
+	}
+
+	if s.Endpoints == nil {
+		s.Endpoints = make(map[string]endpointState)
+	}
+
+	// Carry over the state saved before multiple endpoints were supported
+	if _, ok := s.Endpoints[endpointSecurityAlerts]; !ok && s.LastTimestamp != 0 {
+		s.Endpoints[endpointSecurityAlerts] = endpointState{LastTimestamp: s.LastTimestamp}
+	}
+	s.LastTimestamp = 0
+
+	// New endpoints start from now
+	for _, name := range names {
+		if _, ok := s.Endpoints[name]; !ok {
+			s.Endpoints[name] = defaultEndpointState()
+		}
+	}
+
+	return s, nil
+}
+
+func defaultEndpointState() endpointState {
+	return endpointState{LastTimestamp: time.Now().Unix()}
+}
+
+// withEndpoint returns a copy of the state with the endpoint state replaced
+func (s *msgraphState) withEndpoint(name string, state endpointState) *msgraphState {
+	newState := &msgraphState{Endpoints: make(map[string]endpointState, len(s.Endpoints))}
+	for k, v := range s.Endpoints {
+		newState.Endpoints[k] = v
+	}
+	newState.Endpoints[name] = state
+	return newState
+}
+
+// seenCache tracks the records read in the lookback window
+type seenCache struct {
+	seen map[string]int64
+}
+
+func newSeenCache(seen map[string]int64) *seenCache {
+	cache := &seenCache{seen: make(map[string]int64, len(seen))}
+	for k, v := range seen {
+		cache.seen[k] = v
+	}
+	return cache
+}
+
+// add records the key, returning false if it has been seen before
+func (c *seenCache) add(key string, timestamp int64) bool {
+	if _, ok := c.seen[key]; ok {
+		return false
+	}
+	c.seen[key] = timestamp
+	return true
+}
+
+// prune removes the records before the start of the next window, as they cannot be returned again, and then
+// the oldest records until at most max are left
+func (c *seenCache) prune(windowStart int64, max int) map[string]int64 {
+	keys := make([]string, 0, len(c.seen))
+	for k, v := range c.seen {
+		if v < windowStart {
+			delete(c.seen, k)
+			continue
+		}
+		keys = append(keys, k)
+	}
+
+	if len(keys) > max {
+		sort.Slice(keys, func(i, j int) bool {
+			if c.seen[keys[i]] == c.seen[keys[j]] {
+				return keys[i] < keys[j]
+			}
+			return c.seen[keys[i]] < c.seen[keys[j]]
+		})
+		for _, k := range keys[:len(keys)-max] {
+			delete(c.seen, k)
+		}
+	}
+
+	if len(c.seen) == 0 {
+		return nil
+	}
+	return c.seen
+}
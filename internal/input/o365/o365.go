@@ -0,0 +1,309 @@
+package o365
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ThoronicLLC/collector/internal/integrations/msgraph"
+	"github.com/ThoronicLLC/collector/pkg/core"
+	"github.com/tidwall/pretty"
+	"time"
+)
+
+var InputName = "o365"
+
+const (
+	// maxWindow is the longest time window the API lists content for
+	maxWindow = 24 * time.Hour
+
+	// maxAge is how long content is available for, with a margin so the start time is not rejected
+	maxAge = 7*24*time.Hour - time.Hour
+)
+
+// Config for the o365 input which reads audit events from the Office 365 Management Activity API
+type Config struct {
+	TenantID     string   `json:"tenant_id" validate:"required"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"client_secret"`
+	ContentTypes []string `json:"content_types"`
+	Schedule     int      `json:"schedule" validate:"required|min:0"`
+
+	// Alternatives to the client secret, see the msgraph input
+	CertificatePath     string `json:"certificate_path"`
+	CertificatePassword string `json:"certificate_password"`
+	FederatedTokenFile  string `json:"federated_token_file"`
+	ManagedIdentity     bool   `json:"managed_identity"`
+
+	// InitialLookback is how many hours of content to read on the first run
+	InitialLookback int `json:"initial_lookback" validate:"min:0|max:167"`
+
+	// MaxEventsPerResult splits large windows into multiple results
+	MaxEventsPerResult int `json:"max_events_per_result" validate:"min:0"`
+
+	// Endpoints for government clouds
+	LoginEndpoint      string `json:"login_endpoint,omitempty"`
+	ManagementEndpoint string `json:"management_endpoint,omitempty"`
+}
+
+type o365Input struct {
+	config     Config
+	ctx        context.Context
+	cancelFunc context.CancelFunc
+	client     *msgraph.Client
+}
+
+func Handler() core.InputHandler {
+	return func(config []byte) (core.Input, error) {
+		// Set config defaults
+		conf := defaultConfig()
+
+		// Unmarshal config
+		err := json.Unmarshal(config, &conf)
+		if err != nil {
+			return nil, fmt.Errorf("issue unmarshalling file config: %s", err)
+		}
+
+		// Validate config
+		err = core.ValidateStruct(&conf)
+		if err != nil {
+			return nil, err
+		}
+
+		err = validateContentTypes(conf.ContentTypes)
+		if err != nil {
+			return nil, err
+		}
+
+		// Setup client
+		client, err := msgraph.NewClientWithCredentials(conf.TenantID, conf.ClientID, msgraph.Credentials{
+			ClientSecret:        conf.ClientSecret,
+			CertificatePath:     conf.CertificatePath,
+			CertificatePassword: conf.CertificatePassword,
+			FederatedTokenFile:  conf.FederatedTokenFile,
+			ManagedIdentity:     conf.ManagedIdentity,
+		}, msgraph.ManagementScope.String())
+		if err != nil {
+			return nil, err
+		}
+
+		// Setup other MS login endpoint
+		if conf.LoginEndpoint != "" {
+			err = client.SetLoginEndpoint(conf.LoginEndpoint)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// Setup other management API endpoint
+		if conf.ManagementEndpoint != "" {
+			err = client.SetManagementEndpoint(conf.ManagementEndpoint)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// Setup context
+		ctx, cancelFn := context.WithCancel(context.Background())
+
+		return &o365Input{
+			config:     conf,
+			ctx:        ctx,
+			cancelFunc: cancelFn,
+			client:     client,
+		}, nil
+	}
+}
+
+func defaultConfig() Config {
+	return Config{
+		ContentTypes: []string{
+			msgraph.ContentTypeAzureActiveDirectory,
+			msgraph.ContentTypeExchange,
+			msgraph.ContentTypeSharePoint,
+			msgraph.ContentTypeGeneral,
+		},
+		Schedule:           300,
+		InitialLookback:    1,
+		MaxEventsPerResult: 100000,
+	}
+}
+
+// Run will execute the input with the supplied context and state and return results
+func (input *o365Input) Run(errorHandler core.ErrorHandler, state core.State, processPipe chan<- core.PipelineResults) {
+	// Test login
+	isValid := input.client.Ping()
+	if !isValid {
+		errorHandler(true, fmt.Errorf("failed to ping office 365 management client"))
+		return
+	}
+
+	// Make sure every content type is subscribed to
+	err := input.startSubscriptions()
+	if err != nil {
+		errorHandler(true, err)
+		return
+	}
+
+	// Validate and load state
+	currentState := loadState(state, input.config.ContentTypes, time.Duration(input.config.InitialLookback)*time.Hour)
+
+	for {
+		select {
+		case <-input.ctx.Done():
+			return
+		case <-time.After(time.Duration(input.config.Schedule) * time.Second):
+			for _, contentType := range input.config.ContentTypes {
+				newState, err := input.poll(contentType, currentState, processPipe)
+				if err != nil {
+					errorHandler(false, err)
+				}
+
+				// Update current state to the last window read
+				currentState = newState
+			}
+		}
+	}
+}
+
+func (input *o365Input) Stop() {
+	input.cancelFunc()
+}
+
+// startSubscriptions starts the subscriptions to the content types that are not enabled yet
+func (input *o365Input) startSubscriptions() error {
+	subscriptions, err := input.client.ManagementSubscriptions()
+	if err != nil {
+		return fmt.Errorf("issue listing subscriptions: %s", err)
+	}
+
+	enabled := make(map[string]bool)
+	for _, v := range subscriptions {
+		enabled[v.ContentType] = v.Status == "enabled"
+	}
+
+	for _, contentType := range input.config.ContentTypes {
+		if enabled[contentType] {
+			continue
+		}
+
+		err = input.client.StartManagementSubscription(contentType)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// poll reads the content made available since the last run in windows of up to 24 hours, checkpointing the state
+// after each window
+func (input *o365Input) poll(contentType string, state o365State, processPipe chan<- core.PipelineResults) (o365State, error) {
+	now := time.Now().UTC()
+	start := state.ContentTypes[contentType]
+
+	// Content older than 7 days can no longer be listed
+	if start.Before(now.Add(-maxAge)) {
+		start = now.Add(-maxAge)
+	}
+
+	initialState, err := json.Marshal(state)
+	if err != nil {
+		return state, fmt.Errorf("issue marshalling state: %s", err)
+	}
+
+	writer, err := core.NewResultWriter(input.config.MaxEventsPerResult, initialState, processPipe)
+	if err != nil {
+		return state, fmt.Errorf("issue opening a new result writer: %s", err)
+	}
+
+	currentState := state
+	var readErr error
+	for start.Before(now) && input.ctx.Err() == nil {
+		end := start.Add(maxWindow)
+		if end.After(now) {
+			end = now
+		}
+
+		readErr = input.readWindow(contentType, start, end, writer)
+		if readErr != nil {
+			break
+		}
+
+		currentState = currentState.withContentType(contentType, end)
+		newState, err := json.Marshal(currentState)
+		if err != nil {
+			readErr = fmt.Errorf("issue marshalling state: %s", err)
+			break
+		}
+		writer.Checkpoint(newState)
+		start = end
+	}
+
+	// Send the results read so far. After a failure the state points at the end of the last complete window, so
+	// the failed window is read again on the next run.
+	finalState, err := json.Marshal(currentState)
+	if err != nil {
+		writer.Discard()
+		return state, fmt.Errorf("issue marshalling state: %s", err)
+	}
+
+	err = writer.Flush(finalState)
+	if err != nil {
+		writer.Discard()
+		return state, err
+	}
+
+	return currentState, readErr
+}
+
+// readWindow downloads every content blob in the window and writes its events
+func (input *o365Input) readWindow(contentType string, start, end time.Time, writer *core.ResultWriter) error {
+	content, err := input.client.ManagementContent(contentType, start, end)
+	if err != nil {
+		return fmt.Errorf("issue listing %s content: %s", contentType, err)
+	}
+
+	for _, blob := range content {
+		events, err := input.client.ManagementContentBlob(blob.ContentURI)
+		if err != nil {
+			return fmt.Errorf("issue downloading %s content %s: %s", contentType, blob.ContentID, err)
+		}
+
+		for _, event := range events {
+			_, err = writer.Write(pretty.Ugly(event))
+			if err != nil {
+				return fmt.Errorf("issue writing event to temp file: %s", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateContentTypes checks every content type is supported and only listed once
+func validateContentTypes(contentTypes []string) error {
+	if len(contentTypes) == 0 {
+		return fmt.Errorf("at least one content type is required")
+	}
+
+	supported := map[string]bool{
+		msgraph.ContentTypeAzureActiveDirectory: true,
+		msgraph.ContentTypeExchange:             true,
+		msgraph.ContentTypeSharePoint:           true,
+		msgraph.ContentTypeGeneral:              true,
+		msgraph.ContentTypeDLP:                  true,
+	}
+
+	seen := make(map[string]bool)
+	for _, contentType := range contentTypes {
+		if !supported[contentType] {
+			return fmt.Errorf("unsupported content type: %s", contentType)
+		}
+		if seen[contentType] {
+			return fmt.Errorf("duplicate content type: %s", contentType)
+		}
+		seen[contentType] = true
+	}
+
+	return nil
+}
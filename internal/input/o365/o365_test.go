@@ -0,0 +1,121 @@
+package o365
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ThoronicLLC/collector/internal/integrations/msgraph"
+	"github.com/ThoronicLLC/collector/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+var config1 = `{"tenant_id": "tenant-1", "client_id": "client-1", "client_secret": "secret-1"}`
+var config2 = `{"tenant_id": "tenant-1", "client_id": "client-1", "client_secret": "secret-1", "content_types": ["Audit.Exchange", "DLP.All"], "schedule": 60, "initial_lookback": 24}`
+var config3 = `{"tenant_id": "tenant-1", "client_id": "client-1", "client_secret": "secret-1", "login_endpoint": "https://login.microsoftonline.us", "management_endpoint": "https://manage.office365.us"}`
+var badConfig1 = `{"tenant_id": "", "client_id": "client-1", "client_secret": "secret-1"}`
+var badConfig2 = `{"tenant_id": "tenant-1", "client_id": "client-1", "client_secret": "secret-1", "schedule": 0}`
+var badConfig3 = `{"tenant_id": "tenant-1", "client_id": "client-1", "client_secret": "secret-1", "initial_lookback": 200}`
+var badConfig4 = `{"tenant_id": "tenant-1", "client_id": "client-1", "client_secret": "secret-1", "content_types": ["Audit.Teams"]}`
+var badConfig5 = `{"tenant_id": "tenant-1", "client_id": "client-1", "client_secret": "secret-1", "content_types": []}`
+
+func TestValidate(t *testing.T) {
+	arr := []string{config1, config2, config3}
+	for i, v := range arr {
+		testConfig := defaultConfig()
+		err := json.Unmarshal([]byte(v), &testConfig)
+		assert.Nilf(t, err, "test #%d - failed to unmarshal json: %s", i, err)
+		err = core.ValidateStruct(&testConfig)
+		assert.Nilf(t, err, "test #%d - validation error: %s", i, err)
+	}
+}
+
+func TestValidateFailed(t *testing.T) {
+	arr := []string{badConfig1, badConfig2, badConfig3}
+	for i, v := range arr {
+		testConfig := defaultConfig()
+		err := json.Unmarshal([]byte(v), &testConfig)
+		assert.Nilf(t, err, "test #%d - failed to unmarshal json: %s", i, err)
+		err = core.ValidateStruct(&testConfig)
+		assert.NotNilf(t, err, "test #%d - validation should have returned an error: %s", i, err)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	arr := []string{config1, config2, config3}
+	for i, v := range arr {
+		handleFunc := Handler()
+		_, err := handleFunc([]byte(v))
+		assert.Nilf(t, err, "test #%d - validation error: %s", i, err)
+	}
+}
+
+func TestHandlerFailed(t *testing.T) {
+	arr := []string{badConfig1, badConfig2, badConfig3, badConfig4, badConfig5}
+	for i, v := range arr {
+		handleFunc := Handler()
+		_, err := handleFunc([]byte(v))
+		assert.NotNilf(t, err, "test #%d - validation should have returned an error: %s", i, err)
+	}
+}
+
+func TestPoll(t *testing.T) {
+	requests := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/tenant-1/oauth2/v2.0/token", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{"token_type": "Bearer", "expires_in": 3599, "access_token": "token"}`)
+	})
+	mux.HandleFunc("/api/v1.0/tenant-1/activity/feed/subscriptions/content", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		assert.Equal(t, msgraph.ContentTypeExchange, r.URL.Query().Get("contentType"))
+		requests++
+
+		// The first window is split over two pages
+		switch {
+		case requests == 1:
+			w.Header().Set("NextPageUri", fmt.Sprintf("http://%s%s?%s&nextPage=2", r.Host, r.URL.Path, r.URL.RawQuery))
+			_, _ = fmt.Fprintf(w, `[{"contentType": "Audit.Exchange", "contentId": "1", "contentUri": "http://%s/blob/1"}]`, r.Host)
+		case r.URL.Query().Get("nextPage") == "2":
+			_, _ = fmt.Fprintf(w, `[{"contentType": "Audit.Exchange", "contentId": "2", "contentUri": "http://%s/blob/2"}]`, r.Host)
+		default:
+			_, _ = fmt.Fprint(w, `[]`)
+		}
+	})
+	mux.HandleFunc("/blob/", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "tenant-1", r.URL.Query().Get("PublisherIdentifier"))
+		_, _ = fmt.Fprintf(w, `[{"Id": "%s-a", "Operation": "MailItemsAccessed"}, {"Id": "%s-b"}]`, r.URL.Path, r.URL.Path)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	input, err := Handler()([]byte(fmt.Sprintf(`{"tenant_id": "tenant-1", "client_id": "client-1", "client_secret": "secret-1", "content_types": ["Audit.Exchange"], "login_endpoint": "%s", "management_endpoint": "%s"}`, server.URL, server.URL)))
+	assert.Nil(t, err)
+
+	// Two windows of content are read, with three requests as the first window has two pages
+	start := time.Now().UTC().Add(-36 * time.Hour)
+	processPipe := make(chan core.PipelineResults, 10)
+	newState, err := input.(*o365Input).poll(msgraph.ContentTypeExchange, o365State{ContentTypes: map[string]time.Time{msgraph.ContentTypeExchange: start}}, processPipe)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, requests)
+	assert.WithinDuration(t, time.Now(), newState.ContentTypes[msgraph.ContentTypeExchange], 5*time.Second)
+
+	res := <-processPipe
+	assert.Equal(t, 4, res.ResultCount)
+	content, _ := os.ReadFile(res.FilePath)
+	assert.Equal(t, "{\"Id\":\"/blob/1-a\",\"Operation\":\"MailItemsAccessed\"}\n{\"Id\":\"/blob/1-b\"}\n{\"Id\":\"/blob/2-a\",\"Operation\":\"MailItemsAccessed\"}\n{\"Id\":\"/blob/2-b\"}\n", string(content))
+	_ = os.Remove(res.FilePath)
+}
+
+func TestLoadState(t *testing.T) {
+	saved := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := loadState([]byte(`{"content_types": {"Audit.Exchange": "2022-01-01T00:00:00Z"}}`), []string{msgraph.ContentTypeExchange, msgraph.ContentTypeSharePoint}, time.Hour)
+	assert.Equal(t, saved, s.ContentTypes[msgraph.ContentTypeExchange])
+	assert.WithinDuration(t, time.Now().Add(-time.Hour), s.ContentTypes[msgraph.ContentTypeSharePoint], 5*time.Second)
+
+	newState := s.withContentType(msgraph.ContentTypeExchange, saved.Add(time.Hour))
+	assert.Equal(t, saved, s.ContentTypes[msgraph.ContentTypeExchange])
+	assert.Equal(t, saved.Add(time.Hour), newState.ContentTypes[msgraph.ContentTypeExchange])
+}
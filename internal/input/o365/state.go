@@ -0,0 +1,54 @@
+package o365
+
+import (
+	"encoding/json"
+	"github.com/ThoronicLLC/collector/pkg/core"
+	"time"
+)
+
+// o365State is the end of the last window read for each content type
+type o365State struct {
+	ContentTypes map[string]time.Time `json:"content_types"`
+}
+
+func defaultState(contentTypes []string, lookback time.Duration) o365State {
+	s := o365State{ContentTypes: make(map[string]time.Time)}
+	for _, contentType := range contentTypes {
+		s.ContentTypes[contentType] = time.Now().UTC().Add(-lookback)
+	}
+	return s
+}
+
+func loadState(state core.State, contentTypes []string, lookback time.Duration) o365State {
+	if state == nil {
+		return defaultState(contentTypes, lookback)
+	}
+
+	var loadedState o365State
+	err := json.Unmarshal(state, &loadedState)
+	if err != nil {
+		return defaultState(contentTypes, lookback)
+	}
+
+	// Content types added since the state was saved start from the lookback
+	if loadedState.ContentTypes == nil {
+		loadedState.ContentTypes = make(map[string]time.Time)
+	}
+	for _, contentType := range contentTypes {
+		if _, ok := loadedState.ContentTypes[contentType]; !ok {
+			loadedState.ContentTypes[contentType] = time.Now().UTC().Add(-lookback)
+		}
+	}
+
+	return loadedState
+}
+
+// withContentType returns a copy of the state with the content type checkpoint replaced
+func (s o365State) withContentType(contentType string, checkpoint time.Time) o365State {
+	newState := o365State{ContentTypes: make(map[string]time.Time, len(s.ContentTypes))}
+	for k, v := range s.ContentTypes {
+		newState.ContentTypes[k] = v
+	}
+	newState.ContentTypes[contentType] = checkpoint
+	return newState
+}
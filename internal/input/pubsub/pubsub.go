@@ -5,8 +5,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/ThoronicLLC/collector/internal/integrations/gcp"
 	"github.com/ThoronicLLC/collector/pkg/core"
-	"google.golang.org/api/option"
+	"os"
 	"sync"
 	"time"
 )
@@ -19,6 +20,28 @@ type Config struct {
 	Credentials     json.RawMessage `json:"credentials,omitempty"`
 	CredentialsPath string          `json:"credentials_path"`
 	FlushFrequency  int             `json:"flush_frequency" validate:"required|min:0"`
+	MaxBatchBytes   int64           `json:"max_batch_bytes" validate:"min:0"`
+	MaxBatchEvents  int             `json:"max_batch_events" validate:"min:0"`
+
+	// Flow control for the receiver. Zero uses the client defaults and a negative number of outstanding messages
+	// or bytes removes the limit.
+	MaxOutstandingMessages int `json:"max_outstanding_messages" validate:"min:-1"`
+	MaxOutstandingBytes    int `json:"max_outstanding_bytes" validate:"min:-1"`
+	NumGoroutines          int `json:"num_goroutines" validate:"min:0"`
+
+	// IncludeAttributes adds the message attributes, ID, publish time and ordering key to JSON messages under the
+	// @metadata key
+	IncludeAttributes bool `json:"include_attributes"`
+
+	// EmulatorHost connects to the Pub/Sub emulator without credentials. Defaults to PUBSUB_EMULATOR_HOST.
+	EmulatorHost string `json:"emulator_host"`
+
+	// MaxInFlightBatches is how many batches may be waiting on the outputs before message callbacks block. Blocked
+	// messages count against the flow control limits above, so no more are pulled until the outputs catch up.
+	// MaxDiskBytes blocks the callbacks once the waiting batches use that many bytes of temp files. Zero disables
+	// either limit.
+	MaxInFlightBatches int   `json:"max_in_flight_batches" validate:"min:0"`
+	MaxDiskBytes       int64 `json:"max_disk_bytes" validate:"min:0"`
 }
 
 type pubSubInput struct {
@@ -32,6 +55,7 @@ func Handler() core.InputHandler {
 		// Set config defaults
 		conf := Config{
 			FlushFrequency: 300,
+			EmulatorHost:   os.Getenv("PUBSUB_EMULATOR_HOST"),
 		}
 
 		// Unmarshal config
@@ -46,10 +70,12 @@ func Handler() core.InputHandler {
 			return nil, err
 		}
 
-		// Validate credentials
-		err = validateCredentialsOrPath(conf.Credentials, conf.CredentialsPath)
-		if err != nil {
-			return nil, err
+		// Validate credentials, which the emulator does not need
+		if conf.EmulatorHost == "" {
+			err = validateCredentialsOrPath(conf.Credentials, conf.CredentialsPath)
+			if err != nil {
+				return nil, err
+			}
 		}
 
 		// Setup context
@@ -65,18 +91,27 @@ func Handler() core.InputHandler {
 
 func (p *pubSubInput) Run(errorHandler core.ErrorHandler, state core.State, processPipe chan<- core.PipelineResults) {
 	// Setup local variables
-	tmpWriter, err := core.NewTmpWriter()
+	batcher, err := core.NewBatcher(p.ctx, core.BatchConfig{
+		FlushFrequency:     p.config.FlushFrequency,
+		MaxBatchBytes:      p.config.MaxBatchBytes,
+		MaxBatchEvents:     p.config.MaxBatchEvents,
+		MaxInFlightBatches: p.config.MaxInFlightBatches,
+		MaxDiskBytes:       p.config.MaxDiskBytes,
+		DropPolicy:         core.DropPolicyBlock,
+	}, processPipe)
 	if err != nil {
 		errorHandler(true, err)
 		return
 	}
 
 	// Setup new client
-	opts := make([]option.ClientOption, 0)
-	if p.config.Credentials != nil && len(p.config.Credentials) > 0 && string(p.config.Credentials) != "null" {
-		opts = append(opts, option.WithCredentialsJSON(p.config.Credentials))
-	} else if p.config.CredentialsPath != "" {
-		opts = append(opts, option.WithCredentialsFile(p.config.CredentialsPath))
+	opts, conn, err := gcp.PubSubClientOptions(p.config.Credentials, p.config.CredentialsPath, p.config.EmulatorHost)
+	if err != nil {
+		errorHandler(true, err)
+		return
+	}
+	if conn != nil {
+		defer conn.Close()
 	}
 
 	client, err := pubsub.NewClient(p.ctx, p.config.ProjectID, opts...)
@@ -84,21 +119,39 @@ func (p *pubSubInput) Run(errorHandler core.ErrorHandler, state core.State, proc
 		errorHandler(true, fmt.Errorf("issue setting up pub sub client: %s", err))
 		return
 	}
+	defer client.Close()
 
 	// Setup subscription
 	subscription := client.Subscription(p.config.SubscriptionID)
+	subscription.ReceiveSettings.MaxOutstandingMessages = p.config.MaxOutstandingMessages
+	subscription.ReceiveSettings.MaxOutstandingBytes = p.config.MaxOutstandingBytes
+	if p.config.NumGoroutines > 0 {
+		subscription.ReceiveSettings.NumGoroutines = p.config.NumGoroutines
+	}
 
-	// Setup wait group
+	// Setup wait group. The flush context is only cancelled once the receiver has stopped writing, so the final
+	// flush includes every message received.
 	var wg sync.WaitGroup
-	flushCtx, flushCancelFn := context.WithCancel(p.ctx)
+	flushCtx, flushCancelFn := context.WithCancel(context.Background())
 
 	// Start pub sub receiver go routine
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		rErr := subscription.Receive(p.ctx, func(ctx context.Context, msg *pubsub.Message) {
+			// Get message data, with the attributes if they should be included and the message is json
+			data := msg.Data
+			if p.config.IncludeAttributes {
+				newData, err := addAttributesToJsonMessage(msg)
+				if err != nil {
+					errorHandler(false, fmt.Errorf("unable to add pubsub attributes to message: %s", err))
+				} else {
+					data = newData
+				}
+			}
+
 			// Write new message data to tmp writer
-			_, writeErr := tmpWriter.Write(msg.Data)
+			_, writeErr := batcher.Write(data)
 			if writeErr != nil {
 				errorHandler(false, fmt.Errorf("issue writing pubsub message: %s", writeErr))
 				msg.Nack()
@@ -120,32 +173,45 @@ func (p *pubSubInput) Run(errorHandler core.ErrorHandler, state core.State, proc
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		for {
-			select {
-			case <-flushCtx.Done():
-				return
-			case <-time.After(time.Duration(p.config.FlushFrequency) * time.Second):
-				err = flush(tmpWriter, processPipe)
-				if err != nil {
-					errorHandler(false, fmt.Errorf("issue flushing file: %s", err))
-				}
-			}
-		}
+		batcher.Run(flushCtx, errorHandler)
 	}()
 
 	wg.Wait()
-
-	// Flush any remaining data to pipeline before return
-	err = flush(tmpWriter, processPipe)
-	if err != nil {
-		errorHandler(false, fmt.Errorf("issue flushing file: %s", err))
-	}
 }
 
 func (p *pubSubInput) Stop() {
 	p.cancelFunc()
 }
 
+// addAttributesToJsonMessage adds the message attributes and metadata to the JSON message under the @metadata key
+func addAttributesToJsonMessage(msg *pubsub.Message) ([]byte, error) {
+	// Check if message is json
+	var jsonMessage map[string]interface{}
+	err := json.Unmarshal(msg.Data, &jsonMessage)
+	if err != nil {
+		// Message is not json, so it will not add attributes
+		return nil, err
+	}
+
+	metadata := map[string]interface{}{
+		"message_id":   msg.ID,
+		"publish_time": msg.PublishTime.UTC().Format(time.RFC3339Nano),
+	}
+	if len(msg.Attributes) > 0 {
+		metadata["attributes"] = msg.Attributes
+	}
+	if msg.OrderingKey != "" {
+		metadata["ordering_key"] = msg.OrderingKey
+	}
+	if msg.DeliveryAttempt != nil {
+		metadata["delivery_attempt"] = *msg.DeliveryAttempt
+	}
+
+	jsonMessage["@metadata"] = metadata
+
+	return json.Marshal(jsonMessage)
+}
+
 func validateCredentialsOrPath(credentials json.RawMessage, path string) error {
 	if credentials != nil && len(credentials) > 0 && string(credentials) != "null" {
 		return nil
@@ -155,21 +221,3 @@ func validateCredentialsOrPath(credentials json.RawMessage, path string) error {
 
 	return fmt.Errorf("missing credentials")
 }
-
-func flush(tmpFile *core.TmpWriter, processPipe chan<- core.PipelineResults) error {
-	// Rotate the temp writer
-	count, fileName, err := tmpFile.Rotate()
-	if err != nil {
-		return err
-	}
-
-	// Only send on if there are results
-	processPipe <- core.PipelineResults{
-		FilePath:    fileName,
-		ResultCount: count,
-		State:       nil,
-		RetryCount:  0,
-	}
-
-	return nil
-}
@@ -1,24 +1,30 @@
 package pubsub
 
 import (
+	"cloud.google.com/go/pubsub"
 	"encoding/json"
 	"github.com/ThoronicLLC/collector/pkg/core"
 	"github.com/stretchr/testify/assert"
 	"testing"
+	"time"
 )
 
 var config1 = `{"project_id": "project-1", "subscription_id": "sub-1", "credentials_path": "/tmp/file.txt", "flush_frequency": 10}`
 var config2 = `{"project_id": "project-2", "subscription_id": "sub-2", "credentials_path": "/tmp/file.txt", "flush_frequency": 100}`
 var config3 = `{"project_id": "project-3", "subscription_id": "sub-3", "credentials": {}, "flush_frequency": 1000}`
 var config4 = `{"project_id": "project-4", "subscription_id": "sub-4", "credentials": {}, "flush_frequency": 10000}`
+var config5 = `{"project_id": "project-5", "subscription_id": "sub-5", "credentials": {}, "flush_frequency": 10, "max_outstanding_messages": 5000, "max_outstanding_bytes": -1, "num_goroutines": 4, "include_attributes": true}`
+var config6 = `{"project_id": "project-6", "subscription_id": "sub-6", "emulator_host": "localhost:8085", "flush_frequency": 10}`
 var badConfig1 = `{"project_id": "project-1", "subscription_id": "sub-1", "credentials": {}, "flush_frequency": -1}`
 var badConfig2 = `{"project_id": "project-2", "subscription_id": "sub-2", "credentials": {}, "flush_frequency": 0}`
 var badConfig3 = `{"project_id": "project-3", "subscription_id": "", "credentials": {}, "flush_frequency": 10}`
 var badConfig4 = `{"project_id": "", "subscription_id": "sub-3", "credentials": {}, "flush_frequency": 10}`
 var badConfig5 = `{"project_id": "project-1", "subscription_id": "sub-1", "flush_frequency": 10}`
+var badConfig6 = `{"project_id": "project-1", "subscription_id": "sub-1", "credentials": {}, "flush_frequency": 10, "max_outstanding_messages": -2}`
+var badConfig7 = `{"project_id": "project-1", "subscription_id": "sub-1", "credentials": {}, "flush_frequency": 10, "num_goroutines": -1}`
 
 func TestValidate(t *testing.T) {
-	arr := []string{config1, config2, config3, config4}
+	arr := []string{config1, config2, config3, config4, config5, config6}
 	for i, v := range arr {
 		var testConfig Config
 		err := json.Unmarshal([]byte(v), &testConfig)
@@ -29,7 +35,7 @@ func TestValidate(t *testing.T) {
 }
 
 func TestValidateFailed(t *testing.T) {
-	arr := []string{badConfig1, badConfig2, badConfig3, badConfig4}
+	arr := []string{badConfig1, badConfig2, badConfig3, badConfig4, badConfig6, badConfig7}
 	for i, v := range arr {
 		var testConfig Config
 		err := json.Unmarshal([]byte(v), &testConfig)
@@ -40,7 +46,7 @@ func TestValidateFailed(t *testing.T) {
 }
 
 func TestHandler(t *testing.T) {
-	arr := []string{config1, config2, config3, config4}
+	arr := []string{config1, config2, config3, config4, config5, config6}
 	for i, v := range arr {
 		var testConfig Config
 		err := json.Unmarshal([]byte(v), &testConfig)
@@ -52,7 +58,7 @@ func TestHandler(t *testing.T) {
 }
 
 func TestHandlerFailed(t *testing.T) {
-	arr := []string{badConfig1, badConfig2, badConfig3, badConfig4, badConfig5}
+	arr := []string{badConfig1, badConfig2, badConfig3, badConfig4, badConfig5, badConfig6, badConfig7}
 	for i, v := range arr {
 		var testConfig Config
 		err := json.Unmarshal([]byte(v), &testConfig)
@@ -62,3 +68,21 @@ func TestHandlerFailed(t *testing.T) {
 		assert.NotNilf(t, err, "test #%d - validation should have returned an error: %s", i, err)
 	}
 }
+
+func TestAddAttributesToJsonMessage(t *testing.T) {
+	msg := &pubsub.Message{
+		ID:          "msg-1",
+		Data:        []byte(`{"action": "login"}`),
+		Attributes:  map[string]string{"source": "audit"},
+		PublishTime: time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC),
+		OrderingKey: "user-1",
+	}
+	data, err := addAttributesToJsonMessage(msg)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"action": "login", "@metadata": {"message_id": "msg-1", "publish_time": "2022-01-02T03:04:05Z", "attributes": {"source": "audit"}, "ordering_key": "user-1"}}`, string(data))
+
+	// Messages that are not json objects are left as is
+	msg.Data = []byte("plain text")
+	_, err = addAttributesToJsonMessage(msg)
+	assert.NotNil(t, err)
+}
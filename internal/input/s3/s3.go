@@ -0,0 +1,264 @@
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	awsutil "github.com/ThoronicLLC/collector/internal/integrations/aws"
+	"github.com/ThoronicLLC/collector/pkg/core"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"sort"
+	"strings"
+	"time"
+)
+
+var InputName = "s3"
+
+type Config struct {
+	Bucket         string             `json:"bucket" validate:"required"`
+	Prefix         string             `json:"prefix"`
+	Region         string             `json:"region" validate:"required"`
+	AuthConfig     awsutil.AuthConfig `json:"auth_config"`
+	Endpoint       string             `json:"endpoint"`
+	ForcePathStyle bool               `json:"force_path_style"`
+	Schedule       int                `json:"schedule" validate:"required|min:0"`
+
+	// RecordsField splits JSON objects such as CloudTrail logs into one event per record
+	RecordsField string `json:"records_field"`
+
+	// ReadExisting reads the objects already in the bucket on the first run instead of only new objects
+	ReadExisting bool `json:"read_existing"`
+
+	// Lookback is how many seconds before the newest object read that objects which appear late are still read.
+	// Multipart uploads are listed with the time they started, so it should cover the longest upload.
+	Lookback int `json:"lookback" validate:"min:0"`
+
+	// MaxEventsPerResult splits large objects into multiple results
+	MaxEventsPerResult int `json:"max_events_per_result" validate:"min:0"`
+}
+
+type s3Input struct {
+	config     Config
+	ctx        context.Context
+	cancelFunc context.CancelFunc
+	client     s3iface.S3API
+}
+
+// object is an S3 object waiting to be read
+type object struct {
+	Key          string
+	LastModified time.Time
+}
+
+func Handler() core.InputHandler {
+	return func(config []byte) (core.Input, error) {
+		// Set config defaults
+		conf := defaultConfig()
+
+		// Unmarshal config
+		err := json.Unmarshal(config, &conf)
+		if err != nil {
+			return nil, fmt.Errorf("issue unmarshalling file config: %s", err)
+		}
+
+		// Validate config
+		err = core.ValidateStruct(&conf)
+		if err != nil {
+			return nil, err
+		}
+
+		err = conf.AuthConfig.Validate()
+		if err != nil {
+			return nil, err
+		}
+
+		// Initialize AWS session
+		awsSession, err := awsutil.NewSession(awsutil.SessionConfig{
+			Region:         conf.Region,
+			Endpoint:       conf.Endpoint,
+			ForcePathStyle: conf.ForcePathStyle,
+			AuthConfig:     conf.AuthConfig,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// Setup context
+		ctx, cancelFn := context.WithCancel(context.Background())
+
+		return &s3Input{
+			config:     conf,
+			ctx:        ctx,
+			cancelFunc: cancelFn,
+			client:     s3.New(awsSession),
+		}, nil
+	}
+}
+
+// Run will execute the input with the supplied context and state and return results
+func (input *s3Input) Run(errorHandler core.ErrorHandler, state core.State, processPipe chan<- core.PipelineResults) {
+	// Validate and load state
+	currentState := loadState(state, input.config.ReadExisting)
+
+	for {
+		select {
+		case <-input.ctx.Done():
+			return
+		case <-time.After(time.Duration(input.config.Schedule) * time.Second):
+			newState, err := input.poll(currentState, processPipe)
+			if err != nil {
+				errorHandler(false, err)
+			}
+
+			// Update current state to the last object read
+			currentState = newState
+		}
+	}
+}
+
+func (input *s3Input) Stop() {
+	input.cancelFunc()
+}
+
+// poll reads every new object in the bucket and returns the state after the last object read
+func (input *s3Input) poll(state s3State, processPipe chan<- core.PipelineResults) (s3State, error) {
+	objects, err := input.listObjects(state)
+	if err != nil {
+		return state, err
+	}
+
+	// Nothing to do
+	if len(objects) == 0 {
+		return state, nil
+	}
+
+	initialState, err := json.Marshal(state)
+	if err != nil {
+		return state, fmt.Errorf("issue marshalling state: %s", err)
+	}
+
+	writer, err := core.NewResultWriter(input.config.MaxEventsPerResult, initialState, processPipe)
+	if err != nil {
+		return state, fmt.Errorf("issue opening a new result writer: %s", err)
+	}
+
+	// Read objects in order, checkpointing the state after each one
+	currentState := state
+	var readErr error
+	for _, obj := range objects {
+		if input.ctx.Err() != nil {
+			break
+		}
+
+		readErr = input.readObject(obj.Key, writer)
+		if readErr != nil {
+			break
+		}
+
+		currentState = currentState.advance(obj.Key, obj.LastModified, input.lookback())
+		newState, err := json.Marshal(currentState)
+		if err != nil {
+			readErr = fmt.Errorf("issue marshalling state: %s", err)
+			break
+		}
+		writer.Checkpoint(newState)
+	}
+
+	// Send the results read so far. After a failure the state points at the last complete object, so the failed
+	// object is read again on the next run.
+	finalState, err := json.Marshal(currentState)
+	if err != nil {
+		writer.Discard()
+		return state, fmt.Errorf("issue marshalling state: %s", err)
+	}
+
+	err = writer.Flush(finalState)
+	if err != nil {
+		writer.Discard()
+		return state, err
+	}
+
+	return currentState, readErr
+}
+
+// listObjects returns the objects under the prefix that have not been read yet, oldest first
+func (input *s3Input) listObjects(state s3State) ([]object, error) {
+	objects := make([]object, 0)
+	err := input.client.ListObjectsV2PagesWithContext(input.ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(input.config.Bucket),
+		Prefix: aws.String(input.config.Prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, v := range page.Contents {
+			if v == nil || v.Key == nil || v.LastModified == nil {
+				continue
+			}
+
+			// Skip folder placeholders
+			if strings.HasSuffix(*v.Key, "/") {
+				continue
+			}
+
+			if state.processed(*v.Key, *v.LastModified, input.lookback()) {
+				continue
+			}
+
+			objects = append(objects, object{Key: *v.Key, LastModified: *v.LastModified})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("issue listing s3://%s/%s: %s", input.config.Bucket, input.config.Prefix, err)
+	}
+
+	sortObjects(objects)
+	return objects, nil
+}
+
+// readObject writes the object to the results, one event per line or per record when records_field is set
+func (input *s3Input) readObject(key string, writer *core.ResultWriter) error {
+	reader, err := awsutil.GetObjectReader(input.ctx, input.client, input.config.Bucket, key)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	writeLine := func(line []byte) error {
+		_, err := writer.Write(line)
+		return err
+	}
+
+	if input.config.RecordsField != "" {
+		err = core.ReadJSONRecords(reader, input.config.RecordsField, writeLine)
+	} else {
+		err = core.ReadLines(reader, writeLine)
+	}
+	if err != nil {
+		return fmt.Errorf("issue reading s3://%s/%s: %s", input.config.Bucket, key, err)
+	}
+
+	return nil
+}
+
+func (input *s3Input) lookback() time.Duration {
+	return time.Duration(input.config.Lookback) * time.Second
+}
+
+// sortObjects sorts objects by last modified time and then key
+func sortObjects(objects []object) {
+	sort.Slice(objects, func(i, j int) bool {
+		if !objects[i].LastModified.Equal(objects[j].LastModified) {
+			return objects[i].LastModified.Before(objects[j].LastModified)
+		}
+		return objects[i].Key < objects[j].Key
+	})
+}
+
+func defaultConfig() Config {
+	return Config{
+		Schedule:           60,
+		Lookback:           900,
+		MaxEventsPerResult: 100000,
+	}
+}
@@ -0,0 +1,142 @@
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/ThoronicLLC/collector/pkg/core"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+var config1 = `{"bucket": "example-bucket", "region": "us-east-1", "schedule": 30}`
+var config2 = `{"bucket": "example-bucket", "prefix": "AWSLogs/", "region": "us-east-1", "records_field": "Records", "read_existing": true, "max_events_per_result": 1000}`
+var config3 = `{"bucket": "example-bucket", "region": "us-east-1", "endpoint": "http://localhost:9000", "force_path_style": true, "auth_config": {"static": {"enabled": true, "access_key_id": "1234567890", "secret_access_key": "1234567890"}}}`
+var badConfig1 = `{"bucket": "", "region": "us-east-1"}`
+var badConfig2 = `{"bucket": "example-bucket", "region": ""}`
+var badConfig3 = `{"bucket": "example-bucket", "region": "us-east-1", "schedule": 0}`
+var badConfig4 = `{"bucket": "example-bucket", "region": "us-east-1", "max_events_per_result": -1}`
+var badConfig5 = `{"bucket": "example-bucket", "region": "us-east-1", "auth_config": {"static": {"enabled": true}}}`
+var badConfig6 = `{"bucket": "example-bucket", "region": "us-east-1", "lookback": -1}`
+
+func TestValidate(t *testing.T) {
+	arr := []string{config1, config2, config3}
+	for i, v := range arr {
+		testConfig := defaultConfig()
+		err := json.Unmarshal([]byte(v), &testConfig)
+		assert.Nilf(t, err, "test #%d - failed to unmarshal json: %s", i, err)
+		err = core.ValidateStruct(&testConfig)
+		assert.Nilf(t, err, "test #%d - validation error: %s", i, err)
+	}
+}
+
+func TestValidateFailed(t *testing.T) {
+	arr := []string{badConfig1, badConfig2, badConfig3, badConfig4, badConfig6}
+	for i, v := range arr {
+		testConfig := defaultConfig()
+		err := json.Unmarshal([]byte(v), &testConfig)
+		assert.Nilf(t, err, "test #%d - failed to unmarshal json: %s", i, err)
+		err = core.ValidateStruct(&testConfig)
+		assert.NotNilf(t, err, "test #%d - validation should have returned an error: %s", i, err)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	arr := []string{config1, config2, config3}
+	for i, v := range arr {
+		testConfig := defaultConfig()
+		err := json.Unmarshal([]byte(v), &testConfig)
+		assert.Nilf(t, err, "test #%d - failed to unmarshal json: %s", i, err)
+		handleFunc := Handler()
+		_, err = handleFunc([]byte(v))
+		assert.Nilf(t, err, "test #%d - validation error: %s", i, err)
+	}
+}
+
+func TestHandlerFailed(t *testing.T) {
+	arr := []string{badConfig1, badConfig2, badConfig3, badConfig4, badConfig5, badConfig6}
+	for i, v := range arr {
+		testConfig := defaultConfig()
+		err := json.Unmarshal([]byte(v), &testConfig)
+		assert.Nilf(t, err, "test #%d - failed to unmarshal json: %s", i, err)
+		handleFunc := Handler()
+		_, err = handleFunc([]byte(v))
+		assert.NotNilf(t, err, "test #%d - validation should have returned an error: %s", i, err)
+	}
+}
+
+type mockS3Client struct {
+	s3iface.S3API
+	objects map[string]string
+	times   map[string]time.Time
+}
+
+func (m *mockS3Client) ListObjectsV2PagesWithContext(_ aws.Context, _ *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool, _ ...request.Option) error {
+	page := &s3.ListObjectsV2Output{}
+	for key := range m.objects {
+		page.Contents = append(page.Contents, &s3.Object{Key: aws.String(key), LastModified: aws.Time(m.times[key])})
+	}
+	fn(page, true)
+	return nil
+}
+
+func (m *mockS3Client) GetObjectWithContext(_ aws.Context, input *s3.GetObjectInput, _ ...request.Option) (*s3.GetObjectOutput, error) {
+	return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(m.objects[*input.Key]))}, nil
+}
+
+func TestPoll(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	client := &mockS3Client{
+		objects: map[string]string{"old.log": "old\n", "b.log": "b1\nb2\nb3\n", "a.log": "a1\n"},
+		times:   map[string]time.Time{"old.log": now.Add(-time.Hour), "b.log": now, "a.log": now},
+	}
+
+	processPipe := make(chan core.PipelineResults, 10)
+	input := &s3Input{config: Config{Bucket: "example-bucket", MaxEventsPerResult: 3, Lookback: 900}, ctx: context.Background(), client: client}
+	state, err := input.poll(s3State{LastModified: now.Add(-time.Minute)}, processPipe)
+	assert.Nil(t, err)
+	assert.Equal(t, s3State{LastModified: now, Keys: map[string]time.Time{"a.log": now, "b.log": now}}, state)
+
+	// The second object is split once the result is full
+	results := make([]core.PipelineResults, 0)
+	for len(processPipe) > 0 {
+		res := <-processPipe
+		_ = os.Remove(res.FilePath)
+		results = append(results, res)
+	}
+	assert.Equal(t, 2, len(results))
+	assert.Equal(t, 3, results[0].ResultCount)
+	assert.Equal(t, 1, results[1].ResultCount)
+
+	// The split result carries the state after the first object
+	var splitState s3State
+	assert.Nil(t, json.Unmarshal(results[0].State, &splitState))
+	assert.Equal(t, map[string]time.Time{"a.log": now}, splitState.Keys)
+
+	// Nothing new is read on the next run
+	state, err = input.poll(state, processPipe)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(processPipe))
+	assert.Equal(t, 2, len(state.Keys))
+
+	// Objects that appear late are read when they are inside the lookback window
+	client.objects["late.log"] = "late\n"
+	client.times["late.log"] = now.Add(-5 * time.Minute)
+	client.objects["older.log"] = "older\n"
+	client.times["older.log"] = now.Add(-30 * time.Minute)
+	state, err = input.poll(state, processPipe)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(processPipe))
+	res := <-processPipe
+	_ = os.Remove(res.FilePath)
+	assert.Equal(t, 1, res.ResultCount)
+	assert.Equal(t, now, state.LastModified)
+	assert.Equal(t, map[string]time.Time{"a.log": now, "b.log": now, "late.log": now.Add(-5 * time.Minute)}, state.Keys)
+}
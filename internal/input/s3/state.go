@@ -0,0 +1,69 @@
+package s3
+
+import (
+	"encoding/json"
+	"github.com/ThoronicLLC/collector/pkg/core"
+	"time"
+)
+
+// s3State tracks the newest last modified time processed along with the keys processed within the lookback window
+// before it. Objects can become listable after newer objects have been read, when multipart uploads finish late or
+// listings lag, so objects inside the window are read unless their key has already been read.
+type s3State struct {
+	LastModified time.Time            `json:"last_modified"`
+	Keys         map[string]time.Time `json:"keys"`
+}
+
+func defaultState(readExisting bool) s3State {
+	if readExisting {
+		return s3State{}
+	}
+
+	return s3State{LastModified: time.Now().UTC()}
+}
+
+func loadState(state core.State, readExisting bool) s3State {
+	if state == nil {
+		return defaultState(readExisting)
+	}
+
+	var loadedState s3State
+	err := json.Unmarshal(state, &loadedState)
+	if err != nil {
+		return defaultState(readExisting)
+	}
+
+	return loadedState
+}
+
+// processed checks if the object has already been read. Until an object has been read the last modified time is
+// the start time and older objects are skipped.
+func (s s3State) processed(key string, lastModified time.Time, lookback time.Duration) bool {
+	if len(s.Keys) == 0 {
+		return lastModified.Before(s.LastModified)
+	}
+
+	if lastModified.Before(s.LastModified.Add(-lookback)) {
+		return true
+	}
+
+	_, ok := s.Keys[key]
+	return ok
+}
+
+// advance returns the state after the object has been read, dropping the keys that have left the lookback window
+func (s s3State) advance(key string, lastModified time.Time, lookback time.Duration) s3State {
+	newState := s3State{LastModified: s.LastModified, Keys: make(map[string]time.Time, len(s.Keys)+1)}
+	if lastModified.After(newState.LastModified) {
+		newState.LastModified = lastModified
+	}
+
+	for k, v := range s.Keys {
+		if !v.Before(newState.LastModified.Add(-lookback)) {
+			newState.Keys[k] = v
+		}
+	}
+	newState.Keys[key] = lastModified
+
+	return newState
+}
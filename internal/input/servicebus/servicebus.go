@@ -0,0 +1,378 @@
+package servicebus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	"github.com/ThoronicLLC/collector/pkg/core"
+	"sync"
+	"time"
+)
+
+var InputName = "servicebus"
+
+const (
+	// retryDelay is the wait before receiving again after an error
+	retryDelay = 5 * time.Second
+	// settleTimeout limits how long settling a message, or waiting for the last batches to settle on stop, can take
+	settleTimeout = 60 * time.Second
+	// settleWorkers is how many messages are settled at once, and pendingSettles how many can wait on the workers
+	// before acks block
+	settleWorkers  = 4
+	pendingSettles = 1000
+)
+
+// Config for the Service Bus input. Messages are received with a peek-lock and only completed once the batch
+// holding them has been written to the outputs. Messages that fail to be delivered are abandoned so they are
+// received again.
+type Config struct {
+	ConnectionString string `json:"connection_string" validate:"required"`
+	Queue            string `json:"queue"`
+	Topic            string `json:"topic"`
+	Subscription     string `json:"subscription"`
+	MaxMessages      int    `json:"max_messages" validate:"required|min:1"`
+
+	// LockRenewInterval is the seconds between renewing the locks of messages waiting to be delivered. It should be
+	// shorter than the lock duration of the entity. Zero disables renewal.
+	LockRenewInterval int `json:"lock_renew_interval" validate:"min:0"`
+
+	FlushFrequency int   `json:"flush_frequency" validate:"required|min:0"`
+	MaxBatchBytes  int64 `json:"max_batch_bytes" validate:"min:0"`
+	MaxBatchEvents int   `json:"max_batch_events" validate:"min:0"`
+
+	// MaxInFlightBatches is how many batches may be waiting on the outputs before receiving stops. The locks of the
+	// messages waiting are still renewed. MaxDiskBytes stops receiving once the waiting batches use that many bytes
+	// of temp files. Zero disables either limit.
+	MaxInFlightBatches int   `json:"max_in_flight_batches" validate:"min:0"`
+	MaxDiskBytes       int64 `json:"max_disk_bytes" validate:"min:0"`
+}
+
+type serviceBusInput struct {
+	config     Config
+	ctx        context.Context
+	cancelFunc context.CancelFunc
+}
+
+func Handler() core.InputHandler {
+	return func(config []byte) (core.Input, error) {
+		// Set config defaults
+		conf := defaultConfig()
+
+		// Unmarshal config
+		err := json.Unmarshal(config, &conf)
+		if err != nil {
+			return nil, fmt.Errorf("issue unmarshalling file config: %s", err)
+		}
+
+		// Validate config
+		err = core.ValidateStruct(&conf)
+		if err != nil {
+			return nil, err
+		}
+
+		// Validate entity settings
+		err = validateConfig(conf)
+		if err != nil {
+			return nil, err
+		}
+
+		// Setup context
+		ctx, cancelFn := context.WithCancel(context.Background())
+
+		return &serviceBusInput{
+			config:     conf,
+			ctx:        ctx,
+			cancelFunc: cancelFn,
+		}, nil
+	}
+}
+
+func (s *serviceBusInput) Run(errorHandler core.ErrorHandler, state core.State, processPipe chan<- core.PipelineResults) {
+	// Setup local variables
+	batcher, err := core.NewBatcher(s.ctx, core.BatchConfig{
+		FlushFrequency:     s.config.FlushFrequency,
+		MaxBatchBytes:      s.config.MaxBatchBytes,
+		MaxBatchEvents:     s.config.MaxBatchEvents,
+		MaxInFlightBatches: s.config.MaxInFlightBatches,
+		MaxDiskBytes:       s.config.MaxDiskBytes,
+		DropPolicy:         core.DropPolicyBlock,
+	}, processPipe)
+	if err != nil {
+		errorHandler(true, err)
+		return
+	}
+
+	// Setup new client
+	client, err := azservicebus.NewClientFromConnectionString(s.config.ConnectionString, nil)
+	if err != nil {
+		errorHandler(true, fmt.Errorf("issue setting up service bus client: %s", err))
+		return
+	}
+	defer client.Close(context.Background())
+
+	receiver, err := s.newReceiver(client)
+	if err != nil {
+		errorHandler(true, fmt.Errorf("issue setting up service bus receiver: %s", err))
+		return
+	}
+	defer receiver.Close(context.Background())
+
+	// Track the messages waiting to be delivered
+	pending := newLockTracker()
+
+	// Start the workers settling messages, so acks do not wait on the network. Messages settled after the workers
+	// have stopped are left for their lock to expire.
+	settlements := make(chan settlement, pendingSettles)
+	settleDone := make(chan struct{})
+	settle := func(v settlement) {
+		select {
+		case settlements <- v:
+		case <-settleDone:
+		}
+	}
+	var settleWg sync.WaitGroup
+	for i := 0; i < settleWorkers; i++ {
+		settleWg.Add(1)
+		go func() {
+			defer settleWg.Done()
+			s.settleMessages(receiver, settlements, settleDone, pending, errorHandler)
+		}()
+	}
+
+	// Start lock renewal go routine, which runs until the pending messages have settled
+	renewCtx, renewCancelFn := context.WithCancel(context.Background())
+	renewDone := make(chan struct{})
+	go func() {
+		defer close(renewDone)
+		s.renewLocks(renewCtx, receiver, pending, errorHandler)
+	}()
+
+	// Setup wait group. The flush context is only cancelled once the receiver has stopped writing, so the final
+	// flush includes every message received.
+	var wg sync.WaitGroup
+	flushCtx, flushCancelFn := context.WithCancel(context.Background())
+
+	// Start service bus receiver go routine
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer flushCancelFn()
+		s.receive(receiver, batcher, pending, settle, errorHandler)
+	}()
+
+	// Start timed process sync go routine
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		batcher.Run(flushCtx, errorHandler)
+	}()
+
+	wg.Wait()
+
+	// Batches are acknowledged after the outputs have written them, so keep the receiver open for the last batches
+	pending.wait(settleTimeout)
+	close(settleDone)
+	settleWg.Wait()
+	renewCancelFn()
+	<-renewDone
+}
+
+func (s *serviceBusInput) Stop() {
+	s.cancelFunc()
+}
+
+// newReceiver creates a peek-lock receiver for the queue or topic subscription
+func (s *serviceBusInput) newReceiver(client *azservicebus.Client) (*azservicebus.Receiver, error) {
+	options := &azservicebus.ReceiverOptions{ReceiveMode: azservicebus.ReceiveModePeekLock}
+	if s.config.Queue != "" {
+		return client.NewReceiverForQueue(s.config.Queue, options)
+	}
+
+	return client.NewReceiverForSubscription(s.config.Topic, s.config.Subscription, options)
+}
+
+// receive writes the messages to the batcher until the input is stopped. Each message is completed once its batch
+// is delivered, and abandoned if it can not be written or delivered.
+func (s *serviceBusInput) receive(receiver *azservicebus.Receiver, batcher *core.Batcher, pending *lockTracker, settle func(settlement), errorHandler core.ErrorHandler) {
+	for {
+		messages, err := receiver.ReceiveMessages(s.ctx, s.config.MaxMessages, nil)
+		if err != nil {
+			if s.ctx.Err() != nil {
+				return
+			}
+
+			errorHandler(false, fmt.Errorf("error receiving service bus messages: %s", err))
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-time.After(retryDelay):
+			}
+			continue
+		}
+
+		for _, message := range messages {
+			message := message
+			pending.add(message)
+
+			// Skip empty messages
+			if len(message.Body) == 0 {
+				settle(settlement{message: message, complete: true})
+				continue
+			}
+
+			_, writeErr := batcher.WriteWithAck(message.Body, func(delivered bool) {
+				settle(settlement{message: message, complete: delivered})
+			})
+			if writeErr != nil {
+				errorHandler(false, fmt.Errorf("error writing to tmp file: %s", writeErr))
+				settle(settlement{message: message})
+			}
+		}
+	}
+}
+
+// settlement completes or abandons a received message
+type settlement struct {
+	message  *azservicebus.ReceivedMessage
+	complete bool
+}
+
+// settleMessages settles the messages until done is closed
+func (s *serviceBusInput) settleMessages(receiver *azservicebus.Receiver, settlements <-chan settlement, done <-chan struct{}, pending *lockTracker, errorHandler core.ErrorHandler) {
+	for {
+		select {
+		case <-done:
+			return
+		case v := <-settlements:
+			if v.complete {
+				s.complete(receiver, v.message, pending, errorHandler)
+			} else {
+				s.abandon(receiver, v.message, pending, errorHandler)
+			}
+		}
+	}
+}
+
+// complete removes the message from the entity after it has been delivered
+func (s *serviceBusInput) complete(receiver *azservicebus.Receiver, message *azservicebus.ReceivedMessage, pending *lockTracker, errorHandler core.ErrorHandler) {
+	defer pending.remove(message)
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), settleTimeout)
+	defer cancelFn()
+
+	err := receiver.CompleteMessage(ctx, message, nil)
+	if err != nil {
+		errorHandler(false, fmt.Errorf("issue completing service bus message %s: %s", message.MessageID, err))
+	}
+}
+
+// abandon releases the lock on the message so it can be received again
+func (s *serviceBusInput) abandon(receiver *azservicebus.Receiver, message *azservicebus.ReceivedMessage, pending *lockTracker, errorHandler core.ErrorHandler) {
+	defer pending.remove(message)
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), settleTimeout)
+	defer cancelFn()
+
+	err := receiver.AbandonMessage(ctx, message, nil)
+	if err != nil {
+		errorHandler(false, fmt.Errorf("issue abandoning service bus message %s: %s", message.MessageID, err))
+	}
+}
+
+// renewLocks renews the locks of the pending messages on every interval until the context is cancelled. Messages
+// whose lock can not be renewed are no longer tracked, as they will be received again.
+func (s *serviceBusInput) renewLocks(ctx context.Context, receiver *azservicebus.Receiver, pending *lockTracker, errorHandler core.ErrorHandler) {
+	if s.config.LockRenewInterval == 0 {
+		<-ctx.Done()
+		return
+	}
+
+	ticker := time.NewTicker(time.Duration(s.config.LockRenewInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, message := range pending.messages() {
+				err := receiver.RenewMessageLock(ctx, message, nil)
+				if err != nil {
+					if ctx.Err() != nil {
+						return
+					}
+					errorHandler(false, fmt.Errorf("issue renewing lock of service bus message %s: %s", message.MessageID, err))
+					pending.remove(message)
+				}
+			}
+		}
+	}
+}
+
+// lockTracker holds the messages that have been received but not yet settled
+type lockTracker struct {
+	mu       sync.Mutex
+	received map[*azservicebus.ReceivedMessage]struct{}
+}
+
+func newLockTracker() *lockTracker {
+	return &lockTracker{received: make(map[*azservicebus.ReceivedMessage]struct{})}
+}
+
+func (t *lockTracker) add(message *azservicebus.ReceivedMessage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.received[message] = struct{}{}
+}
+
+func (t *lockTracker) remove(message *azservicebus.ReceivedMessage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.received, message)
+}
+
+func (t *lockTracker) messages() []*azservicebus.ReceivedMessage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	messages := make([]*azservicebus.ReceivedMessage, 0, len(t.received))
+	for message := range t.received {
+		messages = append(messages, message)
+	}
+	return messages
+}
+
+func (t *lockTracker) len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.received)
+}
+
+// wait blocks until there are no pending messages or the timeout is reached
+func (t *lockTracker) wait(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for t.len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// validateConfig checks that either a queue, or a topic and subscription, is set
+func validateConfig(conf Config) error {
+	if conf.Queue != "" && (conf.Topic != "" || conf.Subscription != "") {
+		return fmt.Errorf("queue cannot be set with a topic or subscription")
+	}
+
+	if conf.Queue == "" && (conf.Topic == "" || conf.Subscription == "") {
+		return fmt.Errorf("either queue, or topic and subscription, are required")
+	}
+
+	return nil
+}
+
+func defaultConfig() Config {
+	return Config{
+		MaxMessages:       100,
+		LockRenewInterval: 30,
+		FlushFrequency:    300,
+	}
+}
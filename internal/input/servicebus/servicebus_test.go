@@ -0,0 +1,83 @@
+package servicebus
+
+import (
+	"encoding/json"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	"github.com/ThoronicLLC/collector/pkg/core"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+var connectionString = "Endpoint=sb://example.servicebus.windows.net/;SharedAccessKeyName=listen;SharedAccessKey=1234567890"
+
+var config1 = `{"connection_string": "` + connectionString + `", "queue": "audit-logs"}`
+var config2 = `{"connection_string": "` + connectionString + `", "topic": "audit-logs", "subscription": "collector", "flush_frequency": 100}`
+var config3 = `{"connection_string": "` + connectionString + `", "queue": "audit-logs", "max_messages": 500, "lock_renew_interval": 0, "max_batch_events": 1000}`
+var badConfig1 = `{"connection_string": "", "queue": "audit-logs"}`
+var badConfig2 = `{"connection_string": "` + connectionString + `", "queue": "audit-logs", "max_messages": 0}`
+var badConfig3 = `{"connection_string": "` + connectionString + `", "queue": "audit-logs", "lock_renew_interval": -1}`
+var badConfig4 = `{"connection_string": "` + connectionString + `", "queue": "audit-logs", "flush_frequency": -1}`
+var badConfig5 = `{"connection_string": "` + connectionString + `"}`
+var badConfig6 = `{"connection_string": "` + connectionString + `", "topic": "audit-logs"}`
+var badConfig7 = `{"connection_string": "` + connectionString + `", "queue": "audit-logs", "topic": "audit-logs", "subscription": "collector"}`
+
+func TestValidate(t *testing.T) {
+	arr := []string{config1, config2, config3}
+	for i, v := range arr {
+		testConfig := defaultConfig()
+		err := json.Unmarshal([]byte(v), &testConfig)
+		assert.Nilf(t, err, "test #%d - failed to unmarshal json: %s", i, err)
+		err = core.ValidateStruct(&testConfig)
+		assert.Nilf(t, err, "test #%d - validation error: %s", i, err)
+	}
+}
+
+func TestValidateFailed(t *testing.T) {
+	arr := []string{badConfig1, badConfig2, badConfig3, badConfig4}
+	for i, v := range arr {
+		testConfig := defaultConfig()
+		err := json.Unmarshal([]byte(v), &testConfig)
+		assert.Nilf(t, err, "test #%d - failed to unmarshal json: %s", i, err)
+		err = core.ValidateStruct(&testConfig)
+		assert.NotNilf(t, err, "test #%d - validation should have returned an error: %s", i, err)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	arr := []string{config1, config2, config3}
+	for i, v := range arr {
+		handleFunc := Handler()
+		_, err := handleFunc([]byte(v))
+		assert.Nilf(t, err, "test #%d - validation error: %s", i, err)
+	}
+}
+
+func TestHandlerFailed(t *testing.T) {
+	arr := []string{badConfig1, badConfig2, badConfig3, badConfig4, badConfig5, badConfig6, badConfig7}
+	for i, v := range arr {
+		handleFunc := Handler()
+		_, err := handleFunc([]byte(v))
+		assert.NotNilf(t, err, "test #%d - validation should have returned an error: %s", i, err)
+	}
+}
+
+func TestLockTracker(t *testing.T) {
+	pending := newLockTracker()
+	message1 := &azservicebus.ReceivedMessage{MessageID: "1"}
+	message2 := &azservicebus.ReceivedMessage{MessageID: "2"}
+	pending.add(message1)
+	pending.add(message2)
+	assert.Equal(t, 2, len(pending.messages()))
+
+	pending.remove(message1)
+	assert.Equal(t, []*azservicebus.ReceivedMessage{message2}, pending.messages())
+
+	// Waiting returns once the remaining message settles
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		pending.remove(message2)
+	}()
+	pending.wait(5 * time.Second)
+	assert.Equal(t, 0, pending.len())
+}
@@ -0,0 +1,81 @@
+package sqs
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// s3Object is an object referenced by an S3 event notification
+type s3Object struct {
+	Bucket string
+	Key    string
+}
+
+// snsEnvelope is the wrapper used when S3 notifications are delivered to SQS through an SNS topic
+type snsEnvelope struct {
+	Type    string `json:"Type"`
+	Message string `json:"Message"`
+}
+
+// s3Notification is an S3 event notification
+//
+// https://docs.aws.amazon.com/AmazonS3/latest/userguide/notification-content-structure.html
+type s3Notification struct {
+	Event   string          `json:"Event"`
+	Records []s3EventRecord `json:"Records"`
+}
+
+type s3EventRecord struct {
+	EventSource string `json:"eventSource"`
+	EventName   string `json:"eventName"`
+	S3          struct {
+		Bucket struct {
+			Name string `json:"name"`
+		} `json:"bucket"`
+		Object struct {
+			Key string `json:"key"`
+		} `json:"object"`
+	} `json:"s3"`
+}
+
+// parseS3Notification returns the objects created in an S3 event notification, unwrapping SNS envelopes. Test
+// events and other event types return no objects.
+func parseS3Notification(body string) ([]s3Object, error) {
+	// Unwrap SNS notifications
+	var envelope snsEnvelope
+	err := json.Unmarshal([]byte(body), &envelope)
+	if err != nil {
+		return nil, fmt.Errorf("message is not a valid s3 notification: %s", err)
+	}
+	if envelope.Type == "Notification" && envelope.Message != "" {
+		body = envelope.Message
+	}
+
+	var notification s3Notification
+	err = json.Unmarshal([]byte(body), &notification)
+	if err != nil {
+		return nil, fmt.Errorf("message is not a valid s3 notification: %s", err)
+	}
+
+	objects := make([]s3Object, 0)
+	for _, record := range notification.Records {
+		if record.EventSource != "aws:s3" || !strings.HasPrefix(record.EventName, "ObjectCreated:") {
+			continue
+		}
+
+		// Object keys are URL encoded in notifications
+		key, err := url.QueryUnescape(record.S3.Object.Key)
+		if err != nil {
+			return nil, fmt.Errorf("issue decoding object key %s: %s", record.S3.Object.Key, err)
+		}
+
+		objects = append(objects, s3Object{
+			Bucket: record.S3.Bucket.Name,
+			Key:    key,
+		})
+	}
+
+	return objects, nil
+}
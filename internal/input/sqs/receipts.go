@@ -0,0 +1,180 @@
+package sqs
+
+import (
+	"context"
+	"fmt"
+	"github.com/ThoronicLLC/collector/pkg/core"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// settleTimeout limits how long a delete call, or waiting for the last batches to be delivered on stop, can take
+	settleTimeout = 60 * time.Second
+	// pendingDeletes is how many delivered messages can wait on the delete worker before acks block
+	pendingDeletes = 1000
+)
+
+// receiptTracker deletes messages from the queue once every event written from them has been delivered. Messages
+// that fail to be delivered are left on the queue so they are received again after the visibility timeout.
+type receiptTracker struct {
+	mu      sync.Mutex
+	pending int
+	deletes chan *string
+	done    chan struct{}
+}
+
+func newReceiptTracker() *receiptTracker {
+	return &receiptTracker{deletes: make(chan *string, pendingDeletes), done: make(chan struct{})}
+}
+
+// add starts tracking the events written from a message
+func (t *receiptTracker) add(receiptHandle *string) *pendingMessage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending++
+	return &pendingMessage{tracker: t, receiptHandle: receiptHandle}
+}
+
+func (t *receiptTracker) settle(receiptHandle *string, delivered bool) {
+	// Messages delivered after the tracker has stopped are received again
+	if delivered {
+		select {
+		case t.deletes <- receiptHandle:
+		case <-t.done:
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending--
+}
+
+func (t *receiptTracker) len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.pending
+}
+
+// wait blocks until there are no pending messages or the timeout is reached
+func (t *receiptTracker) wait(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for t.len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// stop ends deleteMessages once the messages already delivered have been deleted
+func (t *receiptTracker) stop() {
+	close(t.done)
+}
+
+// deleteMessages deletes the delivered messages in batches until the tracker is stopped
+func (t *receiptTracker) deleteMessages(sqsService sqsiface.SQSAPI, queueUrl string, errorHandler core.ErrorHandler) {
+	for {
+		select {
+		case receiptHandle := <-t.deletes:
+			t.deleteBatch(sqsService, queueUrl, receiptHandle, errorHandler)
+		case <-t.done:
+			for {
+				select {
+				case receiptHandle := <-t.deletes:
+					t.deleteBatch(sqsService, queueUrl, receiptHandle, errorHandler)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// deleteBatch deletes the message along with any others already waiting
+func (t *receiptTracker) deleteBatch(sqsService sqsiface.SQSAPI, queueUrl string, receiptHandle *string, errorHandler core.ErrorHandler) {
+	entries := []*sqs.DeleteMessageBatchRequestEntry{{Id: aws.String("0"), ReceiptHandle: receiptHandle}}
+
+collect:
+	for len(entries) < maxReceiveMessages {
+		select {
+		case next := <-t.deletes:
+			entries = append(entries, &sqs.DeleteMessageBatchRequestEntry{Id: aws.String(strconv.Itoa(len(entries))), ReceiptHandle: next})
+		default:
+			break collect
+		}
+	}
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), settleTimeout)
+	defer cancelFn()
+	output, err := sqsService.DeleteMessageBatchWithContext(ctx, &sqs.DeleteMessageBatchInput{
+		QueueUrl: aws.String(queueUrl),
+		Entries:  entries,
+	})
+	if err != nil {
+		errorHandler(false, fmt.Errorf("issue deleting %d sqs messages: %s", len(entries), err))
+		return
+	}
+
+	for _, failed := range output.Failed {
+		errorHandler(false, fmt.Errorf("issue deleting sqs message: %s", derefString(failed.Message)))
+	}
+}
+
+// pendingMessage counts the events of a message waiting to be delivered. The message is settled once it has been
+// fully written and every event has been acknowledged.
+type pendingMessage struct {
+	tracker       *receiptTracker
+	receiptHandle *string
+
+	mu       sync.Mutex
+	events   int
+	finished bool
+	failed   bool
+	settled  bool
+}
+
+// write adds an event of the message to the batch
+func (m *pendingMessage) write(batcher *core.Batcher, p []byte) error {
+	m.mu.Lock()
+	m.events++
+	m.mu.Unlock()
+
+	_, err := batcher.WriteWithAck(p, m.ack)
+	if err != nil {
+		m.ack(false)
+	}
+	return err
+}
+
+func (m *pendingMessage) ack(delivered bool) {
+	m.mu.Lock()
+	m.events--
+	m.failed = m.failed || !delivered
+	m.mu.Unlock()
+	m.settle()
+}
+
+// finish marks the message as fully written, or failed when it could not be
+func (m *pendingMessage) finish(written bool) {
+	m.mu.Lock()
+	m.finished = true
+	m.failed = m.failed || !written
+	m.mu.Unlock()
+	m.settle()
+}
+
+func (m *pendingMessage) settle() {
+	m.mu.Lock()
+	if !m.finished || m.events > 0 || m.settled {
+		m.mu.Unlock()
+		return
+	}
+
+	m.settled = true
+	delivered := !m.failed
+	m.mu.Unlock()
+
+	m.tracker.settle(m.receiptHandle, delivered)
+}
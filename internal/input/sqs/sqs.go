@@ -4,24 +4,53 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	awsutil "github.com/ThoronicLLC/collector/internal/integrations/aws"
 	"github.com/ThoronicLLC/collector/pkg/core"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"sync"
-	"time"
 )
 
 var InputName = "sqs"
 
+const (
+	// maxReceiveMessages is the maximum number of messages SQS returns per receive call
+	maxReceiveMessages = 10
+	// maxWaitTimeSeconds is the maximum long poll wait time supported by SQS
+	maxWaitTimeSeconds = 20
+)
+
+// Config for the SQS input. Messages are only deleted once every event read from them has been delivered, so the
+// visibility timeout of the queue should be longer than the flush frequency.
 type Config struct {
 	QueueUrl        string `json:"queue_url" validate:"required"`
 	Region          string `json:"region" validate:"required"`
-	AccessKeyID     string `json:"access_key_id" validate:"required"`
-	SecretAccessKey string `json:"secret_access_key" validate:"required"`
+	AccessKeyID     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key"`
 	PollFrequency   int    `json:"poll_frequency" validate:"required|int|min:10"`
 	FlushFrequency  int    `json:"flush_frequency" validate:"required|int|min:10"`
+	MaxBatchBytes   int64  `json:"max_batch_bytes" validate:"min:0"`
+	MaxBatchEvents  int    `json:"max_batch_events" validate:"min:0"`
+
+	// AuthConfig falls back to the default AWS credential chain when no method is enabled. Endpoint overrides the
+	// SQS and S3 endpoints for compatible services such as LocalStack.
+	AuthConfig     awsutil.AuthConfig `json:"auth_config"`
+	Endpoint       string             `json:"endpoint"`
+	ForcePathStyle bool               `json:"force_path_style"`
+
+	// In s3_notifications mode messages are S3 event notifications (optionally wrapped in SNS) and the created
+	// objects are read instead of the message body. RecordsField splits JSON objects such as CloudTrail logs
+	// into one event per record.
+	Mode         string `json:"mode" validate:"in:raw,s3_notifications"`
+	RecordsField string `json:"records_field"`
+
+	// MaxInFlightBatches is how many batches may be waiting on the outputs before receiving stops, leaving the
+	// messages in the queue. MaxDiskBytes stops receiving once the waiting batches use that many bytes of temp files.
+	// Zero disables either limit.
+	MaxInFlightBatches int   `json:"max_in_flight_batches" validate:"min:0"`
+	MaxDiskBytes       int64 `json:"max_disk_bytes" validate:"min:0"`
 }
 
 type sqsInput struct {
@@ -47,6 +76,17 @@ func Handler() core.InputHandler {
 			return nil, err
 		}
 
+		// Setup auth config
+		conf.AuthConfig, err = conf.AuthConfig.WithLegacyKeys(conf.AccessKeyID, conf.SecretAccessKey)
+		if err != nil {
+			return nil, err
+		}
+
+		err = conf.AuthConfig.Validate()
+		if err != nil {
+			return nil, err
+		}
+
 		// Setup context
 		ctx, cancelFn := context.WithCancel(context.Background())
 
@@ -60,37 +100,48 @@ func Handler() core.InputHandler {
 
 func (s *sqsInput) Run(errorHandler core.ErrorHandler, state core.State, processPipe chan<- core.PipelineResults) {
 	// Setup local variables
-	tmpWriter, err := core.NewTmpWriter()
+	batcher, err := core.NewBatcher(s.ctx, core.BatchConfig{
+		FlushFrequency:     s.config.FlushFrequency,
+		MaxBatchBytes:      s.config.MaxBatchBytes,
+		MaxBatchEvents:     s.config.MaxBatchEvents,
+		MaxInFlightBatches: s.config.MaxInFlightBatches,
+		MaxDiskBytes:       s.config.MaxDiskBytes,
+		DropPolicy:         core.DropPolicyBlock,
+	}, processPipe)
 	if err != nil {
 		errorHandler(true, err)
 		return
 	}
 
-	// Initialize AWS creds
-	creds := credentials.NewStaticCredentials(s.config.AccessKeyID, s.config.SecretAccessKey, "")
-	_, err = creds.Get()
-	if err != nil {
-		errorHandler(true, fmt.Errorf("invalid aws credentials: %s", err))
-		return
-	}
-
-	// Initialize AWS config
-	awsConf := aws.NewConfig().WithRegion(s.config.Region).WithCredentials(creds)
-
 	// Initialize AWS session
-	awsSession, err := session.NewSession(awsConf)
+	awsSession, err := awsutil.NewSession(awsutil.SessionConfig{
+		Region:         s.config.Region,
+		Endpoint:       s.config.Endpoint,
+		ForcePathStyle: s.config.ForcePathStyle,
+		AuthConfig:     s.config.AuthConfig,
+	})
 	if err != nil {
-		errorHandler(true, fmt.Errorf("issue creating AWS session: %s", err))
+		errorHandler(true, err)
 		return
 	}
 
 	sqsService := sqs.New(awsSession)
+	s3Service := s3.New(awsSession)
 
-	// Setup wait group
+	// Start the worker deleting messages once they are delivered
+	receipts := newReceiptTracker()
+	deleteDone := make(chan struct{})
+	go func() {
+		defer close(deleteDone)
+		receipts.deleteMessages(sqsService, s.config.QueueUrl, errorHandler)
+	}()
+
+	// Setup wait group. The flush context is only cancelled once the receiver has stopped writing, so the final
+	// flush includes every message received.
 	var wg sync.WaitGroup
-	flushCtx, flushCancelFn := context.WithCancel(s.ctx)
+	flushCtx, flushCancelFn := context.WithCancel(context.Background())
 
-	// Start pub sub receiver go routine
+	// Start sqs receiver go routine
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
@@ -103,28 +154,35 @@ func (s *sqsInput) Run(errorHandler core.ErrorHandler, state core.State, process
 				// Long poll SQS
 				output, err := sqsService.ReceiveMessageWithContext(s.ctx, &sqs.ReceiveMessageInput{
 					QueueUrl:            aws.String(s.config.QueueUrl),
-					MaxNumberOfMessages: aws.Int64(10000),
-					WaitTimeSeconds:     aws.Int64(int64(s.config.PollFrequency)),
+					MaxNumberOfMessages: aws.Int64(maxReceiveMessages),
+					WaitTimeSeconds:     aws.Int64(int64(waitTimeSeconds(s.config.PollFrequency))),
 				})
 				if err != nil {
+					// Stopping the input cancels the long poll
+					if s.ctx.Err() != nil {
+						return
+					}
+
 					errorHandler(true, fmt.Errorf("failed to fetch sqs messages: %s", err))
 					return
 				}
 
-				// Loop through received messages and skip those with an empty body
+				// Process received messages, which are deleted from the queue once their events are delivered
 				if output != nil {
 					for _, message := range output.Messages {
-						if message != nil {
-							if message.Body != nil {
-								safeBody := derefString(message.Body)
-								if safeBody != "" {
-									_, err = tmpWriter.Write([]byte(safeBody))
-									if err != nil {
-										errorHandler(false, fmt.Errorf("issue writing sqs message to tmp file: %s", err))
-									}
-								}
-							}
+						if message == nil {
+							continue
 						}
+
+						pending := receipts.add(message.ReceiptHandle)
+						err = s.processMessage(derefString(message.Body), s3Service, func(p []byte) error {
+							return pending.write(batcher, p)
+						})
+						if err != nil {
+							// Leave the message on the queue so it is retried after the visibility timeout
+							errorHandler(false, fmt.Errorf("issue processing sqs message: %s", err))
+						}
+						pending.finish(err == nil)
 					}
 				}
 			}
@@ -135,61 +193,88 @@ func (s *sqsInput) Run(errorHandler core.ErrorHandler, state core.State, process
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		for {
-			select {
-			case <-flushCtx.Done():
-				return
-			case <-time.After(time.Duration(s.config.FlushFrequency) * time.Second):
-				err = flush(tmpWriter, processPipe)
-				if err != nil {
-					errorHandler(false, fmt.Errorf("issue flushing file: %s", err))
-				}
-			}
-		}
+		batcher.Run(flushCtx, errorHandler)
 	}()
 
 	wg.Wait()
 
-	// Flush any remaining data to pipeline before return
-	err = flush(tmpWriter, processPipe)
-	if err != nil {
-		errorHandler(false, fmt.Errorf("issue flushing file: %s", err))
-	}
+	// Batches are acknowledged after the outputs have written them, so wait for the last messages to be deleted
+	receipts.wait(settleTimeout)
+	receipts.stop()
+	<-deleteDone
 }
 
 func (s *sqsInput) Stop() {
 	s.cancelFunc()
 }
 
-func derefString(s *string) string {
-	if s != nil {
-		return *s
+// processMessage writes the message body, or in s3_notifications mode, the contents of every object created in the
+// notification
+func (s *sqsInput) processMessage(body string, s3Service s3iface.S3API, write func(p []byte) error) error {
+	// Skip empty messages
+	if body == "" {
+		return nil
 	}
 
-	return ""
-}
+	if s.config.Mode != "s3_notifications" {
+		return write([]byte(body))
+	}
 
-func defaultConfig() Config {
-	return Config{
-		PollFrequency:  20,
-		FlushFrequency: 300,
+	objects, err := parseS3Notification(body)
+	if err != nil {
+		return err
+	}
+
+	for _, object := range objects {
+		err = s.processObject(object, s3Service, write)
+		if err != nil {
+			return err
+		}
 	}
+
+	return nil
 }
 
-func flush(tmpFile *core.TmpWriter, processPipe chan<- core.PipelineResults) error {
-	// Rotate the temp writer
-	count, fileName, err := tmpFile.Rotate()
+// processObject streams an S3 object, one event per line or per record when records_field is set
+func (s *sqsInput) processObject(object s3Object, s3Service s3iface.S3API, write func(p []byte) error) error {
+	reader, err := awsutil.GetObjectReader(s.ctx, s3Service, object.Bucket, object.Key)
 	if err != nil {
 		return err
 	}
+	defer reader.Close()
 
-	// Only send on if there are results
-	processPipe <- core.PipelineResults{
-		FilePath:    fileName,
-		ResultCount: count,
-		State:       nil,
-		RetryCount:  0,
+	if s.config.RecordsField != "" {
+		err = core.ReadJSONRecords(reader, s.config.RecordsField, write)
+	} else {
+		err = core.ReadLines(reader, write)
+	}
+	if err != nil {
+		return fmt.Errorf("issue reading s3://%s/%s: %s", object.Bucket, object.Key, err)
 	}
 
 	return nil
 }
+
+// waitTimeSeconds limits the poll frequency to the maximum long poll wait time supported by SQS
+func waitTimeSeconds(pollFrequency int) int {
+	if pollFrequency > maxWaitTimeSeconds {
+		return maxWaitTimeSeconds
+	}
+	return pollFrequency
+}
+
+func derefString(s *string) string {
+	if s != nil {
+		return *s
+	}
+
+	return ""
+}
+
+func defaultConfig() Config {
+	return Config{
+		PollFrequency:  20,
+		FlushFrequency: 300,
+		Mode:           "raw",
+	}
+}
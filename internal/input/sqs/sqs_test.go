@@ -1,23 +1,32 @@
 package sqs
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/ThoronicLLC/collector/pkg/core"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/stretchr/testify/assert"
+	"os"
 	"testing"
 )
 
 var config1 = `{"queue_url": "https://example.com", "region": "us-east-1", "access_key_id": "1234567890", "secret_access_key": "1234567890", "poll_frequency": 30, "flush_frequency": 100}`
 var config2 = `{"queue_url": "https://example.com", "region": "us-east-1", "access_key_id": "1234567890", "secret_access_key": "1234567890"}`
+var config3 = `{"queue_url": "https://example.com", "region": "us-east-1", "access_key_id": "1234567890", "secret_access_key": "1234567890", "mode": "s3_notifications", "records_field": "Records"}`
+var config4 = `{"queue_url": "https://example.com", "region": "us-east-1", "endpoint": "http://localhost:4566"}`
+var config5 = `{"queue_url": "https://example.com", "region": "us-east-1", "auth_config": {"profile": {"enabled": true, "name": "collector"}, "assume_role": {"enabled": true, "role_arn": "arn:aws:iam::123456789012:role/collector"}}}`
 var badConfig1 = `{"queue_url": "", "region": "us-east-1", "access_key_id": "1234567890", "secret_access_key": "1234567890", "poll_frequency": 30, "flush_frequency": 100}`
 var badConfig2 = `{"queue_url": "https://example.com", "region": "", "access_key_id": "1234567890", "secret_access_key": "1234567890", "poll_frequency": 30, "flush_frequency": 100}`
 var badConfig3 = `{"queue_url": "https://example.com", "region": "us-east-1", "access_key_id": "", "secret_access_key": "1234567890", "poll_frequency": 30, "flush_frequency": 100}`
 var badConfig4 = `{"queue_url": "https://example.com", "region": "us-east-1", "access_key_id": "1234567890", "secret_access_key": "", "poll_frequency": 30, "flush_frequency": 100}`
 var badConfig5 = `{"queue_url": "https://example.com", "region": "us-east-1", "access_key_id": "1234567890", "secret_access_key": "1234567890", "poll_frequency": 0, "flush_frequency": 100}`
 var badConfig6 = `{"queue_url": "https://example.com", "region": "us-east-1", "access_key_id": "1234567890", "secret_access_key": "1234567890", "poll_frequency": 30, "flush_frequency": 0}`
+var badConfig7 = `{"queue_url": "https://example.com", "region": "us-east-1", "access_key_id": "1234567890", "secret_access_key": "1234567890", "mode": "s3"}`
+var badConfig8 = `{"queue_url": "https://example.com", "region": "us-east-1", "auth_config": {"environment": {"enabled": true}, "profile": {"enabled": true}}}`
+var badConfig9 = `{"queue_url": "https://example.com", "region": "us-east-1", "auth_config": {"web_identity": {"enabled": true, "role_arn": "arn:aws:iam::123456789012:role/collector"}}}`
 
 func TestValidate(t *testing.T) {
-	arr := []string{config1, config2}
+	arr := []string{config1, config2, config3, config4, config5}
 	for i, v := range arr {
 		testConfig := defaultConfig()
 		err := json.Unmarshal([]byte(v), &testConfig)
@@ -28,7 +37,7 @@ func TestValidate(t *testing.T) {
 }
 
 func TestValidateFailed(t *testing.T) {
-	arr := []string{badConfig1, badConfig2, badConfig3, badConfig4, badConfig5, badConfig6}
+	arr := []string{badConfig1, badConfig2, badConfig5, badConfig6, badConfig7}
 	for i, v := range arr {
 		testConfig := defaultConfig()
 		err := json.Unmarshal([]byte(v), &testConfig)
@@ -39,7 +48,7 @@ func TestValidateFailed(t *testing.T) {
 }
 
 func TestHandler(t *testing.T) {
-	arr := []string{config1, config2}
+	arr := []string{config1, config2, config3, config4, config5}
 	for i, v := range arr {
 		testConfig := defaultConfig()
 		err := json.Unmarshal([]byte(v), &testConfig)
@@ -51,7 +60,7 @@ func TestHandler(t *testing.T) {
 }
 
 func TestHandlerFailed(t *testing.T) {
-	arr := []string{badConfig1, badConfig2, badConfig3, badConfig4, badConfig5, badConfig6}
+	arr := []string{badConfig1, badConfig2, badConfig3, badConfig4, badConfig5, badConfig6, badConfig7, badConfig8, badConfig9}
 	for i, v := range arr {
 		testConfig := defaultConfig()
 		err := json.Unmarshal([]byte(v), &testConfig)
@@ -61,3 +70,73 @@ func TestHandlerFailed(t *testing.T) {
 		assert.NotNilf(t, err, "test #%d - validation should have returned an error: %s", i, err)
 	}
 }
+
+func TestParseS3Notification(t *testing.T) {
+	notification := `{"Records": [{"eventSource": "aws:s3", "eventName": "ObjectCreated:Put", "s3": {"bucket": {"name": "logs"}, "object": {"key": "AWSLogs/cloudtrail/file+name%3A1.json.gz"}}}, {"eventSource": "aws:s3", "eventName": "ObjectRemoved:Delete", "s3": {"bucket": {"name": "logs"}, "object": {"key": "removed.json"}}}]}`
+
+	objects, err := parseS3Notification(notification)
+	assert.Nil(t, err)
+	assert.Equal(t, []s3Object{{Bucket: "logs", Key: "AWSLogs/cloudtrail/file name:1.json.gz"}}, objects)
+
+	// Notifications delivered through SNS
+	envelope, _ := json.Marshal(map[string]string{"Type": "Notification", "Message": notification})
+	objects, err = parseS3Notification(string(envelope))
+	assert.Nil(t, err)
+	assert.Equal(t, []s3Object{{Bucket: "logs", Key: "AWSLogs/cloudtrail/file name:1.json.gz"}}, objects)
+
+	// Test events have no objects
+	objects, err = parseS3Notification(`{"Service": "Amazon S3", "Event": "s3:TestEvent", "Bucket": "logs"}`)
+	assert.Nil(t, err)
+	assert.Empty(t, objects)
+
+	_, err = parseS3Notification("not json")
+	assert.NotNil(t, err)
+}
+
+func TestReceiptTracker(t *testing.T) {
+	processPipe := make(chan core.PipelineResults, 10)
+	batcher, err := core.NewBatcher(context.Background(), core.BatchConfig{FlushFrequency: 300, MaxBatchEvents: 2}, processPipe)
+	assert.Nil(t, err)
+
+	receipts := newReceiptTracker()
+
+	// Messages without events are deleted once written
+	empty := receipts.add(aws.String("empty"))
+	empty.finish(true)
+	assert.Equal(t, "empty", *<-receipts.deletes)
+
+	// The first message spans two batches
+	spanning := receipts.add(aws.String("spanning"))
+	for _, v := range []string{"one", "two", "three"} {
+		assert.Nil(t, spanning.write(batcher, []byte(v)))
+	}
+	spanning.finish(true)
+
+	single := receipts.add(aws.String("single"))
+	assert.Nil(t, single.write(batcher, []byte("four")))
+	single.finish(true)
+
+	failed := receipts.add(aws.String("failed"))
+	assert.Nil(t, failed.write(batcher, []byte("five")))
+	failed.finish(true)
+	assert.Nil(t, batcher.Flush())
+
+	// Messages are only deleted once every batch holding their events is delivered
+	res := <-processPipe
+	_ = os.Remove(res.FilePath)
+	res.Acknowledge(true)
+	assert.Equal(t, 0, len(receipts.deletes))
+
+	res = <-processPipe
+	_ = os.Remove(res.FilePath)
+	res.Acknowledge(true)
+	assert.Equal(t, "spanning", *<-receipts.deletes)
+	assert.Equal(t, "single", *<-receipts.deletes)
+
+	// Messages that fail to be delivered are left on the queue
+	res = <-processPipe
+	_ = os.Remove(res.FilePath)
+	res.Acknowledge(false)
+	assert.Equal(t, 0, receipts.len())
+	assert.Equal(t, 0, len(receipts.deletes))
+}
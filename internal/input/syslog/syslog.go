@@ -7,26 +7,38 @@ import (
 	"github.com/ThoronicLLC/collector/pkg/core"
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/mcuadros/go-syslog.v2"
+	"gopkg.in/mcuadros/go-syslog.v2/format"
 	"sync"
-	"time"
 )
 
 var InputName = "syslog"
 
 type Config struct {
-	Address        string `json:"address" validate:"required|ip"`
-	Port           int    `json:"port" validate:"required|int|min:0|max:65535"`
-	Protocol       string `json:"protocol" validate:"required|in:tcp,udp,both"`
-	Format         string `json:"format" validate:"required|in:automatic,RFC3164,RFC5424,RFC6587,raw"`
-	FlushFrequency int    `json:"flush_frequency" validate:"required|min:0"`
+	Address        string    `json:"address" validate:"required|ip"`
+	Port           int       `json:"port" validate:"required|int|min:0|max:65535"`
+	Protocol       string    `json:"protocol" validate:"required|in:tcp,udp,both,tls,unix,unixgram"`
+	Format         string    `json:"format" validate:"required|in:automatic,RFC3164,RFC5424,RFC5425,RFC6587,raw"`
+	FlushFrequency int       `json:"flush_frequency" validate:"required|min:0"`
+	MaxBatchBytes  int64     `json:"max_batch_bytes" validate:"min:0"`
+	MaxBatchEvents int       `json:"max_batch_events" validate:"min:0"`
+	OutputMode     string    `json:"output_mode" validate:"in:message,json"`
+	SocketPath     string    `json:"socket_path"`
+	TLS            TLSConfig `json:"tls"`
+
+	// Backpressure limits; once reached the drop policy either blocks reading (applying TCP backpressure) or
+	// drops the oldest or newest logs
+	MaxInFlightBatches int    `json:"max_in_flight_batches" validate:"min:0"`
+	MaxDiskBytes       int64  `json:"max_disk_bytes" validate:"min:0"`
+	DropPolicy         string `json:"drop_policy" validate:"in:block,oldest,newest"`
 }
 
 type syslogInput struct {
-	config     Config
-	ctx        context.Context
-	cancelFunc context.CancelFunc
-	server     *syslog.Server
-	logChannel syslog.LogPartsChannel
+	config       Config
+	ctx          context.Context
+	cancelFunc   context.CancelFunc
+	server       *syslog.Server
+	logChannel   syslog.LogPartsChannel
+	unixListener *unixStreamListener
 }
 
 func Handler() core.InputHandler {
@@ -38,6 +50,8 @@ func Handler() core.InputHandler {
 			Protocol:       "udp",
 			Format:         "raw",
 			FlushFrequency: 300,
+			OutputMode:     "message",
+			DropPolicy:     core.DropPolicyBlock,
 		}
 
 		// Unmarshal config
@@ -52,6 +66,18 @@ func Handler() core.InputHandler {
 			return nil, err
 		}
 
+		// Validate TLS settings
+		err = validateTLSConfig(conf.Protocol, conf.TLS)
+		if err != nil {
+			return nil, err
+		}
+
+		// Validate unix socket settings
+		err = validateSocketPath(conf.Protocol, conf.SocketPath)
+		if err != nil {
+			return nil, err
+		}
+
 		// Setup context
 		ctx, cancelFn := context.WithCancel(context.Background())
 
@@ -67,7 +93,14 @@ func Handler() core.InputHandler {
 
 func (s *syslogInput) Run(errorHandler core.ErrorHandler, state core.State, processPipe chan<- core.PipelineResults) {
 	// Setup local variables
-	tmpWriter, err := core.NewTmpWriter()
+	batcher, err := core.NewBatcher(s.ctx, core.BatchConfig{
+		FlushFrequency:     s.config.FlushFrequency,
+		MaxBatchBytes:      s.config.MaxBatchBytes,
+		MaxBatchEvents:     s.config.MaxBatchEvents,
+		MaxInFlightBatches: s.config.MaxInFlightBatches,
+		MaxDiskBytes:       s.config.MaxDiskBytes,
+		DropPolicy:         s.config.DropPolicy,
+	}, processPipe)
 	if err != nil {
 		errorHandler(true, err)
 		return
@@ -77,20 +110,7 @@ func (s *syslogInput) Run(errorHandler core.ErrorHandler, state core.State, proc
 	handler := syslog.NewChannelHandler(s.logChannel)
 
 	// Set syslog format and handler
-	switch s.config.Format {
-	case "automatic":
-		s.server.SetFormat(syslog.Automatic)
-	case "RFC3164":
-		s.server.SetFormat(syslog.RFC3164)
-	case "RFC5424":
-		s.server.SetFormat(syslog.RFC5424)
-	case "RFC6587":
-		s.server.SetFormat(syslog.RFC6587)
-	case "raw":
-		s.server.SetFormat(noFormat)
-	default:
-		s.server.SetFormat(noFormat)
-	}
+	s.server.SetFormat(s.syslogFormat())
 	s.server.SetHandler(handler)
 
 	addressAndPort := fmt.Sprintf("%s:%d", s.config.Address, s.config.Port)
@@ -113,41 +133,74 @@ func (s *syslogInput) Run(errorHandler core.ErrorHandler, state core.State, proc
 		}
 	}
 
+	// Setup TLS listener
+	if s.config.Protocol == "tls" {
+		tlsConfig, err := newTLSConfig(s.config.TLS)
+		if err != nil {
+			errorHandler(true, err)
+			return
+		}
+		s.server.SetTlsPeerNameFunc(tlsPeerName(s.config.TLS.AllowedSubjects))
+
+		log.Debugf("syslog server listening on %s/%s", addressAndPort, "TLS")
+		if err := s.server.ListenTCPTLS(addressAndPort, tlsConfig); err != nil {
+			errorHandler(true, fmt.Errorf("unable to start TLS listener on %s", addressAndPort))
+			return
+		}
+	}
+
+	// Setup unix datagram listener
+	if s.config.Protocol == "unixgram" {
+		if err := removeStaleSocket(s.config.SocketPath); err != nil {
+			errorHandler(true, fmt.Errorf("unable to remove existing socket %s: %s", s.config.SocketPath, err))
+			return
+		}
+
+		log.Debugf("syslog server listening on %s/%s", s.config.SocketPath, "UNIXGRAM")
+		if err := s.server.ListenUnixgram(s.config.SocketPath); err != nil {
+			errorHandler(true, fmt.Errorf("unable to start unixgram listener on %s", s.config.SocketPath))
+			return
+		}
+	}
+
 	// Boot up server
 	if err = s.server.Boot(); err != nil {
 		errorHandler(true, fmt.Errorf("unable to boot syslog service: %v", err))
 		return
 	}
 
-	// Setup wait group
+	// Setup unix stream listener
+	if s.config.Protocol == "unix" {
+		if err := removeStaleSocket(s.config.SocketPath); err != nil {
+			errorHandler(true, fmt.Errorf("unable to remove existing socket %s: %s", s.config.SocketPath, err))
+			return
+		}
+
+		log.Debugf("syslog server listening on %s/%s", s.config.SocketPath, "UNIX")
+		s.unixListener, err = newUnixStreamListener(s.ctx, s.config.SocketPath, s.syslogFormat(), s.logChannel)
+		if err != nil {
+			errorHandler(true, fmt.Errorf("unable to start unix listener on %s", s.config.SocketPath))
+			return
+		}
+		s.unixListener.Start()
+	}
+
+	// Setup wait group. The flush context is only cancelled once the log handler has stopped writing, so the
+	// final flush includes every log received.
 	var wg sync.WaitGroup
+	flushCtx, flushCancelFn := context.WithCancel(context.Background())
 
 	// Start timed process sync go routine
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		for {
-			select {
-			case <-s.ctx.Done():
-				err := s.flush(tmpWriter, processPipe)
-				if err != nil {
-					errorHandler(false, err)
-				}
-				return
-			case <-time.After(time.Duration(s.config.FlushFrequency) * time.Second):
-				err := s.flush(tmpWriter, processPipe)
-				if err != nil {
-					errorHandler(true, err)
-					s.Stop()
-					continue
-				}
-			}
-		}
+		batcher.Run(flushCtx, errorHandler)
 	}()
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
+		defer flushCancelFn()
 		for {
 			select {
 			case <-s.ctx.Done():
@@ -157,21 +210,19 @@ func (s *syslogInput) Run(errorHandler core.ErrorHandler, state core.State, proc
 					return
 				}
 
-				// Get data from content of message
-				if contentVal, contentExists := logParts["content"]; contentExists {
-					if stringContentVal, ok := contentVal.(string); ok {
-						_, err := tmpWriter.Write([]byte(stringContentVal))
-						if err != nil {
-							errorHandler(false, fmt.Errorf("issue writing log: %s", err))
-						}
-					}
-				} else if messageVal, messageExists := logParts["message"]; messageExists {
-					if stringMessageVal, ok := messageVal.(string); ok {
-						_, err := tmpWriter.Write([]byte(stringMessageVal))
-						if err != nil {
-							errorHandler(false, fmt.Errorf("issue writing log: %s", err))
-						}
-					}
+				// Format the log parts based on the configured output mode
+				event, ok, err := s.formatLogParts(logParts)
+				if err != nil {
+					errorHandler(false, fmt.Errorf("issue formatting log: %s", err))
+					continue
+				}
+				if !ok {
+					continue
+				}
+
+				_, err = batcher.Write(event)
+				if err != nil {
+					errorHandler(false, fmt.Errorf("issue writing log: %s", err))
 				}
 			}
 		}
@@ -183,25 +234,52 @@ func (s *syslogInput) Run(errorHandler core.ErrorHandler, state core.State, proc
 func (s *syslogInput) Stop() {
 	s.cancelFunc()
 	_ = s.server.Kill()
+	if s.unixListener != nil {
+		_ = s.unixListener.Close()
+	}
 	close(s.logChannel)
 }
 
-func (s *syslogInput) flush(writer *core.TmpWriter, processPipe chan<- core.PipelineResults) error {
-	// Rotate the temp writer
-	count, fileName, rErr := writer.Rotate()
-	if rErr != nil {
-		return fmt.Errorf("issue rotating temp file: %s", rErr)
+// syslogFormat returns the syslog format for the configured format name
+func (s *syslogInput) syslogFormat() format.Format {
+	switch s.config.Format {
+	case "automatic":
+		return syslog.Automatic
+	case "RFC3164":
+		return syslog.RFC3164
+	case "RFC5424":
+		return syslog.RFC5424
+	case "RFC5425", "RFC6587":
+		// RFC 5425 uses the RFC 6587 octet counting framing over TLS
+		return syslog.RFC6587
+	case "raw":
+		return noFormat
+	default:
+		return noFormat
+	}
+}
+
+// formatLogParts converts the parsed log parts into the event written to the pipeline. In message mode only the
+// message body is returned, in json mode all parsed fields (including the client and TLS peer) are returned.
+func (s *syslogInput) formatLogParts(logParts format.LogParts) ([]byte, bool, error) {
+	if s.config.OutputMode == "json" {
+		event, err := json.Marshal(logParts)
+		if err != nil {
+			return nil, false, err
+		}
+		return event, true, nil
 	}
 
-	// Only send on if there are results
-	if count > 0 {
-		processPipe <- core.PipelineResults{
-			FilePath:    fileName,
-			ResultCount: count,
-			State:       nil,
-			RetryCount:  0,
+	// Get data from content of message
+	if contentVal, contentExists := logParts["content"]; contentExists {
+		if stringContentVal, ok := contentVal.(string); ok {
+			return []byte(stringContentVal), true, nil
+		}
+	} else if messageVal, messageExists := logParts["message"]; messageExists {
+		if stringMessageVal, ok := messageVal.(string); ok {
+			return []byte(stringMessageVal), true, nil
 		}
 	}
 
-	return nil
+	return nil, false, nil
 }
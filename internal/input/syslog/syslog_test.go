@@ -1,10 +1,16 @@
 package syslog
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/ThoronicLLC/collector/pkg/core"
 	"github.com/stretchr/testify/assert"
+	"gopkg.in/mcuadros/go-syslog.v2"
+	"gopkg.in/mcuadros/go-syslog.v2/format"
+	"net"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 var config1 = `{"address": "0.0.0.0", "port": 8433, "protocol": "tcp", "format": "raw", "flush_frequency": 10}`
@@ -12,14 +18,25 @@ var config2 = `{"address": "127.0.0.1", "port": 1514, "protocol": "udp", "format
 var config3 = `{"address": "172.55.0.1", "port": 1514, "protocol": "both", "format": "RFC3164", "flush_frequency": 1000}`
 var config4 = `{"address": "192.168.1.1", "port": 514, "protocol": "tcp", "format": "RFC5424", "flush_frequency": 10000}`
 var config5 = `{"address": "10.120.0.1", "port": 1514, "protocol": "both", "format": "RFC6587", "flush_frequency": 100000}`
+var config6 = `{"address": "0.0.0.0", "port": 6514, "protocol": "tls", "format": "RFC5425", "flush_frequency": 10, "tls": {"cert_file": "/etc/collector/server.crt", "key_file": "/etc/collector/server.key"}}`
+var config7 = `{"address": "0.0.0.0", "port": 6514, "protocol": "tls", "format": "RFC5425", "flush_frequency": 10, "tls": {"cert_file": "/etc/collector/server.crt", "key_file": "/etc/collector/server.key", "client_ca_file": "/etc/collector/ca.crt", "allowed_subjects": ["firewall-1"]}}`
+var config8 = `{"address": "0.0.0.0", "port": 1514, "protocol": "udp", "format": "RFC5424", "flush_frequency": 10, "output_mode": "json"}`
+var config9 = `{"protocol": "unixgram", "format": "RFC3164", "flush_frequency": 10, "socket_path": "/dev/log"}`
+var config10 = `{"protocol": "unix", "format": "automatic", "flush_frequency": 10, "socket_path": "/run/collector/syslog.sock"}`
+var config11 = `{"address": "0.0.0.0", "port": 1514, "protocol": "udp", "format": "raw", "flush_frequency": 10, "max_in_flight_batches": 5, "max_disk_bytes": 1073741824, "drop_policy": "oldest"}`
 var badConfig1 = `{"address": "0.0.0.0", "port": 8433, "protocol": "tcp", "format": "raw", "flush_frequency": -1}`
 var badConfig2 = `{"address": "0.0.0.0", "port": 8433, "protocol": "tcp", "format": "something", "flush_frequency": 10}`
 var badConfig3 = `{"address": "0.0.0.0", "port": 8433, "protocol": "icmp", "format": "raw", "flush_frequency": 10}`
 var badConfig4 = `{"address": "0.0.0.0", "port": 9999999, "protocol": "tcp", "format": "raw", "flush_frequency": 10}`
 var badConfig5 = `{"address": "localhost", "port": 8443, "protocol": "tcp", "format": "raw", "flush_frequency": 10}`
+var badConfig6 = `{"address": "0.0.0.0", "port": 6514, "protocol": "tls", "format": "RFC5425", "flush_frequency": 10}`
+var badConfig7 = `{"address": "0.0.0.0", "port": 6514, "protocol": "tls", "format": "RFC5425", "flush_frequency": 10, "tls": {"cert_file": "/etc/collector/server.crt", "key_file": "/etc/collector/server.key", "allowed_subjects": ["firewall-1"]}}`
+var badConfig8 = `{"address": "0.0.0.0", "port": 1514, "protocol": "udp", "format": "RFC5424", "flush_frequency": 10, "output_mode": "xml"}`
+var badConfig9 = `{"protocol": "unixgram", "format": "RFC3164", "flush_frequency": 10}`
+var badConfig10 = `{"address": "0.0.0.0", "port": 1514, "protocol": "udp", "format": "raw", "flush_frequency": 10, "drop_policy": "random"}`
 
 func TestValidate(t *testing.T) {
-	arr := []string{config1, config2, config3, config4, config5}
+	arr := []string{config1, config2, config3, config4, config5, config6, config7, config8}
 	for i, v := range arr {
 		var testConfig Config
 		err := json.Unmarshal([]byte(v), &testConfig)
@@ -30,7 +47,7 @@ func TestValidate(t *testing.T) {
 }
 
 func TestValidateFailed(t *testing.T) {
-	arr := []string{badConfig1, badConfig2, badConfig3, badConfig4, badConfig5}
+	arr := []string{badConfig1, badConfig2, badConfig3, badConfig4, badConfig5, badConfig8, badConfig10}
 	for i, v := range arr {
 		var testConfig Config
 		err := json.Unmarshal([]byte(v), &testConfig)
@@ -41,7 +58,7 @@ func TestValidateFailed(t *testing.T) {
 }
 
 func TestHandler(t *testing.T) {
-	arr := []string{config1, config2, config3, config4, config5}
+	arr := []string{config1, config2, config3, config4, config5, config6, config7, config8, config9, config10, config11}
 	for i, v := range arr {
 		var testConfig Config
 		err := json.Unmarshal([]byte(v), &testConfig)
@@ -53,7 +70,7 @@ func TestHandler(t *testing.T) {
 }
 
 func TestHandlerFailed(t *testing.T) {
-	arr := []string{badConfig1, badConfig2, badConfig3, badConfig4, badConfig5}
+	arr := []string{badConfig1, badConfig2, badConfig3, badConfig4, badConfig5, badConfig6, badConfig7, badConfig8, badConfig9, badConfig10}
 	for i, v := range arr {
 		var testConfig Config
 		err := json.Unmarshal([]byte(v), &testConfig)
@@ -63,3 +80,53 @@ func TestHandlerFailed(t *testing.T) {
 		assert.NotNilf(t, err, "test #%d - validation should have returned an error: %s", i, err)
 	}
 }
+
+func TestFormatLogParts(t *testing.T) {
+	logParts := format.LogParts{
+		"hostname": "firewall-1",
+		"app_name": "sshd",
+		"message":  "accepted publickey",
+		"client":   "10.0.0.1:51234",
+		"tls_peer": "",
+	}
+
+	input := &syslogInput{config: Config{OutputMode: "message"}}
+	event, ok, err := input.formatLogParts(logParts)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "accepted publickey", string(event))
+
+	input = &syslogInput{config: Config{OutputMode: "json"}}
+	event, ok, err = input.formatLogParts(logParts)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.JSONEq(t, `{"hostname": "firewall-1", "app_name": "sshd", "message": "accepted publickey", "client": "10.0.0.1:51234", "tls_peer": ""}`, string(event))
+}
+
+func TestUnixStreamListener(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "syslog.sock")
+	logChannel := make(syslog.LogPartsChannel)
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+
+	listener, err := newUnixStreamListener(ctx, socketPath, noFormat, logChannel)
+	assert.Nil(t, err)
+	listener.Start()
+
+	conn, err := net.Dial("unix", socketPath)
+	assert.Nil(t, err)
+	_, err = conn.Write([]byte("hello from a local socket\n"))
+	assert.Nil(t, err)
+
+	select {
+	case logParts := <-logChannel:
+		assert.Equal(t, "hello from a local socket", logParts["content"])
+		assert.Equal(t, socketPath, logParts["client"])
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for log")
+	}
+
+	_ = conn.Close()
+	cancelFn()
+	assert.Nil(t, listener.Close())
+}
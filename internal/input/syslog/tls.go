@@ -0,0 +1,65 @@
+package syslog
+
+import (
+	"crypto/tls"
+	"fmt"
+	"github.com/ThoronicLLC/collector/internal/integrations/tlsconfig"
+)
+
+// TLSConfig is the configuration for the TLS (RFC 5425) syslog listener
+type TLSConfig struct {
+	CertFile        string   `json:"cert_file"`
+	KeyFile         string   `json:"key_file"`
+	ClientCAFile    string   `json:"client_ca_file"`
+	AllowedSubjects []string `json:"allowed_subjects"`
+}
+
+// validateTLSConfig makes sure the required certificate files are supplied when TLS is enabled
+func validateTLSConfig(protocol string, conf TLSConfig) error {
+	if protocol != "tls" {
+		return nil
+	}
+
+	if conf.CertFile == "" || conf.KeyFile == "" {
+		return fmt.Errorf("tls protocol requires a cert_file and key_file")
+	}
+
+	if len(conf.AllowedSubjects) > 0 && conf.ClientCAFile == "" {
+		return fmt.Errorf("allowed_subjects requires a client_ca_file")
+	}
+
+	return nil
+}
+
+// newTLSConfig builds the server TLS config from the supplied certificate files
+func newTLSConfig(conf TLSConfig) (*tls.Config, error) {
+	return tlsconfig.NewServerConfig(conf.CertFile, conf.KeyFile, conf.ClientCAFile)
+}
+
+// tlsPeerName returns the peer's certificate subject name and rejects connections whose subject is not in the
+// allowed list. Connections without a client certificate are accepted when mutual TLS is disabled.
+func tlsPeerName(allowedSubjects []string) func(tlsConn *tls.Conn) (string, bool) {
+	return func(tlsConn *tls.Conn) (string, bool) {
+		state := tlsConn.ConnectionState()
+		if len(state.PeerCertificates) == 0 {
+			return "", len(allowedSubjects) == 0
+		}
+
+		peer := state.PeerCertificates[0]
+		if len(allowedSubjects) == 0 {
+			return peer.Subject.CommonName, true
+		}
+
+		// Match against the common name and any DNS names on the certificate
+		names := append([]string{peer.Subject.CommonName}, peer.DNSNames...)
+		for _, allowed := range allowedSubjects {
+			for _, name := range names {
+				if name != "" && name == allowed {
+					return name, true
+				}
+			}
+		}
+
+		return peer.Subject.CommonName, false
+	}
+}
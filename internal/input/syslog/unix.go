@@ -0,0 +1,134 @@
+package syslog
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"gopkg.in/mcuadros/go-syslog.v2"
+	"gopkg.in/mcuadros/go-syslog.v2/format"
+	"net"
+	"os"
+	"sync"
+)
+
+// unixStreamListener accepts syslog messages on a unix stream socket. The syslog server library only supports
+// unix datagram sockets, so stream sockets are handled here and fed into the same log channel.
+type unixStreamListener struct {
+	ctx        context.Context
+	listener   net.Listener
+	format     format.Format
+	logChannel syslog.LogPartsChannel
+	wg         sync.WaitGroup
+	mu         sync.Mutex
+	conns      map[net.Conn]struct{}
+}
+
+// validateSocketPath makes sure a socket path is supplied when a unix socket protocol is used
+func validateSocketPath(protocol, socketPath string) error {
+	if protocol != "unix" && protocol != "unixgram" {
+		return nil
+	}
+
+	if socketPath == "" {
+		return fmt.Errorf("%s protocol requires a socket_path", protocol)
+	}
+
+	return nil
+}
+
+// removeStaleSocket removes a socket file left behind by a previous run so the path can be bound again
+func removeStaleSocket(socketPath string) error {
+	info, err := os.Stat(socketPath)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", socketPath)
+	}
+
+	return os.Remove(socketPath)
+}
+
+func newUnixStreamListener(ctx context.Context, socketPath string, f format.Format, logChannel syslog.LogPartsChannel) (*unixStreamListener, error) {
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &unixStreamListener{
+		ctx:        ctx,
+		listener:   listener,
+		format:     f,
+		logChannel: logChannel,
+		conns:      make(map[net.Conn]struct{}),
+	}, nil
+}
+
+// Start accepts new connections until the listener is closed
+func (u *unixStreamListener) Start() {
+	u.wg.Add(1)
+	go func() {
+		defer u.wg.Done()
+		for {
+			conn, err := u.listener.Accept()
+			if err != nil {
+				return
+			}
+
+			u.mu.Lock()
+			u.conns[conn] = struct{}{}
+			u.mu.Unlock()
+
+			u.wg.Add(1)
+			go u.scan(conn)
+		}
+	}()
+}
+
+func (u *unixStreamListener) scan(conn net.Conn) {
+	defer u.wg.Done()
+	defer func() {
+		u.mu.Lock()
+		delete(u.conns, conn)
+		u.mu.Unlock()
+		_ = conn.Close()
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	if sf := u.format.GetSplitFunc(); sf != nil {
+		scanner.Split(sf)
+	}
+
+	client := u.listener.Addr().String()
+	for scanner.Scan() {
+		parser := u.format.GetParser(scanner.Bytes())
+		_ = parser.Parse()
+
+		logParts := parser.Dump()
+		logParts["client"] = client
+		logParts["tls_peer"] = ""
+
+		select {
+		case u.logChannel <- logParts:
+		case <-u.ctx.Done():
+			return
+		}
+	}
+}
+
+// Close stops accepting connections, closes open connections and waits for them to finish
+func (u *unixStreamListener) Close() error {
+	err := u.listener.Close()
+
+	u.mu.Lock()
+	for conn := range u.conns {
+		_ = conn.Close()
+	}
+	u.mu.Unlock()
+
+	u.wg.Wait()
+	return err
+}
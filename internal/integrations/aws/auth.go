@@ -0,0 +1,191 @@
+package aws
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"time"
+)
+
+// AuthConfig selects how AWS credentials are loaded. When no method is enabled the default AWS credential chain
+// is used (environment, shared config, web identity, ECS task role and EC2 instance profile). AssumeRole can be
+// combined with any of the other methods, which then supply the credentials used to assume the role.
+type AuthConfig struct {
+	Static      AuthStaticConfig      `json:"static"`
+	Environment AuthEnvironmentConfig `json:"environment"`
+	Profile     AuthProfileConfig     `json:"profile"`
+	WebIdentity AuthWebIdentityConfig `json:"web_identity"`
+	AssumeRole  AuthAssumeRoleConfig  `json:"assume_role"`
+}
+
+// AuthStaticConfig is the configuration for static access keys
+type AuthStaticConfig struct {
+	Enabled         bool   `json:"enabled"`
+	AccessKeyID     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key"`
+	SessionToken    string `json:"session_token"`
+}
+
+// AuthEnvironmentConfig is the configuration for reading keys from the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY
+// environment variables
+type AuthEnvironmentConfig struct {
+	Enabled bool `json:"enabled"`
+}
+
+// AuthProfileConfig is the configuration for a profile in the shared config and credentials files
+type AuthProfileConfig struct {
+	Enabled bool     `json:"enabled"`
+	Name    string   `json:"name"`
+	Files   []string `json:"files"`
+}
+
+// AuthWebIdentityConfig is the configuration for assuming a role with a web identity token file, such as the
+// tokens mounted by EKS
+type AuthWebIdentityConfig struct {
+	Enabled     bool   `json:"enabled"`
+	RoleARN     string `json:"role_arn"`
+	TokenFile   string `json:"token_file"`
+	SessionName string `json:"session_name"`
+}
+
+// AuthAssumeRoleConfig is the configuration for assuming a role with STS
+type AuthAssumeRoleConfig struct {
+	Enabled     bool   `json:"enabled"`
+	RoleARN     string `json:"role_arn"`
+	ExternalID  string `json:"external_id"`
+	SessionName string `json:"session_name"`
+	Duration    int    `json:"duration"` // Duration in seconds
+}
+
+// SessionConfig is the configuration for a new AWS session
+type SessionConfig struct {
+	Region         string
+	Endpoint       string
+	ForcePathStyle bool
+	MaxRetries     int
+	AuthConfig     AuthConfig
+}
+
+// Validate checks that the required settings are supplied for the enabled auth methods
+func (a AuthConfig) Validate() error {
+	enabled := 0
+	for _, v := range []bool{a.Static.Enabled, a.Environment.Enabled, a.Profile.Enabled, a.WebIdentity.Enabled} {
+		if v {
+			enabled++
+		}
+	}
+	if enabled > 1 {
+		return fmt.Errorf("only one of static, environment, profile or web_identity auth may be enabled")
+	}
+
+	if a.Static.Enabled && (a.Static.AccessKeyID == "" || a.Static.SecretAccessKey == "") {
+		return fmt.Errorf("static auth requires an access_key_id and secret_access_key")
+	}
+
+	if a.WebIdentity.Enabled && (a.WebIdentity.RoleARN == "" || a.WebIdentity.TokenFile == "") {
+		return fmt.Errorf("web_identity auth requires a role_arn and token_file")
+	}
+
+	if a.AssumeRole.Enabled && a.AssumeRole.RoleARN == "" {
+		return fmt.Errorf("assume_role auth requires a role_arn")
+	}
+
+	if a.AssumeRole.Duration < 0 {
+		return fmt.Errorf("assume_role duration must be positive")
+	}
+
+	return nil
+}
+
+// WithLegacyKeys enables static auth with the access keys from the top level access_key_id and secret_access_key
+// settings, which predate the auth config
+func (a AuthConfig) WithLegacyKeys(accessKeyID, secretAccessKey string) (AuthConfig, error) {
+	if accessKeyID == "" && secretAccessKey == "" {
+		return a, nil
+	}
+
+	if accessKeyID == "" || secretAccessKey == "" {
+		return a, fmt.Errorf("access_key_id and secret_access_key must be supplied together")
+	}
+
+	if a.Static.Enabled {
+		return a, fmt.Errorf("access_key_id and secret_access_key cannot be combined with static auth")
+	}
+
+	a.Static = AuthStaticConfig{
+		Enabled:         true,
+		AccessKeyID:     accessKeyID,
+		SecretAccessKey: secretAccessKey,
+	}
+
+	return a, nil
+}
+
+// NewSession creates a new AWS session with the configured region, endpoint and credentials
+func NewSession(conf SessionConfig) (*session.Session, error) {
+	awsConf := aws.NewConfig().WithRegion(conf.Region)
+
+	// Custom endpoints are used for S3 compatible services such as MinIO or LocalStack
+	if conf.Endpoint != "" {
+		awsConf = awsConf.WithEndpoint(conf.Endpoint).WithS3ForcePathStyle(conf.ForcePathStyle)
+	}
+
+	if conf.MaxRetries > 0 {
+		awsConf = awsConf.WithMaxRetries(conf.MaxRetries)
+	}
+
+	auth := conf.AuthConfig
+	switch {
+	case auth.Static.Enabled:
+		awsConf = awsConf.WithCredentials(credentials.NewStaticCredentials(auth.Static.AccessKeyID, auth.Static.SecretAccessKey, auth.Static.SessionToken))
+	case auth.Environment.Enabled:
+		awsConf = awsConf.WithCredentials(credentials.NewEnvCredentials())
+	}
+
+	// Setup session options; profiles use the shared config so roles and SSO settings in the profile are honored
+	opts := session.Options{Config: *awsConf}
+	if auth.Profile.Enabled {
+		opts.Profile = auth.Profile.Name
+		opts.SharedConfigState = session.SharedConfigEnable
+		if len(auth.Profile.Files) > 0 {
+			opts.SharedConfigFiles = auth.Profile.Files
+		}
+	}
+
+	awsSession, err := session.NewSessionWithOptions(opts)
+	if err != nil {
+		return nil, fmt.Errorf("issue creating AWS session: %s", err)
+	}
+
+	if auth.WebIdentity.Enabled {
+		creds := stscreds.NewWebIdentityCredentials(awsSession, auth.WebIdentity.RoleARN, sessionName(auth.WebIdentity.SessionName), auth.WebIdentity.TokenFile)
+		awsSession = awsSession.Copy(aws.NewConfig().WithCredentials(creds))
+	}
+
+	// Assume a role using the credentials configured above
+	if auth.AssumeRole.Enabled {
+		creds := stscreds.NewCredentials(awsSession, auth.AssumeRole.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+			p.RoleSessionName = sessionName(auth.AssumeRole.SessionName)
+			if auth.AssumeRole.ExternalID != "" {
+				p.ExternalID = aws.String(auth.AssumeRole.ExternalID)
+			}
+			if auth.AssumeRole.Duration > 0 {
+				p.Duration = time.Duration(auth.AssumeRole.Duration) * time.Second
+			}
+		})
+		awsSession = awsSession.Copy(aws.NewConfig().WithCredentials(creds))
+	}
+
+	return awsSession, nil
+}
+
+// sessionName returns the supplied role session name or a default one
+func sessionName(name string) string {
+	if name != "" {
+		return name
+	}
+
+	return fmt.Sprintf("collector-%d", time.Now().Unix())
+}
@@ -0,0 +1,42 @@
+package aws
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestAuthConfigValidate(t *testing.T) {
+	valid := []AuthConfig{
+		{},
+		{Static: AuthStaticConfig{Enabled: true, AccessKeyID: "1234567890", SecretAccessKey: "1234567890"}},
+		{Environment: AuthEnvironmentConfig{Enabled: true}, AssumeRole: AuthAssumeRoleConfig{Enabled: true, RoleARN: "arn:aws:iam::123456789012:role/collector"}},
+		{WebIdentity: AuthWebIdentityConfig{Enabled: true, RoleARN: "arn:aws:iam::123456789012:role/collector", TokenFile: "/tmp/token"}},
+	}
+	for i, v := range valid {
+		assert.Nilf(t, v.Validate(), "test #%d - validation error", i)
+	}
+
+	invalid := []AuthConfig{
+		{Static: AuthStaticConfig{Enabled: true, AccessKeyID: "1234567890"}},
+		{Environment: AuthEnvironmentConfig{Enabled: true}, Profile: AuthProfileConfig{Enabled: true}},
+		{WebIdentity: AuthWebIdentityConfig{Enabled: true, TokenFile: "/tmp/token"}},
+		{AssumeRole: AuthAssumeRoleConfig{Enabled: true}},
+		{AssumeRole: AuthAssumeRoleConfig{Enabled: true, RoleARN: "arn:aws:iam::123456789012:role/collector", Duration: -1}},
+	}
+	for i, v := range invalid {
+		assert.NotNilf(t, v.Validate(), "test #%d - validation should have returned an error", i)
+	}
+}
+
+func TestWithLegacyKeys(t *testing.T) {
+	auth, err := AuthConfig{}.WithLegacyKeys("", "")
+	assert.Nil(t, err)
+	assert.False(t, auth.Static.Enabled)
+
+	auth, err = AuthConfig{}.WithLegacyKeys("id", "secret")
+	assert.Nil(t, err)
+	assert.Equal(t, AuthStaticConfig{Enabled: true, AccessKeyID: "id", SecretAccessKey: "secret"}, auth.Static)
+
+	_, err = AuthConfig{}.WithLegacyKeys("id", "")
+	assert.NotNil(t, err)
+}
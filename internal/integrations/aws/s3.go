@@ -0,0 +1,31 @@
+package aws
+
+import (
+	"context"
+	"fmt"
+	"github.com/ThoronicLLC/collector/pkg/core"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"io"
+)
+
+// GetObjectReader opens an S3 object for reading. Gzip compressed objects are detected and decompressed
+// automatically.
+func GetObjectReader(ctx context.Context, client s3iface.S3API, bucket, key string) (io.ReadCloser, error) {
+	output, err := client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("issue getting object s3://%s/%s: %s", bucket, key, err)
+	}
+
+	reader, err := core.NewDecompressingReader(output.Body)
+	if err != nil {
+		_ = output.Body.Close()
+		return nil, fmt.Errorf("issue reading object s3://%s/%s: %s", bucket, key, err)
+	}
+
+	return reader, nil
+}
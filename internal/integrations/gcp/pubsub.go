@@ -0,0 +1,30 @@
+package gcp
+
+import (
+	"encoding/json"
+	"fmt"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+)
+
+// PubSubClientOptions returns the credentials for a Pub/Sub client, or an insecure connection to the emulator when the
+// emulator host is set. The client does not close a connection it is given, so the emulator connection is returned
+// to be closed after the client.
+func PubSubClientOptions(credentials json.RawMessage, credentialsPath, emulatorHost string) ([]option.ClientOption, *grpc.ClientConn, error) {
+	opts := make([]option.ClientOption, 0)
+	if emulatorHost != "" {
+		conn, err := grpc.Dial(emulatorHost, grpc.WithInsecure())
+		if err != nil {
+			return nil, nil, fmt.Errorf("issue connecting to pub sub emulator: %s", err)
+		}
+		return append(opts, option.WithGRPCConn(conn), option.WithTelemetryDisabled()), conn, nil
+	}
+
+	if credentials != nil && len(credentials) > 0 && string(credentials) != "null" {
+		opts = append(opts, option.WithCredentialsJSON(credentials))
+	} else if credentialsPath != "" {
+		opts = append(opts, option.WithCredentialsFile(credentialsPath))
+	}
+
+	return opts, nil, nil
+}
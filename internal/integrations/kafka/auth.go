@@ -7,16 +7,52 @@ import (
   "github.com/jcmturner/gokrb5/v8/config"
   kt "github.com/jcmturner/gokrb5/v8/keytab"
   "github.com/segmentio/kafka-go/sasl"
+  "github.com/segmentio/kafka-go/sasl/plain"
   "github.com/segmentio/kafka-go/sasl/scram"
 )
 
 type AuthConfig struct {
+  TLS            AuthTLSConfig            `json:"tls"`
+  Plain          AuthPlainConfig          `json:"plain"`
+  OAuthBearer    AuthOAuthBearerConfig    `json:"oauthbearer"`
   ScramSha256    AuthScramSha256Config    `json:"scram_sha_256"`
   ScramSha512    AuthScramSha512Config    `json:"scram_sha_512"`
   GssApiKeytab   AuthGssApiKeytabConfig   `json:"gssapi_keytab"`
   GssApiPassword AuthGssApiPasswordConfig `json:"gssapi_password"`
 }
 
+// AuthTLSConfig is the configuration for connecting to the brokers over TLS. The system roots are used when no CA
+// file is supplied, and the client certificate is only presented when a certificate and key file are supplied.
+type AuthTLSConfig struct {
+  Enabled            bool   `json:"enabled"`
+  CAFile             string `json:"ca_file"`
+  CertFile           string `json:"cert_file"`
+  KeyFile            string `json:"key_file"`
+  ServerName         string `json:"server_name"`
+  InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+}
+
+// AuthPlainConfig is the configuration for the SASL/PLAIN authentication mechanism. Event Hubs expects the username
+// $ConnectionString with the connection string as the password.
+type AuthPlainConfig struct {
+  Enabled  bool   `json:"enabled"`
+  Username string `json:"username" validate:"required_if:Enabled,true"`
+  Password string `json:"password" validate:"required_if:Enabled,true"`
+}
+
+// AuthOAuthBearerConfig is the configuration for the SASL/OAUTHBEARER authentication mechanism. Tokens are either
+// static or fetched from the token URL with the client credentials grant. Extensions are sent with the token, such
+// as the logicalCluster and identityPoolId Confluent Cloud expects.
+type AuthOAuthBearerConfig struct {
+  Enabled      bool              `json:"enabled"`
+  Token        string            `json:"token"`
+  TokenURL     string            `json:"token_url"`
+  ClientID     string            `json:"client_id"`
+  ClientSecret string            `json:"client_secret"`
+  Scopes       []string          `json:"scopes"`
+  Extensions   map[string]string `json:"extensions"`
+}
+
 // AuthScramSha256Config is the configuration for the SCRAM-SHA-256 authentication mechanism
 type AuthScramSha256Config struct {
   Enabled  bool   `json:"enabled"`
@@ -51,9 +87,31 @@ type AuthGssApiPasswordConfig struct {
   ConfigFile  string `json:"config_file" validate:"required_if:Enabled,true|filePath"`
 }
 
+// Validate makes sure the TLS files can be loaded and the plain and oauthbearer mechanisms are complete. The GSSAPI
+// configuration files are only loaded when connecting.
+func (kConf AuthConfig) Validate() error {
+  _, err := newTLSConfig(kConf.TLS)
+  if err != nil {
+    return fmt.Errorf("issue setting up kafka tls: %s", err)
+  }
+
+  if kConf.Plain.Enabled || kConf.OAuthBearer.Enabled {
+    _, err = newMechanism(kConf)
+    if err != nil {
+      return fmt.Errorf("issue setting up kafka auth: %s", err)
+    }
+  }
+
+  return nil
+}
+
 // newMechanism creates a new SASL mechanism based on the configuration
 func newMechanism(kConf AuthConfig) (sasl.Mechanism, error) {
   switch {
+  case kConf.Plain.Enabled:
+    return newMechanismPlain(kConf.Plain)
+  case kConf.OAuthBearer.Enabled:
+    return newMechanismOAuthBearer(kConf.OAuthBearer)
   case kConf.ScramSha256.Enabled:
     return newMechanismScramSha256(kConf.ScramSha256)
   case kConf.ScramSha512.Enabled:
@@ -67,6 +125,15 @@ func newMechanism(kConf AuthConfig) (sasl.Mechanism, error) {
   return nil, nil
 }
 
+// newMechanismPlain creates a new plain mechanism
+func newMechanismPlain(conf AuthPlainConfig) (sasl.Mechanism, error) {
+  if conf.Username == "" || conf.Password == "" {
+    return nil, fmt.Errorf("plain auth requires a username and password")
+  }
+
+  return plain.Mechanism{Username: conf.Username, Password: conf.Password}, nil
+}
+
 // newMechanismGSSAPIWithPassword creates a new GSSAPI mechanism with a password
 func newMechanismGSSAPIWithPassword(conf AuthGssApiPasswordConfig) (sasl.Mechanism, error) {
   cfg, err := config.Load(conf.ConfigFile)
@@ -0,0 +1,153 @@
+package kafka
+
+import (
+  "context"
+  "fmt"
+  "github.com/segmentio/kafka-go"
+  "sort"
+  "time"
+)
+
+// Client looks up the topics, partitions and offsets of a cluster
+type Client struct {
+  client *kafka.Client
+}
+
+type ClientConfig struct {
+  AuthConfig AuthConfig
+  Brokers    []string
+}
+
+// NewClient creates a new kafka client
+func NewClient(kConf ClientConfig) (*Client, error) {
+  transport, err := newTransport(kConf.AuthConfig)
+  if err != nil {
+    return nil, err
+  }
+
+  return &Client{
+    client: &kafka.Client{
+      Addr:      kafka.TCP(kConf.Brokers...),
+      Timeout:   30 * time.Second,
+      Transport: transport,
+    },
+  }, nil
+}
+
+// Partitions returns the partitions of each topic. Every topic except the internal topics is returned when no
+// topics are supplied.
+func (c *Client) Partitions(ctx context.Context, topics []string) (map[string][]int, error) {
+  res, err := c.client.Metadata(ctx, &kafka.MetadataRequest{Topics: topics})
+  if err != nil {
+    return nil, fmt.Errorf("client.Metadata(): %w", err)
+  }
+
+  partitions := make(map[string][]int)
+  for _, topic := range res.Topics {
+    if topic.Error != nil {
+      return nil, fmt.Errorf("topic %s: %w", topic.Name, topic.Error)
+    }
+
+    if topic.Internal && len(topics) == 0 {
+      continue
+    }
+
+    ids := make([]int, 0, len(topic.Partitions))
+    for _, partition := range topic.Partitions {
+      ids = append(ids, partition.ID)
+    }
+    sort.Ints(ids)
+    partitions[topic.Name] = ids
+  }
+
+  return partitions, nil
+}
+
+// OffsetsAt returns the offset of the first message at or after the time for each partition. Partitions with no
+// messages after the time return the offset of the next message written.
+func (c *Client) OffsetsAt(ctx context.Context, topic string, partitions []int, at time.Time) (map[int]int64, error) {
+  requests := make([]kafka.OffsetRequest, 0, len(partitions)*2)
+  for _, partition := range partitions {
+    requests = append(requests, kafka.TimeOffsetOf(partition, at), kafka.LastOffsetOf(partition))
+  }
+
+  res, err := c.client.ListOffsets(ctx, &kafka.ListOffsetsRequest{
+    Topics: map[string][]kafka.OffsetRequest{topic: requests},
+  })
+  if err != nil {
+    return nil, fmt.Errorf("client.ListOffsets(): %w", err)
+  }
+
+  offsets := make(map[int]int64)
+  for _, partition := range res.Topics[topic] {
+    if partition.Error != nil {
+      return nil, fmt.Errorf("topic %s partition %d: %w", topic, partition.Partition, partition.Error)
+    }
+
+    offsets[partition.Partition] = partition.LastOffset
+    for offset := range partition.Offsets {
+      if offset >= 0 {
+        offsets[partition.Partition] = offset
+      }
+    }
+  }
+
+  return offsets, nil
+}
+
+// CommittedOffsets returns the offsets committed by the consumer group. Partitions without a committed offset
+// return -1.
+func (c *Client) CommittedOffsets(ctx context.Context, groupID string, partitions map[string][]int) (map[string]map[int]int64, error) {
+  res, err := c.client.OffsetFetch(ctx, &kafka.OffsetFetchRequest{
+    GroupID: groupID,
+    Topics:  partitions,
+  })
+  if err != nil {
+    return nil, fmt.Errorf("client.OffsetFetch(): %w", err)
+  }
+  if res.Error != nil {
+    return nil, fmt.Errorf("client.OffsetFetch(): %w", res.Error)
+  }
+
+  offsets := make(map[string]map[int]int64)
+  for topic, topicPartitions := range res.Topics {
+    offsets[topic] = make(map[int]int64)
+    for _, partition := range topicPartitions {
+      if partition.Error != nil {
+        return nil, fmt.Errorf("topic %s partition %d: %w", topic, partition.Partition, partition.Error)
+      }
+      offsets[topic][partition.Partition] = partition.CommittedOffset
+    }
+  }
+
+  return offsets, nil
+}
+
+// CommitOffsets commits offsets for a consumer group that has no active members
+func (c *Client) CommitOffsets(ctx context.Context, groupID string, offsets map[string]map[int]int64) error {
+  topics := make(map[string][]kafka.OffsetCommit)
+  for topic, partitions := range offsets {
+    for partition, offset := range partitions {
+      topics[topic] = append(topics[topic], kafka.OffsetCommit{Partition: partition, Offset: offset})
+    }
+  }
+
+  res, err := c.client.OffsetCommit(ctx, &kafka.OffsetCommitRequest{
+    GroupID:      groupID,
+    GenerationID: -1,
+    Topics:       topics,
+  })
+  if err != nil {
+    return fmt.Errorf("client.OffsetCommit(): %w", err)
+  }
+
+  for topic, partitions := range res.Topics {
+    for _, partition := range partitions {
+      if partition.Error != nil {
+        return fmt.Errorf("topic %s partition %d: %w", topic, partition.Partition, partition.Error)
+      }
+    }
+  }
+
+  return nil
+}
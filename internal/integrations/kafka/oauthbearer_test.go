@@ -0,0 +1,34 @@
+package kafka
+
+import (
+  "context"
+  "github.com/stretchr/testify/assert"
+  "testing"
+)
+
+func TestOAuthBearerMechanism(t *testing.T) {
+  mechanism, err := newMechanism(AuthConfig{OAuthBearer: AuthOAuthBearerConfig{
+    Enabled:    true,
+    Token:      "token-1",
+    Extensions: map[string]string{"logicalCluster": "lkc-1", "identityPoolId": "pool-1"},
+  }})
+  assert.Nil(t, err)
+  assert.Equal(t, "OAUTHBEARER", mechanism.Name())
+
+  session, response, err := mechanism.Start(context.Background())
+  assert.Nil(t, err)
+  assert.Equal(t, "n,,\x01auth=Bearer token-1\x01identityPoolId=pool-1\x01logicalCluster=lkc-1\x01\x01", string(response))
+
+  // The server only sends a challenge when the token is rejected
+  done, _, err := session.Next(context.Background(), []byte(`{"status":"invalid_token"}`))
+  assert.False(t, done)
+  assert.NotNil(t, err)
+
+  done, _, err = session.Next(context.Background(), nil)
+  assert.True(t, done)
+  assert.Nil(t, err)
+
+  // Tokens must come from somewhere
+  _, err = newMechanism(AuthConfig{OAuthBearer: AuthOAuthBearerConfig{Enabled: true}})
+  assert.NotNil(t, err)
+}
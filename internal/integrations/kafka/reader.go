@@ -12,40 +12,52 @@ type Reader struct {
   ctx    context.Context
 }
 
+// ReaderConfig is the configuration for a reader. Readers in a consumer group may read multiple topics and start
+// from StartOffset when the group has no committed offset. Readers without a group read a single partition of
+// Topic from the offset set with SetOffset or SetOffsetAt.
 type ReaderConfig struct {
-  Ctx        context.Context
-  AuthConfig AuthConfig
-  Brokers    []string
-  Topic      string
-  GroupID    string
-  MinBytes   int
-  MaxBytes   int
+  Ctx         context.Context
+  AuthConfig  AuthConfig
+  Brokers     []string
+  Topic       string
+  Topics      []string
+  GroupID     string
+  Partition   int
+  StartOffset int64
+  MinBytes    int
+  MaxBytes    int
 }
 
 // NewReader creates a new kafka reader client
 func NewReader(kConf ReaderConfig) (*Reader, error) {
-  // Configure the auth mechanism based on the supplied config
-  mechanism, err := newMechanism(kConf.AuthConfig)
+  // Create a read dialer with the configured mechanism
+  readDialer, err := newDialer(kConf.AuthConfig)
   if err != nil {
-    return nil, fmt.Errorf("newMechanism(): %w", err)
+    return nil, err
   }
 
-  // Create a read dialer with the configured mechanism
-  readDialer := &kafka.Dialer{
-    Timeout:       10 * time.Second,
-    DualStack:     true,
-    SASLMechanism: mechanism,
+  readerConfig := kafka.ReaderConfig{
+    Brokers:     kConf.Brokers,
+    GroupID:     kConf.GroupID,
+    StartOffset: kConf.StartOffset,
+    MinBytes:    kConf.MinBytes, // 10e3 10KB
+    MaxBytes:    kConf.MaxBytes, // 10e6 = 10MB
+    Dialer:      readDialer,
+  }
+
+  // Consumer groups may read multiple topics, otherwise a single partition is read
+  switch {
+  case kConf.GroupID != "" && len(kConf.Topics) > 0:
+    readerConfig.GroupTopics = kConf.Topics
+  case kConf.GroupID != "":
+    readerConfig.Topic = kConf.Topic
+  default:
+    readerConfig.Topic = kConf.Topic
+    readerConfig.Partition = kConf.Partition
   }
 
-  // Initialize the reader with the broker addresses, topic, groupID, and dialer
-  r := kafka.NewReader(kafka.ReaderConfig{
-    Brokers:  kConf.Brokers,
-    Topic:    kConf.Topic,
-    GroupID:  kConf.GroupID,
-    MinBytes: kConf.MinBytes, // 10e3 10KB
-    MaxBytes: kConf.MaxBytes, // 10e6 = 10MB
-    Dialer:   readDialer,
-  })
+  // Initialize the reader with the broker addresses, topics, groupID, and dialer
+  r := kafka.NewReader(readerConfig)
 
   return &Reader{
     reader: r,
@@ -58,6 +70,27 @@ func (k *Reader) ReadMessage() (kafka.Message, error) {
   return k.reader.ReadMessage(k.ctx)
 }
 
+// SetOffset sets the offset the next message is read from. It is only supported by readers without a group.
+func (k *Reader) SetOffset(offset int64) error {
+  err := k.reader.SetOffset(offset)
+  if err != nil {
+    return fmt.Errorf("reader.SetOffset(): %w", err)
+  }
+
+  return nil
+}
+
+// SetOffsetAt sets the offset to the first message at or after the time. It is only supported by readers without
+// a group.
+func (k *Reader) SetOffsetAt(t time.Time) error {
+  err := k.reader.SetOffsetAt(k.ctx, t)
+  if err != nil {
+    return fmt.Errorf("reader.SetOffsetAt(): %w", err)
+  }
+
+  return nil
+}
+
 // Close closes the reader
 func (k *Reader) Close() error {
   err := k.reader.Close()
@@ -0,0 +1,56 @@
+package kafka
+
+import (
+  "crypto/tls"
+  "fmt"
+  "github.com/ThoronicLLC/collector/internal/integrations/tlsconfig"
+  "github.com/segmentio/kafka-go"
+  "time"
+)
+
+// newDialer creates a dialer for readers with the configured TLS and auth mechanism
+func newDialer(conf AuthConfig) (*kafka.Dialer, error) {
+  mechanism, err := newMechanism(conf)
+  if err != nil {
+    return nil, fmt.Errorf("newMechanism(): %w", err)
+  }
+
+  tlsConfig, err := newTLSConfig(conf.TLS)
+  if err != nil {
+    return nil, fmt.Errorf("newTLSConfig(): %w", err)
+  }
+
+  return &kafka.Dialer{
+    Timeout:       10 * time.Second,
+    DualStack:     true,
+    TLS:           tlsConfig,
+    SASLMechanism: mechanism,
+  }, nil
+}
+
+// newTransport creates a transport for writers and clients with the configured TLS and auth mechanism
+func newTransport(conf AuthConfig) (*kafka.Transport, error) {
+  mechanism, err := newMechanism(conf)
+  if err != nil {
+    return nil, fmt.Errorf("newMechanism(): %w", err)
+  }
+
+  tlsConfig, err := newTLSConfig(conf.TLS)
+  if err != nil {
+    return nil, fmt.Errorf("newTLSConfig(): %w", err)
+  }
+
+  return &kafka.Transport{
+    TLS:  tlsConfig,
+    SASL: mechanism,
+  }, nil
+}
+
+// newTLSConfig creates the client TLS config, or nil when TLS is not enabled
+func newTLSConfig(conf AuthTLSConfig) (*tls.Config, error) {
+  if !conf.Enabled {
+    return nil, nil
+  }
+
+  return tlsconfig.NewClientConfig(conf.CAFile, conf.CertFile, conf.KeyFile, conf.ServerName, conf.InsecureSkipVerify)
+}
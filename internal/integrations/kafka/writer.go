@@ -2,13 +2,25 @@ package kafka
 
 import (
   "context"
+  "errors"
   "fmt"
   "github.com/segmentio/kafka-go"
+  "github.com/segmentio/kafka-go/compress"
+  "sync"
+  "time"
 )
 
 type Writer struct {
   writer *kafka.Writer
   ctx    context.Context
+
+  // Failed writes are tracked here since async writes only report errors through the completion callback. Queued
+  // async messages are found by their value, which the callback is given the original slice of.
+  mu        sync.Mutex
+  failed    int
+  failedIDs []int
+  firstErr  error
+  queued    map[*byte]int
 }
 
 type WriterConfig struct {
@@ -16,50 +28,266 @@ type WriterConfig struct {
   AuthConfig AuthConfig
   Brokers    []string
   Topic      string
+
+  // Balancer is one of hash, round_robin, least_bytes, crc32 or murmur2, defaulting to hash. The crc32 and murmur2
+  // balancers partition keys the same way as librdkafka and the Java client.
+  Balancer string
+
+  // Compression is one of none, gzip, snappy, lz4 or zstd, defaulting to none
+  Compression string
+
+  // RequiredAcks is one of none, one or all, defaulting to all
+  RequiredAcks string
+
+  BatchSize    int
+  BatchBytes   int64
+  BatchTimeout time.Duration
+  WriteTimeout time.Duration
+
+  // Async writes return as soon as the messages are queued. Failures are reported when the writer is closed.
+  Async bool
+}
+
+// Message is a message to write with an optional key and headers. The ID is returned by FailedIDs when the message
+// fails to write.
+type Message struct {
+  ID      int
+  Key     []byte
+  Value   []byte
+  Headers []Header
+}
+
+// Header is a message header
+type Header struct {
+  Key   string
+  Value []byte
 }
 
 // NewWriter creates a new kafka writer client
 func NewWriter(kConf WriterConfig) (*Writer, error) {
-  // Configure the auth mechanism based on the supplied config
-  mechanism, err := newMechanism(kConf.AuthConfig)
-  if err != nil {
-    return nil, fmt.Errorf("newMechanism(): %w", err)
-  }
-
   // Transports are responsible for managing connection pools and other resources,
   // it's generally best to create a few of these and share them across your
   // application.
-  sharedTransport := &kafka.Transport{
-    SASL: mechanism,
+  sharedTransport, err := newTransport(kConf.AuthConfig)
+  if err != nil {
+    return nil, err
   }
 
-  // Initialize the writer with the broker addresses, topic, and transport
-  w := &kafka.Writer{
-    Addr:      kafka.TCP(kConf.Brokers...),
-    Topic:     kConf.Topic,
-    Balancer:  &kafka.Hash{},
-    Transport: sharedTransport,
+  balancer, err := newBalancer(kConf.Balancer)
+  if err != nil {
+    return nil, err
   }
 
-  return &Writer{
-    writer: w,
+  compression, err := newCompression(kConf.Compression)
+  if err != nil {
+    return nil, err
+  }
+
+  requiredAcks, err := newRequiredAcks(kConf.RequiredAcks)
+  if err != nil {
+    return nil, err
+  }
+
+  k := &Writer{
     ctx:    kConf.Ctx,
-  }, nil
+    queued: make(map[*byte]int),
+  }
+
+  // Initialize the writer with the broker addresses, topic, and transport
+  k.writer = &kafka.Writer{
+    Addr:         kafka.TCP(kConf.Brokers...),
+    Topic:        kConf.Topic,
+    Balancer:     balancer,
+    Compression:  compression,
+    RequiredAcks: requiredAcks,
+    BatchSize:    kConf.BatchSize,
+    BatchBytes:   kConf.BatchBytes,
+    BatchTimeout: kConf.BatchTimeout,
+    WriteTimeout: kConf.WriteTimeout,
+    Async:        kConf.Async,
+    Transport:    sharedTransport,
+  }
+  if kConf.Async {
+    k.writer.Completion = func(messages []kafka.Message, err error) {
+      ids := make([]int, 0, len(messages))
+      k.mu.Lock()
+      for _, message := range messages {
+        if len(message.Value) == 0 {
+          continue
+        }
+        if id, ok := k.queued[&message.Value[0]]; ok {
+          delete(k.queued, &message.Value[0])
+          ids = append(ids, id)
+        }
+      }
+      k.mu.Unlock()
+
+      if err != nil {
+        k.recordFailures(len(messages), ids, err)
+      }
+    }
+  }
+
+  return k, nil
 }
 
 // WriteMessage writes the message to the kafka topic
 func (k *Writer) WriteMessage(message []byte) error {
-  return k.writer.WriteMessages(k.ctx, kafka.Message{
-    Value: message,
-  })
+  return k.WriteMessages(Message{Value: message})
+}
+
+// WriteMessages writes the messages to the kafka topic. Async writers only return an error when the messages could
+// not be queued.
+func (k *Writer) WriteMessages(messages ...Message) error {
+  kMessages := make([]kafka.Message, 0, len(messages))
+  ids := make([]int, 0, len(messages))
+  for _, message := range messages {
+    ids = append(ids, message.ID)
+    headers := make([]kafka.Header, 0, len(message.Headers))
+    for _, header := range message.Headers {
+      headers = append(headers, kafka.Header{Key: header.Key, Value: header.Value})
+    }
+
+    kMessages = append(kMessages, kafka.Message{
+      Key:     message.Key,
+      Value:   message.Value,
+      Headers: headers,
+    })
+  }
+
+  if k.writer.Async {
+    k.mu.Lock()
+    for i, message := range kMessages {
+      if len(message.Value) > 0 {
+        k.queued[&message.Value[0]] = ids[i]
+      }
+    }
+    k.mu.Unlock()
+  }
+
+  err := k.writer.WriteMessages(k.ctx, kMessages...)
+  if err != nil {
+    if k.writer.Async {
+      k.mu.Lock()
+      for _, message := range kMessages {
+        if len(message.Value) > 0 {
+          delete(k.queued, &message.Value[0])
+        }
+      }
+      k.mu.Unlock()
+    }
+
+    // Only count the messages that failed when the writer reports them individually
+    var writeErrors kafka.WriteErrors
+    if errors.As(err, &writeErrors) && len(writeErrors) == len(ids) {
+      failedIDs := make([]int, 0, writeErrors.Count())
+      for i, writeErr := range writeErrors {
+        if writeErr != nil {
+          failedIDs = append(failedIDs, ids[i])
+        }
+      }
+      k.recordFailures(len(failedIDs), failedIDs, err)
+    } else {
+      k.recordFailures(len(messages), ids, err)
+    }
+
+    return fmt.Errorf("writer.WriteMessages(): %w", err)
+  }
+
+  return nil
 }
 
-// Close closes the writer
+// Failed returns the number of messages that failed to write so far. Async writes are only counted once the writer
+// is closed.
+func (k *Writer) Failed() int {
+  k.mu.Lock()
+  defer k.mu.Unlock()
+
+  return k.failed
+}
+
+// FailedIDs returns the IDs of the messages that failed to write so far. Async writes are only included once the
+// writer is closed.
+func (k *Writer) FailedIDs() []int {
+  k.mu.Lock()
+  defer k.mu.Unlock()
+
+  return append([]int(nil), k.failedIDs...)
+}
+
+// Close flushes any queued messages and closes the writer. An error is returned if any message failed to write.
 func (k *Writer) Close() error {
   err := k.writer.Close()
   if err != nil {
     return fmt.Errorf("writer.Close(): %w", err)
   }
 
+  k.mu.Lock()
+  defer k.mu.Unlock()
+  if k.failed > 0 {
+    return fmt.Errorf("failed to write %d messages: %w", k.failed, k.firstErr)
+  }
+
   return nil
 }
+
+func (k *Writer) recordFailures(count int, ids []int, err error) {
+  k.mu.Lock()
+  defer k.mu.Unlock()
+
+  k.failed += count
+  k.failedIDs = append(k.failedIDs, ids...)
+  if k.firstErr == nil {
+    k.firstErr = err
+  }
+}
+
+// newBalancer creates the partition balancer by name
+func newBalancer(name string) (kafka.Balancer, error) {
+  switch name {
+  case "", "hash":
+    return &kafka.Hash{}, nil
+  case "round_robin":
+    return &kafka.RoundRobin{}, nil
+  case "least_bytes":
+    return &kafka.LeastBytes{}, nil
+  case "crc32":
+    return kafka.CRC32Balancer{}, nil
+  case "murmur2":
+    return kafka.Murmur2Balancer{}, nil
+  }
+
+  return nil, fmt.Errorf("unknown balancer: %s", name)
+}
+
+// newCompression returns the compression codec by name
+func newCompression(name string) (kafka.Compression, error) {
+  switch name {
+  case "", "none":
+    return 0, nil
+  case "gzip":
+    return compress.Gzip, nil
+  case "snappy":
+    return compress.Snappy, nil
+  case "lz4":
+    return compress.Lz4, nil
+  case "zstd":
+    return compress.Zstd, nil
+  }
+
+  return 0, fmt.Errorf("unknown compression: %s", name)
+}
+
+// newRequiredAcks returns the acknowledgements required from the brokers by name
+func newRequiredAcks(name string) (kafka.RequiredAcks, error) {
+  switch name {
+  case "", "all":
+    return kafka.RequireAll, nil
+  case "one":
+    return kafka.RequireOne, nil
+  case "none":
+    return kafka.RequireNone, nil
+  }
+
+  return 0, fmt.Errorf("unknown required acks: %s", name)
+}
@@ -0,0 +1,13 @@
+package msgraph
+
+import "net/url"
+
+// SignIns lists Azure AD sign-in logs
+func (client *Client) SignIns(params url.Values) (*GraphListResponse, error) {
+	return client.graphList("/v1.0/auditLogs/signIns", params)
+}
+
+// DirectoryAudits lists Azure AD directory audit logs
+func (client *Client) DirectoryAudits(params url.Values) (*GraphListResponse, error) {
+	return client.graphList("/v1.0/auditLogs/directoryAudits", params)
+}
@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"net/url"
 	"path"
+	"strings"
 	"time"
 )
 
@@ -30,6 +31,13 @@ type Client struct {
 	logger             *log.Entry
 	graphEndpoint      string
 	loginEndpoint      string
+	defenderEndpoint   string
+	managementEndpoint string
+
+	// Alternatives to the client secret
+	credentials             Credentials
+	certificate             *clientCertificate
+	managedIdentityEndpoint string
 }
 
 type GraphListResponse struct {
@@ -51,6 +59,10 @@ func NewClient(tenantId, clientId, clientSecret, scope string) *Client {
 		logger:             log.NewEntry(baseLogger),
 		graphEndpoint:      "https://graph.microsoft.com",
 		loginEndpoint:      "https://login.microsoftonline.com",
+		defenderEndpoint:   "https://api.securitycenter.microsoft.com",
+		managementEndpoint: "https://manage.office.com",
+
+		managedIdentityEndpoint: "http://169.254.169.254/metadata/identity/oauth2/token",
 	}
 }
 
@@ -81,25 +93,74 @@ func (client *Client) SetLoginEndpoint(loginEndpoint string) error {
 	return nil
 }
 
+func (client *Client) SetDefenderEndpoint(defenderEndpoint string) error {
+	_, err := url.Parse(defenderEndpoint)
+	if err != nil {
+		return fmt.Errorf("issue parsing endpoint: %s", err)
+	}
+	client.defenderEndpoint = defenderEndpoint
+	return nil
+}
+
+func (client *Client) SetManagementEndpoint(managementEndpoint string) error {
+	_, err := url.Parse(managementEndpoint)
+	if err != nil {
+		return fmt.Errorf("issue parsing endpoint: %s", err)
+	}
+	client.managementEndpoint = managementEndpoint
+	return nil
+}
+
+func (client *Client) SetManagedIdentityEndpoint(managedIdentityEndpoint string) error {
+	_, err := url.Parse(managedIdentityEndpoint)
+	if err != nil {
+		return fmt.Errorf("issue parsing endpoint: %s", err)
+	}
+	client.managedIdentityEndpoint = managedIdentityEndpoint
+	return nil
+}
+
 func (client *Client) Ping() bool {
 	err := client.login()
 	return err == nil
 }
 
+// Token returns an access token for the client's scope, logging in again once the current token has expired. It is
+// used to call APIs outside of this package with the same credentials.
+func (client *Client) Token() (string, error) {
+	if time.Now().After(client.accessTokenExpires) || client.AccessToken == "" {
+		err := client.login()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return client.AccessToken, nil
+}
+
 func (client *Client) login() error {
+	if client.credentials.ManagedIdentity {
+		return client.loginManagedIdentity()
+	}
+
+	// Build login URI
+	uri, err := client.buildLoginUri(fmt.Sprintf("/%s/oauth2/v2.0/token", client.TenantId))
+	if err != nil {
+		return fmt.Errorf("issue building login URI: %s", err)
+	}
+
 	params := url.Values{}
 	params.Set("scope", client.scope)
 	params.Set("client_id", client.ClientId)
-	params.Set("client_secret", client.ClientSecret)
 	params.Set("grant_type", "client_credentials")
 	headers := map[string]string{
 		"Content-Type": "application/x-www-form-urlencoded",
 	}
 
-	// Build login URI
-	uri, err := client.buildLoginUri(fmt.Sprintf("/%s/oauth2/v2.0/token", client.TenantId))
+	// Add the client secret or assertion
+	err = client.setCredentialParams(params, uri)
 	if err != nil {
-		return fmt.Errorf("issue building login URI: %s", err)
+		return err
 	}
 
 	// Conduct request
@@ -108,8 +169,34 @@ func (client *Client) login() error {
 		return fmt.Errorf("error in login request: %v", err)
 	}
 
+	return client.setAccessToken(body)
+}
+
+// loginManagedIdentity requests a token for the scope's resource from the instance metadata service. The client
+// ID selects a user-assigned identity and may be empty to use the system-assigned identity.
+func (client *Client) loginManagedIdentity() error {
+	params := url.Values{}
+	params.Set("api-version", "2018-02-01")
+	params.Set("resource", strings.TrimSuffix(client.scope, "/.default"))
+	if client.ClientId != "" {
+		params.Set("client_id", client.ClientId)
+	}
+	headers := map[string]string{
+		"Metadata": "true",
+	}
+
+	// Conduct request
+	_, body, err := client.makeCallSafe(client.managedIdentityEndpoint, nil, params, http.MethodGet, headers)
+	if err != nil {
+		return fmt.Errorf("error in managed identity token request: %v", err)
+	}
+
+	return client.setAccessToken(body)
+}
+
+func (client *Client) setAccessToken(body []byte) error {
 	var res authResponse
-	err = json.Unmarshal(body, &res)
+	err := json.Unmarshal(body, &res)
 
 	// Handle error
 	if err != nil {
@@ -270,6 +357,24 @@ func (client *Client) buildGraphUri(uriPath string) (string, error) {
 	return u.String(), nil
 }
 
+func (client *Client) buildDefenderUri(uriPath string) (string, error) {
+	u, err := url.Parse(client.defenderEndpoint)
+	if err != nil {
+		return "", err
+	}
+	u.Path = path.Join(u.Path, uriPath)
+	return u.String(), nil
+}
+
+func (client *Client) buildManagementUri(uriPath string) (string, error) {
+	u, err := url.Parse(client.managementEndpoint)
+	if err != nil {
+		return "", err
+	}
+	u.Path = path.Join(u.Path, "/api/v1.0", client.TenantId, "/activity/feed", uriPath)
+	return u.String(), nil
+}
+
 func (client *Client) buildLoginUri(uriPath string) (string, error) {
 	u, err := url.Parse(client.loginEndpoint)
 	if err != nil {
@@ -0,0 +1,224 @@
+package msgraph
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/pkcs12"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// clientAssertionType is the assertion type for signed JWT and federated token client credentials
+const clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// Credentials is the configuration for how the client authenticates. Only one of the client secret, certificate,
+// federated token file or managed identity may be used.
+type Credentials struct {
+	ClientSecret string
+
+	// CertificatePath is a PEM file with the certificate and RSA private key, or a PFX file
+	CertificatePath     string
+	CertificatePassword string
+
+	// FederatedTokenFile is a workload identity federation token, read again on each login as it is rotated
+	FederatedTokenFile string
+
+	// ManagedIdentity requests tokens from the Azure instance metadata service
+	ManagedIdentity bool
+}
+
+// Validate checks only one authentication method is configured
+func (c Credentials) Validate() error {
+	methods := 0
+	for _, v := range []bool{c.ClientSecret != "", c.CertificatePath != "", c.FederatedTokenFile != "", c.ManagedIdentity} {
+		if v {
+			methods++
+		}
+	}
+
+	if methods == 0 {
+		return fmt.Errorf("one of client_secret, certificate_path, federated_token_file or managed_identity is required")
+	}
+
+	if methods > 1 {
+		return fmt.Errorf("only one of client_secret, certificate_path, federated_token_file or managed_identity may be set")
+	}
+
+	if c.CertificatePassword != "" && c.CertificatePath == "" {
+		return fmt.Errorf("certificate_password requires a certificate_path")
+	}
+
+	return nil
+}
+
+// clientCertificate is a certificate used to sign client assertions
+type clientCertificate struct {
+	certificate *x509.Certificate
+	key         *rsa.PrivateKey
+}
+
+// NewClientWithCredentials creates a client which authenticates with the supplied credentials. Certificates are
+// loaded straight away so problems are reported before the first login.
+func NewClientWithCredentials(tenantId, clientId string, credentials Credentials, scope string) (*Client, error) {
+	err := credentials.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	// Only managed identities can be used without a client ID, which selects the system-assigned identity
+	if clientId == "" && !credentials.ManagedIdentity {
+		return nil, fmt.Errorf("client_id is required")
+	}
+
+	client := NewClient(tenantId, clientId, credentials.ClientSecret, scope)
+	client.credentials = credentials
+
+	if credentials.CertificatePath != "" {
+		client.certificate, err = loadCertificate(credentials.CertificatePath, credentials.CertificatePassword)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return client, nil
+}
+
+// loadCertificate loads the certificate and private key from a PEM or PFX file
+func loadCertificate(path, password string) (*clientCertificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("issue reading certificate: %s", err)
+	}
+
+	var key interface{}
+	var certificate *x509.Certificate
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".pfx", ".p12":
+		key, certificate, err = pkcs12.Decode(data, password)
+		if err != nil {
+			return nil, fmt.Errorf("issue decoding pfx certificate: %s", err)
+		}
+	default:
+		key, certificate, err = decodePEM(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("certificate private key must be an RSA key")
+	}
+
+	return &clientCertificate{certificate: certificate, key: rsaKey}, nil
+}
+
+// decodePEM finds the first certificate and private key in the PEM data
+func decodePEM(data []byte) (interface{}, *x509.Certificate, error) {
+	var key interface{}
+	var certificate *x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		var err error
+		switch block.Type {
+		case "CERTIFICATE":
+			if certificate == nil {
+				certificate, err = x509.ParseCertificate(block.Bytes)
+			}
+		case "RSA PRIVATE KEY":
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "PRIVATE KEY":
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("issue parsing %s: %s", strings.ToLower(block.Type), err)
+		}
+	}
+
+	if certificate == nil || key == nil {
+		return nil, nil, fmt.Errorf("certificate file must contain a certificate and private key")
+	}
+
+	return key, certificate, nil
+}
+
+// clientAssertion signs a JWT identifying the client for the token endpoint
+//
+// https://learn.microsoft.com/en-us/azure/active-directory/develop/active-directory-certificate-credentials
+func (c *clientCertificate) clientAssertion(clientId, audience string) (string, error) {
+	sha1Thumbprint := sha1.Sum(c.certificate.Raw)
+	sha256Thumbprint := sha256.Sum256(c.certificate.Raw)
+	header, err := json.Marshal(map[string]string{
+		"alg":      "RS256",
+		"typ":      "JWT",
+		"x5t":      base64.RawURLEncoding.EncodeToString(sha1Thumbprint[:]),
+		"x5t#S256": base64.RawURLEncoding.EncodeToString(sha256Thumbprint[:]),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims, err := json.Marshal(map[string]interface{}{
+		"aud": audience,
+		"iss": clientId,
+		"sub": clientId,
+		"jti": uuid.New().String(),
+		"nbf": now.Unix(),
+		"iat": now.Unix(),
+		"exp": now.Add(10 * time.Minute).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, c.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("issue signing client assertion: %s", err)
+	}
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// setCredentialParams adds the client credential to the token request
+func (client *Client) setCredentialParams(params url.Values, tokenUri string) error {
+	switch {
+	case client.certificate != nil:
+		assertion, err := client.certificate.clientAssertion(client.ClientId, tokenUri)
+		if err != nil {
+			return err
+		}
+		params.Set("client_assertion_type", clientAssertionType)
+		params.Set("client_assertion", assertion)
+	case client.credentials.FederatedTokenFile != "":
+		token, err := os.ReadFile(client.credentials.FederatedTokenFile)
+		if err != nil {
+			return fmt.Errorf("issue reading federated token file: %s", err)
+		}
+		params.Set("client_assertion_type", clientAssertionType)
+		params.Set("client_assertion", strings.TrimSpace(string(token)))
+	default:
+		params.Set("client_secret", client.ClientSecret)
+	}
+
+	return nil
+}
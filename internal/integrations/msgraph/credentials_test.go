@@ -0,0 +1,139 @@
+package msgraph
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCredentialsValidate(t *testing.T) {
+	assert.Nil(t, Credentials{ClientSecret: "secret"}.Validate())
+	assert.Nil(t, Credentials{CertificatePath: "cert.pfx", CertificatePassword: "password"}.Validate())
+	assert.Nil(t, Credentials{FederatedTokenFile: "token"}.Validate())
+	assert.Nil(t, Credentials{ManagedIdentity: true}.Validate())
+	assert.NotNil(t, Credentials{}.Validate())
+	assert.NotNil(t, Credentials{ClientSecret: "secret", FederatedTokenFile: "token"}.Validate())
+	assert.NotNil(t, Credentials{ClientSecret: "secret", CertificatePassword: "password"}.Validate())
+}
+
+func TestCertificateLogin(t *testing.T) {
+	certPath, key := writeTestCertificate(t)
+
+	var form map[string][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/tenant-1/oauth2/v2.0/token", r.URL.Path)
+		_ = r.ParseForm()
+		form = r.PostForm
+		_, _ = fmt.Fprint(w, `{"token_type": "Bearer", "expires_in": 3599, "access_token": "token"}`)
+	}))
+	defer server.Close()
+
+	client, err := NewClientWithCredentials("tenant-1", "client-1", Credentials{CertificatePath: certPath}, GraphScope.String())
+	assert.Nil(t, err)
+	assert.Nil(t, client.SetLoginEndpoint(server.URL))
+	assert.True(t, client.Ping())
+	assert.Equal(t, "token", client.AccessToken)
+
+	// The assertion is sent instead of the secret and signed by the certificate key
+	assert.Empty(t, form["client_secret"])
+	assert.Equal(t, clientAssertionType, form["client_assertion_type"][0])
+	parts := strings.Split(form["client_assertion"][0], ".")
+	assert.Equal(t, 3, len(parts))
+
+	signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	assert.Nil(t, rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature))
+
+	var claims map[string]interface{}
+	claimBytes, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	assert.Nil(t, json.Unmarshal(claimBytes, &claims))
+	assert.Equal(t, "client-1", claims["iss"])
+	assert.Equal(t, server.URL+"/tenant-1/oauth2/v2.0/token", claims["aud"])
+
+	// Missing and invalid certificates are reported when the client is created
+	_, err = NewClientWithCredentials("tenant-1", "client-1", Credentials{CertificatePath: filepath.Join(t.TempDir(), "missing.pem")}, GraphScope.String())
+	assert.NotNil(t, err)
+	invalidPath := filepath.Join(t.TempDir(), "invalid.pem")
+	assert.Nil(t, os.WriteFile(invalidPath, []byte("not a certificate"), 0600))
+	_, err = NewClientWithCredentials("tenant-1", "client-1", Credentials{CertificatePath: invalidPath}, GraphScope.String())
+	assert.NotNil(t, err)
+}
+
+func TestFederatedTokenLogin(t *testing.T) {
+	tokenPath := filepath.Join(t.TempDir(), "token")
+	assert.Nil(t, os.WriteFile(tokenPath, []byte("federated-token\n"), 0600))
+
+	var assertion string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		assertion = r.PostForm.Get("client_assertion")
+		_, _ = fmt.Fprint(w, `{"token_type": "Bearer", "expires_in": 3599, "access_token": "token"}`)
+	}))
+	defer server.Close()
+
+	client, err := NewClientWithCredentials("tenant-1", "client-1", Credentials{FederatedTokenFile: tokenPath}, GraphScope.String())
+	assert.Nil(t, err)
+	assert.Nil(t, client.SetLoginEndpoint(server.URL))
+	assert.True(t, client.Ping())
+	assert.Equal(t, "federated-token", assertion)
+}
+
+func TestManagedIdentityLogin(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "true", r.Header.Get("Metadata"))
+		assert.Equal(t, "https://graph.microsoft.com", r.URL.Query().Get("resource"))
+		assert.Equal(t, "", r.URL.Query().Get("client_id"))
+		_, _ = fmt.Fprint(w, `{"token_type": "Bearer", "expires_in": "3599", "access_token": "token"}`)
+	}))
+	defer server.Close()
+
+	client, err := NewClientWithCredentials("tenant-1", "", Credentials{ManagedIdentity: true}, GraphScope.String())
+	assert.Nil(t, err)
+	assert.Nil(t, client.SetManagedIdentityEndpoint(server.URL))
+	assert.True(t, client.Ping())
+	assert.Equal(t, "token", client.AccessToken)
+
+	// Other credentials require a client ID
+	_, err = NewClientWithCredentials("tenant-1", "", Credentials{ClientSecret: "secret"}, GraphScope.String())
+	assert.NotNil(t, err)
+}
+
+// writeTestCertificate writes a self-signed certificate and key to a PEM file
+func writeTestCertificate(t *testing.T) (string, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "collector"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.Nil(t, err)
+
+	certPath := filepath.Join(t.TempDir(), "cert.pem")
+	data := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer})...)
+	assert.Nil(t, os.WriteFile(certPath, data, 0600))
+
+	return certPath, key
+}
@@ -0,0 +1,17 @@
+package msgraph
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// DefenderAlerts lists Microsoft Defender for Endpoint alerts. The client must be created with the AtpScope.
+func (client *Client) DefenderAlerts(params url.Values) (*GraphListResponse, error) {
+	// Build URI
+	uri, err := client.buildDefenderUri("/api/alerts")
+	if err != nil {
+		return nil, fmt.Errorf("issue building defender alerts URI: %s", err)
+	}
+
+	return client.list(uri, params)
+}
@@ -0,0 +1,175 @@
+package msgraph
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Office 365 Management Activity API content types
+//
+// https://learn.microsoft.com/en-us/office/office-365-management-api/office-365-management-activity-api-reference
+const (
+	ContentTypeAzureActiveDirectory = "Audit.AzureActiveDirectory"
+	ContentTypeExchange             = "Audit.Exchange"
+	ContentTypeSharePoint           = "Audit.SharePoint"
+	ContentTypeGeneral              = "Audit.General"
+	ContentTypeDLP                  = "DLP.All"
+)
+
+// managementTimeFormat is the time format accepted by the Management Activity API
+const managementTimeFormat = "2006-01-02T15:04:05"
+
+// subscriptionAlreadyEnabled is the error code returned when starting a subscription that is already enabled
+const subscriptionAlreadyEnabled = "AF20024"
+
+// ManagementSubscription is a content type subscription
+type ManagementSubscription struct {
+	ContentType string `json:"contentType"`
+	Status      string `json:"status"`
+}
+
+// ManagementContent is a content blob available for download
+type ManagementContent struct {
+	ContentType       string `json:"contentType"`
+	ContentID         string `json:"contentId"`
+	ContentURI        string `json:"contentUri"`
+	ContentCreated    string `json:"contentCreated"`
+	ContentExpiration string `json:"contentExpiration"`
+}
+
+// ManagementSubscriptions lists the content type subscriptions of the tenant. The client must be created with the
+// ManagementScope.
+func (client *Client) ManagementSubscriptions() ([]ManagementSubscription, error) {
+	// Build URI
+	uri, err := client.buildManagementUri("/subscriptions/list")
+	if err != nil {
+		return nil, fmt.Errorf("issue building subscriptions URI: %s", err)
+	}
+
+	// Conduct request
+	_, body, err := client.makeCall(uri, nil, client.managementParams(), http.MethodGet, make(map[string]string))
+	if err != nil {
+		return nil, fmt.Errorf("issue conducting request: %s", err)
+	}
+
+	// Unmarshal body
+	var subscriptions []ManagementSubscription
+	err = json.Unmarshal(body, &subscriptions)
+	if err != nil {
+		return nil, fmt.Errorf("issue unmarshalling request body into struct: %s", err)
+	}
+
+	return subscriptions, nil
+}
+
+// StartManagementSubscription starts the subscription to a content type. Starting a subscription that is already
+// enabled is not an error.
+func (client *Client) StartManagementSubscription(contentType string) error {
+	// Build URI, the query is part of the URI as it is not sent as params for POST requests
+	uri, err := client.buildManagementUri("/subscriptions/start")
+	if err != nil {
+		return fmt.Errorf("issue building subscription start URI: %s", err)
+	}
+	params := client.managementParams()
+	params.Set("contentType", contentType)
+	uri = fmt.Sprintf("%s?%s", uri, params.Encode())
+
+	// Conduct request
+	res, _, err := client.makeCall(uri, nil, nil, http.MethodPost, make(map[string]string))
+	if err != nil {
+		if res != nil && strings.Contains(string(res.Body()), subscriptionAlreadyEnabled) {
+			return nil
+		}
+		return fmt.Errorf("issue starting %s subscription: %s", contentType, err)
+	}
+
+	return nil
+}
+
+// ManagementContent lists the content blobs of the content type made available between the start and end time.
+// The window may be up to 24 hours long and within the last 7 days.
+func (client *Client) ManagementContent(contentType string, start, end time.Time) ([]ManagementContent, error) {
+	// Build URI
+	uri, err := client.buildManagementUri("/subscriptions/content")
+	if err != nil {
+		return nil, fmt.Errorf("issue building content URI: %s", err)
+	}
+
+	params := client.managementParams()
+	params.Set("contentType", contentType)
+	params.Set("startTime", start.UTC().Format(managementTimeFormat))
+	params.Set("endTime", end.UTC().Format(managementTimeFormat))
+
+	content := make([]ManagementContent, 0)
+	for {
+		// Conduct request
+		res, body, err := client.makeCall(uri, nil, params, http.MethodGet, make(map[string]string))
+		if err != nil {
+			return nil, fmt.Errorf("issue conducting request: %s", err)
+		}
+
+		// Unmarshal body
+		var page []ManagementContent
+		err = json.Unmarshal(body, &page)
+		if err != nil {
+			return nil, fmt.Errorf("issue unmarshalling request body into struct: %s", err)
+		}
+		content = append(content, page...)
+
+		// Further pages are returned in the NextPageUri header
+		nextPage := res.Header().Get("NextPageUri")
+		if nextPage == "" {
+			return content, nil
+		}
+
+		nextUrl, err := url.Parse(nextPage)
+		if err != nil {
+			return nil, fmt.Errorf("issue parsing next page URI: %s", err)
+		}
+		params = nextUrl.Query()
+		nextUrl.RawQuery = ""
+		uri = nextUrl.String()
+	}
+}
+
+// ManagementContentBlob downloads the events in a content blob
+func (client *Client) ManagementContentBlob(contentURI string) ([]json.RawMessage, error) {
+	blobUrl, err := url.Parse(contentURI)
+	if err != nil {
+		return nil, fmt.Errorf("issue parsing content URI: %s", err)
+	}
+
+	// The query is sent as params so it is not replaced
+	params := blobUrl.Query()
+	if params.Get("PublisherIdentifier") == "" {
+		params.Set("PublisherIdentifier", client.TenantId)
+	}
+	blobUrl.RawQuery = ""
+
+	// Conduct request
+	_, body, err := client.makeCall(blobUrl.String(), nil, params, http.MethodGet, make(map[string]string))
+	if err != nil {
+		return nil, fmt.Errorf("issue conducting request: %s", err)
+	}
+
+	// Unmarshal body
+	var events []json.RawMessage
+	err = json.Unmarshal(body, &events)
+	if err != nil {
+		return nil, fmt.Errorf("issue unmarshalling request body into struct: %s", err)
+	}
+
+	return events, nil
+}
+
+// managementParams returns the params sent with every request. The tenant is sent as the publisher so requests
+// count against the tenant's own throttling quota.
+func (client *Client) managementParams() url.Values {
+	params := url.Values{}
+	params.Set("PublisherIdentifier", client.TenantId)
+	return params
+}
@@ -5,6 +5,12 @@ type Scope string
 const (
 	AtpScope   Scope = "https://api.securitycenter.windows.com/.default"
 	GraphScope Scope = "https://graph.microsoft.com/.default"
+
+	// ManagementScope is the scope for the Office 365 Management Activity API
+	ManagementScope Scope = "https://manage.office.com/.default"
+
+	// MonitorScope is the scope for the Azure Monitor Logs Ingestion API
+	MonitorScope Scope = "https://monitor.azure.com/.default"
 )
 
 // String makes Scope satisfy the Stringer interface.
@@ -8,18 +8,50 @@ import (
 	"strings"
 )
 
+// SecurityAlerts lists alerts from the legacy security alerts API
 func (client *Client) SecurityAlerts(params url.Values) (*GraphListResponse, error) {
-	headers := make(map[string]string, 0)
+	return client.graphList("/v1.0/security/alerts", params)
+}
+
+// SecurityAlertsV2 lists alerts from the Microsoft 365 Defender alerts API
+func (client *Client) SecurityAlertsV2(params url.Values) (*GraphListResponse, error) {
+	return client.graphList("/v1.0/security/alerts_v2", params)
+}
+
+// SecurityIncidents lists Microsoft 365 Defender incidents
+func (client *Client) SecurityIncidents(params url.Values) (*GraphListResponse, error) {
+	return client.graphList("/v1.0/security/incidents", params)
+}
+
+// NextPage requests the page at the @odata.nextLink of a previous response
+func (client *Client) NextPage(nextLink string) (*GraphListResponse, error) {
+	nextUrl, err := url.Parse(nextLink)
+	if err != nil {
+		return nil, fmt.Errorf("issue parsing next link: %s", err)
+	}
+
+	// The query is sent as params so it is not replaced
+	params := nextUrl.Query()
+	nextUrl.RawQuery = ""
+
+	return client.list(nextUrl.String(), params)
+}
 
+func (client *Client) graphList(uriPath string, params url.Values) (*GraphListResponse, error) {
 	// Build URI
-	securityAlertsUri, err := client.buildGraphUri("/v1.0/security/alerts")
+	uri, err := client.buildGraphUri(uriPath)
 	if err != nil {
-		return nil, fmt.Errorf("issue building security alerts URI: %s", err)
+		return nil, fmt.Errorf("issue building %s URI: %s", uriPath, err)
 	}
 
-	// Get alerts
-	res, body, err := client.makeCall(securityAlertsUri, nil, params, http.MethodGet, headers)
+	return client.list(uri, params)
+}
+
+func (client *Client) list(uri string, params url.Values) (*GraphListResponse, error) {
+	headers := make(map[string]string, 0)
+
 	// Conduct request
+	res, body, err := client.makeCall(uri, nil, params, http.MethodGet, headers)
 	if err != nil {
 		return nil, fmt.Errorf("issue conducting request: %s", err)
 	}
@@ -30,11 +62,11 @@ func (client *Client) SecurityAlerts(params url.Values) (*GraphListResponse, err
 	}
 
 	// Unmarshal body
-	var alertsResponse GraphListResponse
-	err = json.Unmarshal(body, &alertsResponse)
+	var listResponse GraphListResponse
+	err = json.Unmarshal(body, &listResponse)
 	if err != nil {
 		return nil, fmt.Errorf("issue unmarshalling request body into struct: %s", err)
 	}
 
-	return &alertsResponse, nil
+	return &listResponse, nil
 }
@@ -0,0 +1,176 @@
+package schemaregistry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-resty/resty/v2"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Schema types returned by the registry. Avro schemas are returned without a type.
+const (
+	TypeAvro     = "AVRO"
+	TypeProtobuf = "PROTOBUF"
+	TypeJSON     = "JSON"
+)
+
+// ErrNotFound is returned when the registry has no schema with the ID or reference
+var ErrNotFound = errors.New("schema not found")
+
+// Config is the configuration for a Confluent compatible schema registry
+type Config struct {
+	URL      string
+	Username string
+	Password string
+	Timeout  time.Duration
+}
+
+// Schema is a schema registered in the registry. Protobuf schemas are fetched in the serialized format, so the
+// schema holds a base64 encoded FileDescriptorProto.
+type Schema struct {
+	ID         int         `json:"id"`
+	SchemaType string      `json:"schemaType"`
+	Schema     string      `json:"schema"`
+	References []Reference `json:"references"`
+}
+
+// Reference is a schema imported by another schema, such as a protobuf import or a named avro type
+type Reference struct {
+	Name    string `json:"name"`
+	Subject string `json:"subject"`
+	Version int    `json:"version"`
+}
+
+// Client fetches schemas from the registry. Schemas never change once registered, so they are cached for the life
+// of the client.
+type Client struct {
+	restyClient *resty.Client
+	baseUrl     string
+
+	mu        sync.Mutex
+	byID      map[int]*Schema
+	bySubject map[string]*Schema
+}
+
+// NewClient creates a new schema registry client
+func NewClient(conf Config) (*Client, error) {
+	parsed, err := url.Parse(conf.URL)
+	if err != nil {
+		return nil, fmt.Errorf("issue parsing schema registry url: %s", err)
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return nil, fmt.Errorf("schema registry url must be http or https: %s", conf.URL)
+	}
+
+	// Throttled requests and server errors are retried along with network errors
+	restyClient := resty.New().SetRetryCount(3).SetRetryWaitTime(2 * time.Second).SetRetryMaxWaitTime(10 * time.Second)
+	restyClient.AddRetryCondition(func(resp *resty.Response, err error) bool {
+		return resp != nil && (resp.StatusCode() == http.StatusTooManyRequests || resp.StatusCode() >= http.StatusInternalServerError)
+	})
+	restyClient.SetHeader("Accept", "application/vnd.schemaregistry.v1+json")
+	if conf.Timeout > 0 {
+		restyClient.SetTimeout(conf.Timeout)
+	}
+	if conf.Username != "" {
+		restyClient.SetBasicAuth(conf.Username, conf.Password)
+	}
+
+	return &Client{
+		restyClient: restyClient,
+		baseUrl:     strings.TrimRight(conf.URL, "/"),
+		byID:        make(map[int]*Schema),
+		bySubject:   make(map[string]*Schema),
+	}, nil
+}
+
+// SchemaByID returns the schema registered with the ID
+func (client *Client) SchemaByID(id int) (*Schema, error) {
+	client.mu.Lock()
+	schema, ok := client.byID[id]
+	client.mu.Unlock()
+	if ok {
+		return schema, nil
+	}
+
+	schema, err := client.get(fmt.Sprintf("/schemas/ids/%d", id))
+	if err != nil {
+		return nil, err
+	}
+
+	// The serialized format is only supported for protobuf schemas, so they are requested again once the type is known
+	if schema.SchemaType == TypeProtobuf {
+		schema, err = client.get(fmt.Sprintf("/schemas/ids/%d?format=serialized", id))
+		if err != nil {
+			return nil, err
+		}
+	}
+	schema.ID = id
+
+	client.mu.Lock()
+	client.byID[id] = schema
+	client.mu.Unlock()
+
+	return schema, nil
+}
+
+// SchemaByReference returns the schema version a reference points to. Protobuf schemas are returned in the
+// serialized format.
+func (client *Client) SchemaByReference(reference Reference) (*Schema, error) {
+	key := fmt.Sprintf("%s/%d", reference.Subject, reference.Version)
+	client.mu.Lock()
+	schema, ok := client.bySubject[key]
+	client.mu.Unlock()
+	if ok {
+		return schema, nil
+	}
+
+	path := fmt.Sprintf("/subjects/%s/versions/%d", url.PathEscape(reference.Subject), reference.Version)
+	schema, err := client.get(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if schema.SchemaType == TypeProtobuf {
+		schema, err = client.get(path + "?format=serialized")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	client.mu.Lock()
+	client.bySubject[key] = schema
+	client.mu.Unlock()
+
+	return schema, nil
+}
+
+func (client *Client) get(path string) (*Schema, error) {
+	resp, err := client.restyClient.R().Get(client.baseUrl + path)
+	if err != nil {
+		return nil, fmt.Errorf("issue requesting schema: %s", err)
+	}
+	if resp.StatusCode() == http.StatusNotFound {
+		return nil, fmt.Errorf("issue requesting schema %s: %w", path, ErrNotFound)
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return nil, fmt.Errorf("issue requesting schema %s: status %d: %s", path, resp.StatusCode(), resp.String())
+	}
+
+	// Registries differ in the content type they respond with, so the body is always read as JSON
+	var schema Schema
+	err = json.Unmarshal(resp.Body(), &schema)
+	if err != nil {
+		return nil, fmt.Errorf("issue unmarshalling schema response: %s", err)
+	}
+
+	if schema.SchemaType == "" {
+		schema.SchemaType = TypeAvro
+	}
+
+	return &schema, nil
+}
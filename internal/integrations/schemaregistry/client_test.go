@@ -0,0 +1,62 @@
+package schemaregistry
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSchemaByID(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		username, password, _ := r.BasicAuth()
+		assert.Equal(t, "key", username)
+		assert.Equal(t, "secret", password)
+
+		switch r.URL.RequestURI() {
+		case "/schemas/ids/1":
+			_, _ = w.Write([]byte(`{"schema": "{\"type\": \"string\"}"}`))
+		case "/schemas/ids/2":
+			_, _ = w.Write([]byte(`{"schemaType": "PROTOBUF", "schema": "syntax = \"proto3\";", "references": [{"name": "common.proto", "subject": "common", "version": 3}]}`))
+		case "/schemas/ids/2?format=serialized":
+			_, _ = w.Write([]byte(`{"schemaType": "PROTOBUF", "schema": "CgZ0ZXN0", "references": [{"name": "common.proto", "subject": "common", "version": 3}]}`))
+		case "/subjects/common/versions/3":
+			_, _ = w.Write([]byte(`{"subject": "common", "version": 3, "id": 5, "schema": "{\"type\": \"int\"}"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error_code": 40403, "message": "Schema not found"}`))
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(Config{URL: server.URL + "/", Username: "key", Password: "secret"})
+	assert.Nil(t, err)
+
+	schema, err := client.SchemaByID(1)
+	assert.Nil(t, err)
+	assert.Equal(t, TypeAvro, schema.SchemaType)
+	assert.Equal(t, `{"type": "string"}`, schema.Schema)
+
+	// Schemas are cached
+	_, err = client.SchemaByID(1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, requests)
+
+	// Protobuf schemas are fetched in the serialized format
+	schema, err = client.SchemaByID(2)
+	assert.Nil(t, err)
+	assert.Equal(t, "CgZ0ZXN0", schema.Schema)
+	assert.Equal(t, 2, schema.ID)
+
+	schema, err = client.SchemaByReference(schema.References[0])
+	assert.Nil(t, err)
+	assert.Equal(t, `{"type": "int"}`, schema.Schema)
+
+	_, err = client.SchemaByID(9)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = NewClient(Config{URL: "ftp://registry"})
+	assert.NotNil(t, err)
+}
@@ -10,6 +10,7 @@ import (
   log "github.com/sirupsen/logrus"
   "os"
   "strings"
+  "time"
 )
 
 var OutputName = "kafka"
@@ -18,17 +19,51 @@ type Config struct {
   Brokers    []string         `json:"brokers" validate:"required|minLen:1"`
   Topic      string           `json:"topic" validate:"required"`
   AuthConfig kafka.AuthConfig `json:"auth_config"`
+
+  // The message key is taken from the JSON path in key_field or the result of the CEL expression in key_expression,
+  // which is evaluated with the result as the event variable
+  KeyField      string `json:"key_field"`
+  KeyExpression string `json:"key_expression"`
+
+  // Headers are added to every message. Header fields map a header name to the JSON path of its value.
+  Headers      map[string]string `json:"headers"`
+  HeaderFields map[string]string `json:"header_fields"`
+
+  Balancer     string `json:"balancer" validate:"in:hash,round_robin,least_bytes,crc32,murmur2"`
+  Compression  string `json:"compression" validate:"in:none,gzip,snappy,lz4,zstd"`
+  RequiredAcks string `json:"required_acks" validate:"in:none,one,all"`
+
+  // Messages are written in batches of up to batch_size messages or batch_bytes, waiting at most batch_timeout_ms
+  // for a batch to fill. Async writes queue batches without waiting on the previous one.
+  BatchSize      int   `json:"batch_size" validate:"min:1"`
+  BatchBytes     int64 `json:"batch_bytes" validate:"min:1"`
+  BatchTimeoutMs int   `json:"batch_timeout_ms" validate:"min:1"`
+  WriteTimeout   int   `json:"write_timeout" validate:"min:1"`
+  Async          bool  `json:"async"`
 }
 
 type kafkaOutput struct {
-  config Config
-  ctx    context.Context
+  config  Config
+  ctx     context.Context
+  builder *messageBuilder
+
+  // Lines of files that failed to write which were written or skipped, so retries only send the rest
+  progress core.WriteProgress
 }
 
 func Handler() core.OutputHandler {
   return func(config []byte) (core.Output, error) {
     // Set config defaults
-    conf := Config{}
+    conf := Config{
+      Balancer:       "hash",
+      Compression:    "none",
+      RequiredAcks:   "all",
+      BatchSize:      100,
+      BatchBytes:     1048576,
+      BatchTimeoutMs: 1000,
+      WriteTimeout:   10,
+      Async:          true,
+    }
 
     // Unmarshal config
     err := json.Unmarshal(config, &conf)
@@ -42,67 +77,159 @@ func Handler() core.OutputHandler {
       return nil, err
     }
 
+    err = conf.AuthConfig.Validate()
+    if err != nil {
+      return nil, err
+    }
+
+    if conf.KeyField != "" && conf.KeyExpression != "" {
+      return nil, fmt.Errorf("only one of key_field or key_expression may be set")
+    }
+
+    builder, err := newMessageBuilder(conf)
+    if err != nil {
+      return nil, err
+    }
+
     ctx := context.Background()
 
     return &kafkaOutput{
-      config: conf,
-      ctx:    ctx,
+      config:  conf,
+      ctx:     ctx,
+      builder: builder,
     }, nil
   }
 }
 
+// Write publishes each line of the results as a message. When the write fails, the lines that were written or could
+// not be built are remembered so retrying the file only sends the messages that failed.
 func (p *kafkaOutput) Write(inputFile string) (int, error) {
+  attempt, err := p.write(inputFile)
+  p.progress.Save(inputFile, attempt.handled, err == nil)
+  return attempt.count, err
+}
+
+// writeAttempt is the lines handled by an attempt to write a file, along with the messages it wrote
+type writeAttempt struct {
+  handled map[int]bool
+  count   int
+}
+
+func (p *kafkaOutput) write(inputFile string) (writeAttempt, error) {
   // Set up line variables
-  lineCount := 0
+  attempt := writeAttempt{handled: p.progress.Handled(inputFile)}
+  sent := make([]int, 0)
   emptyLines := 0
+  skipped := 0
 
   // Set up writer
   writer, err := kafka.NewWriter(kafka.WriterConfig{
-    Ctx:        p.ctx,
-    AuthConfig: p.config.AuthConfig,
-    Brokers:    p.config.Brokers,
-    Topic:      p.config.Topic,
+    Ctx:          p.ctx,
+    AuthConfig:   p.config.AuthConfig,
+    Brokers:      p.config.Brokers,
+    Topic:        p.config.Topic,
+    Balancer:     p.config.Balancer,
+    Compression:  p.config.Compression,
+    RequiredAcks: p.config.RequiredAcks,
+    BatchSize:    p.config.BatchSize,
+    BatchBytes:   p.config.BatchBytes,
+    BatchTimeout: time.Duration(p.config.BatchTimeoutMs) * time.Millisecond,
+    WriteTimeout: time.Duration(p.config.WriteTimeout) * time.Second,
+    Async:        p.config.Async,
   })
   if err != nil {
-    return 0, fmt.Errorf("issue setting up kafka writer: %s", err)
+    return attempt, fmt.Errorf("issue setting up kafka writer: %s", err)
   }
-  defer writer.Close()
 
   // Open file
   file, err := os.Open(inputFile)
   if err != nil {
-    return 0, fmt.Errorf("issue opening input file: %s", err)
+    _ = writer.Close()
+    return attempt, fmt.Errorf("issue opening input file: %s", err)
   }
   defer file.Close()
 
+  // Messages are handed to the writer a batch at a time so synchronous writes are not waiting on the batch timeout
+  batch := make([]kafka.Message, 0, p.config.BatchSize)
+  flush := func() {
+    if len(batch) == 0 {
+      return
+    }
+
+    // Failures are counted by the writer and reported when it is closed. Synchronous writes return the failure
+    // of each batch.
+    err := writer.WriteMessages(batch...)
+    if err != nil {
+      log.Errorf("issue publishing batch of %d messages to kafka: %s", len(batch), err)
+    }
+    batch = batch[:0]
+  }
+
   // Start reading from the file with a reader.
   scanner := bufio.NewScanner(file)
   buffer := make([]byte, 0, core.MaxLogSize)
   scanner.Buffer(buffer, core.MaxLogSize)
-  for scanner.Scan() {
+  for lineNumber := 0; scanner.Scan(); lineNumber++ {
+    // Skip lines handled by an earlier attempt
+    if attempt.handled[lineNumber] {
+      continue
+    }
+
     line := scanner.Text()
     trimmedLine := strings.TrimSpace(line)
 
     // Check empty lines
     if trimmedLine == "" {
       emptyLines++
+      attempt.handled[lineNumber] = true
       continue
     }
 
-    // Write to kafka topic
-    err = writer.WriteMessage([]byte(trimmedLine))
+    // Messages that can not be built are not retried
+    message, err := p.builder.build(trimmedLine)
     if err != nil {
-      log.Errorf("issue publishing line to kafka: %s", err)
+      log.Errorf("issue building kafka message: %s", err)
+      skipped++
+      attempt.handled[lineNumber] = true
       continue
     }
 
-    // Debug print with empty line count
-    if emptyLines > 0 {
-      log.Debugf("ignored %d empty log entries", emptyLines)
+    message.ID = lineNumber
+    batch = append(batch, message)
+    sent = append(sent, lineNumber)
+    if len(batch) >= p.config.BatchSize {
+      flush()
     }
+  }
+  flush()
 
-    lineCount++
+  // Debug print with empty line count
+  if emptyLines > 0 {
+    log.Debugf("ignored %d empty log entries", emptyLines)
+  }
+
+  // Closing waits for queued messages to be written
+  closeErr := writer.Close()
+  failed := make(map[int]bool)
+  for _, id := range writer.FailedIDs() {
+    failed[id] = true
+  }
+  for _, lineNumber := range sent {
+    if !failed[lineNumber] {
+      attempt.handled[lineNumber] = true
+      attempt.count++
+    }
+  }
+
+  if err := scanner.Err(); err != nil {
+    return attempt, fmt.Errorf("issue reading input file: %s", err)
+  }
+  if closeErr != nil {
+    return attempt, fmt.Errorf("issue publishing to kafka: %s", closeErr)
+  }
+  if skipped > 0 {
+    return attempt, fmt.Errorf("issue building %d kafka messages", skipped)
   }
 
-  return lineCount, nil
+  return attempt, nil
 }
@@ -9,12 +9,25 @@ import (
 
 var config1 = `{"brokers": ["uri-1", "uri-2"], "topic": "topic-1"}`
 var config2 = `{"brokers": ["uri-1"], "topic": "topic-1", "auth_config": {"scram_sha_512": {"enabled": true, "username": "user", "password": "pass"}}}`
+var config3 = `{"brokers": ["uri-1"], "topic": "topic-1", "auth_config": {"tls": {"enabled": true, "server_name": "kafka.example.com"}, "plain": {"enabled": true, "username": "$ConnectionString", "password": "Endpoint=sb://example.servicebus.windows.net/"}}}`
+var config4 = `{"brokers": ["uri-1"], "topic": "topic-1", "auth_config": {"tls": {"enabled": true}, "oauthbearer": {"enabled": true, "token_url": "https://login.example.com/token", "client_id": "id", "client_secret": "secret", "extensions": {"logicalCluster": "lkc-1"}}}}`
+var config5 = `{"brokers": ["uri-1"], "topic": "topic-1", "key_field": "host.name", "headers": {"source": "collector"}, "header_fields": {"event_type": "type"}, "balancer": "murmur2", "compression": "zstd", "required_acks": "one", "batch_size": 500, "async": false}`
+var config6 = `{"brokers": ["uri-1"], "topic": "topic-1", "key_expression": "event.tenant + '-' + event.user", "balancer": "round_robin", "compression": "gzip", "batch_timeout_ms": 50}`
 var badConfig1 = `{"brokers": [], "topic": "topic-1"}`
 var badConfig2 = `{"brokers": [], "topic": ""}`
 var badConfig3 = `{"brokers": ["uri-1"], "topic": ""}`
+var badConfig4 = `{"brokers": ["uri-1"], "topic": "topic-1", "auth_config": {"oauthbearer": {"enabled": true, "token_url": "https://login.example.com/token"}}}`
+var badConfig5 = `{"brokers": ["uri-1"], "topic": "topic-1", "auth_config": {"tls": {"enabled": true, "ca_file": "/does/not/exist.pem"}}}`
+var badConfig6 = `{"brokers": ["uri-1"], "topic": "topic-1", "auth_config": {"plain": {"enabled": true, "username": "user"}}}`
+var badConfig7 = `{"brokers": ["uri-1"], "topic": "topic-1", "compression": "brotli"}`
+var badConfig8 = `{"brokers": ["uri-1"], "topic": "topic-1", "balancer": "random"}`
+var badConfig9 = `{"brokers": ["uri-1"], "topic": "topic-1", "required_acks": "two"}`
+var badConfig10 = `{"brokers": ["uri-1"], "topic": "topic-1", "batch_size": -1}`
+var badConfig11 = `{"brokers": ["uri-1"], "topic": "topic-1", "key_field": "id", "key_expression": "event.id"}`
+var badConfig12 = `{"brokers": ["uri-1"], "topic": "topic-1", "key_expression": "event.id +"}`
 
 func TestValidate(t *testing.T) {
-  arr := []string{config1, config2}
+  arr := []string{config1, config2, config3, config4, config5, config6}
   for i, v := range arr {
     var testConfig Config
     err := json.Unmarshal([]byte(v), &testConfig)
@@ -25,7 +38,7 @@ func TestValidate(t *testing.T) {
 }
 
 func TestValidateFailed(t *testing.T) {
-  arr := []string{badConfig1, badConfig2}
+  arr := []string{badConfig1, badConfig2, badConfig7, badConfig8, badConfig9, badConfig10}
   for i, v := range arr {
     var testConfig Config
     err := json.Unmarshal([]byte(v), &testConfig)
@@ -36,7 +49,7 @@ func TestValidateFailed(t *testing.T) {
 }
 
 func TestHandler(t *testing.T) {
-  arr := []string{config1, config2}
+  arr := []string{config1, config2, config3, config4, config5, config6}
   for i, v := range arr {
     var testConfig Config
     err := json.Unmarshal([]byte(v), &testConfig)
@@ -48,7 +61,7 @@ func TestHandler(t *testing.T) {
 }
 
 func TestHandlerFailed(t *testing.T) {
-  arr := []string{badConfig1, badConfig2, badConfig3}
+  arr := []string{badConfig1, badConfig2, badConfig3, badConfig4, badConfig5, badConfig6, badConfig7, badConfig8, badConfig9, badConfig10, badConfig11, badConfig12}
   for i, v := range arr {
     var testConfig Config
     err := json.Unmarshal([]byte(v), &testConfig)
@@ -58,3 +71,34 @@ func TestHandlerFailed(t *testing.T) {
     assert.NotNilf(t, err, "test #%d - validation should have returned an error: %s", i, err)
   }
 }
+
+func TestMessageBuilder(t *testing.T) {
+  builder, err := newMessageBuilder(Config{
+    KeyField:     "host.name",
+    Headers:      map[string]string{"source": "collector"},
+    HeaderFields: map[string]string{"event_type": "type", "missing": "not.here"},
+  })
+  assert.Nil(t, err)
+
+  message, err := builder.build(`{"host": {"name": "web-1"}, "type": "login"}`)
+  assert.Nil(t, err)
+  assert.Equal(t, "web-1", string(message.Key))
+  assert.Equal(t, 2, len(message.Headers))
+  assert.Equal(t, "source", message.Headers[0].Key)
+  assert.Equal(t, "event_type", message.Headers[1].Key)
+  assert.Equal(t, "login", string(message.Headers[1].Value))
+
+  // Missing key fields leave the key empty so the balancer picks the partition
+  message, err = builder.build(`{"type": "login"}`)
+  assert.Nil(t, err)
+  assert.Nil(t, message.Key)
+
+  builder, err = newMessageBuilder(Config{KeyExpression: "event.tenant + '-' + event.user"})
+  assert.Nil(t, err)
+  message, err = builder.build(`{"tenant": "acme", "user": "bob"}`)
+  assert.Nil(t, err)
+  assert.Equal(t, "acme-bob", string(message.Key))
+
+  _, err = builder.build(`{"tenant": "acme"}`)
+  assert.NotNil(t, err)
+}
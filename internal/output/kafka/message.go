@@ -0,0 +1,132 @@
+package kafka
+
+import (
+  "bytes"
+  "fmt"
+  "github.com/ThoronicLLC/collector/internal/integrations/kafka"
+  "github.com/golang/protobuf/jsonpb"
+  structpb "github.com/golang/protobuf/ptypes/struct"
+  "github.com/google/cel-go/cel"
+  "github.com/google/cel-go/checker/decls"
+  "github.com/google/cel-go/common/types"
+  "github.com/tidwall/gjson"
+  "sort"
+)
+
+// messageBuilder builds the kafka message for each result with its key and headers
+type messageBuilder struct {
+  keyField     string
+  keyProgram   cel.Program
+  headers      []kafka.Header
+  headerFields map[string]string
+  headerNames  []string
+}
+
+// newMessageBuilder compiles the key expression and sorts the headers so messages are built the same way each time
+func newMessageBuilder(conf Config) (*messageBuilder, error) {
+  builder := &messageBuilder{
+    keyField:     conf.KeyField,
+    headerFields: conf.HeaderFields,
+  }
+
+  if conf.KeyExpression != "" {
+    program, err := compileKeyExpression(conf.KeyExpression)
+    if err != nil {
+      return nil, err
+    }
+    builder.keyProgram = program
+  }
+
+  for _, k := range sortedKeys(conf.Headers) {
+    builder.headers = append(builder.headers, kafka.Header{Key: k, Value: []byte(conf.Headers[k])})
+  }
+  builder.headerNames = sortedKeys(conf.HeaderFields)
+
+  return builder, nil
+}
+
+// build creates the message for the line. Header fields missing from the line are left out.
+func (b *messageBuilder) build(line string) (kafka.Message, error) {
+  message := kafka.Message{Value: []byte(line)}
+
+  key, err := b.key(line)
+  if err != nil {
+    return message, err
+  }
+  message.Key = key
+
+  message.Headers = append(message.Headers, b.headers...)
+  for _, name := range b.headerNames {
+    result := gjson.Get(line, b.headerFields[name])
+    if !result.Exists() {
+      continue
+    }
+    message.Headers = append(message.Headers, kafka.Header{Key: name, Value: []byte(result.String())})
+  }
+
+  return message, nil
+}
+
+// key returns the message key from the key field or expression, or nil when neither is set or the field is missing
+func (b *messageBuilder) key(line string) ([]byte, error) {
+  if b.keyField != "" {
+    result := gjson.Get(line, b.keyField)
+    if !result.Exists() {
+      return nil, nil
+    }
+    return []byte(result.String()), nil
+  }
+
+  if b.keyProgram == nil {
+    return nil, nil
+  }
+
+  var spb structpb.Struct
+  err := jsonpb.Unmarshal(bytes.NewBufferString(line), &spb)
+  if err != nil {
+    return nil, fmt.Errorf("issue unmarshalling event for key expression: %s", err)
+  }
+
+  val, _, err := b.keyProgram.Eval(map[string]interface{}{"event": &spb})
+  if err != nil {
+    return nil, fmt.Errorf("issue evaluating key expression: %s", err)
+  }
+
+  str := val.ConvertToType(types.StringType)
+  if types.IsError(str) {
+    return nil, fmt.Errorf("key expression returned a %s which cannot be converted to a string", val.Type().TypeName())
+  }
+
+  return []byte(str.Value().(string)), nil
+}
+
+// compileKeyExpression parses and checks the CEL key expression against the event
+func compileKeyExpression(expression string) (cel.Program, error) {
+  env, err := cel.NewEnv(cel.Declarations(
+    decls.NewConst("event", decls.NewMapType(decls.String, decls.Dyn), nil),
+  ))
+  if err != nil {
+    return nil, fmt.Errorf("issue creating cel environment: %s", err)
+  }
+
+  ast, iss := env.Compile(expression)
+  if iss != nil && iss.Err() != nil {
+    return nil, fmt.Errorf("issue compiling key expression: %s", iss.Err())
+  }
+
+  program, err := env.Program(ast)
+  if err != nil {
+    return nil, fmt.Errorf("issue creating key expression program: %s", err)
+  }
+
+  return program, nil
+}
+
+func sortedKeys(m map[string]string) []string {
+  keys := make([]string, 0, len(m))
+  for k := range m {
+    keys = append(keys, k)
+  }
+  sort.Strings(keys)
+  return keys
+}
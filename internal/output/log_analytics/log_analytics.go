@@ -2,12 +2,14 @@ package log_analytics
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"github.com/ThoronicLLC/collector/internal/integrations/msgraph"
 	"github.com/ThoronicLLC/collector/pkg/core"
 	"github.com/go-resty/resty/v2"
 	log "github.com/sirupsen/logrus"
@@ -19,28 +21,76 @@ import (
 
 var OutputName = "log_analytics"
 
+const (
+	modeDataCollector = "data_collector"
+	modeLogsIngestion = "logs_ingestion"
+
+	// dataCollectorMaxBytes is the size of the requests sent to the data collector API, below its 30MB limit
+	dataCollectorMaxBytes = 25 * 1024 * 1024
+
+	// defaultEndpointSuffix is the endpoint suffix of the Azure public cloud
+	defaultEndpointSuffix = "azure.com"
+)
+
+// Config for the log_analytics output. The data_collector mode signs requests to the HTTP Data Collector API with
+// the workspace key. The logs_ingestion mode authenticates with an Entra ID application and posts to the stream of a
+// data collection rule through a data collection endpoint.
 type Config struct {
-	LogType     string `json:"log_type" validate:"required"`
-	WorkspaceID string `json:"workspace_id" validate:"required"`
-	PrimaryKey  string `json:"primary_key" validate:"required"`
+	Mode        string `json:"mode" validate:"in:data_collector,logs_ingestion"`
+	LogType     string `json:"log_type"`
+	WorkspaceID string `json:"workspace_id"`
+	PrimaryKey  string `json:"primary_key"`
 	DateField   string `json:"date_field,omitempty"`
+
+	// Logs ingestion settings. The data collection rule maps the stream to its table, including the time field.
+	DataCollectionEndpoint string `json:"data_collection_endpoint"`
+	DcrImmutableID         string `json:"dcr_immutable_id"`
+	StreamName             string `json:"stream_name"`
+	TenantID               string `json:"tenant_id"`
+	ClientID               string `json:"client_id"`
+	ClientSecret           string `json:"client_secret"`
+
+	// Alternatives to the client secret, see the msgraph input
+	CertificatePath     string `json:"certificate_path"`
+	CertificatePassword string `json:"certificate_password"`
+	FederatedTokenFile  string `json:"federated_token_file"`
+	ManagedIdentity     bool   `json:"managed_identity"`
+
+	// LoginEndpoint for government clouds
+	LoginEndpoint string `json:"login_endpoint,omitempty"`
+
+	// MaxRetries is how many times throttled or failed uploads are retried
+	MaxRetries int `json:"max_retries" validate:"min:0"`
+
+	// EndpointSuffix of the Azure cloud, such as azure.us or azure.cn for sovereign clouds
+	EndpointSuffix string `json:"endpoint_suffix"`
+
+	// Field limits, which default to the limits of the API. Records over a limit, or larger than a request, are
+	// truncated or rejected. Rejected lines are written to the dead letter path, or dropped when it is not set.
+	MaxFields        int    `json:"max_fields" validate:"min:0"`
+	MaxFieldBytes    int    `json:"max_field_bytes" validate:"min:0"`
+	OversizedRecords string `json:"oversized_records" validate:"in:truncate,reject"`
+	DeadLetterPath   string `json:"dead_letter_path"`
 }
 
 type logAnalyticsOutput struct {
 	config     Config
 	ctx        context.Context
 	cancelFunc context.CancelFunc
-}
-
-type logAnalyticsDefaultLog struct {
-	Message string `json:"message"`
+	client     *msgraph.Client
+	limits     recordLimits
+	progress   core.WriteProgress
 }
 
 func Handler() core.OutputHandler {
 	return func(config []byte) (core.Output, error) {
 		// Set config defaults
 		conf := Config{
-			DateField: "Timestamp",
+			Mode:             modeDataCollector,
+			DateField:        "Timestamp",
+			MaxRetries:       5,
+			EndpointSuffix:   defaultEndpointSuffix,
+			OversizedRecords: oversizedTruncate,
 		}
 
 		// Unmarshal config
@@ -55,6 +105,21 @@ func Handler() core.OutputHandler {
 			return nil, err
 		}
 
+		// Validate the settings of the mode
+		err = validateConfig(conf)
+		if err != nil {
+			return nil, err
+		}
+
+		// Setup the Entra ID client for the logs ingestion API
+		var client *msgraph.Client
+		if conf.Mode == modeLogsIngestion {
+			client, err = newIngestionClient(conf)
+			if err != nil {
+				return nil, err
+			}
+		}
+
 		// Setup context
 		ctx, cancelFn := context.WithCancel(context.Background())
 
@@ -62,21 +127,79 @@ func Handler() core.OutputHandler {
 			config:     conf,
 			ctx:        ctx,
 			cancelFunc: cancelFn,
+			client:     client,
+			limits:     newRecordLimits(conf),
 		}, nil
 	}
 }
 
+// Write uploads the results in chunks below the request limit. Failed chunks are retried, and if a chunk still fails
+// the number of results uploaded is returned with the error. The lines uploaded, rejected or empty are remembered, so
+// writing the file again only uploads the rest.
 func (l *logAnalyticsOutput) Write(inputFile string) (int, error) {
-	// Make upload buffer and line count
-	uploadBuffer := make([]interface{}, 0)
-	uploadBufferByteSize := 0
-	lineCount := 0
+	attempt, err := l.write(inputFile)
+	l.progress.Save(inputFile, attempt.handled, err == nil)
+	return attempt.count, err
+}
+
+// writeAttempt is the progress of one attempt at writing a results file
+type writeAttempt struct {
+	handled map[int]bool
+	count   int
+}
+
+// write uploads the lines of the file not handled by earlier attempts
+func (l *logAnalyticsOutput) write(inputFile string) (writeAttempt, error) {
+	attempt := writeAttempt{handled: l.progress.Handled(inputFile)}
+
+	// Make upload chunk and counts, with the line numbers of the chunk
+	chunk := make([]json.RawMessage, 0)
+	chunkLines := make([]int, 0)
+	chunkByteSize := 0
+	rejectedCount := 0
 	emptyLines := 0
 
+	// Rejected lines are only written to the dead letter path once the chunk read with them is uploaded
+	rejected := make([][]byte, 0)
+	rejectedLines := make([]int, 0)
+	deadLetter := &deadLetterWriter{path: l.config.DeadLetterPath}
+	defer deadLetter.close()
+
+	commitRejected := func() error {
+		for i, line := range rejected {
+			err := deadLetter.write(line)
+			if err != nil {
+				return err
+			}
+			attempt.handled[rejectedLines[i]] = true
+			rejectedCount++
+		}
+		rejected = make([][]byte, 0)
+		rejectedLines = make([]int, 0)
+		return nil
+	}
+
+	uploadChunk := func() error {
+		log.Debugf("uploading %d bytes of data (%d log entries)", chunkByteSize, len(chunk))
+		err := l.uploadWithRetry(chunk)
+		if err != nil {
+			return fmt.Errorf("issue uploading logs to log analytics: %s", err)
+		}
+
+		for _, lineNumber := range chunkLines {
+			attempt.handled[lineNumber] = true
+		}
+		attempt.count += len(chunk)
+		chunk = make([]json.RawMessage, 0)
+		chunkLines = make([]int, 0)
+		chunkByteSize = 0
+		return commitRejected()
+	}
+
 	// Open file
 	file, err := os.Open(inputFile)
 	if err != nil {
-		return 0, err
+		return attempt, err
 	}
 	defer file.Close()
 
@@ -84,72 +207,136 @@ func (l *logAnalyticsOutput) Write(inputFile string) (int, error) {
 	scanner := bufio.NewScanner(file)
 	buffer := make([]byte, 0, core.MaxLogSize)
 	scanner.Buffer(buffer, core.MaxLogSize)
-	for scanner.Scan() {
-		line := scanner.Text()
-		trimmedLine := strings.TrimSpace(line)
-
-		if trimmedLine != "" {
-			var tmpLog interface{}
-			var tmpLogBytes []byte
-
-			// If the value is a valid json, serialize it, if not, set as message to default type
-			if json.Valid([]byte(trimmedLine)) {
-				err = json.Unmarshal([]byte(trimmedLine), &tmpLog)
-				if err != nil {
-					return 0, fmt.Errorf("issue unmarshalling line: %s", err)
-				}
-			} else {
-				tmpLog = logAnalyticsDefaultLog{
-					Message: trimmedLine,
-				}
-			}
+	for lineNumber := 0; scanner.Scan(); lineNumber++ {
+		// Skip lines handled by an earlier attempt
+		if attempt.handled[lineNumber] {
+			continue
+		}
 
-			tmpLogBytes, err = json.Marshal(tmpLog)
-			if err != nil {
-				return 0, fmt.Errorf("issue marshalling log: %s", err)
+		trimmedLine := bytes.TrimSpace(scanner.Bytes())
+		if len(trimmedLine) == 0 {
+			attempt.handled[lineNumber] = true
+			emptyLines++
+			continue
+		}
+
+		// Records over the limits are rejected, along with records that do not fit in a request on their own
+		record, err := l.limits.prepareRecord(trimmedLine)
+		if err == nil && len(record)+2 > l.maxRequestBytes() {
+			err = fmt.Errorf("record is %d bytes, more than the request limit of %d", len(record), l.maxRequestBytes()-2)
+		}
+		if err != nil {
+			if l.config.DeadLetterPath == "" {
+				log.Warnf("dropping log entry: %s", err)
+				attempt.handled[lineNumber] = true
+				rejectedCount++
+				continue
 			}
 
-			if uploadBufferByteSize+len(tmpLogBytes) >= (25 * 1024 * 1024) {
-				log.Debugf("buffer limit reached, uploading 25MB worth of data (%d log entries)", lineCount)
-				lineCount = 1
-
-				// Do upload
-				err = logAnalyticsUpload(uploadBuffer, l.config.LogType, l.config.WorkspaceID, l.config.PrimaryKey, l.config.DateField)
-				if err != nil {
-					return 0, fmt.Errorf("issue uploading log: %s", err)
-				}
-
-				// Clear upload buffer and add new line
-				uploadBuffer = make([]interface{}, 0)
-				uploadBuffer = append(uploadBuffer, tmpLog)
-
-				// Reset upload buffer byte size
-				uploadBufferByteSize = len(tmpLogBytes)
-			} else {
-				lineCount++
-				uploadBufferByteSize += len(tmpLogBytes)
-				uploadBuffer = append(uploadBuffer, tmpLog)
+			// Copy the line, which is the scanner's buffer
+			log.Debugf("writing log entry to dead letter path: %s", err)
+			rejected = append(rejected, append([]byte(nil), trimmedLine...))
+			rejectedLines = append(rejectedLines, lineNumber)
+			continue
+		}
+
+		// The array adds a comma between each log and the surrounding brackets to the request size
+		if len(chunk) > 0 && chunkByteSize+len(record)+len(chunk)+2 > l.maxRequestBytes() {
+			err = uploadChunk()
+			if err != nil {
+				return attempt, err
 			}
-		} else {
-			emptyLines++
 		}
+
+		chunk = append(chunk, record)
+		chunkLines = append(chunkLines, lineNumber)
+		chunkByteSize += len(record)
+	}
+
+	// Lines longer than the maximum log size stop the scanner
+	if err = scanner.Err(); err != nil {
+		return attempt, fmt.Errorf("issue reading results: %s", err)
 	}
 
 	// Upload any remaining data
-	if len(uploadBuffer) > 0 {
-		log.Debugf("uploading remaining buffer data (%d log entries)", lineCount)
-		err = logAnalyticsUpload(uploadBuffer, l.config.LogType, l.config.WorkspaceID, l.config.PrimaryKey, l.config.DateField)
-		if err != nil {
-			return 0, fmt.Errorf("issue uploading logs to log analytics: %s", err)
-		}
+	if len(chunk) > 0 {
+		err = uploadChunk()
+	} else {
+		err = commitRejected()
+	}
+	if err != nil {
+		return attempt, err
 	}
 
-	// Debug print with empty line count
+	// Debug print with empty line and rejected counts
 	if emptyLines > 0 {
 		log.Debugf("ignored %d empty log entries", emptyLines)
 	}
+	if rejectedCount > 0 {
+		log.Warnf("rejected %d log entries over the log analytics limits", rejectedCount)
+	}
 
-	return lineCount, nil
+	return attempt, nil
+}
+
+// upload sends the logs with the API of the configured mode
+func (l *logAnalyticsOutput) upload(data []json.RawMessage) error {
+	if l.config.Mode == modeLogsIngestion {
+		return l.logsIngestionUpload(data)
+	}
+
+	return logAnalyticsUpload(data, l.config.LogType, l.config.WorkspaceID, l.config.PrimaryKey, l.config.DateField, l.config.EndpointSuffix)
+}
+
+// maxRequestBytes is the largest request the API of the configured mode accepts
+func (l *logAnalyticsOutput) maxRequestBytes() int {
+	if l.config.Mode == modeLogsIngestion {
+		return logsIngestionMaxBytes
+	}
+
+	return dataCollectorMaxBytes
+}
+
+// validateConfig checks that the settings needed by the mode are set
+func validateConfig(conf Config) error {
+	if conf.EndpointSuffix == "" {
+		return fmt.Errorf("endpoint_suffix cannot be empty")
+	}
+
+	if conf.Mode == modeLogsIngestion {
+		if conf.DataCollectionEndpoint == "" || conf.DcrImmutableID == "" || conf.StreamName == "" || conf.TenantID == "" {
+			return fmt.Errorf("data_collection_endpoint, dcr_immutable_id, stream_name and tenant_id are required in logs_ingestion mode")
+		}
+		return nil
+	}
+
+	if conf.LogType == "" || conf.WorkspaceID == "" || conf.PrimaryKey == "" {
+		return fmt.Errorf("log_type, workspace_id and primary_key are required in data_collector mode")
+	}
+
+	return nil
+}
+
+// newRecordLimits returns the configured field limits, or the limits of the API
+func newRecordLimits(conf Config) recordLimits {
+	limits := recordLimits{
+		maxFields:     conf.MaxFields,
+		maxFieldBytes: conf.MaxFieldBytes,
+		truncate:      conf.OversizedRecords != oversizedReject,
+	}
+
+	if limits.maxFields == 0 {
+		limits.maxFields = maxFields
+	}
+
+	if limits.maxFieldBytes == 0 {
+		limits.maxFieldBytes = dataCollectorMaxFieldBytes
+		if conf.Mode == modeLogsIngestion {
+			limits.maxFieldBytes = logsIngestionMaxFieldBytes
+		}
+	}
+
+	return limits
 }
 
 func logAnalyticsBuildSignature(message, secret string) (string, error) {
@@ -163,7 +350,7 @@ func logAnalyticsBuildSignature(message, secret string) (string, error) {
 	return base64.StdEncoding.EncodeToString(mac.Sum(nil)), nil
 }
 
-func logAnalyticsUpload(data []interface{}, logName, workspaceID, key, dateField string) error {
+func logAnalyticsUpload(data []json.RawMessage, logName, workspaceID, key, dateField, endpointSuffix string) error {
 	// Marshal data
 	dataBytes, err := json.Marshal(data)
 	if err != nil {
@@ -181,7 +368,7 @@ func logAnalyticsUpload(data []interface{}, logName, workspaceID, key, dateField
 	}
 
 	signature := fmt.Sprintf("SharedKey %s:%s", workspaceID, hashedString)
-	uri := fmt.Sprintf("https://%s.ods.opinsights.azure.com/api/logs?api-version=2016-04-01", workspaceID)
+	uri := fmt.Sprintf("https://%s.ods.opinsights.%s/api/logs?api-version=2016-04-01", workspaceID, endpointSuffix)
 
 	request := resty.New().SetRetryCount(3).R()
 	request.SetHeader("Log-Type", logName)
@@ -200,9 +387,5 @@ func logAnalyticsUpload(data []interface{}, logName, workspaceID, key, dateField
 	}
 
 	// Handle response error
-	if response.IsError() {
-		return fmt.Errorf("response returned: %s", response.Status())
-	}
-
-	return nil
+	return responseError(response)
 }
@@ -2,19 +2,39 @@ package log_analytics
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/ThoronicLLC/collector/pkg/core"
 	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 var config1 = `{"log_type": "GenericLog", "workspace_id": "1234567890", "primary_key": "1234567890", "date_field": "TimeGenerated"}`
 var config2 = `{"log_type": "GenericLog", "workspace_id": "1234567890", "primary_key": "1234567890"}`
+var config3 = `{"mode": "logs_ingestion", "data_collection_endpoint": "https://collector-dce.eastus-1.ingest.monitor.azure.com", "dcr_immutable_id": "dcr-1234567890", "stream_name": "Custom-GenericLog_CL", "tenant_id": "tenant-1", "client_id": "client-1", "client_secret": "1234567890"}`
+var config4 = `{"mode": "logs_ingestion", "data_collection_endpoint": "https://collector-dce.eastus-1.ingest.monitor.azure.com", "dcr_immutable_id": "dcr-1234567890", "stream_name": "Custom-GenericLog_CL", "tenant_id": "tenant-1", "managed_identity": true, "max_retries": 0}`
+var config5 = `{"log_type": "GenericLog", "workspace_id": "1234567890", "primary_key": "1234567890", "endpoint_suffix": "azure.us", "max_fields": 100, "max_field_bytes": 1024, "oversized_records": "reject", "dead_letter_path": "/tmp/log_analytics_rejected.log"}`
 var badConfig1 = `{"log_type": "", "workspace_id": "1234567890", "primary_key": "1234567890", "date_field": "TimeGenerated"}`
 var badConfig2 = `{"log_type": "GenericLog", "workspace_id": "", "primary_key": "1234567890", "date_field": "TimeGenerated"}`
 var badConfig3 = `{"log_type": "GenericLog", "workspace_id": "1234567890", "primary_key": "", "date_field": "TimeGenerated"}`
+var badConfig4 = `{"mode": "logs_ingestion", "data_collection_endpoint": "", "dcr_immutable_id": "dcr-1234567890", "stream_name": "Custom-GenericLog_CL", "tenant_id": "tenant-1", "client_id": "client-1", "client_secret": "1234567890"}`
+var badConfig5 = `{"mode": "logs_ingestion", "data_collection_endpoint": "https://collector-dce.eastus-1.ingest.monitor.azure.com", "dcr_immutable_id": "", "stream_name": "Custom-GenericLog_CL", "tenant_id": "tenant-1", "client_id": "client-1", "client_secret": "1234567890"}`
+var badConfig6 = `{"mode": "logs_ingestion", "data_collection_endpoint": "https://collector-dce.eastus-1.ingest.monitor.azure.com", "dcr_immutable_id": "dcr-1234567890", "stream_name": "", "tenant_id": "tenant-1", "client_id": "client-1", "client_secret": "1234567890"}`
+var badConfig7 = `{"mode": "http", "log_type": "GenericLog", "workspace_id": "1234567890", "primary_key": "1234567890"}`
+var badConfig8 = `{"mode": "logs_ingestion", "data_collection_endpoint": "https://collector-dce.eastus-1.ingest.monitor.azure.com", "dcr_immutable_id": "dcr-1234567890", "stream_name": "Custom-GenericLog_CL", "tenant_id": "tenant-1", "client_id": "client-1"}`
+var badConfig9 = `{"mode": "logs_ingestion", "data_collection_endpoint": "collector-dce", "dcr_immutable_id": "dcr-1234567890", "stream_name": "Custom-GenericLog_CL", "tenant_id": "tenant-1", "client_id": "client-1", "client_secret": "1234567890"}`
+var badConfig10 = `{"log_type": "GenericLog", "workspace_id": "1234567890", "primary_key": "1234567890", "oversized_records": "split"}`
+var badConfig11 = `{"log_type": "GenericLog", "workspace_id": "1234567890", "primary_key": "1234567890", "max_fields": -1}`
+var badConfig12 = `{"log_type": "GenericLog", "workspace_id": "1234567890", "primary_key": "1234567890", "endpoint_suffix": ""}`
 
 func TestValidate(t *testing.T) {
-	arr := []string{config1, config2}
+	arr := []string{config1, config2, config3, config4, config5}
 	for i, v := range arr {
 		var testConfig Config
 		err := json.Unmarshal([]byte(v), &testConfig)
@@ -25,7 +45,7 @@ func TestValidate(t *testing.T) {
 }
 
 func TestValidateFailed(t *testing.T) {
-	arr := []string{badConfig1, badConfig2, badConfig3}
+	arr := []string{badConfig7, badConfig10, badConfig11}
 	for i, v := range arr {
 		var testConfig Config
 		err := json.Unmarshal([]byte(v), &testConfig)
@@ -36,7 +56,7 @@ func TestValidateFailed(t *testing.T) {
 }
 
 func TestHandler(t *testing.T) {
-	arr := []string{config1, config2}
+	arr := []string{config1, config2, config3, config4, config5}
 	for i, v := range arr {
 		var testConfig Config
 		err := json.Unmarshal([]byte(v), &testConfig)
@@ -48,7 +68,7 @@ func TestHandler(t *testing.T) {
 }
 
 func TestHandlerFailed(t *testing.T) {
-	arr := []string{badConfig1, badConfig2, badConfig3}
+	arr := []string{badConfig1, badConfig2, badConfig3, badConfig4, badConfig5, badConfig6, badConfig7, badConfig8, badConfig9, badConfig10, badConfig11, badConfig12}
 	for i, v := range arr {
 		var testConfig Config
 		err := json.Unmarshal([]byte(v), &testConfig)
@@ -58,3 +78,220 @@ func TestHandlerFailed(t *testing.T) {
 		assert.NotNilf(t, err, "test #%d - validation should have returned an error: %s", i, err)
 	}
 }
+
+func TestLogsIngestionWrite(t *testing.T) {
+	attempts := 0
+	bodies := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Token requests
+		if r.URL.Path == "/tenant-1/oauth2/v2.0/token" {
+			_ = r.ParseForm()
+			assert.Equal(t, "https://monitor.azure.com/.default", r.PostForm.Get("scope"))
+			_, _ = fmt.Fprint(w, `{"token_type": "Bearer", "expires_in": 3599, "access_token": "token"}`)
+			return
+		}
+
+		assert.Equal(t, "/dataCollectionRules/dcr-1/streams/Custom-GenericLog_CL", r.URL.Path)
+		assert.Equal(t, "2023-01-01", r.URL.Query().Get("api-version"))
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+
+		// The first request is throttled
+		attempts++
+		if attempts == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	config := fmt.Sprintf(`{"mode": "logs_ingestion", "data_collection_endpoint": "%s", "dcr_immutable_id": "dcr-1", "stream_name": "Custom-GenericLog_CL", "tenant_id": "tenant-1", "client_id": "client-1", "client_secret": "secret", "login_endpoint": "%s"}`, server.URL, server.URL)
+	output, err := Handler()([]byte(config))
+	assert.Nil(t, err)
+
+	inputFile := filepath.Join(t.TempDir(), "results.log")
+	assert.Nil(t, os.WriteFile(inputFile, []byte("{\"action\": \"login\"}\nplain text\n"), 0600))
+
+	_, err = output.Write(inputFile)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, attempts)
+	assert.Equal(t, []string{`[{"action":"login"},{"message":"plain text"}]`}, bodies)
+}
+
+func TestRetryAfter(t *testing.T) {
+	assert.Equal(t, 10*time.Second, retryAfter("10", 0))
+	assert.Equal(t, time.Duration(0), retryAfter(time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), 0))
+	assert.Equal(t, retryWait, retryAfter("", 0))
+	assert.Equal(t, 4*retryWait, retryAfter("", 2))
+	assert.Equal(t, maxRetryWait, retryAfter("", 40))
+}
+
+func TestWriteRetriesFailedChunk(t *testing.T) {
+	// Each request fits two records, and the second request fails once
+	requests := 0
+	bodies := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/tenant-1/oauth2/v2.0/token" {
+			_, _ = fmt.Fprint(w, `{"token_type": "Bearer", "expires_in": 3599, "access_token": "token"}`)
+			return
+		}
+
+		requests++
+		if requests == 2 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	config := fmt.Sprintf(`{"mode": "logs_ingestion", "data_collection_endpoint": "%s", "dcr_immutable_id": "dcr-1", "stream_name": "Custom-GenericLog_CL", "tenant_id": "tenant-1", "client_id": "client-1", "client_secret": "secret", "login_endpoint": "%s", "max_field_bytes": 1048576}`, server.URL, server.URL)
+	output, err := Handler()([]byte(config))
+	assert.Nil(t, err)
+
+	lines := ""
+	for i := 1; i <= 5; i++ {
+		lines += fmt.Sprintf("{\"id\": %d, \"message\": \"%s\"}\n\n", i, strings.Repeat("a", 400*1024))
+	}
+	inputFile := filepath.Join(t.TempDir(), "results.log")
+	assert.Nil(t, os.WriteFile(inputFile, []byte(lines), 0600))
+
+	// The failed chunk is sent again without sending the others twice
+	count, err := output.Write(inputFile)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, count)
+	assert.Equal(t, 4, requests)
+	assert.Equal(t, 3, len(bodies))
+	assert.True(t, strings.HasPrefix(bodies[0], `[{"id":1,`))
+	assert.True(t, strings.HasPrefix(bodies[1], `[{"id":3,`))
+	assert.True(t, strings.HasPrefix(bodies[2], `[{"id":5,`))
+}
+
+func TestWriteResume(t *testing.T) {
+	// Each request fits two records, and the second request is rejected until the file is written again
+	failed := true
+	requests := 0
+	bodies := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/tenant-1/oauth2/v2.0/token" {
+			_, _ = fmt.Fprint(w, `{"token_type": "Bearer", "expires_in": 3599, "access_token": "token"}`)
+			return
+		}
+
+		requests++
+		if failed && requests == 2 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	config := fmt.Sprintf(`{"mode": "logs_ingestion", "data_collection_endpoint": "%s", "dcr_immutable_id": "dcr-1", "stream_name": "Custom-GenericLog_CL", "tenant_id": "tenant-1", "client_id": "client-1", "client_secret": "secret", "login_endpoint": "%s", "max_field_bytes": 1048576}`, server.URL, server.URL)
+	output, err := Handler()([]byte(config))
+	assert.Nil(t, err)
+
+	lines := ""
+	for i := 1; i <= 5; i++ {
+		lines += fmt.Sprintf("{\"id\": %d, \"message\": \"%s\"}\n\n", i, strings.Repeat("a", 400*1024))
+	}
+	inputFile := filepath.Join(t.TempDir(), "results.log")
+	assert.Nil(t, os.WriteFile(inputFile, []byte(lines), 0600))
+
+	count, err := output.Write(inputFile)
+	assert.NotNil(t, err)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, 1, len(bodies))
+
+	// Writing the file again does not post the first chunk twice
+	failed = false
+	count, err = output.Write(inputFile)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, count)
+	assert.Equal(t, 3, len(bodies))
+	assert.True(t, strings.HasPrefix(bodies[0], `[{"id":1,`))
+	assert.True(t, strings.HasPrefix(bodies[1], `[{"id":3,`))
+	assert.True(t, strings.HasPrefix(bodies[2], `[{"id":5,`))
+}
+
+func TestRecordLimits(t *testing.T) {
+	limits := recordLimits{maxFields: 2, maxFieldBytes: 8, truncate: true}
+
+	// Fields past the limit are removed in name order and long values truncated
+	record, err := limits.prepareRecord([]byte(`{"c": 1, "b": "0123456789", "a": {"nested": true}}`))
+	assert.Nil(t, err)
+	assert.Equal(t, `{"a":"{\"nested","b":"01234567"}`, string(record))
+
+	// Lines that are not JSON are sent as the message
+	record, err = limits.prepareRecord([]byte(`plain text`))
+	assert.Nil(t, err)
+	assert.Equal(t, `{"message":"plain te"}`, string(record))
+
+	// Characters are not split
+	assert.Equal(t, "ab", truncateString("ab\u00e9", 3))
+
+	// Records over the limits are rejected
+	limits.truncate = false
+	_, err = limits.prepareRecord([]byte(`{"a": 1, "b": 2, "c": 3}`))
+	assert.NotNil(t, err)
+	_, err = limits.prepareRecord([]byte(`{"a": "0123456789"}`))
+	assert.NotNil(t, err)
+	_, err = limits.prepareRecord([]byte(`{"a": "01234567"}`))
+	assert.Nil(t, err)
+}
+
+func TestWriteDeadLetter(t *testing.T) {
+	failed := true
+	bodies := make([]string, 0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/tenant-1/oauth2/v2.0/token" {
+			_, _ = fmt.Fprint(w, `{"token_type": "Bearer", "expires_in": 3599, "access_token": "token"}`)
+			return
+		}
+
+		if failed {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	deadLetterPath := filepath.Join(t.TempDir(), "rejected.log")
+	config := fmt.Sprintf(`{"mode": "logs_ingestion", "data_collection_endpoint": "%s", "dcr_immutable_id": "dcr-1", "stream_name": "Custom-GenericLog_CL", "tenant_id": "tenant-1", "client_id": "client-1", "client_secret": "secret", "login_endpoint": "%s", "max_field_bytes": 16, "oversized_records": "reject", "dead_letter_path": "%s"}`, server.URL, server.URL, deadLetterPath)
+	output, err := Handler()([]byte(config))
+	assert.Nil(t, err)
+
+	inputFile := filepath.Join(t.TempDir(), "results.log")
+	assert.Nil(t, os.WriteFile(inputFile, []byte("{\"id\": 1}\n{\"id\": 2, \"message\": \"longer than sixteen bytes\"}\n{\"id\": 3}\n"), 0600))
+
+	// Rejected lines are not written until the records read with them are uploaded
+	_, err = output.Write(inputFile)
+	assert.NotNil(t, err)
+	_, err = os.Stat(deadLetterPath)
+	assert.True(t, os.IsNotExist(err))
+
+	failed = false
+	count, err := output.Write(inputFile)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, []string{`[{"id":1},{"id":3}]`}, bodies)
+
+	rejected, err := os.ReadFile(deadLetterPath)
+	assert.Nil(t, err)
+	assert.Equal(t, "{\"id\": 2, \"message\": \"longer than sixteen bytes\"}\n", string(rejected))
+}
@@ -0,0 +1,83 @@
+package log_analytics
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ThoronicLLC/collector/internal/integrations/msgraph"
+	"github.com/go-resty/resty/v2"
+	"net/url"
+	"strings"
+)
+
+const (
+	// logsIngestionMaxBytes is the largest request the logs ingestion API accepts
+	//
+	// https://learn.microsoft.com/en-us/azure/azure-monitor/service-limits#logs-ingestion-api
+	logsIngestionMaxBytes = 1024 * 1024
+	logsIngestionVersion  = "2023-01-01"
+)
+
+// newIngestionClient creates the Entra ID client used to get tokens for the logs ingestion API
+func newIngestionClient(conf Config) (*msgraph.Client, error) {
+	endpoint, err := url.Parse(conf.DataCollectionEndpoint)
+	if err != nil || endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("data_collection_endpoint must be an absolute URL")
+	}
+
+	// Sovereign clouds have their own monitor scope
+	scope := msgraph.MonitorScope.String()
+	if conf.EndpointSuffix != defaultEndpointSuffix {
+		scope = fmt.Sprintf("https://monitor.%s/.default", conf.EndpointSuffix)
+	}
+
+	client, err := msgraph.NewClientWithCredentials(conf.TenantID, conf.ClientID, msgraph.Credentials{
+		ClientSecret:        conf.ClientSecret,
+		CertificatePath:     conf.CertificatePath,
+		CertificatePassword: conf.CertificatePassword,
+		FederatedTokenFile:  conf.FederatedTokenFile,
+		ManagedIdentity:     conf.ManagedIdentity,
+	}, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	// Setup other MS login endpoint
+	if conf.LoginEndpoint != "" {
+		err = client.SetLoginEndpoint(conf.LoginEndpoint)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return client, nil
+}
+
+// logsIngestionUpload posts the logs to the stream of the data collection rule. Throttled requests and server errors
+// return a retryableError.
+func (l *logAnalyticsOutput) logsIngestionUpload(data []json.RawMessage) error {
+	// Marshal data
+	dataBytes, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	uri := fmt.Sprintf("%s/dataCollectionRules/%s/streams/%s?api-version=%s", strings.TrimSuffix(l.config.DataCollectionEndpoint, "/"),
+		url.PathEscape(l.config.DcrImmutableID), url.PathEscape(l.config.StreamName), logsIngestionVersion)
+
+	token, err := l.client.Token()
+	if err != nil {
+		return fmt.Errorf("issue getting access token: %s", err)
+	}
+
+	response, err := resty.New().R().
+		SetContext(l.ctx).
+		SetHeader("Authorization", fmt.Sprintf("Bearer %s", token)).
+		SetHeader("Content-Type", "application/json").
+		SetBody(dataBytes).
+		Post(uri)
+	if err != nil {
+		return err
+	}
+
+	return responseError(response)
+}
@@ -0,0 +1,140 @@
+package log_analytics
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"unicode/utf8"
+)
+
+const (
+	oversizedTruncate = "truncate"
+	oversizedReject   = "reject"
+
+	// maxFields is the most columns a record can have
+	maxFields = 500
+
+	// Largest field values each API stores
+	dataCollectorMaxFieldBytes = 32 * 1024
+	logsIngestionMaxFieldBytes = 64 * 1024
+)
+
+// recordLimits are the field limits applied to each record before it is uploaded
+type recordLimits struct {
+	maxFields     int
+	maxFieldBytes int
+	truncate      bool
+}
+
+// prepareRecord converts the line to a JSON record within the limits. Lines that are not JSON are sent as the
+// message field. Records over the limits are truncated, or return an error when they should be rejected.
+func (r recordLimits) prepareRecord(line []byte) (json.RawMessage, error) {
+	var record interface{}
+	if json.Valid(line) {
+		err := json.Unmarshal(line, &record)
+		if err != nil {
+			return nil, fmt.Errorf("issue unmarshalling line: %s", err)
+		}
+	} else {
+		record = map[string]interface{}{"message": string(line)}
+	}
+
+	// Only the fields of objects are limited
+	if fields, ok := record.(map[string]interface{}); ok {
+		err := r.limitFields(fields)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(record)
+}
+
+// limitFields removes the fields past the maximum number, in name order, and truncates values over the maximum
+// size. Values that are not strings are truncated as their JSON string.
+func (r recordLimits) limitFields(fields map[string]interface{}) error {
+	if len(fields) > r.maxFields {
+		if !r.truncate {
+			return fmt.Errorf("record has %d fields, more than the limit of %d", len(fields), r.maxFields)
+		}
+
+		names := make([]string, 0, len(fields))
+		for name := range fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names[r.maxFields:] {
+			delete(fields, name)
+		}
+	}
+
+	for name, value := range fields {
+		stringValue, ok := value.(string)
+		if !ok {
+			if value == nil {
+				continue
+			}
+
+			jsonValue, err := json.Marshal(value)
+			if err != nil {
+				return fmt.Errorf("issue marshalling field %s: %s", name, err)
+			}
+			stringValue = string(jsonValue)
+		}
+
+		if len(stringValue) <= r.maxFieldBytes {
+			continue
+		}
+
+		if !r.truncate {
+			return fmt.Errorf("field %s is %d bytes, more than the limit of %d", name, len(stringValue), r.maxFieldBytes)
+		}
+		fields[name] = truncateString(stringValue, r.maxFieldBytes)
+	}
+
+	return nil
+}
+
+// truncateString shortens the string to at most the number of bytes without splitting a character
+func truncateString(value string, maxBytes int) string {
+	if len(value) <= maxBytes {
+		return value
+	}
+
+	value = value[:maxBytes]
+	for len(value) > 0 && !utf8.ValidString(value) {
+		value = value[:len(value)-1]
+	}
+	return value
+}
+
+// deadLetterWriter appends rejected lines to the dead letter file, which is only opened once a line is rejected
+type deadLetterWriter struct {
+	path string
+	file *os.File
+}
+
+func (d *deadLetterWriter) write(line []byte) error {
+	if d.file == nil {
+		file, err := os.OpenFile(d.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return fmt.Errorf("issue opening dead letter file: %s", err)
+		}
+		d.file = file
+	}
+
+	entry := make([]byte, 0, len(line)+1)
+	_, err := d.file.Write(append(append(entry, line...), '\n'))
+	if err != nil {
+		return fmt.Errorf("issue writing to dead letter file: %s", err)
+	}
+
+	return nil
+}
+
+func (d *deadLetterWriter) close() {
+	if d.file != nil {
+		_ = d.file.Close()
+	}
+}
@@ -0,0 +1,90 @@
+package log_analytics
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-resty/resty/v2"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// Wait between retries of failed uploads without a Retry-After header
+	retryWait    = 2 * time.Second
+	maxRetryWait = 60 * time.Second
+)
+
+// retryableError is an upload that was throttled or failed on the server and can be sent again
+type retryableError struct {
+	err        error
+	retryAfter string
+}
+
+func (e *retryableError) Error() string {
+	return e.err.Error()
+}
+
+// responseError returns the error of a failed response, which can be retried for throttled requests and server
+// errors
+func responseError(response *resty.Response) error {
+	if !response.IsError() {
+		return nil
+	}
+
+	err := fmt.Errorf("response returned: %s: %s", response.Status(), response.String())
+	status := response.StatusCode()
+	if status != http.StatusTooManyRequests && status < http.StatusInternalServerError {
+		return err
+	}
+
+	return &retryableError{err: err, retryAfter: response.Header().Get("Retry-After")}
+}
+
+// uploadWithRetry uploads the chunk, retrying throttled requests and server errors after the time in the
+// Retry-After header or an increasing wait
+func (l *logAnalyticsOutput) uploadWithRetry(data []json.RawMessage) error {
+	for attempt := 0; ; attempt++ {
+		err := l.upload(data)
+		var retryable *retryableError
+		if err == nil || !errors.As(err, &retryable) {
+			return err
+		}
+
+		if attempt >= l.config.MaxRetries {
+			return fmt.Errorf("%s after %d retries", err, attempt)
+		}
+
+		wait := retryAfter(retryable.retryAfter, attempt)
+		log.Debugf("log analytics upload failed: %s, retrying in %s", err, wait)
+		select {
+		case <-l.ctx.Done():
+			return l.ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// retryAfter returns the wait from a Retry-After header, in seconds or as a date, or doubles the wait for each
+// attempt when the header is not set
+func retryAfter(header string, attempt int) time.Duration {
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(header); err == nil {
+		wait := time.Until(date)
+		if wait < 0 {
+			return 0
+		}
+		return wait
+	}
+
+	wait := retryWait << attempt
+	if wait <= 0 || wait > maxRetryWait {
+		return maxRetryWait
+	}
+	return wait
+}
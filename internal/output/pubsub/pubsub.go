@@ -6,11 +6,15 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/ThoronicLLC/collector/internal/integrations/gcp"
 	"github.com/ThoronicLLC/collector/pkg/core"
 	log "github.com/sirupsen/logrus"
-	"google.golang.org/api/option"
+	"github.com/tidwall/gjson"
+	"google.golang.org/grpc"
 	"os"
 	"strings"
+	"sync"
+	"time"
 )
 
 var OutputName = "pubsub"
@@ -20,17 +24,70 @@ type Config struct {
 	TopicID         string          `json:"topic_id" validate:"required"`
 	Credentials     json.RawMessage `json:"credentials,omitempty"`
 	CredentialsPath string          `json:"credentials_path"`
+
+	// OrderingKeyField is the JSON path of the ordering key. Message ordering is enabled on the topic when it is set.
+	OrderingKeyField string `json:"ordering_key_field"`
+
+	// Attributes are added to every message. Attribute fields map an attribute name to the JSON path of its value.
+	Attributes      map[string]string `json:"attributes"`
+	AttributeFields map[string]string `json:"attribute_fields"`
+
+	// Messages are published in batches once any threshold is reached. Publishing blocks once the outstanding
+	// messages or bytes are reached, where a negative number removes the limit.
+	DelayThresholdMs       int `json:"delay_threshold_ms" validate:"min:1"`
+	CountThreshold         int `json:"count_threshold" validate:"min:1"`
+	ByteThreshold          int `json:"byte_threshold" validate:"min:1"`
+	NumGoroutines          int `json:"num_goroutines" validate:"min:0"`
+	Timeout                int `json:"timeout" validate:"min:1"` // Publish timeout in seconds
+	MaxOutstandingMessages int `json:"max_outstanding_messages" validate:"min:-1"`
+	MaxOutstandingBytes    int `json:"max_outstanding_bytes" validate:"min:-1"`
+
+	// EmulatorHost connects to the Pub/Sub emulator without credentials. Defaults to PUBSUB_EMULATOR_HOST.
+	EmulatorHost string `json:"emulator_host"`
 }
 
 type pubSubOutput struct {
 	config Config
 	ctx    context.Context
+
+	// The client and topic are created on the first write and reused, so batches are shared across writes
+	mu     sync.Mutex
+	client *pubsub.Client
+	conn   *grpc.ClientConn
+	topic  *pubsub.Topic
+
+	// Lines of files that failed to publish which were published, so retries only publish the rest
+	progress core.WriteProgress
+}
+
+// pendingPublish is a published message waiting on its result
+type pendingPublish struct {
+	result      *pubsub.PublishResult
+	orderingKey string
+	lineNumber  int
+}
+
+// publishSummary is the outcome of the messages published from a file
+type publishSummary struct {
+	published    int
+	failed       int
+	firstErr     error
+	orderingKeys map[string]bool
+	handled      map[int]bool
 }
 
 func Handler() core.OutputHandler {
 	return func(config []byte) (core.Output, error) {
 		// Set config defaults
-		var conf Config
+		conf := Config{
+			DelayThresholdMs:       10,
+			CountThreshold:         100,
+			ByteThreshold:          1e6,
+			Timeout:                60,
+			MaxOutstandingMessages: 1000,
+			MaxOutstandingBytes:    -1,
+			EmulatorHost:           os.Getenv("PUBSUB_EMULATOR_HOST"),
+		}
 
 		// Unmarshal config
 		err := json.Unmarshal(config, &conf)
@@ -44,10 +101,12 @@ func Handler() core.OutputHandler {
 			return nil, err
 		}
 
-		// Validate credentials
-		err = validateCredentialsOrPath(conf.Credentials, conf.CredentialsPath)
-		if err != nil {
-			return nil, err
+		// Validate credentials, which the emulator does not need
+		if conf.EmulatorHost == "" {
+			err = validateCredentialsOrPath(conf.Credentials, conf.CredentialsPath)
+			if err != nil {
+				return nil, err
+			}
 		}
 
 		return &pubSubOutput{
@@ -57,25 +116,14 @@ func Handler() core.OutputHandler {
 	}
 }
 
+// Write publishes each line of the results as a message. When publishing fails, the lines that were published are
+// remembered so retrying the file only publishes the messages that failed.
 func (p *pubSubOutput) Write(inputFile string) (int, error) {
-	// Setup new client
-	opts := make([]option.ClientOption, 0)
-	if p.config.Credentials != nil && len(p.config.Credentials) > 0 && string(p.config.Credentials) != "null" {
-		opts = append(opts, option.WithCredentialsJSON(p.config.Credentials))
-	} else if p.config.CredentialsPath != "" {
-		opts = append(opts, option.WithCredentialsFile(p.config.CredentialsPath))
-	}
-
-	// Setup PubSub client
-	client, err := pubsub.NewClient(p.ctx, p.config.ProjectID, opts...)
+	topic, err := p.getTopic()
 	if err != nil {
-		return 0, fmt.Errorf("issue setting up pub sub client: %s", err)
+		return 0, err
 	}
-
-	// Setup line variables
-	lineCount := 0
-	emptyLines := 0
-	topicClient := client.Topic(p.config.TopicID)
+	handled := p.progress.Handled(inputFile)
 
 	// Open file
 	file, err := os.Open(inputFile)
@@ -84,11 +132,26 @@ func (p *pubSubOutput) Write(inputFile string) (int, error) {
 	}
 	defer file.Close()
 
+	// Collect the publish results while the file is read so the results do not build up in memory
+	results := make(chan pendingPublish, p.config.CountThreshold)
+	summaryCh := make(chan publishSummary)
+	go func() {
+		summaryCh <- p.collectResults(results, handled)
+	}()
+
+	// Setup line variables
+	emptyLines := 0
+
 	// Start reading from the file with a reader.
 	scanner := bufio.NewScanner(file)
 	buffer := make([]byte, 0, core.MaxLogSize)
 	scanner.Buffer(buffer, core.MaxLogSize)
-	for scanner.Scan() {
+	for lineNumber := 0; scanner.Scan(); lineNumber++ {
+		// Skip lines published by an earlier attempt
+		if handled[lineNumber] {
+			continue
+		}
+
 		line := scanner.Text()
 		trimmedLine := strings.TrimSpace(line)
 
@@ -98,27 +161,146 @@ func (p *pubSubOutput) Write(inputFile string) (int, error) {
 			continue
 		}
 
-		// Setup messages
-		msg := &pubsub.Message{
-			Data: []byte(trimmedLine),
+		// Publish is asynchronous, batching messages until a threshold is reached
+		msg := p.message(trimmedLine)
+		results <- pendingPublish{result: topic.Publish(p.ctx, msg), orderingKey: msg.OrderingKey, lineNumber: lineNumber}
+	}
+	close(results)
+	summary := <-summaryCh
+
+	// Debug print with empty line count
+	if emptyLines > 0 {
+		log.Debugf("ignored %d empty log entries", emptyLines)
+	}
+
+	// Publishing stops for an ordering key after a failure until it is resumed
+	for key := range summary.orderingKeys {
+		topic.ResumePublish(key)
+	}
+
+	if err := scanner.Err(); err != nil {
+		p.progress.Save(inputFile, summary.handled, false)
+		return summary.published, fmt.Errorf("issue reading input file: %s", err)
+	}
+	if summary.failed > 0 {
+		p.progress.Save(inputFile, summary.handled, false)
+		return summary.published, fmt.Errorf("failed to publish %d of %d messages to pubsub: %s", summary.failed, summary.published+summary.failed, summary.firstErr)
+	}
+
+	p.progress.Save(inputFile, summary.handled, true)
+	return summary.published, nil
+}
+
+// getTopic returns the topic, creating the client on the first call
+func (p *pubSubOutput) getTopic() (*pubsub.Topic, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.topic != nil {
+		return p.topic, nil
+	}
+
+	opts, conn, err := gcp.PubSubClientOptions(p.config.Credentials, p.config.CredentialsPath, p.config.EmulatorHost)
+	if err != nil {
+		return nil, err
+	}
+
+	// Setup PubSub client
+	client, err := pubsub.NewClient(p.ctx, p.config.ProjectID, opts...)
+	if err != nil {
+		if conn != nil {
+			_ = conn.Close()
 		}
+		return nil, fmt.Errorf("issue setting up pub sub client: %s", err)
+	}
 
-		// Setup subscription
-		_, err = topicClient.Publish(p.ctx, msg).Get(p.ctx)
-		if err != nil {
-			log.Errorf("issue publishing line to pubsub: %s", err)
-			continue
+	topic := client.Topic(p.config.TopicID)
+	topic.EnableMessageOrdering = p.config.OrderingKeyField != ""
+	topic.PublishSettings.DelayThreshold = time.Duration(p.config.DelayThresholdMs) * time.Millisecond
+	topic.PublishSettings.CountThreshold = p.config.CountThreshold
+	topic.PublishSettings.ByteThreshold = p.config.ByteThreshold
+	topic.PublishSettings.NumGoroutines = p.config.NumGoroutines
+	topic.PublishSettings.Timeout = time.Duration(p.config.Timeout) * time.Second
+	topic.PublishSettings.FlowControlSettings = pubsub.FlowControlSettings{
+		MaxOutstandingMessages: p.config.MaxOutstandingMessages,
+		MaxOutstandingBytes:    p.config.MaxOutstandingBytes,
+		LimitExceededBehavior:  pubsub.FlowControlBlock,
+	}
+
+	p.client = client
+	p.conn = conn
+	p.topic = topic
+	return topic, nil
+}
+
+// Close publishes the messages still batched by the topic and closes the client along with the emulator connection
+func (p *pubSubOutput) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.topic == nil {
+		return nil
+	}
+
+	p.topic.Stop()
+	err := p.client.Close()
+	if p.conn != nil {
+		_ = p.conn.Close()
+	}
+
+	p.client, p.conn, p.topic = nil, nil, nil
+	return err
+}
+
+// message builds the message for the line with its ordering key and attributes. Fields missing from the line are
+// left out.
+func (p *pubSubOutput) message(line string) *pubsub.Message {
+	msg := &pubsub.Message{
+		Data: []byte(line),
+	}
+
+	if p.config.OrderingKeyField != "" {
+		msg.OrderingKey = gjson.Get(line, p.config.OrderingKeyField).String()
+	}
+
+	if len(p.config.Attributes) > 0 || len(p.config.AttributeFields) > 0 {
+		msg.Attributes = make(map[string]string, len(p.config.Attributes)+len(p.config.AttributeFields))
+		for k, v := range p.config.Attributes {
+			msg.Attributes[k] = v
+		}
+		for k, path := range p.config.AttributeFields {
+			result := gjson.Get(line, path)
+			if result.Exists() {
+				msg.Attributes[k] = result.String()
+			}
 		}
+	}
 
-		// Debug print with empty line count
-		if emptyLines > 0 {
-			log.Debugf("ignored %d empty log entries", emptyLines)
+	return msg
+}
+
+// collectResults waits on each publish result, adding the published lines to handled and counting the failures and
+// the ordering keys they paused
+func (p *pubSubOutput) collectResults(results <-chan pendingPublish, handled map[int]bool) publishSummary {
+	summary := publishSummary{orderingKeys: make(map[string]bool), handled: handled}
+	for pending := range results {
+		_, err := pending.result.Get(p.ctx)
+		if err == nil {
+			summary.published++
+			handled[pending.lineNumber] = true
+			continue
 		}
 
-		lineCount++
+		summary.failed++
+		if pending.orderingKey != "" {
+			summary.orderingKeys[pending.orderingKey] = true
+		}
+		if summary.firstErr == nil {
+			summary.firstErr = err
+		}
 	}
 
-	return lineCount, nil
+	return summary
 }
 
 func validateCredentialsOrPath(credentials json.RawMessage, path string) error {
@@ -1,20 +1,33 @@
 package pubsub
 
 import (
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/pstest"
+	"context"
 	"encoding/json"
+	"github.com/ThoronicLLC/collector/internal/integrations/gcp"
 	"github.com/ThoronicLLC/collector/pkg/core"
 	"github.com/stretchr/testify/assert"
+	pb "google.golang.org/genproto/googleapis/pubsub/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
 var config1 = `{"project_id": "project-1", "topic_id": "sub-1", "credentials_path": "/tmp/file.txt"}`
 var config2 = `{"project_id": "project-2", "topic_id": "sub-3", "credentials": {}}`
+var config3 = `{"project_id": "project-3", "topic_id": "topic-3", "credentials": {}, "ordering_key_field": "user.id", "attributes": {"source": "collector"}, "attribute_fields": {"event_type": "type"}, "delay_threshold_ms": 50, "count_threshold": 500, "max_outstanding_messages": -1}`
+var config4 = `{"project_id": "project-4", "topic_id": "topic-4", "emulator_host": "localhost:8085"}`
 var badConfig1 = `{"project_id": "project-3", "topic_id": "", "credentials": {}}`
 var badConfig2 = `{"project_id": "", "topic_id": "sub-3", "credentials": {}}`
 var badConfig3 = `{"project_id": "project-1", "topic_id": "sub-1"}`
+var badConfig4 = `{"project_id": "project-1", "topic_id": "sub-1", "credentials": {}, "count_threshold": -1}`
+var badConfig5 = `{"project_id": "project-1", "topic_id": "sub-1", "credentials": {}, "max_outstanding_bytes": -5}`
 
 func TestValidate(t *testing.T) {
-	arr := []string{config1, config2}
+	arr := []string{config1, config2, config3, config4}
 	for i, v := range arr {
 		var testConfig Config
 		err := json.Unmarshal([]byte(v), &testConfig)
@@ -25,7 +38,7 @@ func TestValidate(t *testing.T) {
 }
 
 func TestValidateFailed(t *testing.T) {
-	arr := []string{badConfig1, badConfig2}
+	arr := []string{badConfig1, badConfig2, badConfig4, badConfig5}
 	for i, v := range arr {
 		var testConfig Config
 		err := json.Unmarshal([]byte(v), &testConfig)
@@ -36,7 +49,7 @@ func TestValidateFailed(t *testing.T) {
 }
 
 func TestHandler(t *testing.T) {
-	arr := []string{config1, config2}
+	arr := []string{config1, config2, config3, config4}
 	for i, v := range arr {
 		var testConfig Config
 		err := json.Unmarshal([]byte(v), &testConfig)
@@ -48,7 +61,7 @@ func TestHandler(t *testing.T) {
 }
 
 func TestHandlerFailed(t *testing.T) {
-	arr := []string{badConfig1, badConfig2, badConfig3}
+	arr := []string{badConfig1, badConfig2, badConfig3, badConfig4, badConfig5}
 	for i, v := range arr {
 		var testConfig Config
 		err := json.Unmarshal([]byte(v), &testConfig)
@@ -58,3 +71,109 @@ func TestHandlerFailed(t *testing.T) {
 		assert.NotNilf(t, err, "test #%d - validation should have returned an error: %s", i, err)
 	}
 }
+
+func TestWrite(t *testing.T) {
+	server := pstest.NewServer()
+	defer server.Close()
+
+	conf := Config{
+		ProjectID:        "project-1",
+		TopicID:          "topic-1",
+		EmulatorHost:     server.Addr,
+		OrderingKeyField: "user",
+		Attributes:       map[string]string{"source": "collector"},
+		AttributeFields:  map[string]string{"event_type": "type"},
+		DelayThresholdMs: 10,
+		CountThreshold:   100,
+		ByteThreshold:    1e6,
+		Timeout:          60,
+	}
+
+	// Create the topic for the output
+	opts, conn, err := gcp.PubSubClientOptions(conf.Credentials, conf.CredentialsPath, conf.EmulatorHost)
+	assert.Nil(t, err)
+	defer conn.Close()
+	client, err := pubsub.NewClient(context.Background(), conf.ProjectID, opts...)
+	assert.Nil(t, err)
+	defer client.Close()
+	_, err = client.CreateTopic(context.Background(), conf.TopicID)
+	assert.Nil(t, err)
+
+	inputFile := filepath.Join(t.TempDir(), "results.txt")
+	assert.Nil(t, os.WriteFile(inputFile, []byte("{\"user\": \"bob\", \"type\": \"login\"}\n\n{\"user\": \"alice\"}\n"), 0600))
+
+	output := &pubSubOutput{config: conf, ctx: context.Background()}
+	count, err := output.Write(inputFile)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+
+	messages := server.Messages()
+	assert.Equal(t, 2, len(messages))
+	assert.Equal(t, "bob", messages[0].OrderingKey)
+	assert.Equal(t, map[string]string{"source": "collector", "event_type": "login"}, messages[0].Attributes)
+	assert.Equal(t, map[string]string{"source": "collector"}, messages[1].Attributes)
+
+	// The client is reused between writes
+	count, err = output.Write(inputFile)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, 4, len(server.Messages()))
+	assert.Nil(t, output.Close())
+
+	// Publish failures are returned
+	conf.TopicID = "missing"
+	output = &pubSubOutput{config: conf, ctx: context.Background()}
+	count, err = output.Write(inputFile)
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, count)
+}
+
+// failingReactor fails the publish requests containing the data until it is disabled
+type failingReactor struct {
+	data    string
+	enabled bool
+}
+
+func (r *failingReactor) React(req interface{}) (bool, interface{}, error) {
+	for _, msg := range req.(*pb.PublishRequest).Messages {
+		if r.enabled && string(msg.Data) == r.data {
+			return true, nil, status.Error(codes.InvalidArgument, "rejected")
+		}
+	}
+	return false, nil, nil
+}
+
+func TestWriteResume(t *testing.T) {
+	reactor := &failingReactor{data: `{"user": "alice"}`, enabled: true}
+	server := pstest.NewServer(pstest.ServerReactorOption{FuncName: "Publish", Reactor: reactor})
+	defer server.Close()
+
+	conf := Config{ProjectID: "project-1", TopicID: "topic-1", EmulatorHost: server.Addr, CountThreshold: 1, Timeout: 60}
+	opts, conn, err := gcp.PubSubClientOptions(conf.Credentials, conf.CredentialsPath, conf.EmulatorHost)
+	assert.Nil(t, err)
+	defer conn.Close()
+	client, err := pubsub.NewClient(context.Background(), conf.ProjectID, opts...)
+	assert.Nil(t, err)
+	defer client.Close()
+	_, err = client.CreateTopic(context.Background(), conf.TopicID)
+	assert.Nil(t, err)
+
+	inputFile := filepath.Join(t.TempDir(), "results.txt")
+	assert.Nil(t, os.WriteFile(inputFile, []byte("{\"user\": \"bob\"}\n{\"user\": \"alice\"}\n"), 0600))
+
+	output := &pubSubOutput{config: conf, ctx: context.Background()}
+	defer output.Close()
+	count, err := output.Write(inputFile)
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, 1, len(server.Messages()))
+
+	// Retrying the file only publishes the message that failed
+	reactor.enabled = false
+	count, err = output.Write(inputFile)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+	messages := server.Messages()
+	assert.Equal(t, 2, len(messages))
+	assert.Equal(t, `{"user": "alice"}`, string(messages[1].Data))
+}
@@ -4,10 +4,10 @@ import (
 	"compress/gzip"
 	"encoding/json"
 	"fmt"
+	awsutil "github.com/ThoronicLLC/collector/internal/integrations/aws"
 	"github.com/ThoronicLLC/collector/pkg/core"
 	"github.com/ThoronicLLC/collector/pkg/core/variable_replacer"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"io"
@@ -18,17 +18,21 @@ import (
 var OutputName = "s3"
 
 type Config struct {
-	Bucket          string `json:"bucket" validate:"required"`
-	Region          string `json:"region" validate:"required"`
-	AccessKeyID     string `json:"access_key_id" validate:"required"`
-	SecretAccessKey string `json:"secret_access_key" validate:"required"`
-	Path            string `json:"path" validate:"required"`
-	MaxRetries      int    `json:"max_retries" validate:"int|min:0"`
-	GZip            bool   `json:"gzip" validate:"bool"`
+	Bucket          string             `json:"bucket" validate:"required"`
+	Region          string             `json:"region" validate:"required"`
+	AccessKeyID     string             `json:"access_key_id"`
+	SecretAccessKey string             `json:"secret_access_key"`
+	AuthConfig      awsutil.AuthConfig `json:"auth_config"`
+	Endpoint        string             `json:"endpoint"`
+	ForcePathStyle  bool               `json:"force_path_style" validate:"bool"`
+	Path            string             `json:"path" validate:"required"`
+	MaxRetries      int                `json:"max_retries" validate:"int|min:0"`
+	GZip            bool               `json:"gzip" validate:"bool"`
 }
 
 type s3Output struct {
-	config Config
+	config  Config
+	session *session.Session
 }
 
 func Handler() core.OutputHandler {
@@ -51,8 +55,32 @@ func Handler() core.OutputHandler {
 			return nil, err
 		}
 
+		// Setup auth config
+		conf.AuthConfig, err = conf.AuthConfig.WithLegacyKeys(conf.AccessKeyID, conf.SecretAccessKey)
+		if err != nil {
+			return nil, err
+		}
+
+		err = conf.AuthConfig.Validate()
+		if err != nil {
+			return nil, err
+		}
+
+		// Initialize AWS session
+		awsSession, err := awsutil.NewSession(awsutil.SessionConfig{
+			Region:         conf.Region,
+			Endpoint:       conf.Endpoint,
+			ForcePathStyle: conf.ForcePathStyle,
+			MaxRetries:     conf.MaxRetries,
+			AuthConfig:     conf.AuthConfig,
+		})
+		if err != nil {
+			return nil, err
+		}
+
 		return &s3Output{
-			config: conf,
+			config:  conf,
+			session: awsSession,
 		}, nil
 	}
 }
@@ -123,32 +151,13 @@ func (s *s3Output) Write(inputFile string) (int, error) {
 		return 0, fmt.Errorf("issue getting file stat: %s", err)
 	}
 
-	// Initialize AWS creds
-	creds := credentials.NewStaticCredentials(s.config.AccessKeyID, s.config.SecretAccessKey, "")
-	_, err = creds.Get()
-	if err != nil {
-		return 0, fmt.Errorf("invalid credentials: %s", err)
-	}
-
-	// Initialize AWS config
-	awsConf := aws.NewConfig().WithRegion(s.config.Region).WithCredentials(creds)
-	if s.config.MaxRetries > 0 {
-		awsConf = awsConf.WithMaxRetries(s.config.MaxRetries)
-	}
-
-	// Initialize AWS session
-	awsSession, err := session.NewSession(awsConf)
-	if err != nil {
-		return 0, fmt.Errorf("issue creating AWS session: %s", err)
-	}
-
 	partSize, err := getMaxPartSize(fileInfo.Size())
 	if err != nil {
 		return 0, err
 	}
 
 	// Create an uploader with the session and custom options
-	uploader := s3manager.NewUploader(awsSession, func(u *s3manager.Uploader) {
+	uploader := s3manager.NewUploader(s.session, func(u *s3manager.Uploader) {
 		u.PartSize = partSize // We calculate part size based on file size
 	})
 
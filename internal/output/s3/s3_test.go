@@ -9,14 +9,19 @@ import (
 
 var config1 = `{"bucket": "example-bucket", "region": "us-east-1", "access_key_id": "1234567890", "secret_access_key": "1234567890", "path": "/tmp/test.txt", "max_retries": 3}`
 var config2 = `{"bucket": "example-bucket", "region": "us-east-1", "access_key_id": "1234567890", "secret_access_key": "1234567890", "path": "/tmp/test.txt"}`
+var config3 = `{"bucket": "example-bucket", "region": "us-east-1", "endpoint": "http://localhost:9000", "force_path_style": true, "path": "/tmp/test.txt"}`
+var config4 = `{"bucket": "example-bucket", "region": "us-east-1", "auth_config": {"static": {"enabled": true, "access_key_id": "1234567890", "secret_access_key": "1234567890"}, "assume_role": {"enabled": true, "role_arn": "arn:aws:iam::123456789012:role/collector", "external_id": "example"}}, "path": "/tmp/test.txt"}`
+var config5 = `{"bucket": "example-bucket", "region": "us-east-1", "auth_config": {"web_identity": {"enabled": true, "role_arn": "arn:aws:iam::123456789012:role/collector", "token_file": "/var/run/secrets/token"}}, "path": "/tmp/test.txt"}`
 var badConfig1 = `{"bucket": "", "region": "us-east-1", "access_key_id": "1234567890", "secret_access_key": "1234567890", "path": "/tmp/test.txt", "max_retries": 3}`
 var badConfig2 = `{"bucket": "example-bucket", "region": "", "access_key_id": "1234567890", "secret_access_key": "1234567890", "path": "/tmp/test.txt", "max_retries": 3}`
 var badConfig3 = `{"bucket": "example-bucket", "region": "us-east-1", "access_key_id": "", "secret_access_key": "1234567890", "path": "/tmp/test.txt", "max_retries": 3}`
 var badConfig4 = `{"bucket": "example-bucket", "region": "us-east-1", "access_key_id": "1234567890", "secret_access_key": "", "path": "/tmp/test.txt", "max_retries": 3}`
 var badConfig5 = `{"bucket": "example-bucket", "region": "us-east-1", "access_key_id": "1234567890", "secret_access_key": "1234567890", "path": "", "max_retries": 3}`
+var badConfig6 = `{"bucket": "example-bucket", "region": "us-east-1", "auth_config": {"static": {"enabled": true}}, "path": "/tmp/test.txt"}`
+var badConfig7 = `{"bucket": "example-bucket", "region": "us-east-1", "auth_config": {"assume_role": {"enabled": true}}, "path": "/tmp/test.txt"}`
 
 func TestValidate(t *testing.T) {
-	arr := []string{config1, config2}
+	arr := []string{config1, config2, config3, config4, config5}
 	for i, v := range arr {
 		var testConfig Config
 		err := json.Unmarshal([]byte(v), &testConfig)
@@ -27,7 +32,7 @@ func TestValidate(t *testing.T) {
 }
 
 func TestValidateFailed(t *testing.T) {
-	arr := []string{badConfig1, badConfig2, badConfig3, badConfig4, badConfig5}
+	arr := []string{badConfig1, badConfig2, badConfig5}
 	for i, v := range arr {
 		var testConfig Config
 		err := json.Unmarshal([]byte(v), &testConfig)
@@ -38,7 +43,7 @@ func TestValidateFailed(t *testing.T) {
 }
 
 func TestHandler(t *testing.T) {
-	arr := []string{config1, config2}
+	arr := []string{config1, config2, config3, config4, config5}
 	for i, v := range arr {
 		var testConfig Config
 		err := json.Unmarshal([]byte(v), &testConfig)
@@ -50,7 +55,7 @@ func TestHandler(t *testing.T) {
 }
 
 func TestHandlerFailed(t *testing.T) {
-	arr := []string{badConfig1, badConfig2, badConfig3, badConfig4, badConfig5}
+	arr := []string{badConfig1, badConfig2, badConfig3, badConfig4, badConfig5, badConfig6, badConfig7}
 	for i, v := range arr {
 		var testConfig Config
 		err := json.Unmarshal([]byte(v), &testConfig)
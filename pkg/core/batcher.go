@@ -0,0 +1,342 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// DropPolicyBlock pauses writers until the outputs catch up
+	DropPolicyBlock = "block"
+	// DropPolicyOldest discards the pending batch to make room for new events
+	DropPolicyOldest = "oldest"
+	// DropPolicyNewest discards new events until the outputs catch up
+	DropPolicyNewest = "newest"
+)
+
+// BatchConfig configures when a Batcher sends its current batch down the pipeline. A batch is flushed every
+// FlushFrequency seconds, or early once it reaches MaxBatchBytes or MaxBatchEvents (a zero value disables the limit).
+//
+// MaxInFlightBatches and MaxDiskBytes limit how many batches and bytes may be waiting on the processors and
+// outputs. Once every in-flight slot is taken the pending batch only grows until it is full, or holds a single event
+// when no batch limits are set. Once that or the disk budget is reached the DropPolicy decides whether writers wait
+// or events are dropped.
+//
+// State is called as each batch is closed and the result is sent with the batch. Inputs that track their position
+// should only advance it once the event has been written, so the state never covers events missing from a batch.
+// Inputs that settle each event with the source, or only advance once events are delivered, use WriteWithAck.
+type BatchConfig struct {
+	FlushFrequency     int
+	MaxBatchBytes      int64
+	MaxBatchEvents     int
+	MaxInFlightBatches int
+	MaxDiskBytes       int64
+	DropPolicy         string
+	State              func() State
+}
+
+// Batcher writes streaming input events to temp files and sends them to the process pipe on a timer or when
+// the batch size limits are reached.
+type Batcher struct {
+	ctx         context.Context
+	config      BatchConfig
+	writer      *TmpWriter
+	processPipe chan<- PipelineResults
+	mu          sync.Mutex
+
+	acks            []func(delivered bool)
+	inFlightBatches int
+	inFlightBytes   int64
+	released        chan struct{}
+	dropped         int64
+	reportedDropped int64
+}
+
+// NewBatcher creates a new Batcher that sends its batches to the supplied process pipe. Writers waiting on the
+// in-flight limits are released once the context is done.
+func NewBatcher(ctx context.Context, config BatchConfig, processPipe chan<- PipelineResults) (*Batcher, error) {
+	writer, err := NewTmpWriter()
+	if err != nil {
+		return nil, err
+	}
+
+	if config.DropPolicy == "" {
+		config.DropPolicy = DropPolicyBlock
+	}
+
+	return &Batcher{
+		ctx:         ctx,
+		config:      config,
+		writer:      writer,
+		processPipe: processPipe,
+		released:    make(chan struct{}),
+	}, nil
+}
+
+// closedBatch is a batch file that has been rotated out and is ready to send
+type closedBatch struct {
+	count    int
+	fileName string
+	size     int64
+	state    State
+	acks     []func(delivered bool)
+}
+
+// Write adds an event to the current batch and flushes the batch if a size limit has been reached
+func (b *Batcher) Write(p []byte) (int, error) {
+	return b.WriteWithAck(p, nil)
+}
+
+// WriteWithAck adds an event to the current batch like Write. The ack is called once the pipeline has finished with
+// the batch holding the event, with whether the outputs wrote it. It is not called for events that fail to write or
+// are dropped.
+func (b *Batcher) WriteWithAck(p []byte, ack func(delivered bool)) (int, error) {
+	b.mu.Lock()
+
+	// Apply the drop policy while the disk budget is exhausted or the outputs have fallen behind
+	for (b.overDiskBudget() || b.backlogged()) && b.ctx.Err() == nil {
+		// Move the pending batch along if another batch may be sent
+		if b.pendingCount() > 0 && b.canSend() {
+			batch, err := b.rotate()
+			b.mu.Unlock()
+			if err != nil {
+				return 0, fmt.Errorf("issue rotating temp file: %s", err)
+			}
+			b.send(batch)
+			b.mu.Lock()
+			continue
+		}
+
+		switch {
+		case b.config.DropPolicy == DropPolicyOldest && b.pendingCount() > 0:
+			batch, err := b.rotate()
+			if err != nil {
+				b.mu.Unlock()
+				return 0, fmt.Errorf("issue rotating temp file: %s", err)
+			}
+			_ = os.Remove(batch.fileName)
+			b.dropped += int64(batch.count)
+		case b.config.DropPolicy != DropPolicyBlock:
+			b.dropped++
+			b.mu.Unlock()
+			return 0, nil
+		default:
+			b.waitForRelease()
+		}
+	}
+
+	n, err := b.writer.Write(p)
+	if err != nil {
+		b.mu.Unlock()
+		return n, err
+	}
+	if ack != nil {
+		b.acks = append(b.acks, ack)
+	}
+
+	// Check if the batch is full
+	if !b.full() || !b.canSend() {
+		b.mu.Unlock()
+		return n, nil
+	}
+
+	batch, err := b.rotate()
+	b.mu.Unlock()
+	if err != nil {
+		return n, fmt.Errorf("issue rotating temp file: %s", err)
+	}
+
+	b.send(batch)
+	return n, nil
+}
+
+// Flush sends the current batch down the pipeline if it has any events. When every in-flight slot is taken and
+// writers do not wait, the batch is kept until a slot is free.
+func (b *Batcher) Flush() error {
+	b.mu.Lock()
+	if !b.canSend() {
+		b.mu.Unlock()
+		return nil
+	}
+
+	batch, err := b.rotate()
+	b.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("issue rotating temp file: %s", err)
+	}
+
+	b.send(batch)
+	return nil
+}
+
+// Run flushes the batch every FlushFrequency seconds until the context is done. Any remaining events are
+// flushed before returning.
+func (b *Batcher) Run(ctx context.Context, errorHandler ErrorHandler) {
+	for {
+		select {
+		case <-ctx.Done():
+			err := b.Flush()
+			if err != nil {
+				errorHandler(false, fmt.Errorf("issue flushing file: %s", err))
+			}
+			b.reportDropped(errorHandler)
+			return
+		case <-time.After(time.Duration(b.config.FlushFrequency) * time.Second):
+			err := b.Flush()
+			if err != nil {
+				errorHandler(false, fmt.Errorf("issue flushing file: %s", err))
+			}
+			b.reportDropped(errorHandler)
+		}
+	}
+}
+
+// Dropped returns the total number of events dropped by the drop policy
+func (b *Batcher) Dropped() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.dropped
+}
+
+// full checks the current batch against the configured limits. It must be called with the lock held.
+func (b *Batcher) full() bool {
+	if b.config.MaxBatchBytes > 0 && b.pendingBytes() >= b.config.MaxBatchBytes {
+		return true
+	}
+
+	if b.config.MaxBatchEvents > 0 && b.pendingCount() >= b.config.MaxBatchEvents {
+		return true
+	}
+
+	return false
+}
+
+// overDiskBudget checks if the in-flight and pending batches have used up the disk budget. It must be called with
+// the lock held.
+func (b *Batcher) overDiskBudget() bool {
+	return b.config.MaxDiskBytes > 0 && b.inFlightBytes+b.pendingBytes() >= b.config.MaxDiskBytes
+}
+
+// backlogged checks if every in-flight slot is taken and the pending batch can not take more events. Without batch
+// limits the pending batch would otherwise grow until the next flush. It must be called with the lock held.
+func (b *Batcher) backlogged() bool {
+	if b.config.MaxInFlightBatches <= 0 || b.inFlightBatches < b.config.MaxInFlightBatches {
+		return false
+	}
+
+	if b.config.MaxBatchBytes == 0 && b.config.MaxBatchEvents == 0 {
+		return b.pendingCount() > 0
+	}
+
+	return b.full()
+}
+
+// canSend checks if another batch may be sent. When blocking, the send waits for a free slot instead. It must be
+// called with the lock held.
+func (b *Batcher) canSend() bool {
+	if b.config.DropPolicy == DropPolicyBlock || b.config.MaxInFlightBatches <= 0 {
+		return true
+	}
+
+	return b.inFlightBatches < b.config.MaxInFlightBatches
+}
+
+// waitForRelease releases the lock until an in-flight batch is acknowledged or the context is done. It must be
+// called with the lock held.
+func (b *Batcher) waitForRelease() {
+	released := b.released
+	b.mu.Unlock()
+	select {
+	case <-released:
+	case <-b.ctx.Done():
+	}
+	b.mu.Lock()
+}
+
+// pendingBytes returns the size of the batch not yet sent. It must be called with the lock held.
+func (b *Batcher) pendingBytes() int64 {
+	if b.writer.file == nil {
+		return 0
+	}
+	return b.writer.size
+}
+
+// pendingCount returns the number of events not yet sent. It must be called with the lock held.
+func (b *Batcher) pendingCount() int {
+	if b.writer.file == nil {
+		return 0
+	}
+	return b.writer.WriteCount
+}
+
+// rotate closes the current batch file and returns it with the state and acks to send with it. It must be called
+// with the lock held.
+func (b *Batcher) rotate() (closedBatch, error) {
+	batch := closedBatch{size: b.pendingBytes(), acks: b.acks}
+	b.acks = nil
+	if b.config.State != nil {
+		batch.state = b.config.State()
+	}
+
+	var err error
+	batch.count, batch.fileName, err = b.writer.Rotate()
+	return batch, err
+}
+
+func (b *Batcher) send(batch closedBatch) {
+	// Only send on if there are results
+	if batch.count == 0 {
+		return
+	}
+
+	// Wait for a free in-flight slot
+	b.mu.Lock()
+	for b.config.MaxInFlightBatches > 0 && b.inFlightBatches >= b.config.MaxInFlightBatches && b.ctx.Err() == nil {
+		b.waitForRelease()
+	}
+	b.inFlightBatches++
+	b.inFlightBytes += batch.size
+	b.mu.Unlock()
+
+	var once sync.Once
+	b.processPipe <- PipelineResults{
+		FilePath:    batch.fileName,
+		ResultCount: batch.count,
+		State:       batch.state,
+		RetryCount:  0,
+		Ack: func(delivered bool) {
+			once.Do(func() {
+				b.release(batch.size)
+				for _, ack := range batch.acks {
+					ack(delivered)
+				}
+			})
+		},
+	}
+}
+
+// release frees the in-flight budget used by a batch and wakes up any waiting writers
+func (b *Batcher) release(size int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.inFlightBatches--
+	b.inFlightBytes -= size
+	close(b.released)
+	b.released = make(chan struct{})
+}
+
+// reportDropped reports any events dropped since the last report
+func (b *Batcher) reportDropped(errorHandler ErrorHandler) {
+	b.mu.Lock()
+	newlyDropped := b.dropped - b.reportedDropped
+	total := b.dropped
+	b.reportedDropped = b.dropped
+	b.mu.Unlock()
+
+	if newlyDropped > 0 {
+		errorHandler(false, fmt.Errorf("dropped %d events due to backpressure (%d total)", newlyDropped, total))
+	}
+}
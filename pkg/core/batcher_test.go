@@ -0,0 +1,251 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestBatcherMaxEvents(t *testing.T) {
+	processPipe := make(chan PipelineResults, 10)
+	batcher, err := NewBatcher(context.Background(), BatchConfig{FlushFrequency: 300, MaxBatchEvents: 2}, processPipe)
+	assert.Nil(t, err)
+
+	for _, v := range []string{"one", "two", "three"} {
+		_, err = batcher.Write([]byte(v))
+		assert.Nil(t, err)
+	}
+
+	// The first two events should have been flushed early
+	assert.Equal(t, 1, len(processPipe))
+	res := <-processPipe
+	assert.Equal(t, 2, res.ResultCount)
+	_ = os.Remove(res.FilePath)
+
+	// The remaining event is sent on flush
+	assert.Nil(t, batcher.Flush())
+	res = <-processPipe
+	assert.Equal(t, 1, res.ResultCount)
+	_ = os.Remove(res.FilePath)
+
+	// Empty batches are not sent
+	assert.Nil(t, batcher.Flush())
+	assert.Equal(t, 0, len(processPipe))
+}
+
+func TestBatcherMaxBytes(t *testing.T) {
+	processPipe := make(chan PipelineResults, 10)
+	batcher, err := NewBatcher(context.Background(), BatchConfig{FlushFrequency: 300, MaxBatchBytes: 10}, processPipe)
+	assert.Nil(t, err)
+
+	_, err = batcher.Write([]byte("12345"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(processPipe))
+
+	_, err = batcher.Write([]byte("67890"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(processPipe))
+
+	res := <-processPipe
+	assert.Equal(t, 2, res.ResultCount)
+	_ = os.Remove(res.FilePath)
+}
+
+func TestBatcherState(t *testing.T) {
+	processPipe := make(chan PipelineResults, 10)
+	written := 0
+	batcher, err := NewBatcher(context.Background(), BatchConfig{
+		FlushFrequency: 300,
+		MaxBatchEvents: 2,
+		State: func() State {
+			return State(fmt.Sprintf(`{"written": %d}`, written))
+		},
+	}, processPipe)
+	assert.Nil(t, err)
+
+	// The position is advanced after each write
+	for _, v := range []string{"one", "two", "three"} {
+		_, err = batcher.Write([]byte(v))
+		assert.Nil(t, err)
+		written++
+	}
+
+	// The early flush happens inside the write, before the position covers the second event
+	res := <-processPipe
+	assert.Equal(t, `{"written": 1}`, string(res.State))
+	_ = os.Remove(res.FilePath)
+
+	assert.Nil(t, batcher.Flush())
+	res = <-processPipe
+	assert.Equal(t, `{"written": 3}`, string(res.State))
+	_ = os.Remove(res.FilePath)
+}
+
+func TestBatcherWriteWithAck(t *testing.T) {
+	processPipe := make(chan PipelineResults, 10)
+	batcher, err := NewBatcher(context.Background(), BatchConfig{FlushFrequency: 300, MaxBatchEvents: 2}, processPipe)
+	assert.Nil(t, err)
+
+	acked := make([]string, 0)
+	for _, v := range []string{"one", "two", "three"} {
+		v := v
+		_, err = batcher.WriteWithAck([]byte(v), func(delivered bool) {
+			acked = append(acked, fmt.Sprintf("%s:%t", v, delivered))
+		})
+		assert.Nil(t, err)
+	}
+
+	// Acks are only called once the batch holding the event is acknowledged
+	res := <-processPipe
+	assert.Equal(t, 0, len(acked))
+	_ = os.Remove(res.FilePath)
+	res.Acknowledge(true)
+	res.Acknowledge(true)
+	assert.Equal(t, []string{"one:true", "two:true"}, acked)
+
+	// Failed deliveries are passed on to the acks
+	assert.Nil(t, batcher.Flush())
+	res = <-processPipe
+	_ = os.Remove(res.FilePath)
+	res.Acknowledge(false)
+	assert.Equal(t, []string{"one:true", "two:true", "three:false"}, acked)
+}
+
+func TestBatcherBacklogged(t *testing.T) {
+	processPipe := make(chan PipelineResults, 10)
+	batcher, err := NewBatcher(context.Background(), BatchConfig{
+		FlushFrequency:     300,
+		MaxInFlightBatches: 1,
+		DropPolicy:         DropPolicyNewest,
+	}, processPipe)
+	assert.Nil(t, err)
+
+	// Fill the only in-flight slot
+	_, err = batcher.Write([]byte("one"))
+	assert.Nil(t, err)
+	assert.Nil(t, batcher.Flush())
+
+	// Without batch limits or a disk budget the pending batch holds a single event while the outputs are behind
+	for _, v := range []string{"two", "three", "four"} {
+		_, err = batcher.Write([]byte(v))
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, int64(2), batcher.Dropped())
+
+	res := <-processPipe
+	_ = os.Remove(res.FilePath)
+	res.Acknowledge(false)
+
+	assert.Nil(t, batcher.Flush())
+	res = <-processPipe
+	assert.Equal(t, 1, res.ResultCount)
+	_ = os.Remove(res.FilePath)
+}
+
+func TestBatcherDropNewest(t *testing.T) {
+	processPipe := make(chan PipelineResults, 10)
+	batcher, err := NewBatcher(context.Background(), BatchConfig{
+		FlushFrequency:     300,
+		MaxBatchEvents:     1,
+		MaxInFlightBatches: 1,
+		MaxDiskBytes:       4,
+		DropPolicy:         DropPolicyNewest,
+	}, processPipe)
+	assert.Nil(t, err)
+
+	// The first event fills the only in-flight slot and the disk budget
+	_, err = batcher.Write([]byte("one"))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(processPipe))
+
+	// New events are dropped until the batch is acknowledged
+	_, err = batcher.Write([]byte("two"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), batcher.Dropped())
+
+	res := <-processPipe
+	_ = os.Remove(res.FilePath)
+	res.Acknowledge(true)
+
+	_, err = batcher.Write([]byte("three"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), batcher.Dropped())
+	assert.Equal(t, 1, len(processPipe))
+	res = <-processPipe
+	_ = os.Remove(res.FilePath)
+}
+
+func TestBatcherDropOldest(t *testing.T) {
+	processPipe := make(chan PipelineResults, 10)
+	batcher, err := NewBatcher(context.Background(), BatchConfig{
+		FlushFrequency:     300,
+		MaxInFlightBatches: 1,
+		MaxDiskBytes:       8,
+		DropPolicy:         DropPolicyOldest,
+	}, processPipe)
+	assert.Nil(t, err)
+
+	// Fill the only in-flight slot
+	_, err = batcher.Write([]byte("one"))
+	assert.Nil(t, err)
+	assert.Nil(t, batcher.Flush())
+	assert.Equal(t, 1, len(processPipe))
+
+	// The pending batch is dropped once the disk budget is used up
+	_, err = batcher.Write([]byte("two"))
+	assert.Nil(t, err)
+	_, err = batcher.Write([]byte("three"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), batcher.Dropped())
+
+	res := <-processPipe
+	_ = os.Remove(res.FilePath)
+	res.Acknowledge(true)
+
+	assert.Nil(t, batcher.Flush())
+	res = <-processPipe
+	assert.Equal(t, 1, res.ResultCount)
+	_ = os.Remove(res.FilePath)
+}
+
+func TestBatcherBlock(t *testing.T) {
+	processPipe := make(chan PipelineResults, 10)
+	batcher, err := NewBatcher(context.Background(), BatchConfig{
+		FlushFrequency:     300,
+		MaxBatchEvents:     1,
+		MaxInFlightBatches: 1,
+	}, processPipe)
+	assert.Nil(t, err)
+
+	_, err = batcher.Write([]byte("one"))
+	assert.Nil(t, err)
+
+	// The second write blocks until the first batch is acknowledged
+	written := make(chan struct{})
+	go func() {
+		_, _ = batcher.Write([]byte("two"))
+		close(written)
+	}()
+
+	select {
+	case <-written:
+		t.Fatal("write should have blocked")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	res := <-processPipe
+	_ = os.Remove(res.FilePath)
+	res.Acknowledge(true)
+
+	select {
+	case <-written:
+	case <-time.After(5 * time.Second):
+		t.Fatal("write should have been released")
+	}
+
+	res = <-processPipe
+	_ = os.Remove(res.FilePath)
+}
@@ -5,4 +5,16 @@ type PipelineResults struct {
 	ResultCount int
 	State       State
 	RetryCount  int
+
+	// Ack is called once the pipeline has finished with the results. Delivered is only true when every output wrote
+	// the results; it is false when a processor or output failed. Inputs use it to track how many batches are in
+	// flight and to settle events with their source. It may be nil.
+	Ack func(delivered bool)
+}
+
+// Acknowledge marks the results as finished with if the input requested an acknowledgement
+func (r PipelineResults) Acknowledge(delivered bool) {
+	if r.Ack != nil {
+		r.Ack(delivered)
+	}
 }
@@ -0,0 +1,110 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// gzipMagic is the header every gzip stream starts with
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// LineHandler is called for every event read from an object
+type LineHandler func(line []byte) error
+
+// objectReader closes both the decompression stream and the underlying object body
+type objectReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (o *objectReader) Close() error {
+	var err error
+	for _, closer := range o.closers {
+		if cErr := closer.Close(); cErr != nil && err == nil {
+			err = cErr
+		}
+	}
+	return err
+}
+
+// NewDecompressingReader wraps the reader and decompresses it if it is a gzip stream. Closing the returned reader
+// closes the supplied one.
+func NewDecompressingReader(body io.ReadCloser) (io.ReadCloser, error) {
+	bufferedReader := bufio.NewReader(body)
+
+	// Check for gzip compression
+	header, err := bufferedReader.Peek(len(gzipMagic))
+	if err == nil && bytes.Equal(header, gzipMagic) {
+		gzipReader, err := gzip.NewReader(bufferedReader)
+		if err != nil {
+			return nil, fmt.Errorf("issue opening gzip stream: %s", err)
+		}
+		return &objectReader{Reader: gzipReader, closers: []io.Closer{gzipReader, body}}, nil
+	}
+
+	return &objectReader{Reader: bufferedReader, closers: []io.Closer{body}}, nil
+}
+
+// ReadLines calls the handler for every non-empty line in the reader
+func ReadLines(reader io.Reader, handler LineHandler) error {
+	scanner := bufio.NewScanner(reader)
+	buffer := make([]byte, 0, 64*1024)
+	scanner.Buffer(buffer, MaxLogSize)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		err := handler(line)
+		if err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// ReadJSONRecords decodes a stream of JSON documents and calls the handler for every element of the array stored
+// in the supplied field, such as the Records array in CloudTrail log files
+func ReadJSONRecords(reader io.Reader, field string, handler LineHandler) error {
+	decoder := json.NewDecoder(reader)
+	for {
+		var document map[string]json.RawMessage
+		err := decoder.Decode(&document)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("issue decoding json document: %s", err)
+		}
+
+		rawRecords, ok := document[field]
+		if !ok {
+			continue
+		}
+
+		var records []json.RawMessage
+		err = json.Unmarshal(rawRecords, &records)
+		if err != nil {
+			return fmt.Errorf("issue decoding %s field: %s", field, err)
+		}
+
+		// Compact each record so it is written as a single line
+		for _, record := range records {
+			var compacted bytes.Buffer
+			err = json.Compact(&compacted, record)
+			if err != nil {
+				return fmt.Errorf("issue compacting record: %s", err)
+			}
+
+			err = handler(compacted.Bytes())
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
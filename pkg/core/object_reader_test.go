@@ -0,0 +1,44 @@
+package core
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReadLines(t *testing.T) {
+	var compressed bytes.Buffer
+	gzipWriter := gzip.NewWriter(&compressed)
+	_, _ = gzipWriter.Write([]byte("line 1\n\nline 2\n"))
+	_ = gzipWriter.Close()
+
+	for _, body := range []io.Reader{strings.NewReader("line 1\n\nline 2\n"), &compressed} {
+		reader, err := NewDecompressingReader(io.NopCloser(body))
+		assert.Nil(t, err)
+
+		lines := make([]string, 0)
+		err = ReadLines(reader, func(line []byte) error {
+			lines = append(lines, string(line))
+			return nil
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"line 1", "line 2"}, lines)
+		assert.Nil(t, reader.Close())
+	}
+}
+
+func TestReadJSONRecords(t *testing.T) {
+	body := `{"Records": [{"eventName": "ConsoleLogin",
+  "eventSource": "signin.amazonaws.com"}, {"eventName": "AssumeRole"}]}`
+
+	records := make([]string, 0)
+	err := ReadJSONRecords(strings.NewReader(body), "Records", func(line []byte) error {
+		records = append(records, string(line))
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{`{"eventName":"ConsoleLogin","eventSource":"signin.amazonaws.com"}`, `{"eventName":"AssumeRole"}`}, records)
+}
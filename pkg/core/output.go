@@ -2,6 +2,8 @@ package core
 
 type OutputHandler func(config []byte) (Output, error)
 
+// Output writes the results to a destination. Outputs that keep clients open between writes can also implement
+// io.Closer to be closed once the pipeline stops.
 type Output interface {
 	Write(inputFile string) (int, error)
 }
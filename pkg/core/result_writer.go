@@ -0,0 +1,141 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// ResultWriter writes the events of a polling input to temp files and sends them down the pipeline. A new file is
+// started whenever MaxEvents is reached so large sources are split into multiple results.
+//
+// Split results carry the state of the last checkpoint, so a restart resumes from the last fully written source.
+type ResultWriter struct {
+	maxEvents   int
+	writer      *TmpWriter
+	processPipe chan<- PipelineResults
+	state       State
+
+	// Results sent and not yet acknowledged, with acked signalled on each acknowledgement
+	mu      sync.Mutex
+	failed  bool
+	pending int
+	acked   chan struct{}
+}
+
+// NewResultWriter creates a new ResultWriter. The supplied state is sent with any results split before the first
+// checkpoint.
+func NewResultWriter(maxEvents int, state State, processPipe chan<- PipelineResults) (*ResultWriter, error) {
+	writer, err := NewTmpWriter()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ResultWriter{
+		maxEvents:   maxEvents,
+		writer:      writer,
+		processPipe: processPipe,
+		state:       state,
+		acked:       make(chan struct{}, 1),
+	}, nil
+}
+
+// Write adds an event to the current results and sends them on once MaxEvents is reached
+func (w *ResultWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	if err != nil {
+		return n, err
+	}
+
+	if w.maxEvents > 0 && w.writer.WriteCount >= w.maxEvents {
+		err = w.send(w.state)
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// Checkpoint sets the state sent with any results split from now on
+func (w *ResultWriter) Checkpoint(state State) {
+	w.state = state
+}
+
+// Flush checkpoints the state and sends the remaining results. The results are sent even when empty so the state
+// is saved.
+func (w *ResultWriter) Flush(state State) error {
+	w.state = state
+	return w.send(state)
+}
+
+// FlushWait sends the remaining results like Flush and waits until the pipeline has finished with every result sent
+// by the writer, as results can be finished with out of order. It returns whether every result was delivered to the
+// outputs, which is false if the context is done first.
+func (w *ResultWriter) FlushWait(ctx context.Context, state State) (bool, error) {
+	err := w.Flush(state)
+	if err != nil {
+		return false, err
+	}
+
+	for {
+		w.mu.Lock()
+		pending, failed := w.pending, w.failed
+		w.mu.Unlock()
+		if pending == 0 {
+			return !failed, nil
+		}
+
+		select {
+		case <-w.acked:
+		case <-ctx.Done():
+			return false, nil
+		}
+	}
+}
+
+// Discard removes any results that have not been sent
+func (w *ResultWriter) Discard() {
+	fileName := w.writer.Name()
+	_ = w.writer.Close()
+	if fileName != "" {
+		_ = os.Remove(fileName)
+	}
+}
+
+// send sends the current results down the pipeline, counting them until they are acknowledged and recording any
+// failed delivery
+func (w *ResultWriter) send(state State) error {
+	count, fileName, err := w.writer.Rotate()
+	if err != nil {
+		return fmt.Errorf("issue rotating temp file: %s", err)
+	}
+
+	w.mu.Lock()
+	w.pending++
+	w.mu.Unlock()
+
+	var once sync.Once
+	w.processPipe <- PipelineResults{
+		FilePath:    fileName,
+		ResultCount: count,
+		State:       state,
+		RetryCount:  0,
+		Ack: func(delivered bool) {
+			once.Do(func() {
+				w.mu.Lock()
+				w.pending--
+				w.failed = w.failed || !delivered
+				w.mu.Unlock()
+
+				select {
+				case w.acked <- struct{}{}:
+				default:
+				}
+			})
+		},
+	}
+
+	return nil
+}
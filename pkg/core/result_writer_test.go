@@ -0,0 +1,86 @@
+package core
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestResultWriterSplit(t *testing.T) {
+	processPipe := make(chan PipelineResults, 10)
+	writer, err := NewResultWriter(2, State("initial"), processPipe)
+	assert.Nil(t, err)
+
+	for _, v := range []string{"one", "two", "three"} {
+		_, err = writer.Write([]byte(v))
+		assert.Nil(t, err)
+	}
+
+	// Results split before a checkpoint carry the initial state
+	assert.Equal(t, 1, len(processPipe))
+	res := <-processPipe
+	assert.Equal(t, 2, res.ResultCount)
+	assert.Equal(t, State("initial"), res.State)
+	_ = os.Remove(res.FilePath)
+	split := res
+
+	writer.Checkpoint(State("checkpoint"))
+	_, err = writer.Write([]byte("four"))
+	assert.Nil(t, err)
+	res = <-processPipe
+	assert.Equal(t, 2, res.ResultCount)
+	assert.Equal(t, State("checkpoint"), res.State)
+	_ = os.Remove(res.FilePath)
+	res.Acknowledge(true)
+
+	// Flush always sends so the final state is saved
+	assert.Nil(t, writer.Flush(State("final")))
+	res = <-processPipe
+	assert.Equal(t, 0, res.ResultCount)
+	assert.Equal(t, State("final"), res.State)
+	res.Acknowledge(true)
+
+	// FlushWait waits on every result sent, not only the last one which can be acknowledged first
+	go func() {
+		res := <-processPipe
+		res.Acknowledge(true)
+	}()
+	ctx, cancelFn := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	delivered, err := writer.FlushWait(ctx, State("final"))
+	cancelFn()
+	assert.Nil(t, err)
+	assert.False(t, delivered)
+
+	// FlushWait returns once the results are acknowledged
+	go func() {
+		res := <-processPipe
+		res.Acknowledge(true)
+		split.Acknowledge(true)
+	}()
+	delivered, err = writer.FlushWait(context.Background(), State("final"))
+	assert.Nil(t, err)
+	assert.True(t, delivered)
+
+	// Any failed delivery is reported
+	go func() {
+		res := <-processPipe
+		res.Acknowledge(false)
+		res = <-processPipe
+		res.Acknowledge(true)
+	}()
+	assert.Nil(t, writer.Flush(State("final")))
+	delivered, err = writer.FlushWait(context.Background(), State("final"))
+	assert.Nil(t, err)
+	assert.False(t, delivered)
+
+	// FlushWait stops waiting once the context is done
+	ctx, cancelFn = context.WithCancel(context.Background())
+	cancelFn()
+	delivered, err = writer.FlushWait(ctx, State("final"))
+	assert.Nil(t, err)
+	assert.False(t, delivered)
+	res = <-processPipe
+	_ = os.Remove(res.FilePath)
+}
@@ -0,0 +1,49 @@
+package core
+
+import (
+	"os"
+	"sync"
+)
+
+// WriteProgress remembers the lines of each results file an output has finished with when writing the file fails,
+// so the retried write skips them instead of sending duplicates. Lines are numbered from zero in file order. The
+// zero value is ready to use.
+type WriteProgress struct {
+	mu    sync.Mutex
+	files map[string]map[int]bool
+}
+
+// Handled returns the lines of the file finished with by earlier attempts, which is empty on the first attempt. The
+// returned set can be added to and saved with Save.
+func (p *WriteProgress) Handled(file string) map[int]bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if handled, ok := p.files[file]; ok {
+		return handled
+	}
+	return make(map[int]bool)
+}
+
+// Save records the lines finished with after a failed attempt, or forgets the file once it has been written.
+// Files the pipeline has since removed are forgotten too.
+func (p *WriteProgress) Save(file string, handled map[int]bool, complete bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if complete {
+		delete(p.files, file)
+		return
+	}
+
+	if p.files == nil {
+		p.files = make(map[string]map[int]bool)
+	}
+
+	for name := range p.files {
+		if _, err := os.Stat(name); os.IsNotExist(err) {
+			delete(p.files, name)
+		}
+	}
+	p.files[file] = handled
+}
@@ -0,0 +1,32 @@
+package core
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteProgress(t *testing.T) {
+	var progress WriteProgress
+	file := filepath.Join(t.TempDir(), "results.txt")
+	assert.Nil(t, os.WriteFile(file, []byte("one\ntwo\n"), 0600))
+
+	// Nothing is handled on the first attempt
+	handled := progress.Handled(file)
+	assert.Equal(t, 0, len(handled))
+
+	handled[0] = true
+	progress.Save(file, handled, false)
+	assert.Equal(t, map[int]bool{0: true}, progress.Handled(file))
+
+	// Files are forgotten once written
+	progress.Save(file, handled, true)
+	assert.Equal(t, 0, len(progress.Handled(file)))
+
+	// Files that have been removed are forgotten when the next file is saved
+	progress.Save(file, handled, false)
+	assert.Nil(t, os.Remove(file))
+	progress.Save(filepath.Join(t.TempDir(), "other.txt"), handled, false)
+	assert.Equal(t, 0, len(progress.Handled(file)))
+}